@@ -0,0 +1,214 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Backend stores the files of Items by their ID, while the Store keeps the
+// Items' metadata.
+type Backend interface {
+	// Put stores a new file for the ID, read from r until io.EOF. On an error,
+	// a partially stored file might be left behind and must be deleted. The
+	// file must not become visible before being stored completely.
+	Put(id string, r io.Reader) error
+
+	// Get returns the file for the ID. An *os.File can be sent directly to the
+	// webserver, while other implementations are streamed through a pipe.
+	Get(id string) (io.ReadCloser, error)
+
+	// Delete the file for the ID. Deleting a missing file might result in an
+	// error being fs.ErrNotExist.
+	Delete(id string) error
+}
+
+// listingBackend is a Backend able to list its files, as required by
+// Store.Reconcile.
+type listingBackend interface {
+	Backend
+
+	// List returns the IDs of all stored files.
+	List() ([]string, error)
+}
+
+// tempFilePrefix starts the names of files being written by fileBackend.Put.
+// Such files are left over if the store was killed while writing.
+const tempFilePrefix = ".tmp-"
+
+// fileBackend is a Backend storing the files within a local directory,
+// optionally distributed into shard subdirectories.
+type fileBackend struct {
+	dir string
+
+	shardDepth int
+
+	// removeFile is os.Remove, replaceable for testing.
+	removeFile func(string) error
+}
+
+// newFileBackend creates a fileBackend within the directory. Files not stored
+// at their location for the shardDepth, e.g., after changing it, are being
+// moved.
+func newFileBackend(dir string, shardDepth int) (*fileBackend, error) {
+	fb := &fileBackend{
+		dir:        dir,
+		shardDepth: shardDepth,
+		removeFile: os.Remove,
+	}
+
+	err := fb.migrateShards()
+	if err != nil {
+		return nil, err
+	}
+	return fb, nil
+}
+
+// shardName converts a part of an ID into a safe directory name.
+func shardName(part string) string {
+	return strings.Map(func(r rune) rune {
+		if ('0' <= r && r <= '9') || ('A' <= r && r <= 'Z') || ('a' <= r && r <= 'z') || r == '-' {
+			return r
+		}
+		return '_'
+	}, part)
+}
+
+// fileDir returns the directory for an Item's file, based on the shard depth.
+//
+// Each shard level uses the next two bytes of the ID. Shorter IDs are padded.
+func (fb *fileBackend) fileDir(id string) string {
+	parts := []string{fb.dir}
+
+	padded := id + strings.Repeat("_", fb.shardDepth*shardLength)
+	for i := 0; i < fb.shardDepth; i++ {
+		parts = append(parts, shardName(padded[i*shardLength:(i+1)*shardLength]))
+	}
+
+	return filepath.Join(parts...)
+}
+
+// filePath returns the path of an Item's file.
+func (fb *fileBackend) filePath(id string) string {
+	return filepath.Join(fb.fileDir(id), id)
+}
+
+// migrateShards moves all files within the directory to their path for the
+// current shard depth and removes empty shard directories. Temporary files
+// left over by an interrupted Put are removed.
+func (fb *fileBackend) migrateShards() error {
+	var moved, removed int
+	var dirs []string
+
+	err := filepath.WalkDir(fb.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if path != fb.dir {
+				dirs = append(dirs, path)
+			}
+			return nil
+		}
+
+		if strings.HasPrefix(d.Name(), tempFilePrefix) {
+			removed++
+			return os.Remove(path)
+		}
+
+		target := fb.filePath(d.Name())
+		if path == target {
+			return nil
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return err
+		}
+		if err := os.Rename(path, target); err != nil {
+			return err
+		}
+		moved++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Remove empty directories, deepest first. Non-empty ones will fail.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		_ = os.Remove(dirs[i])
+	}
+
+	if moved > 0 {
+		slog.Info("Moved files to their shard",
+			slog.Int("files", moved), slog.Int("shard_depth", fb.shardDepth))
+	}
+	if removed > 0 {
+		slog.Warn("Removed incomplete files of interrupted insertions", slog.Int("files", removed))
+	}
+	return nil
+}
+
+// Put writes the file to a temporary file within the same directory first,
+// which is synced and renamed to its final path after being written entirely.
+// Thus, a file is either complete or missing, even if the store was killed.
+func (fb *fileBackend) Put(id string, r io.Reader) (err error) {
+	filePath := fb.filePath(id)
+
+	err = os.MkdirAll(filepath.Dir(filePath), 0700)
+	if err != nil {
+		return
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(filePath), tempFilePrefix+shardName(id)+"-*")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		return
+	}
+	err = f.Sync()
+	if err != nil {
+		return
+	}
+	err = f.Close()
+	if err != nil {
+		return
+	}
+	return os.Rename(f.Name(), filePath)
+}
+
+// List returns the IDs of all files within the directory and its shards,
+// except temporary files being written.
+func (fb *fileBackend) List() (ids []string, err error) {
+	err = filepath.WalkDir(fb.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && !strings.HasPrefix(d.Name(), tempFilePrefix) {
+			ids = append(ids, d.Name())
+		}
+		return nil
+	})
+	return
+}
+
+func (fb *fileBackend) Get(id string) (io.ReadCloser, error) {
+	return os.Open(fb.filePath(id))
+}
+
+func (fb *fileBackend) Delete(id string) error {
+	return fb.removeFile(fb.filePath(id))
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config configures a Backend for an S3 compatible object storage.
+type S3Config struct {
+	// Endpoint is the base URL, e.g., "https://s3.example.com".
+	Endpoint string `yaml:"endpoint"`
+	// Region defaults to "us-east-1", being used by most compatible services.
+	Region string `yaml:"region"`
+	Bucket string `yaml:"bucket"`
+	// Prefix is optionally prepended to each object's key.
+	Prefix string `yaml:"prefix"`
+
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+}
+
+// s3Backend stores files as objects in an S3 bucket, addressed path-style,
+// e.g., "https://s3.example.com/bucket/prefix/id". Requests are signed by AWS
+// Signature Version 4.
+//
+// As S3 requires an object's size in advance, each file is spooled into a
+// temporary file within the spoolDir before being uploaded.
+type s3Backend struct {
+	endpoint *url.URL
+	region   string
+	bucket   string
+	prefix   string
+
+	accessKey string
+	secretKey string
+
+	spoolDir string
+	client   *http.Client
+}
+
+// emptyPayloadHash is the hex encoded SHA-256 hash of an empty payload.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// NewS3Backend creates a Backend for an S3 bucket, spooling uploads within the
+// spoolDir, which will be created if necessary.
+func NewS3Backend(conf S3Config, spoolDir string) (Backend, error) {
+	endpoint, err := url.Parse(conf.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse S3 endpoint %q: %w", conf.Endpoint, err)
+	} else if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("S3 endpoint %q must be an HTTP or HTTPS URL", conf.Endpoint)
+	}
+
+	if conf.Bucket == "" {
+		return nil, fmt.Errorf("S3 backend requires a bucket")
+	}
+	if conf.AccessKey == "" || conf.SecretKey == "" {
+		return nil, fmt.Errorf("S3 backend requires an access_key and a secret_key")
+	}
+
+	region := conf.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	err = os.MkdirAll(spoolDir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3Backend{
+		endpoint:  endpoint,
+		region:    region,
+		bucket:    conf.Bucket,
+		prefix:    conf.Prefix,
+		accessKey: conf.AccessKey,
+		secretKey: conf.SecretKey,
+		spoolDir:  spoolDir,
+		client:    &http.Client{},
+	}, nil
+}
+
+// s3Escape URI encodes a string as required by AWS Signature Version 4. Each
+// byte except the unreserved characters is percent-encoded, while slashes are
+// kept if keepSlash is set.
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// objectUrl returns the URL of the object for the ID.
+func (sb *s3Backend) objectUrl(id string) *url.URL {
+	u := *sb.endpoint
+	u.Path = path.Join("/", u.Path, sb.bucket, sb.prefix, id)
+	u.RawPath = s3Escape(u.Path, true)
+	return &u
+}
+
+// hmacSha256 calculates the HMAC-SHA256 of the data with the key.
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sign the request by AWS Signature Version 4 for the hex encoded SHA-256 hash
+// of its payload at the time t.
+func (sb *s3Backend) sign(req *http.Request, payloadHash string, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	query := req.URL.Query()
+	queryKeys := make([]string, 0, len(query))
+	for k := range query {
+		queryKeys = append(queryKeys, k)
+	}
+	sort.Strings(queryKeys)
+
+	queryParts := make([]string, 0, len(queryKeys))
+	for _, k := range queryKeys {
+		for _, v := range query[k] {
+			queryParts = append(queryParts, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path, true),
+		strings.Join(queryParts, "&"),
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := date + "/" + sb.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(canonicalHash[:]),
+	}, "\n")
+
+	key := hmacSha256([]byte("AWS4"+sb.secretKey), date)
+	key = hmacSha256(key, sb.region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s,SignedHeaders=%s,Signature=%s",
+		sb.accessKey, scope, signedHeaders, signature))
+}
+
+// do sends a signed request for the object of the ID.
+func (sb *s3Backend) do(method, id string, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	req, err := http.NewRequest(method, sb.objectUrl(id).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+
+	sb.sign(req, payloadHash, time.Now())
+	return sb.client.Do(req)
+}
+
+// s3Error creates an error for an unexpected response and closes its body.
+func s3Error(method, id string, resp *http.Response) error {
+	defer resp.Body.Close()
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("S3 %s of %q failed with %s: %s", method, id, resp.Status, msg)
+}
+
+func (sb *s3Backend) Put(id string, r io.Reader) error {
+	spool, err := os.CreateTemp(sb.spoolDir, "upload-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = spool.Close()
+		_ = os.Remove(spool.Name())
+	}()
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(spool, hasher), r)
+	if err != nil {
+		return err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// A non-nil body without a length would be sent chunked.
+	var body io.Reader = io.NopCloser(spool)
+	if size == 0 {
+		body = http.NoBody
+	}
+
+	resp, err := sb.do(http.MethodPut, id, body, size, hex.EncodeToString(hasher.Sum(nil)))
+	if err != nil {
+		return err
+	} else if resp.StatusCode != http.StatusOK {
+		return s3Error(http.MethodPut, id, resp)
+	}
+	return resp.Body.Close()
+}
+
+func (sb *s3Backend) Get(id string) (io.ReadCloser, error) {
+	resp, err := sb.do(http.MethodGet, id, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+
+	case http.StatusNotFound:
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("S3 object %q: %w", id, fs.ErrNotExist)
+
+	default:
+		return nil, s3Error(http.MethodGet, id, resp)
+	}
+}
+
+// Delete the object of the ID. As S3 does not distinguish deleting a missing
+// object, fs.ErrNotExist is never returned.
+func (sb *s3Backend) Delete(id string) error {
+	resp, err := sb.do(http.MethodDelete, id, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return err
+	} else if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return s3Error(http.MethodDelete, id, resp)
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configEnvPrefix prefixes all environment variables overriding Config fields.
+const configEnvPrefix = "GOSH_"
+
+// configEnvName maps a field's path of YAML keys to its environment variable,
+// e.g., "webserver", "item_config", "max_size" to
+// "GOSH_WEBSERVER_ITEM_CONFIG_MAX_SIZE".
+func configEnvName(keys []string) string {
+	return configEnvPrefix + strings.ToUpper(strings.Join(keys, "_"))
+}
+
+// yamlFieldKey returns a struct field's YAML key, being either its tag's name
+// or its lowercased name, as used by yaml.v3. Fields ignored by YAML result in
+// an empty key.
+func yamlFieldKey(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return strings.ToLower(field.Name)
+	default:
+		return name
+	}
+}
+
+// applyEnvOverrides sets the Config's fields from environment variables named
+// after their YAML keys by configEnvName. String fields and the Secret are
+// taken verbatim, all others are decoded as YAML, e.g., "10m" for a
+// time.Duration or "[a, b]" for a list. Environment variables without a
+// matching field are ignored.
+func applyEnvOverrides(conf *Config, lookupEnv func(string) (string, bool)) error {
+	return applyEnvOverridesStruct(reflect.ValueOf(conf).Elem(), nil, lookupEnv)
+}
+
+func applyEnvOverridesStruct(v reflect.Value, keys []string, lookupEnv func(string) (string, bool)) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		key := yamlFieldKey(field)
+		if key == "" {
+			continue
+		}
+		fieldKeys := append(append([]string{}, keys...), key)
+		fieldValue := v.Field(i)
+
+		_, isUnmarshaler := fieldValue.Addr().Interface().(yaml.Unmarshaler)
+		if fieldValue.Kind() == reflect.Struct && !isUnmarshaler {
+			if err := applyEnvOverridesStruct(fieldValue, fieldKeys, lookupEnv); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := configEnvName(fieldKeys)
+		value, ok := lookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		// Strings and custom types, e.g., Secret, are passed as a YAML string
+		// to not interpret special characters within, e.g., a colon.
+		node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+		if fieldValue.Kind() != reflect.String && !isUnmarshaler {
+			node = &yaml.Node{}
+			if err := yaml.Unmarshal([]byte(value), node); err != nil {
+				return fmt.Errorf("cannot parse environment variable %s: %w", name, err)
+			}
+		}
+
+		newValue := reflect.New(fieldValue.Type())
+		if err := node.Decode(newValue.Interface()); err != nil {
+			return fmt.Errorf("cannot parse environment variable %s: %w", name, err)
+		}
+		fieldValue.Set(newValue.Elem())
+	}
+	return nil
+}
+
+// configEnviron returns the environment variables which might override the
+// Config, to be passed to the child processes.
+func configEnviron() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, configEnvPrefix) {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
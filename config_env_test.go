@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplyEnvOverrides(t *testing.T) {
+	env := map[string]string{
+		"GOSH_USER":                           "gosh",
+		"GOSH_STORE_PATH":                     "/var/lib/gosh",
+		"GOSH_STORE_CLEANUP_INTERVAL":         "5m",
+		"GOSH_STORE_RECONCILE":                "true",
+		"GOSH_STORE_DELETION_HOOK_QUEUE_SIZE": "32",
+		"GOSH_WEBSERVER_CONTACT":              "admin: gosh@example.com",
+		"GOSH_WEBSERVER_ITEM_CONFIG_MAX_SIZE": "1GiB",
+		"GOSH_WEBSERVER_TRUSTED_PROXIES":      "[127.0.0.1, 10.0.0.0/8]",
+		"GOSH_SECRET":                         "[not: a list]",
+		"GOSH_UNKNOWN_FIELD":                  "ignored",
+	}
+	lookupEnv := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+
+	var conf Config
+	conf.Store.Path = "./store"
+	conf.Webserver.ItemConfig.MaxSize = "10MiB"
+	conf.Webserver.ItemConfig.DefaultLifetime = time.Hour
+
+	if err := applyEnvOverrides(&conf, lookupEnv); err != nil {
+		t.Fatal(err)
+	}
+
+	if conf.User != "gosh" {
+		t.Fatalf("unexpected user %q", conf.User)
+	}
+	if conf.Store.Path != "/var/lib/gosh" {
+		t.Fatalf("unexpected store path %q", conf.Store.Path)
+	}
+	if conf.Store.CleanupInterval != 5*time.Minute {
+		t.Fatalf("unexpected cleanup interval %v", conf.Store.CleanupInterval)
+	}
+	if !conf.Store.Reconcile {
+		t.Fatal("reconcile was not enabled")
+	}
+	if conf.Store.DeletionHook.QueueSize != 32 {
+		t.Fatalf("unexpected queue size %d", conf.Store.DeletionHook.QueueSize)
+	}
+	if conf.Webserver.Contact != "admin: gosh@example.com" {
+		t.Fatalf("unexpected contact %q", conf.Webserver.Contact)
+	}
+	if conf.Webserver.ItemConfig.MaxSize != "1GiB" {
+		t.Fatalf("unexpected max size %q", conf.Webserver.ItemConfig.MaxSize)
+	}
+	if conf.Webserver.ItemConfig.DefaultLifetime != time.Hour {
+		t.Fatalf("unset field was altered to %v", conf.Webserver.ItemConfig.DefaultLifetime)
+	}
+	if !reflect.DeepEqual(conf.Webserver.TrustedProxies, []string{"127.0.0.1", "10.0.0.0/8"}) {
+		t.Fatalf("unexpected trusted proxies %v", conf.Webserver.TrustedProxies)
+	}
+	if string(conf.Secret) != "[not: a list]" {
+		t.Fatalf("unexpected secret %q", string(conf.Secret))
+	}
+}
+
+func TestApplyEnvOverridesInvalid(t *testing.T) {
+	lookupEnv := func(key string) (string, bool) {
+		if key == "GOSH_WEBSERVER_MAX_CONNECTIONS" {
+			return "many", true
+		}
+		return "", false
+	}
+
+	var conf Config
+	if err := applyEnvOverrides(&conf, lookupEnv); err == nil {
+		t.Fatal("expected an error for an unparsable integer")
+	}
+}
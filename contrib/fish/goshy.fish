@@ -7,5 +7,5 @@ function goshy
 				set file "@"$option
 		end
 	end
-	curl -F "file=$file" -F "burn=$burn" http://our-server.example/
+	curl -F "burn=$burn" -F "file=$file" http://our-server.example/
 end
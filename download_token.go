@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// downloadTokenPurpose is used to derive the downloadTokens' key from the
+// Secret. External services need to derive the same key to create tokens.
+const downloadTokenPurpose = "download token"
+
+var (
+	ErrTokenInvalid = errors.New("Download token is invalid")
+
+	ErrTokenExpired = errors.New("Download token has expired")
+)
+
+// downloadTokens signs and verifies expiring download tokens for Item IDs.
+//
+// A token is "<expiry>.<mac>", where expiry is a Unix timestamp in seconds and
+// mac the unpadded base64url encoded HMAC-SHA256 over "<id>\n<expiry>".
+type downloadTokens struct {
+	key []byte
+
+	// lifetime of tokens being created for upload responses.
+	lifetime time.Duration
+}
+
+// newDownloadTokens derives its key from the Secret.
+func newDownloadTokens(secret Secret, lifetime time.Duration) (*downloadTokens, error) {
+	key, err := secret.DeriveKey(downloadTokenPurpose, sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &downloadTokens{key: key, lifetime: lifetime}, nil
+}
+
+// mac calculates the HMAC for an ID and expiry timestamp.
+func (dt *downloadTokens) mac(id string, expiry int64) []byte {
+	h := hmac.New(sha256.New, dt.key)
+	fmt.Fprintf(h, "%s\n%d", id, expiry)
+	return h.Sum(nil)
+}
+
+// sign creates a token for the ID, being valid until its expiry.
+func (dt *downloadTokens) sign(id string, expires time.Time) string {
+	expiry := expires.Unix()
+	return fmt.Sprintf("%d.%s", expiry, base64.RawURLEncoding.EncodeToString(dt.mac(id, expiry)))
+}
+
+// verify a token for the ID at the given time.
+func (dt *downloadTokens) verify(id, token string, now time.Time) error {
+	expiryStr, macStr, ok := strings.Cut(token, ".")
+	if !ok {
+		return ErrTokenInvalid
+	}
+
+	expiry, err := strconv.ParseInt(expiryStr, 10, 64)
+	if err != nil {
+		return ErrTokenInvalid
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(macStr)
+	if err != nil || !hmac.Equal(mac, dt.mac(id, expiry)) {
+		return ErrTokenInvalid
+	}
+
+	if !now.Before(time.Unix(expiry, 0)) {
+		return ErrTokenExpired
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDownloadTokens(t *testing.T) {
+	secret := Secret(strings.Repeat("a", secretMinLength))
+
+	dt, err := newDownloadTokens(secret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherDt, err := newDownloadTokens(Secret(strings.Repeat("b", secretMinLength)), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	token := dt.sign("abcd", now.Add(time.Minute))
+	expiryStr, macStr, _ := strings.Cut(token, ".")
+
+	tests := []struct {
+		name  string
+		id    string
+		token string
+		now   time.Time
+		err   error
+	}{
+		{"valid", "abcd", token, now, nil},
+		{"expired", "abcd", token, now.Add(time.Minute), ErrTokenExpired},
+		{"other-id", "abce", token, now, ErrTokenInvalid},
+		{"other-secret", "abcd", otherDt.sign("abcd", now.Add(time.Minute)), now, ErrTokenInvalid},
+		{"extended-expiry", "abcd", "9" + expiryStr + "." + macStr, now, ErrTokenInvalid},
+		{"tampered-mac", "abcd", expiryStr + "." + strings.ToUpper(macStr), now, ErrTokenInvalid},
+		{"missing-mac", "abcd", expiryStr, now, ErrTokenInvalid},
+		{"invalid-expiry", "abcd", "soon." + macStr, now, ErrTokenInvalid},
+		{"invalid-base64", "abcd", expiryStr + ".!!!", now, ErrTokenInvalid},
+		{"empty", "abcd", "", now, ErrTokenInvalid},
+	}
+
+	for _, test := range tests {
+		if err := dt.verify(test.id, test.token, test.now); err != test.err {
+			t.Fatalf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+
+	if _, err := newDownloadTokens(nil, time.Hour); err != ErrNoSecret {
+		t.Fatalf("Expected ErrNoSecret, got %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// fdTagLength is the size of the tag being sent along with each FD.
+const fdTagLength = 8
+
+// sendFd sends an open File (resp. its FD) over an Unix domain socket, tagged
+// to be identified by the receiver.
+func sendFd(f *os.File, tag uint64, conn *net.UnixConn) error {
+	data := binary.BigEndian.AppendUint64(nil, tag)
+	oob := unix.UnixRights(int(f.Fd()))
+	_, _, err := conn.WriteMsgUnix(data, oob, nil)
+	return err
+}
+
+// recvFd receives a File (resp. its FD) and its tag from an Unix domain socket.
+func recvFd(conn *net.UnixConn) (*os.File, uint64, error) {
+	data := make([]byte, fdTagLength)
+	oob := make([]byte, 128)
+	n, oobn, _, _, err := conn.ReadMsgUnix(data, oob)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cmsgs, err := unix.ParseSocketControlMessage(oob[0:oobn])
+	if err != nil {
+		return nil, 0, err
+	} else if len(cmsgs) != 1 {
+		return nil, 0, fmt.Errorf("ParseSocketControlMessage: wrong length %d", len(cmsgs))
+	}
+
+	fds, err := unix.ParseUnixRights(&cmsgs[0])
+	if err != nil {
+		return nil, 0, err
+	} else if len(fds) != 1 {
+		return nil, 0, fmt.Errorf("ParseUnixRights: wrong length %d", len(fds))
+	}
+
+	f := os.NewFile(uintptr(fds[0]), "")
+	if n != fdTagLength {
+		_ = f.Close()
+		return nil, 0, fmt.Errorf("received FD with a tag of %d bytes", n)
+	}
+	return f, binary.BigEndian.Uint64(data), nil
+}
+
+// fdMux multiplexes concurrent FD transfers over a single Unix domain socket.
+//
+// Each FD is sent with the tag of its transfer, which the client generates by
+// nextTag and passes as an RPC argument. A background goroutine receives all
+// FDs and dispatches them to the waiting recv calls by their tag. Thus, two
+// concurrent requests cannot end up with each other's FDs.
+type fdMux struct {
+	conn *net.UnixConn
+
+	tags atomic.Uint64
+
+	mu sync.Mutex
+	// waiting receivers and pending FDs, received before their receiver.
+	waiting map[uint64]chan *os.File
+	pending map[uint64]*os.File
+	// abandoned tags whose FD will be closed on arrival, e.g., after a timeout.
+	abandoned map[uint64]struct{}
+	// err is set after the connection has failed, e.g., was closed.
+	err error
+}
+
+// newFdMux creates a fdMux and starts receiving FDs from the connection until
+// it is closed.
+func newFdMux(conn *net.UnixConn) *fdMux {
+	mux := &fdMux{
+		conn:      conn,
+		waiting:   make(map[uint64]chan *os.File),
+		pending:   make(map[uint64]*os.File),
+		abandoned: make(map[uint64]struct{}),
+	}
+
+	go mux.receive()
+
+	return mux
+}
+
+// nextTag returns a new tag for a transfer, unique for this fdMux.
+func (mux *fdMux) nextTag() uint64 {
+	return mux.tags.Add(1)
+}
+
+// receive FDs from the connection and dispatch them until it fails.
+func (mux *fdMux) receive() {
+	for {
+		f, tag, err := recvFd(mux.conn)
+		if err != nil {
+			mux.fail(err)
+			return
+		}
+
+		mux.mu.Lock()
+		if ch, ok := mux.waiting[tag]; ok {
+			delete(mux.waiting, tag)
+			ch <- f
+		} else if _, ok := mux.abandoned[tag]; ok {
+			delete(mux.abandoned, tag)
+			_ = f.Close()
+		} else {
+			mux.pending[tag] = f
+		}
+		mux.mu.Unlock()
+	}
+}
+
+// fail all waiting and future receivers with the error.
+func (mux *fdMux) fail(err error) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	mux.err = err
+	for tag, ch := range mux.waiting {
+		close(ch)
+		delete(mux.waiting, tag)
+	}
+	for tag, f := range mux.pending {
+		_ = f.Close()
+		delete(mux.pending, tag)
+	}
+}
+
+// send a tagged FD.
+func (mux *fdMux) send(f *os.File, tag uint64) error {
+	return sendFd(f, tag, mux.conn)
+}
+
+// recv the FD of a tag, waiting at most the timeout. After a failure, the tag
+// is abandoned and its FD will be closed if it arrives later.
+func (mux *fdMux) recv(tag uint64, timeout time.Duration) (*os.File, error) {
+	mux.mu.Lock()
+	if f, ok := mux.pending[tag]; ok {
+		delete(mux.pending, tag)
+		mux.mu.Unlock()
+		return f, nil
+	} else if mux.err != nil {
+		mux.mu.Unlock()
+		return nil, mux.err
+	}
+
+	// Buffered, as the receive goroutine must not block on a late receiver.
+	ch := make(chan *os.File, 1)
+	mux.waiting[tag] = ch
+	mux.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case f, ok := <-ch:
+		if !ok {
+			mux.mu.Lock()
+			defer mux.mu.Unlock()
+			return nil, mux.err
+		}
+		return f, nil
+
+	case <-timer.C:
+		mux.mu.Lock()
+		defer mux.mu.Unlock()
+
+		// The FD might have been dispatched in the meantime.
+		select {
+		case f, ok := <-ch:
+			if ok {
+				_ = f.Close()
+			}
+		default:
+			delete(mux.waiting, tag)
+			mux.abandoned[tag] = struct{}{}
+		}
+		return nil, fmt.Errorf("FD transfer %d timed out after %v", tag, timeout)
+	}
+}
+
+// abandon a tag whose FD will not be received, e.g., after a failed RPC call.
+func (mux *fdMux) abandon(tag uint64) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	if f, ok := mux.pending[tag]; ok {
+		delete(mux.pending, tag)
+		_ = f.Close()
+	} else if mux.err == nil {
+		mux.abandoned[tag] = struct{}{}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFdMux(t *testing.T) {
+	_, _, senderConn, receiverConn := newUnixConnPairs(t)
+	sender, receiver := newFdMux(senderConn), newFdMux(receiverConn)
+	defer senderConn.Close()
+	defer receiverConn.Close()
+
+	sendData := func(tag uint64, data string) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		if _, err := w.WriteString(data); err != nil {
+			t.Fatal(err)
+		}
+		_ = w.Close()
+
+		if err := sender.send(r, tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recvData := func(tag uint64) string {
+		f, err := receiver.recv(tag, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		data, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	// FDs are dispatched by their tags, independent of their order.
+	sendData(1, "one")
+	sendData(2, "two")
+	if data := recvData(2); data != "two" {
+		t.Fatalf("Expected FD of tag 2, got %q", data)
+	}
+	if data := recvData(1); data != "one" {
+		t.Fatalf("Expected FD of tag 1, got %q", data)
+	}
+
+	// A timed out tag is abandoned and its late FD must not be pending.
+	if _, err := receiver.recv(3, 10*time.Millisecond); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("Expected a timeout, got %v", err)
+	}
+	sendData(3, "three")
+	sendData(4, "four")
+	if data := recvData(4); data != "four" {
+		t.Fatalf("Expected FD of tag 4, got %q", data)
+	}
+
+	receiver.mu.Lock()
+	pending, abandoned := len(receiver.pending), len(receiver.abandoned)
+	receiver.mu.Unlock()
+	if pending != 0 || abandoned != 0 {
+		t.Fatalf("Expected no pending or abandoned FDs, got %d and %d", pending, abandoned)
+	}
+
+	// A closed connection fails all receivers.
+	_ = receiverConn.Close()
+	if _, err := receiver.recv(5, time.Second); err == nil {
+		t.Fatal("Expected an error after closing the connection")
+	}
+}
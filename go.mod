@@ -4,15 +4,17 @@ go 1.21
 
 require (
 	github.com/akamensky/base58 v0.0.0-20210829145138-ce8bf8802e8f
+	github.com/dgraph-io/badger/v4 v4.5.0
 	github.com/oxzi/syscallset-go v0.1.6
 	github.com/timshannon/badgerhold/v4 v4.0.3
+	golang.org/x/crypto v0.29.0
 	golang.org/x/sys v0.27.0
 	gopkg.in/yaml.v3 v3.0.1
+	rsc.io/qr v0.2.0
 )
 
 require (
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
-	github.com/dgraph-io/badger/v4 v4.5.0 // indirect
 	github.com/dgraph-io/ristretto/v2 v2.0.0 // indirect
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/elastic/go-seccomp-bpf v1.5.0 // indirect
@@ -1,10 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"crypto/tls"
 	"flag"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/signal"
+	"regexp"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"syscall"
 	"time"
 
 	"golang.org/x/sys/unix"
@@ -19,6 +28,320 @@ type StaticFileConfig struct {
 	data []byte
 }
 
+// IdGeneratorConfig describes the store's id_generator from the YAML.
+type IdGeneratorConfig struct {
+	Type     string `yaml:"type"`
+	Length   int    `yaml:"length"`
+	File     string `yaml:"file"`
+	Alphabet string `yaml:"alphabet"`
+}
+
+// WebserverConfig describes the webserver section from the YAML.
+type WebserverConfig struct {
+	Listen struct {
+		Protocol string
+		Bound    string
+	}
+
+	AdminListen struct {
+		Protocol string
+		Bound    string
+	} `yaml:"admin_listen"`
+
+	UnixSocket struct {
+		Chmod string
+		Owner string
+		Group string
+	} `yaml:"unix_socket"`
+
+	Protocol string
+
+	TLS struct {
+		CertFile string `yaml:"cert_file"`
+		KeyFile  string `yaml:"key_file"`
+	} `yaml:"tls"`
+
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	MaxConnections      int `yaml:"max_connections"`
+	MaxConnectionsPerIP int `yaml:"max_connections_per_ip"`
+
+	MaxConcurrentUploads   int `yaml:"max_concurrent_uploads"`
+	MaxConcurrentDownloads int `yaml:"max_concurrent_downloads"`
+
+	UrlPrefix string `yaml:"url_prefix"`
+
+	TrailingSlash string `yaml:"trailing_slash"`
+
+	CustomIndex string `yaml:"custom_index"`
+
+	StaticFiles map[string]StaticFileConfig `yaml:"static_files"`
+
+	ContentSecurityPolicy struct {
+		Items string `yaml:"items"`
+		Index string `yaml:"index"`
+	} `yaml:"content_security_policy"`
+
+	Compression struct {
+		Enabled bool     `yaml:"enabled"`
+		Types   []string `yaml:"types"`
+	} `yaml:"compression"`
+
+	AccessLog bool `yaml:"access_log"`
+	Tracing   bool `yaml:"tracing"`
+
+	MetricsPath string `yaml:"metrics_path"`
+	HealthPath  string `yaml:"health_path"`
+
+	Admin struct {
+		Path  string `yaml:"path"`
+		Token string `yaml:"token"`
+	} `yaml:"admin"`
+
+	ResumableUploads struct {
+		Path     string        `yaml:"path"`
+		Lifetime time.Duration `yaml:"lifetime"`
+	} `yaml:"resumable_uploads"`
+
+	ItemConfig struct {
+		MaxSize         string        `yaml:"max_size"`
+		InlineMaxSize   string        `yaml:"inline_max_size"`
+		Attachment      bool          `yaml:"attachment"`
+		AttachmentTypes []string      `yaml:"attachment_types"`
+		DefaultLifetime time.Duration `yaml:"default_lifetime"`
+		MaxLifetime     time.Duration `yaml:"max_lifetime"`
+
+		MimeDrop []string          `yaml:"mime_drop"`
+		MimeMap  map[string]string `yaml:"mime_map"`
+
+		StripMetadata bool `yaml:"strip_metadata"`
+
+		MaxForwardedHops int `yaml:"max_forwarded_hops"`
+
+		BurnRetries struct {
+			Attempts int           `yaml:"attempts"`
+			Window   time.Duration `yaml:"window"`
+		} `yaml:"burn_retries"`
+
+		DeletionKeys struct {
+			Length   int  `yaml:"length"`
+			Disabled bool `yaml:"disabled"`
+			Confirm  bool `yaml:"confirm"`
+		} `yaml:"deletion_keys"`
+
+		MissingContentType struct {
+			Mode     string `yaml:"mode"`
+			Fallback string `yaml:"fallback"`
+		} `yaml:"missing_content_type"`
+
+		MismatchingContentType string `yaml:"mismatching_content_type"`
+
+		TypeOverrides []string `yaml:"type_overrides"`
+
+		UploadChecksum struct {
+			Algorithms []string `yaml:"algorithms"`
+			Required   bool     `yaml:"required"`
+		} `yaml:"upload_checksum"`
+	} `yaml:"item_config"`
+
+	VerifyChecksum bool `yaml:"verify_checksum"`
+
+	RpcStatsInterval time.Duration `yaml:"rpc_stats_interval"`
+
+	StoreTimeout  time.Duration `yaml:"store_timeout"`
+	StoreTimeouts struct {
+		Get    time.Duration `yaml:"get"`
+		Put    time.Duration `yaml:"put"`
+		Delete time.Duration `yaml:"delete"`
+	} `yaml:"store_timeouts"`
+
+	DownloadTokens struct {
+		Enabled  bool          `yaml:"enabled"`
+		Lifetime time.Duration `yaml:"lifetime"`
+	} `yaml:"download_tokens"`
+
+	UploadRateLimit struct {
+		Uploads  int           `yaml:"uploads"`
+		Interval time.Duration `yaml:"interval"`
+	} `yaml:"upload_rate_limit"`
+
+	UploadAuth UploadAuthConfig `yaml:"upload_auth"`
+
+	UploadOrigins struct {
+		Allowed  []string `yaml:"allowed"`
+		Required bool     `yaml:"required"`
+	} `yaml:"upload_origins"`
+
+	AbuseReports struct {
+		Enabled   bool `yaml:"enabled"`
+		QueueSize int  `yaml:"queue_size"`
+		RateLimit struct {
+			Reports  int           `yaml:"reports"`
+			Interval time.Duration `yaml:"interval"`
+		} `yaml:"rate_limit"`
+		Smtp SmtpConfig `yaml:"smtp"`
+	} `yaml:"abuse_reports"`
+
+	Webhook WebhookConfig `yaml:"webhook"`
+
+	RejectInvalidIds bool `yaml:"reject_invalid_ids"`
+
+	Contact string
+
+	// TemplateVars are operator-defined values for the index template, e.g.,
+	// for a custom_index, available as "{{.Vars.key}}".
+	TemplateVars map[string]string `yaml:"template_vars"`
+
+	// secret is the Config's Secret, set for features deriving their keys.
+	secret Secret
+
+	// idGenerator is the store's IdGeneratorConfig, set for RejectInvalidIds.
+	idGenerator IdGeneratorConfig
+
+	// tlsCertificate is loaded from the TLS files before dropping privileges.
+	tlsCertificate *tls.Certificate
+
+	// indexTpl is the CustomIndex template, being read by loadIndex.
+	indexTpl string
+
+	// version is the buildVersion, set for the index template.
+	version string
+}
+
+// loadIndex reads the CustomIndex template and checks that it parses. Without
+// a CustomIndex, the embedded index.html is used.
+//
+// As the webserver is chrooted before serving, the template must be read
+// before dropping privileges.
+func (conf *WebserverConfig) loadIndex() error {
+	if conf.CustomIndex == "" {
+		slog.Debug("No custom_index is configured, using the embedded index template")
+		return nil
+	}
+
+	indexTpl, err := os.ReadFile(conf.CustomIndex)
+	if err != nil {
+		return fmt.Errorf("cannot read custom_index: %w", err)
+	} else if len(bytes.TrimSpace(indexTpl)) == 0 {
+		return fmt.Errorf("custom_index %q is empty", conf.CustomIndex)
+	}
+
+	_, err = parseIndexTpl(string(indexTpl))
+	if err != nil {
+		return fmt.Errorf("cannot parse custom_index: %w", err)
+	}
+
+	conf.indexTpl = string(indexTpl)
+	return nil
+}
+
+// validateTls checks that TLS is either fully configured or disabled, and only
+// used for the HTTP protocol.
+func (conf WebserverConfig) validateTls() error {
+	if conf.TLS.CertFile == "" && conf.TLS.KeyFile == "" {
+		return nil
+	} else if conf.TLS.CertFile == "" || conf.TLS.KeyFile == "" {
+		return fmt.Errorf("tls requires both a cert_file and a key_file")
+	} else if conf.Protocol != "http" {
+		return fmt.Errorf("tls is only supported for the http protocol, not %q", conf.Protocol)
+	}
+	return nil
+}
+
+// validateMime checks the item_config's MIME lists for conflicts.
+//
+// A MIME type cannot be both dropped and mapped, as it would never be served
+// and the mapping would be dead. Equally, a dropped MIME type cannot be an
+// allowed type override. MIME types are compared case-insensitively.
+func (conf WebserverConfig) validateMime() error {
+	drop := make(map[string]struct{})
+	for _, mime := range conf.ItemConfig.MimeDrop {
+		drop[strings.ToLower(mime)] = struct{}{}
+	}
+
+	mapped := make(map[string]string)
+	for from := range conf.ItemConfig.MimeMap {
+		fromLower := strings.ToLower(from)
+		if _, ok := drop[fromLower]; ok {
+			return fmt.Errorf("MIME type %q is both in mime_drop and mime_map", from)
+		}
+		if other, ok := mapped[fromLower]; ok {
+			return fmt.Errorf("MIME type %q is mapped twice in mime_map, also as %q", from, other)
+		}
+		mapped[fromLower] = from
+	}
+
+	for _, override := range conf.ItemConfig.TypeOverrides {
+		if _, ok := drop[strings.ToLower(override)]; ok {
+			return fmt.Errorf("MIME type %q is both in mime_drop and type_overrides", override)
+		}
+	}
+
+	return nil
+}
+
+// LogFormat is the monitor's log output format.
+type LogFormat string
+
+const (
+	// LogFormatText logs human readable key=value pairs, being the default.
+	LogFormatText LogFormat = "text"
+	// LogFormatJson logs one JSON object per line, e.g., for log collectors.
+	LogFormatJson LogFormat = "json"
+)
+
+// seccompSyscallSets are the syscall sets known to syscallset-go, usable as
+// "@set" within a seccomp filter.
+var seccompSyscallSets = []string{
+	"aio", "basic-io", "chown", "clock", "cpu-emulation", "debug", "default",
+	"file-system", "io-event", "ipc", "keyring", "known", "memlock", "module",
+	"mount", "network-io", "obsolete", "pkey", "privileged", "process",
+	"raw-io", "reboot", "resources", "sandbox", "setuid", "signal", "swap",
+	"sync", "system-service", "timer",
+}
+
+// seccompSyscallName matches a single syscall's name within a seccomp filter.
+var seccompSyscallName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// HardeningConfig extends each process' built-in seccomp filter by further
+// entries in syscallset-go syntax, being appended to the built-in filter.
+type HardeningConfig struct {
+	MonitorSyscalls   []string `yaml:"monitor_syscalls"`
+	StoreSyscalls     []string `yaml:"store_syscalls"`
+	WebserverSyscalls []string `yaml:"webserver_syscalls"`
+}
+
+// validateSeccompFilter checks each entry of a seccomp filter to be either a
+// known syscall set or a syscall's name, both optionally prefixed by a tilde.
+func validateSeccompFilter(filter []string) error {
+	for _, entry := range filter {
+		name := strings.TrimPrefix(entry, "~")
+
+		if set, ok := strings.CutPrefix(name, "@"); ok {
+			if !slices.Contains(seccompSyscallSets, set) {
+				return fmt.Errorf("unknown syscall set %q", entry)
+			}
+		} else if !seccompSyscallName.MatchString(name) {
+			return fmt.Errorf("invalid syscall %q", entry)
+		}
+	}
+	return nil
+}
+
+// validate all configured seccomp filters.
+func (hc HardeningConfig) validate() error {
+	for key, filter := range map[string][]string{
+		"monitor_syscalls":   hc.MonitorSyscalls,
+		"store_syscalls":     hc.StoreSyscalls,
+		"webserver_syscalls": hc.WebserverSyscalls,
+	} {
+		if err := validateSeccompFilter(filter); err != nil {
+			return fmt.Errorf("invalid hardening %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
 // Config is the struct representation of gosh's YAML configuration file.
 //
 // For each field's meaning, please consider the gosh.yml file in this
@@ -28,51 +351,55 @@ type Config struct {
 	User  string
 	Group string
 
+	Secret     Secret `yaml:"secret"`
+	SecretFile string `yaml:"secret_file"`
+
 	Store struct {
 		Path string
+		Type string `yaml:"type"`
 
-		IdGenerator struct {
-			Type   string `yaml:"type"`
-			Length int    `yaml:"length"`
-			File   string `yaml:"file"`
-		} `yaml:"id_generator"`
-	}
+		ShardDepth int `yaml:"shard_depth"`
 
-	Webserver struct {
-		Listen struct {
-			Protocol string
-			Bound    string
-		}
+		Backend struct {
+			Type string   `yaml:"type"`
+			S3   S3Config `yaml:"s3"`
+		} `yaml:"backend"`
 
-		UnixSocket struct {
-			Chmod string
-			Owner string
-			Group string
-		} `yaml:"unix_socket"`
+		StrictPermissions bool `yaml:"strict_permissions"`
 
-		Protocol string
+		MaxFdTransfers int `yaml:"max_fd_transfers"`
 
-		UrlPrefix string `yaml:"url_prefix"`
+		CleanupInterval time.Duration `yaml:"cleanup_interval"`
 
-		CustomIndex string `yaml:"custom_index"`
+		DeletionRetries int `yaml:"deletion_retries"`
 
-		StaticFiles map[string]StaticFileConfig `yaml:"static_files"`
+		Reconcile bool `yaml:"reconcile"`
 
-		ItemConfig struct {
-			MaxSize     string        `yaml:"max_size"`
-			MaxLifetime time.Duration `yaml:"max_lifetime"`
+		MaxStoreSize string      `yaml:"max_store_size"`
+		QuotaPolicy  QuotaPolicy `yaml:"quota_policy"`
 
-			MimeDrop []string          `yaml:"mime_drop"`
-			MimeMap  map[string]string `yaml:"mime_map"`
-		} `yaml:"item_config"`
+		DeletionHook WebhookConfig `yaml:"deletion_hook"`
 
-		Contact string
+		IdGenerator IdGeneratorConfig `yaml:"id_generator"`
 	}
+
+	Log struct {
+		Format     LogFormat     `yaml:"format"`
+		File       string        `yaml:"file"`
+		MaxSize    string        `yaml:"max_size"`
+		MaxAge     time.Duration `yaml:"max_age"`
+		MaxBackups int           `yaml:"max_backups"`
+	} `yaml:"log"`
+
+	Hardening HardeningConfig `yaml:"hardening"`
+
+	Webserver WebserverConfig
 }
 
 // loadConfig loads a Config from a given YAML configuration file at the path.
 func loadConfig(path string) (Config, error) {
 	var conf Config
+	conf.Store.CleanupInterval = time.Minute
 
 	f, err := os.Open(path)
 	if err != nil {
@@ -82,10 +409,183 @@ func loadConfig(path string) (Config, error) {
 
 	decoder := yaml.NewDecoder(f)
 	err = decoder.Decode(&conf)
+	if err != nil {
+		return conf, err
+	}
+
+	err = applyEnvOverrides(&conf, os.LookupEnv)
+	if err != nil {
+		return conf, err
+	}
+
+	if conf.SecretFile != "" {
+		if len(conf.Secret) > 0 {
+			return conf, fmt.Errorf("both secret and secret_file are configured")
+		}
+
+		secret, err := os.ReadFile(conf.SecretFile)
+		if err != nil {
+			return conf, fmt.Errorf("cannot read secret_file: %w", err)
+		}
+		conf.Secret = Secret(bytes.TrimSpace(secret))
+	}
+
+	err = conf.Secret.validate()
+	if err != nil {
+		return conf, err
+	}
+
+	switch conf.Log.Format {
+	case "", LogFormatText, LogFormatJson:
+	default:
+		return conf, fmt.Errorf("unknown log format %q", conf.Log.Format)
+	}
+
+	err = conf.Hardening.validate()
+	if err != nil {
+		return conf, err
+	}
+
+	err = conf.Webserver.validateMime()
+	if err != nil {
+		return conf, err
+	}
+
+	err = conf.Webserver.validateTls()
 	return conf, err
 }
 
+// problems lists all invalid or missing values of the Config, which would
+// otherwise only be noticed when a child process starts.
+func (conf Config) problems() []string {
+	var problems []string
+
+	if conf.User == "" || conf.Group == "" {
+		problems = append(problems, "user and group are required")
+	} else if _, _, err := uidGidForUserGroup(conf.User, conf.Group); err != nil {
+		problems = append(problems, fmt.Sprintf("cannot resolve user %q and group %q: %v", conf.User, conf.Group, err))
+	}
+
+	if conf.Store.Path == "" {
+		problems = append(problems, "store path is required")
+	}
+	switch conf.Store.Type {
+	case "", "badger", "memory":
+	default:
+		problems = append(problems, fmt.Sprintf("unknown store type %q, supported are badger, memory", conf.Store.Type))
+	}
+	switch conf.Store.Backend.Type {
+	case "", "file", "s3":
+	default:
+		problems = append(problems, fmt.Sprintf("unknown store backend type %q, supported are file, s3", conf.Store.Backend.Type))
+	}
+	switch idGenerator := conf.Store.IdGenerator; idGenerator.Type {
+	case "random", "wordlist", "uuid":
+	case "nanoid":
+		if _, err := nanoidIdGenerator(idGenerator.Alphabet, idGenerator.Length); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid nanoid ID generator: %v", err))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown ID generator type %q, supported are random, wordlist, uuid, nanoid", conf.Store.IdGenerator.Type))
+	}
+
+	// The listen section might be omitted when using systemd's socket
+	// activation.
+	switch listen := conf.Webserver.Listen; listen.Protocol {
+	case "":
+		if listen.Bound != "" {
+			problems = append(problems, "webserver listen protocol is required")
+		}
+	case "tcp", "unix":
+		if listen.Bound == "" {
+			problems = append(problems, "webserver listen bound is required")
+		}
+		if listen.Protocol == "unix" {
+			socket := conf.Webserver.UnixSocket
+			if _, _, err := uidGidForUserGroup(socket.Owner, socket.Group); err != nil {
+				problems = append(problems, fmt.Sprintf("cannot resolve unix_socket owner %q and group %q: %v", socket.Owner, socket.Group, err))
+			}
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown webserver listen protocol %q, supported are tcp, unix", listen.Protocol))
+	}
+
+	switch listen := conf.Webserver.AdminListen; listen.Protocol {
+	case "":
+		if listen.Bound != "" {
+			problems = append(problems, "webserver admin_listen protocol is required")
+		}
+	case "tcp", "unix":
+		if listen.Bound == "" {
+			problems = append(problems, "webserver admin_listen bound is required")
+		}
+		if conf.Webserver.MetricsPath == "" && conf.Webserver.Admin.Path == "" {
+			problems = append(problems, "webserver admin_listen requires a metrics_path or admin path")
+		}
+		if listen.Protocol == "unix" && conf.Webserver.Listen.Protocol != "unix" {
+			socket := conf.Webserver.UnixSocket
+			if _, _, err := uidGidForUserGroup(socket.Owner, socket.Group); err != nil {
+				problems = append(problems, fmt.Sprintf("cannot resolve unix_socket owner %q and group %q: %v", socket.Owner, socket.Group, err))
+			}
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown webserver admin_listen protocol %q, supported are tcp, unix", listen.Protocol))
+	}
+
+	switch conf.Webserver.Protocol {
+	case "http", "fcgi":
+	default:
+		problems = append(problems, fmt.Sprintf("unknown webserver protocol %q, supported are http, fcgi", conf.Webserver.Protocol))
+	}
+
+	if conf.Webserver.Contact == "" {
+		problems = append(problems, "webserver contact is required")
+	}
+
+	if conf.Webserver.ItemConfig.MaxSize == "" {
+		problems = append(problems, "webserver item_config max_size is required")
+	}
+	for key, size := range map[string]string{
+		"log max_size":                          conf.Log.MaxSize,
+		"store max_store_size":                  conf.Store.MaxStoreSize,
+		"webserver item_config max_size":        conf.Webserver.ItemConfig.MaxSize,
+		"webserver item_config inline_max_size": conf.Webserver.ItemConfig.InlineMaxSize,
+	} {
+		if size == "" {
+			continue
+		}
+		if _, err := ParseBytesize(size); err != nil {
+			problems = append(problems, fmt.Sprintf("cannot parse %s %q: %v", key, size, err))
+		}
+	}
+
+	slices.Sort(problems)
+	return problems
+}
+
+// Validate the Config's values, reporting all problems at once.
+func (conf Config) Validate() error {
+	if problems := conf.problems(); len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
 func mainMonitor(conf Config) {
+	// The file descriptor limit must be set before forking off the children,
+	// inheriting it, and before applying seccomp filters.
+	if required, ok := requiredNofile(conf); ok {
+		nofile, err := ensureNofileLimit(required)
+		if err != nil {
+			slog.Error("Failed to set file descriptor limit", slog.Any("error", err))
+			os.Exit(1)
+		}
+		slog.Info("Set file descriptor limit",
+			slog.Uint64("required", required), slog.Any("soft", nofile.Cur), slog.Any("hard", nofile.Max))
+	} else {
+		slog.Debug("Not setting file descriptor limit for unlimited connections or file transfers")
+	}
+
 	storeRpcServer, storeRpcClient, err := socketpair()
 	if err != nil {
 		slog.Error("Failed to create socketpair", slog.Any("error", err))
@@ -103,7 +603,21 @@ func mainMonitor(conf Config) {
 		os.Exit(1)
 	}
 
-	procWebserver, err := forkChild("webserver", []*os.File{storeRpcClient, storeFdClient})
+	webserverFiles := []*os.File{storeRpcClient, storeFdClient}
+	var webserverArgs []string
+
+	listenFile, err := systemdListenFile()
+	if err != nil {
+		slog.Error("Failed to use socket from systemd socket activation", slog.Any("error", err))
+		os.Exit(1)
+	} else if listenFile != nil {
+		slog.Info("Passing socket from systemd socket activation to the web server")
+
+		webserverFiles = append(webserverFiles, listenFile)
+		webserverArgs = append(webserverArgs, "-socket-activated")
+	}
+
+	procWebserver, err := forkChild("webserver", webserverFiles, webserverArgs...)
 	if err != nil {
 		slog.Error("Failed to fork off child", slog.Any("error", err), slog.String("child", "webserver"))
 		os.Exit(1)
@@ -120,36 +634,56 @@ func mainMonitor(conf Config) {
 		os.Exit(1)
 	}
 
-	err = restrict(restrict_linux_seccomp,
-		[]string{
-			"@system-service",
-			"~@chown",
-			"~@clock",
-			"~@cpu-emulation",
-			"~@debug",
-			"~@keyring",
-			"~@memlock",
-			"~@module",
-			"~@mount",
-			"~@network-io",
-			"~@privileged",
-			"~@reboot",
-			"~@sandbox",
-			"~@setuid",
-			"~@swap",
-			/* @process */ "~execve", "~execveat", "~fork",
-		})
+	seccompFilter := []string{
+		"@system-service",
+		"~@chown",
+		"~@clock",
+		"~@cpu-emulation",
+		"~@debug",
+		"~@keyring",
+		"~@memlock",
+		"~@module",
+		"~@mount",
+		"~@network-io",
+		"~@privileged",
+		"~@reboot",
+		"~@sandbox",
+		"~@setuid",
+		"~@swap",
+		/* @process */ "~execve", "~execveat", "~fork",
+	}
+	seccompFilter = append(seccompFilter, conf.Hardening.MonitorSyscalls...)
+
+	err = restrict(restrict_linux_seccomp, seccompFilter)
 	if err != nil {
 		slog.Error("Failed to apply seccomp-bpf filter", slog.Any("error", err))
 		os.Exit(1)
 	}
 
-	err = restrict(restrict_openbsd_pledge, "stdio tty proc error", "")
+	// Rotating the log file requires creating and renaming files.
+	promises := "stdio tty proc error"
+	if conf.Log.File != "" {
+		promises += " wpath cpath"
+	}
+	err = restrict(restrict_openbsd_pledge, promises, "")
 	if err != nil {
 		slog.Error("Failed to pledge", slog.Any("error", err))
 		os.Exit(1)
 	}
 
+	// The monitor cannot enter the capability mode as it signals its children
+	// and rotates the log file by its path. However, it has no use for the
+	// children's sockets besides keeping them open.
+	monitorFiles := []syscall.Conn{storeRpcServer, storeRpcClient, storeFdServer, storeFdClient}
+	if listenFile != nil {
+		monitorFiles = append(monitorFiles, listenFile)
+	}
+	err = restrict(restrict_freebsd_capsicum, monitorFiles, "", false)
+	if err != nil {
+		slog.Error("Failed to limit capability rights", slog.Any("error", err))
+		os.Exit(1)
+	}
+
 	sigintCh := make(chan os.Signal, 1)
 	signal.Notify(sigintCh, unix.SIGINT)
 
@@ -184,9 +718,29 @@ func mainMonitor(conf Config) {
 	}
 }
 
-// configureLogger sets the default logger with an optional debug log level and
-// JSON encoded output, useful for the forked off childs.
-func configureLogger(debug, jsonOutput bool) {
+// openLogFile opens the optional log file as a rotatingFile. It must be called
+// before dropping privileges.
+func openLogFile(conf Config) (*rotatingFile, error) {
+	if conf.Log.File == "" {
+		return nil, nil
+	}
+
+	var maxSize int64
+	if conf.Log.MaxSize != "" {
+		var err error
+		maxSize, err = ParseBytesize(conf.Log.MaxSize)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse log max_size %q: %w", conf.Log.MaxSize, err)
+		}
+	}
+
+	return newRotatingFile(conf.Log.File, maxSize, conf.Log.MaxAge, conf.Log.MaxBackups)
+}
+
+// configureLogger sets the default logger writing to out with an optional
+// debug log level and JSON encoded output. The forked off childs log JSON to
+// stderr, being parsed by the monitor, which logs in the configured format.
+func configureLogger(debug, jsonOutput bool, out io.Writer) {
 	loggerLevel := new(slog.LevelVar)
 	if debug {
 		loggerLevel.Set(slog.LevelDebug)
@@ -196,28 +750,52 @@ func configureLogger(debug, jsonOutput bool) {
 
 	var logger *slog.Logger
 	if jsonOutput {
-		logger = slog.New(slog.NewJSONHandler(os.Stderr, handlerOpts))
+		logger = slog.New(slog.NewJSONHandler(out, handlerOpts))
 	} else {
-		logger = slog.New(slog.NewTextHandler(os.Stdout, handlerOpts))
+		logger = slog.New(slog.NewTextHandler(out, handlerOpts))
 	}
 
 	slog.SetDefault(logger)
 }
 
+// version might be set during the build, e.g., by
+// "go build -ldflags '-X main.version=v1.2.3'".
+var version string
+
+// buildVersion returns the version set during the build or, otherwise, the
+// main module's version from the build information, "(devel)" for a checkout.
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+	return "(unknown)"
+}
+
 func main() {
 	var (
-		flagConfig    string
-		flagForkChild string
-		flagVerbose   bool
+		flagConfig          string
+		flagCheckConfig     bool
+		flagForkChild       string
+		flagSocketActivated bool
+		flagVerbose         bool
 	)
 
 	flag.StringVar(&flagConfig, "config", "", "YAML configuration file")
+	flag.BoolVar(&flagCheckConfig, "check-config", false, "Check the configuration and print it")
 	flag.StringVar(&flagForkChild, "fork-child", "", "Start a subprocess child")
+	flag.BoolVar(&flagSocketActivated, "socket-activated", false, "Web server child uses the passed socket")
 	flag.BoolVar(&flagVerbose, "verbose", false, "Verbose logging")
 
 	flag.Parse()
 
-	configureLogger(flagVerbose, flagForkChild != "")
+	if flagForkChild != "" {
+		configureLogger(flagVerbose, true, os.Stderr)
+	} else {
+		configureLogger(flagVerbose, false, os.Stdout)
+	}
 
 	conf, err := loadConfig(flagConfig)
 	if err != nil {
@@ -225,9 +803,36 @@ func main() {
 		os.Exit(1)
 	}
 
+	err = conf.Validate()
+	if err != nil && !flagCheckConfig {
+		slog.Error("Failed to validate configuration", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	if flagCheckConfig {
+		mainCheckConfig(conf)
+		return
+	}
+
+	// Only the monitor writes to the log file, as it aggregates all logs.
+	if flagForkChild == "" {
+		logFile, err := openLogFile(conf)
+		if err != nil {
+			slog.Error("Failed to open log file", slog.Any("error", err))
+			os.Exit(1)
+		}
+
+		var out io.Writer = os.Stdout
+		if logFile != nil {
+			defer logFile.Close()
+			out = io.MultiWriter(os.Stdout, logFile)
+		}
+		configureLogger(flagVerbose, conf.Log.Format == LogFormatJson, out)
+	}
+
 	switch flagForkChild {
 	case "webserver":
-		mainWebserver(conf)
+		mainWebserver(conf, flagSocketActivated)
 
 	case "store":
 		mainStore(conf)
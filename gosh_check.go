@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+	"gopkg.in/yaml.v3"
+)
+
+// checkStorePath verifies that the store directory is writable or, if it does
+// not exist yet, that it can be created within its parent.
+func checkStorePath(path string) error {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		path = filepath.Dir(path)
+	} else if err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return fmt.Errorf("%q is no directory", path)
+	}
+
+	if err := unix.Access(path, unix.W_OK); err != nil {
+		return fmt.Errorf("%q is not writable: %w", path, err)
+	}
+	return nil
+}
+
+// checkConfig validates the Config like Config.Validate and further checks its
+// referenced files, e.g., the store directory and the ID generator's wordlist.
+// All found problems are reported at once.
+func checkConfig(conf Config) error {
+	problems := conf.problems()
+
+	if err := checkStorePath(conf.Store.Path); err != nil {
+		problems = append(problems, fmt.Sprintf("invalid store path: %v", err))
+	}
+
+	if idGenerator := conf.Store.IdGenerator; idGenerator.Type == "wordlist" {
+		if _, err := wordlistIdGenerator(idGenerator.File, idGenerator.Length); err != nil {
+			problems = append(problems, fmt.Sprintf("cannot load ID generator wordlist %q: %v", idGenerator.File, err))
+		}
+	}
+
+	for key, sfc := range conf.Webserver.StaticFiles {
+		if _, err := os.Stat(sfc.Path); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid static file %q: %v", key, err))
+		}
+	}
+
+	if err := conf.Webserver.loadIndex(); err != nil {
+		problems = append(problems, fmt.Sprintf("cannot load custom index template: %v", err))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// redactedConfig returns a copy of the Config without its credentials, e.g.,
+// tokens and keys, to be printed.
+func redactedConfig(conf Config) Config {
+	redact := func(s string) string {
+		return Secret(s).String()
+	}
+
+	conf.Store.Backend.S3.SecretKey = redact(conf.Store.Backend.S3.SecretKey)
+	conf.Webserver.Admin.Token = redact(conf.Webserver.Admin.Token)
+	conf.Webserver.UploadAuth.Token = redact(conf.Webserver.UploadAuth.Token)
+	conf.Webserver.AbuseReports.Smtp.Password = redact(conf.Webserver.AbuseReports.Smtp.Password)
+
+	if users := conf.Webserver.UploadAuth.Users; users != nil {
+		conf.Webserver.UploadAuth.Users = make(map[string]string, len(users))
+		for user, hash := range users {
+			conf.Webserver.UploadAuth.Users[user] = redact(hash)
+		}
+	}
+
+	return conf
+}
+
+// mainCheckConfig checks the Config and prints the effective configuration,
+// including defaults, without starting any process. It exits with a failure
+// status for an invalid configuration.
+func mainCheckConfig(conf Config) {
+	out, err := yaml.Marshal(redactedConfig(conf))
+	if err != nil {
+		slog.Error("Failed to encode effective configuration", slog.Any("error", err))
+		os.Exit(1)
+	}
+	fmt.Print(string(out))
+
+	err = checkConfig(conf)
+	if err != nil {
+		slog.Error("Failed to check configuration", slog.Any("error", err))
+		os.Exit(1)
+	}
+	slog.Info("Configuration is valid")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	currentUser, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	currentGroup, err := user.LookupGroupId(currentUser.Gid)
+	if err != nil {
+		t.Skip(err)
+	}
+
+	dir := t.TempDir()
+	notADir := filepath.Join(dir, "file")
+	if err := os.WriteFile(notADir, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	validConfig := func() Config {
+		var conf Config
+		conf.User = currentUser.Username
+		conf.Group = currentGroup.Name
+		conf.Store.Path = filepath.Join(dir, "store")
+		conf.Store.IdGenerator = IdGeneratorConfig{Type: "random", Length: 8}
+		conf.Webserver.Protocol = "http"
+		conf.Webserver.Contact = "nobody@example.com"
+		conf.Webserver.ItemConfig.MaxSize = "10MiB"
+		return conf
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		errMsg string
+	}{
+		{"valid", func(*Config) {}, ""},
+		{"max size", func(conf *Config) { conf.Webserver.ItemConfig.MaxSize = "10 parsecs" }, "item_config max_size"},
+		{"unknown user", func(conf *Config) { conf.User = "gosh-check-config-nobody" }, "cannot resolve user"},
+		{"store path", func(conf *Config) { conf.Store.Path = notADir }, "invalid store path"},
+		{"id generator", func(conf *Config) { conf.Store.IdGenerator.Type = "sequential" }, "unknown ID generator type"},
+		{"wordlist", func(conf *Config) {
+			conf.Store.IdGenerator = IdGeneratorConfig{Type: "wordlist", File: filepath.Join(dir, "nope"), Length: 3}
+		}, "cannot load ID generator wordlist"},
+		{"static file", func(conf *Config) {
+			conf.Webserver.StaticFiles = map[string]StaticFileConfig{"/x": {Path: filepath.Join(dir, "nope")}}
+		}, "invalid static file"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := validConfig()
+			test.modify(&conf)
+
+			err := checkConfig(conf)
+			if test.errMsg == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if test.errMsg != "" && (err == nil || !strings.Contains(err.Error(), test.errMsg)) {
+				t.Fatalf("expected error containing %q, got %v", test.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestRedactedConfig(t *testing.T) {
+	var conf Config
+	conf.Secret = Secret(strings.Repeat("s", secretMinLength))
+	conf.Store.Backend.S3.SecretKey = "s3-secret"
+	conf.Webserver.Admin.Token = "admin-token"
+	conf.Webserver.UploadAuth.Token = "upload-token"
+	conf.Webserver.AbuseReports.Smtp.Password = "smtp-password"
+	conf.Webserver.UploadAuth.Users = map[string]string{"alice": "bcrypt-hash"}
+
+	redacted := redactedConfig(conf)
+	if conf.Webserver.UploadAuth.Users["alice"] != "bcrypt-hash" {
+		t.Fatal("redaction altered the original config")
+	}
+
+	for _, value := range []string{
+		redacted.Store.Backend.S3.SecretKey,
+		redacted.Webserver.Admin.Token,
+		redacted.Webserver.UploadAuth.Token,
+		redacted.Webserver.AbuseReports.Smtp.Password,
+		redacted.Webserver.UploadAuth.Users["alice"],
+	} {
+		if value != "[REDACTED]" {
+			t.Fatalf("expected redacted value, got %q", value)
+		}
+	}
+}
@@ -1,126 +1,320 @@
 package main
 
 import (
+	"crypto/x509"
+	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 
 	"golang.org/x/sys/unix"
 )
 
+// storeDirProblems lists insecure permissions of the store directory and its
+// parents. The store directory must be owned by the uid and must not be
+// accessible by anyone else. Its parents must be owned by either root or the
+// uid and must not be writable by others, except with the sticky bit set.
+func storeDirProblems(path string, uid int) ([]string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for dir := path; ; dir = filepath.Dir(dir) {
+		fi, err := os.Stat(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		stat, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil, fmt.Errorf("cannot inspect owner of %q", dir)
+		}
+		owner := int(stat.Uid)
+		mode := fi.Mode()
+
+		if dir == path {
+			if owner != uid {
+				problems = append(problems, fmt.Sprintf("%q is owned by %d instead of %d", dir, owner, uid))
+			}
+			if mode.Perm()&0077 != 0 {
+				problems = append(problems, fmt.Sprintf("%q is accessible by group or others, mode %v", dir, mode.Perm()))
+			}
+		} else {
+			if owner != 0 && owner != uid {
+				problems = append(problems, fmt.Sprintf("parent %q is owned by %d instead of root or %d", dir, owner, uid))
+			}
+			if mode.Perm()&0022 != 0 && mode&os.ModeSticky == 0 {
+				problems = append(problems, fmt.Sprintf("parent %q is writable by group or others, mode %v", dir, mode.Perm()))
+			}
+		}
+
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+	return problems, nil
+}
+
 // ensureStoreDir makes sure that a store directory exists and it holds the
-// correct permissions.
-func ensureStoreDir(path, username, groupname string) error {
-	_, stat := os.Stat(path)
+// correct permissions. Afterwards, the permissions of the directory and its
+// parents are verified. Problems are logged as warnings or, if strict, result
+// in an error.
+func ensureStoreDir(path, username, groupname string, strict bool) error {
+	uid, gid, err := uidGidForUserGroup(username, groupname)
+	if err != nil {
+		return err
+	}
+
+	fi, stat := os.Stat(path)
 	if os.IsNotExist(stat) {
 		err := os.Mkdir(path, 0700)
 		if err != nil {
 			return err
 		}
+	} else if stat == nil {
+		sysStat, ok := fi.Sys().(*syscall.Stat_t)
+		if (ok && int(sysStat.Uid) != uid) || fi.Mode().Perm()&0077 != 0 {
+			slog.Warn("Store directory had insecure permissions, fixing them",
+				slog.String("path", path), slog.Any("mode", fi.Mode().Perm()))
+		}
 	}
 
-	err := os.Chmod(path, 0700)
+	err = os.Chmod(path, 0700)
 	if err != nil {
 		return err
 	}
 
-	uid, gid, err := uidGidForUserGroup(username, groupname)
+	err = os.Chown(path, uid, gid)
 	if err != nil {
 		return err
 	}
-	err = os.Chown(path, uid, gid)
+
+	problems, err := storeDirProblems(path, uid)
 	if err != nil {
 		return err
 	}
+	if strict && len(problems) > 0 {
+		return fmt.Errorf("insecure store directory permissions: %s", strings.Join(problems, "; "))
+	}
+	for _, problem := range problems {
+		slog.Warn("Insecure store directory permissions", slog.String("problem", problem))
+	}
 
 	return nil
 }
 
+// newIdGenerator creates the ID generator of the configured type.
+func newIdGenerator(conf IdGeneratorConfig) (func() (string, error), error) {
+	switch conf.Type {
+	case "random":
+		return randomIdGenerator(conf.Length), nil
+
+	case "wordlist":
+		idGenerator, err := wordlistIdGenerator(conf.File, conf.Length)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create wordlist ID generator from %q: %w", conf.File, err)
+		}
+		return idGenerator, nil
+
+	case "uuid":
+		return uuidIdGenerator(), nil
+
+	case "nanoid":
+		idGenerator, err := nanoidIdGenerator(conf.Alphabet, conf.Length)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create nanoid ID generator: %w", err)
+		}
+		return idGenerator, nil
+
+	default:
+		return nil, fmt.Errorf("unknown ID generator type %q, supported are random, wordlist, uuid, nanoid", conf.Type)
+	}
+}
+
 func mainStore(conf Config) {
 	slog.Debug("Starting store child", slog.Any("config", conf.Store))
 
-	var idGenerator func() (string, error)
-	switch conf.Store.IdGenerator.Type {
-	case "random":
-		idGenerator = randomIdGenerator(conf.Store.IdGenerator.Length)
+	idGenerator, err := newIdGenerator(conf.Store.IdGenerator)
+	if err != nil {
+		slog.Error("Failed to configure an ID generator", slog.Any("error", err))
+		os.Exit(1)
+	}
 
-	case "wordlist":
+	var storeOpts = StoreOpts{
+		AutoCleanup:     true,
+		CleanupInterval: conf.Store.CleanupInterval,
+		ShardDepth:      conf.Store.ShardDepth,
+		DeletionRetries: conf.Store.DeletionRetries,
+		QuotaPolicy:     conf.Store.QuotaPolicy,
+		Reconcile:       conf.Store.Reconcile,
+	}
+
+	if conf.Store.MaxStoreSize != "" {
+		maxStoreSize, err := ParseBytesize(conf.Store.MaxStoreSize)
+		if err != nil {
+			slog.Error("Failed to parse max_store_size",
+				slog.String("max_store_size", conf.Store.MaxStoreSize), slog.Any("error", err))
+			os.Exit(1)
+		}
+		storeOpts.MaxStoreSize = maxStoreSize
+	}
+
+	var webhook *Webhook
+	if conf.Store.DeletionHook.Url != "" {
 		var err error
-		idGenerator, err = wordlistIdGenerator(conf.Store.IdGenerator.File, conf.Store.IdGenerator.Length)
+		webhook, err = NewWebhook(conf.Store.DeletionHook)
 		if err != nil {
-			slog.Error("Failed to create wordlist ID generator", slog.Any("error", err))
+			slog.Error("Failed to create deletion hook", slog.Any("error", err))
 			os.Exit(1)
 		}
 
+		storeOpts.DeletionHook = webhook.Notify
+	}
+
+	var s3Backend bool
+	switch conf.Store.Backend.Type {
+	case "", "file":
+	case "s3":
+		s3Backend = true
+
+		// The certificate pool cannot be loaded after chroot'ing.
+		_, err := x509.SystemCertPool()
+		if err != nil {
+			slog.Warn("Failed to load system certificate pool for S3 backend", slog.Any("error", err))
+		}
+
 	default:
-		slog.Error("Failed to configure an ID generator as the type is unknown",
-			slog.String("type", conf.Store.IdGenerator.Type))
+		slog.Error("Failed to configure a backend as the type is unknown",
+			slog.String("type", conf.Store.Backend.Type))
 		os.Exit(1)
 	}
 
-	err := ensureStoreDir(conf.Store.Path, conf.User, conf.Group)
+	err = ensureStoreDir(conf.Store.Path, conf.User, conf.Group, conf.Store.StrictPermissions)
 	if err != nil {
 		slog.Error("Failed to prepare store directory", slog.Any("error", err))
 		os.Exit(1)
 	}
 
+	nofile, err := raiseNofileLimit()
+	if err != nil {
+		slog.Error("Failed to raise file descriptor limit", slog.Any("error", err))
+		os.Exit(1)
+	}
+	slog.Debug("Raised file descriptor limit", slog.Any("soft", nofile.Cur), slog.Any("hard", nofile.Max))
+
+	if conf.Store.MaxFdTransfers > 0 && uint64(conf.Store.MaxFdTransfers) >= uint64(nofile.Cur) {
+		slog.Warn("Maximum concurrent file transfers exceed the file descriptor limit",
+			slog.Int("max_fd_transfers", conf.Store.MaxFdTransfers), slog.Any("limit", nofile.Cur))
+	}
+
 	err = posixPermDrop(conf.Store.Path, conf.User, conf.Group)
 	if err != nil {
 		slog.Error("Failed to drop permissions", slog.Any("error", err))
 		os.Exit(1)
 	}
 
-	err = restrict(restrict_linux_seccomp,
-		[]string{
-			"@system-service",
-			"~@chown",
-			"~@clock",
-			"~@cpu-emulation",
-			"~@debug",
-			"~@keyring",
-			"~@memlock",
-			"~@module",
-			"~@mount",
-			"~@privileged",
-			"~@reboot",
-			"~@sandbox",
-			"~@setuid",
-			"~@swap",
-			/* @process */ "~execve", "~execveat", "~fork", "~kill",
-			/* @network-io */ "~bind", "~connect", "~listen",
-			"fstatat", // for aarch64, same as newfstatat
-		})
+	seccompFilter := []string{
+		"@system-service",
+		"~@chown",
+		"~@clock",
+		"~@cpu-emulation",
+		"~@debug",
+		"~@keyring",
+		"~@memlock",
+		"~@module",
+		"~@mount",
+		"~@privileged",
+		"~@reboot",
+		"~@sandbox",
+		"~@setuid",
+		"~@swap",
+		/* @process */ "~execve", "~execveat", "~fork", "~kill",
+		/* @network-io */ "~bind", "~listen",
+		"fstatat", // for aarch64, same as newfstatat
+	}
+	pledgePromises := "stdio rpath wpath cpath flock unix sendfd recvfd error"
+
+	// Outgoing connections are only necessary for the deletion hook and the
+	// S3 backend.
+	if webhook != nil || s3Backend {
+		pledgePromises += " inet"
+	} else {
+		seccompFilter = append(seccompFilter, "~connect")
+	}
+	seccompFilter = append(seccompFilter, conf.Hardening.StoreSyscalls...)
+
+	err = restrict(restrict_linux_seccomp, seccompFilter)
 	if err != nil {
 		slog.Error("Failed to apply seccomp-bpf filter", slog.Any("error", err))
 		os.Exit(1)
 	}
 
-	err = restrict(restrict_openbsd_pledge,
-		"stdio rpath wpath cpath flock unix sendfd recvfd error",
-		"")
+	err = restrict(restrict_openbsd_pledge, pledgePromises, "")
 	if err != nil {
 		slog.Error("Failed to pledge", slog.Any("error", err))
 		os.Exit(1)
 	}
 
-	store, err := NewStore("/", idGenerator, true)
+	if s3Backend {
+		storeOpts.Backend, err = NewS3Backend(conf.Store.Backend.S3, filepath.Join("/", DirSpool))
+		if err != nil {
+			slog.Error("Failed to create S3 backend", slog.Any("error", err))
+			os.Exit(1)
+		}
+	}
+
+	var store Storer
+	switch conf.Store.Type {
+	case "", "badger":
+		store, err = NewStore("/", idGenerator, storeOpts)
+		if err != nil {
+			slog.Error("Failed to create store", slog.Any("error", err))
+			os.Exit(1)
+		}
+
+	case "memory":
+		if storeOpts.MaxStoreSize > 0 {
+			slog.Warn("The memory store does not support max_store_size, ignoring it")
+		}
+		if storeOpts.Backend != nil {
+			slog.Warn("The memory store does not support backends, ignoring it")
+		}
+		store = NewMemoryStore(idGenerator, storeOpts)
+
+	default:
+		slog.Error("Failed to create store as the type is unknown", slog.String("type", conf.Store.Type))
+		os.Exit(1)
+	}
+
+	// The store cannot enter the capability mode as both the database and the
+	// file backend open files by their path. Thus, only the sockets' rights
+	// are limited.
+	rpcFile, fdFile := os.NewFile(3, ""), os.NewFile(4, "")
+	err = restrict(restrict_freebsd_capsicum,
+		[]syscall.Conn{rpcFile, fdFile}, capsicumSocketRights, false)
 	if err != nil {
-		slog.Error("Failed to create store", slog.Any("error", err))
+		slog.Error("Failed to limit capability rights", slog.Any("error", err))
 		os.Exit(1)
 	}
 
-	rpcConn, err := unixConnFromFile(os.NewFile(3, ""))
+	rpcConn, err := unixConnFromFile(rpcFile)
 	if err != nil {
 		slog.Error("Failed to create Unix Domain Socket from FD", slog.Any("error", err))
 		os.Exit(1)
 	}
-	fdConn, err := unixConnFromFile(os.NewFile(4, ""))
+	fdConn, err := unixConnFromFile(fdFile)
 	if err != nil {
 		slog.Error("Failed to create Unix Domain Socket from FD", slog.Any("error", err))
 		os.Exit(1)
 	}
 
-	rpcStore := NewStoreRpcServer(store, rpcConn, fdConn)
+	rpcStore := NewStoreRpcServer(store, rpcConn, fdConn, conf.Store.MaxFdTransfers)
+	slog.Info("Store is ready to serve requests")
 
 	sigint := make(chan os.Signal, 1)
 	signal.Notify(sigint, unix.SIGINT)
@@ -131,4 +325,8 @@ func mainStore(conf Config) {
 		slog.Error("Failed to close RPC Store", slog.Any("error", err))
 		os.Exit(1)
 	}
+
+	if webhook != nil {
+		_ = webhook.Close()
+	}
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestStoreDirProblems(t *testing.T) {
+	uid := os.Getuid()
+
+	tests := []struct {
+		name       string
+		parentMode os.FileMode
+		dirMode    os.FileMode
+		uid        int
+		problems   bool
+	}{
+		{"secure", 0755, 0700, uid, false},
+		{"sticky parent", 0777 | os.ModeSticky, 0700, uid, false},
+		{"group readable", 0755, 0750, uid, true},
+		{"world readable", 0755, 0705, uid, true},
+		{"group writable parent", 0775, 0700, uid, true},
+		{"world writable parent", 0777, 0700, uid, true},
+		{"wrong owner", 0755, 0700, uid + 1, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "store")
+			if err := os.Mkdir(dir, 0700); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.Chmod(parent, test.parentMode); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(dir, test.dirMode); err != nil {
+				t.Fatal(err)
+			}
+
+			problems, err := storeDirProblems(dir, test.uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Only consider problems within the test's directories, as the
+			// temporary directory's own parents are out of our hands.
+			var relevant []string
+			for _, problem := range problems {
+				for _, path := range []string{dir, parent} {
+					if strings.Contains(problem, strconv.Quote(path)) {
+						relevant = append(relevant, problem)
+						break
+					}
+				}
+			}
+
+			if (len(relevant) > 0) != test.problems {
+				t.Fatalf("expected problems %t, got %v", test.problems, relevant)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigMimeConflicts(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		valid  bool
+	}{
+		{"empty", "webserver: {}", true},
+		{"distinct", `
+webserver:
+  item_config:
+    mime_drop: ["application/x-msdownload"]
+    mime_map: {"text/html": "text/plain"}
+    type_overrides: ["text/plain"]
+`, true},
+		{"drop-and-map", `
+webserver:
+  item_config:
+    mime_drop: ["text/html"]
+    mime_map: {"text/html": "text/plain"}
+`, false},
+		{"drop-and-map-case", `
+webserver:
+  item_config:
+    mime_drop: ["Text/HTML"]
+    mime_map: {"text/html": "text/plain"}
+`, false},
+		{"map-twice-case", `
+webserver:
+  item_config:
+    mime_map: {"text/html": "text/plain", "TEXT/HTML": "text/plain"}
+`, false},
+		{"drop-and-override", `
+webserver:
+  item_config:
+    mime_drop: ["application/json"]
+    type_overrides: ["application/json"]
+`, false},
+	}
+
+	dir := t.TempDir()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configFile := filepath.Join(dir, "gosh.yml")
+			if err := os.WriteFile(configFile, []byte(test.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := loadConfig(configFile)
+			if (err == nil) != test.valid {
+				t.Fatalf("Expected valid %t, got error %v", test.valid, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigExample(t *testing.T) {
+	if _, err := loadConfig("gosh.yml"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfigLogFormat(t *testing.T) {
+	dir := t.TempDir()
+	for config, valid := range map[string]bool{
+		"log: {}":               true,
+		"log: {format: text}":   true,
+		"log: {format: json}":   true,
+		"log: {format: syslog}": false,
+	} {
+		configFile := filepath.Join(dir, "gosh.yml")
+		if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := loadConfig(configFile)
+		if (err == nil) != valid {
+			t.Fatalf("%s: expected valid %t, got error %v", config, valid, err)
+		}
+	}
+}
+
+func TestLoadConfigHardening(t *testing.T) {
+	dir := t.TempDir()
+	for config, valid := range map[string]bool{
+		"hardening: {}": true,
+		"hardening: {store_syscalls: ['@network-io']}":              true,
+		"hardening: {webserver_syscalls: ['~@process', 'uname']}":   true,
+		"hardening: {monitor_syscalls: ['~fstatat']}":               true,
+		"hardening: {store_syscalls: ['@network']}":                 false,
+		"hardening: {webserver_syscalls: ['@system-service ~@io']}": false,
+		"hardening: {monitor_syscalls: ['']}":                       false,
+	} {
+		configFile := filepath.Join(dir, "gosh.yml")
+		if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := loadConfig(configFile)
+		if (err == nil) != valid {
+			t.Fatalf("%s: expected valid %t, got error %v", config, valid, err)
+		}
+	}
+}
+
+func TestRequiredNofile(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxConnections int
+		maxFdTransfers int
+		required       uint64
+		ok             bool
+	}{
+		{"unlimited", 0, 0, 0, false},
+		{"unlimited connections", 0, 256, 0, false},
+		{"unlimited transfers", 256, 0, 0, false},
+		{"webserver bound", 256, 256, nofileReserve + 512, true},
+		{"store bound", 16, 256, nofileReserve + 256, true},
+		{"single", 1, 1, nofileReserve + 2, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var conf Config
+			conf.Webserver.MaxConnections = test.maxConnections
+			conf.Store.MaxFdTransfers = test.maxFdTransfers
+
+			required, ok := requiredNofile(conf)
+			if ok != test.ok {
+				t.Fatalf("expected ok %t, got %t", test.ok, ok)
+			}
+			if required != test.required {
+				t.Fatalf("expected %d, got %d", test.required, required)
+			}
+		})
+	}
+}
+
+func TestEnsureNofileLimit(t *testing.T) {
+	nofile, err := ensureNofileLimit(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nofile.Cur < 1 {
+		t.Fatalf("soft limit %d below required limit", nofile.Cur)
+	}
+
+	_, err = ensureNofileLimit(uint64(nofile.Max) + 1)
+	if err == nil && uint64(nofile.Max) != ^uint64(0) {
+		t.Fatal("expected error for a required limit above the hard limit")
+	}
+}
+
+func TestWebserverConfigLoadIndex(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"valid.html":   "<p>{{prettyBytes .MaxSize}}</p>",
+		"invalid.html": "<p>{{.MaxSize</p>",
+		"empty.html":   "\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		index    string
+		indexTpl string
+		valid    bool
+	}{
+		{"embedded", "", "", true},
+		{"valid", "valid.html", "<p>{{prettyBytes .MaxSize}}</p>", true},
+		{"invalid", "invalid.html", "", false},
+		{"empty", "empty.html", "", false},
+		{"missing", "missing.html", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var conf WebserverConfig
+			if test.index != "" {
+				conf.CustomIndex = filepath.Join(dir, test.index)
+			}
+
+			err := conf.loadIndex()
+			if (err == nil) != test.valid {
+				t.Fatalf("Expected valid %t, got error %v", test.valid, err)
+			} else if conf.indexTpl != test.indexTpl {
+				t.Fatalf("Expected index template %q, got %q", test.indexTpl, conf.indexTpl)
+			}
+		})
+	}
+}
+
+func TestLoadConfigTls(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		valid  bool
+	}{
+		{"disabled", "webserver: {protocol: http}", true},
+		{"http", "webserver: {protocol: http, tls: {cert_file: cert.pem, key_file: key.pem}}", true},
+		{"fcgi", "webserver: {protocol: fcgi, tls: {cert_file: cert.pem, key_file: key.pem}}", false},
+		{"no key", "webserver: {protocol: http, tls: {cert_file: cert.pem}}", false},
+		{"no cert", "webserver: {protocol: http, tls: {key_file: key.pem}}", false},
+	}
+
+	dir := t.TempDir()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configFile := filepath.Join(dir, "gosh.yml")
+			if err := os.WriteFile(configFile, []byte(test.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := loadConfig(configFile)
+			if (err == nil) != test.valid {
+				t.Fatalf("Expected valid %t, got error %v", test.valid, err)
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	currentUser, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	currentGroup, err := user.LookupGroupId(currentUser.Gid)
+	if err != nil {
+		t.Skip(err)
+	}
+
+	var conf Config
+	conf.User = currentUser.Username
+	conf.Group = currentGroup.Name
+	conf.Store.Path = "/var/lib/gosh"
+	conf.Store.IdGenerator = IdGeneratorConfig{Type: "random", Length: 8}
+	conf.Webserver.Listen.Protocol = "tcp"
+	conf.Webserver.Listen.Bound = ":8080"
+	conf.Webserver.Protocol = "http"
+	conf.Webserver.Contact = "nobody@example.com"
+	conf.Webserver.ItemConfig.MaxSize = "10MiB"
+
+	if err := conf.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	conf.Store.IdGenerator.Type = "sequential"
+	conf.Webserver.Listen.Protocol = "udp"
+	conf.Webserver.Protocol = "gopher"
+	conf.Webserver.Contact = ""
+	conf.Webserver.ItemConfig.MaxSize = "lots"
+	conf.Webserver.AdminListen.Protocol = "tcp"
+
+	err = conf.Validate()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, problem := range []string{
+		`unknown ID generator type "sequential"`,
+		`unknown webserver listen protocol "udp"`,
+		`unknown webserver protocol "gopher"`,
+		"webserver contact is required",
+		`cannot parse webserver item_config max_size "lots"`,
+		"webserver admin_listen bound is required",
+		"webserver admin_listen requires a metrics_path or admin path",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Fatalf("error %q does not list %q", err, problem)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"io/fs"
@@ -10,6 +12,8 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
 	"golang.org/x/sys/unix"
 )
@@ -80,38 +84,98 @@ func mkListenSocket(protocol, bound, unixChmod, unixOwner, unixGroup string) (*o
 	}
 }
 
-func mainWebserver(conf Config) {
+// sdListenFdsStart is the first passed file descriptor for systemd's socket
+// activation, SD_LISTEN_FDS_START in sd_listen_fds(3).
+const sdListenFdsStart = 3
+
+// systemdListenFile returns the listening socket passed by systemd's socket
+// activation, or nil if this process was not socket activated.
+//
+// The protocol is described in sd_listen_fds(3). Exactly one stream socket is
+// supported. The environment variables are being unset afterwards and the FD
+// is marked as close-on-exec as it must be passed explicitly to the child.
+func systemdListenFile() (*os.File, error) {
+	defer func() {
+		for _, env := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+			_ = os.Unsetenv(env)
+		}
+	}()
+
+	listenPid, listenFds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	if listenPid == "" || listenFds == "" {
+		return nil, nil
+	}
+
+	pid, err := strconv.Atoi(listenPid)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse LISTEN_PID %q: %v", listenPid, err)
+	} else if pid != os.Getpid() {
+		return nil, nil
+	}
+
+	fds, err := strconv.Atoi(listenFds)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse LISTEN_FDS %q: %v", listenFds, err)
+	} else if fds != 1 {
+		return nil, fmt.Errorf("exactly one socket is supported, got %d", fds)
+	}
+
+	err = checkStreamSocket(sdListenFdsStart)
+	if err != nil {
+		return nil, err
+	}
+
+	unix.CloseOnExec(sdListenFdsStart)
+	return os.NewFile(sdListenFdsStart, "systemd-socket"), nil
+}
+
+// checkStreamSocket verifies that the file descriptor is a stream socket.
+func checkStreamSocket(fd int) error {
+	sockType, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TYPE)
+	if err != nil {
+		return fmt.Errorf("file descriptor %d is no socket: %v", fd, err)
+	} else if sockType != unix.SOCK_STREAM {
+		return fmt.Errorf("file descriptor %d is no stream socket", fd)
+	}
+	return nil
+}
+
+func mainWebserver(conf Config, socketActivated bool) {
 	slog.Debug("Starting webserver child", slog.Any("config", conf.Webserver))
 
-	rpcConn, err := unixConnFromFile(os.NewFile(3, ""))
+	rpcFile, fdFile := os.NewFile(3, ""), os.NewFile(4, "")
+	err := restrict(restrict_freebsd_capsicum,
+		[]syscall.Conn{rpcFile, fdFile}, capsicumSocketRights, false)
+	if err != nil {
+		slog.Error("Failed to limit capability rights", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	rpcConn, err := unixConnFromFile(rpcFile)
 	if err != nil {
 		slog.Error("Failed to prepare store directory", slog.Any("error", err))
 		os.Exit(1)
 	}
-	fdConn, err := unixConnFromFile(os.NewFile(4, ""))
+	fdConn, err := unixConnFromFile(fdFile)
 	if err != nil {
 		slog.Error("Failed to prepare store directory", slog.Any("error", err))
 		os.Exit(1)
 	}
 
-	storeClient := NewStoreRpcClient(rpcConn, fdConn)
-
-	indexTpl := ""
-	if conf.Webserver.CustomIndex != "" {
-		f, err := os.Open(conf.Webserver.CustomIndex)
-		if err != nil {
-			slog.Error("Failed to open custom index file", slog.Any("error", err))
-			os.Exit(1)
-		}
-
-		indexTplRaw, err := io.ReadAll(f)
-		if err != nil {
-			slog.Error("Failed to read custom index file", slog.Any("error", err))
-			os.Exit(1)
-		}
-		_ = f.Close()
-
-		indexTpl = string(indexTplRaw)
+	storeClient := NewStoreRpcClient(rpcConn, fdConn, StoreRpcClientOpts{
+		Timeout:       conf.Webserver.StoreTimeout,
+		GetTimeout:    conf.Webserver.StoreTimeouts.Get,
+		PutTimeout:    conf.Webserver.StoreTimeouts.Put,
+		DeleteTimeout: conf.Webserver.StoreTimeouts.Delete,
+	})
+
+	if conf.Webserver.RpcStatsInterval > 0 {
+		stats := storeClient.EnableStats()
+		go func() {
+			for range time.Tick(conf.Webserver.RpcStatsInterval) {
+				stats.LogSummary()
+			}
+		}()
 	}
 
 	for k, sfc := range conf.Webserver.StaticFiles {
@@ -133,23 +197,59 @@ func mainWebserver(conf Config) {
 		conf.Webserver.StaticFiles[k] = sfc
 	}
 
-	maxFilesize, err := ParseBytesize(conf.Webserver.ItemConfig.MaxSize)
+	err = conf.Webserver.loadIndex()
 	if err != nil {
-		slog.Error("Failed to parse byte size", slog.Any("error", err))
+		slog.Error("Failed to load custom index template", slog.Any("error", err))
 		os.Exit(1)
 	}
 
-	mimeDrop := make(map[string]struct{})
-	for _, key := range conf.Webserver.ItemConfig.MimeDrop {
-		mimeDrop[key] = struct{}{}
+	// The TLS files are unavailable after dropping privileges.
+	if conf.Webserver.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(conf.Webserver.TLS.CertFile, conf.Webserver.TLS.KeyFile)
+		if err != nil {
+			slog.Error("Failed to load TLS certificate", slog.Any("error", err))
+			os.Exit(1)
+		}
+		conf.Webserver.tlsCertificate = &cert
 	}
 
-	fd, err := mkListenSocket(
-		conf.Webserver.Listen.Protocol, conf.Webserver.Listen.Bound,
-		conf.Webserver.UnixSocket.Chmod, conf.Webserver.UnixSocket.Owner, conf.Webserver.UnixSocket.Group)
-	if err != nil {
-		slog.Error("Failed to create listening socket", slog.Any("error", err))
-		os.Exit(1)
+	var fd *os.File
+	if socketActivated {
+		slog.Debug("Using passed socket from systemd socket activation")
+
+		fd = os.NewFile(5, "systemd-socket")
+	} else {
+		fd, err = mkListenSocket(
+			conf.Webserver.Listen.Protocol, conf.Webserver.Listen.Bound,
+			conf.Webserver.UnixSocket.Chmod, conf.Webserver.UnixSocket.Owner, conf.Webserver.UnixSocket.Group)
+		if err != nil {
+			slog.Error("Failed to create listening socket", slog.Any("error", err))
+			os.Exit(1)
+		}
+	}
+
+	// The optional admin listener serves the metrics and admin endpoints apart
+	// from the public listener, e.g., bound to localhost.
+	var adminFd *os.File
+	if conf.Webserver.AdminListen.Protocol != "" {
+		adminFd, err = mkListenSocket(
+			conf.Webserver.AdminListen.Protocol, conf.Webserver.AdminListen.Bound,
+			conf.Webserver.UnixSocket.Chmod, conf.Webserver.UnixSocket.Owner, conf.Webserver.UnixSocket.Group)
+		if err != nil {
+			slog.Error("Failed to create admin listening socket", slog.Any("error", err))
+			os.Exit(1)
+		}
+	}
+
+	// Abuse reports are mailed by an outgoing SMTP connection, possibly using
+	// STARTTLS, and the webhook POSTs to its URL. The certificate pool cannot
+	// be loaded after chroot'ing.
+	outgoingConns := conf.Webserver.AbuseReports.Enabled || conf.Webserver.Webhook.Url != ""
+	if outgoingConns {
+		_, err := x509.SystemCertPool()
+		if err != nil {
+			slog.Warn("Failed to load system certificate pool for outgoing connections", slog.Any("error", err))
+		}
 	}
 
 	bottomlessPit, err := os.MkdirTemp("", "gosh-webserver-chroot")
@@ -163,49 +263,64 @@ func mainWebserver(conf Config) {
 		os.Exit(1)
 	}
 
-	err = restrict(restrict_linux_seccomp,
-		[]string{
-			"@system-service",
-			"~@chown",
-			"~@clock",
-			"~@cpu-emulation",
-			"~@debug",
-			"~@keyring",
-			"~@memlock",
-			"~@module",
-			"~@mount",
-			"~@privileged",
-			"~@reboot",
-			"~@sandbox",
-			"~@setuid",
-			"~@swap",
-			/* @process */ "~execve", "~execveat", "~fork", "~kill",
-			/* @network-io */ "~bind", "~connect", "~listen",
-		})
+	seccompFilter := []string{
+		"@system-service",
+		"~@chown",
+		"~@clock",
+		"~@cpu-emulation",
+		"~@debug",
+		"~@keyring",
+		"~@memlock",
+		"~@module",
+		"~@mount",
+		"~@privileged",
+		"~@reboot",
+		"~@sandbox",
+		"~@setuid",
+		"~@swap",
+		/* @process */ "~execve", "~execveat", "~fork", "~kill",
+		/* @network-io */ "~bind", "~listen",
+	}
+	pledgePromises := "stdio unix sendfd recvfd error"
+
+	if outgoingConns {
+		pledgePromises += " inet"
+	} else {
+		seccompFilter = append(seccompFilter, "~connect")
+	}
+	seccompFilter = append(seccompFilter, conf.Hardening.WebserverSyscalls...)
+
+	err = restrict(restrict_linux_seccomp, seccompFilter)
 	if err != nil {
 		slog.Error("Failed to apply seccomp-bpf filter", slog.Any("error", err))
 		os.Exit(1)
 	}
 
-	err = restrict(restrict_openbsd_pledge,
-		"stdio unix sendfd recvfd error",
-		"")
+	err = restrict(restrict_openbsd_pledge, pledgePromises, "")
 	if err != nil {
 		slog.Error("Failed to pledge", slog.Any("error", err))
 		os.Exit(1)
 	}
 
-	server, err := NewServer(
-		storeClient,
-		maxFilesize,
-		conf.Webserver.ItemConfig.MaxLifetime,
-		conf.Webserver.Contact,
-		mimeDrop,
-		conf.Webserver.ItemConfig.MimeMap,
-		conf.Webserver.UrlPrefix,
-		indexTpl,
-		conf.Webserver.StaticFiles,
-	)
+	// After entering the capability mode, only the already opened file
+	// descriptors are usable. Connections accepted on the listening socket
+	// inherit its rights. As the capability mode forbids outgoing connections,
+	// it is not entered for abuse reports or the webhook.
+	listenFiles := []syscall.Conn{fd}
+	if adminFd != nil {
+		listenFiles = append(listenFiles, adminFd)
+	}
+	err = restrict(restrict_freebsd_capsicum,
+		listenFiles, capsicumSocketRights+" accept", !outgoingConns)
+	if err != nil {
+		slog.Error("Failed to enter capability mode", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	conf.Webserver.secret = conf.Secret
+	conf.Webserver.idGenerator = conf.Store.IdGenerator
+	conf.Webserver.version = buildVersion()
+	server, err := NewServer(storeClient, conf.Webserver, conf.Webserver.indexTpl)
 	if err != nil {
 		slog.Error("Failed to create webserver", slog.Any("error", err))
 		os.Exit(1)
@@ -235,6 +350,16 @@ func mainWebserver(conf Config) {
 		close(serverCh)
 	}()
 
+	if adminFd != nil {
+		go func() {
+			err := server.ServeAdmin(adminFd)
+			if err != nil && err != http.ErrServerClosed {
+				slog.Error("Admin webserver failed to listen", slog.Any("error", err))
+				os.Exit(1)
+			}
+		}()
+	}
+
 	select {
 	case <-sigintCh:
 		slog.Info("Stopping webserver")
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestCheckStreamSocket(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	lnFile, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lnFile.Close()
+
+	if err := checkStreamSocket(int(lnFile.Fd())); err != nil {
+		t.Fatalf("TCP listener was rejected: %v", err)
+	}
+
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+
+	udpFile, err := udpConn.(*net.UDPConn).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpFile.Close()
+
+	if err := checkStreamSocket(int(udpFile.Fd())); err == nil {
+		t.Fatalf("UDP socket was accepted")
+	}
+
+	regularFile, err := os.CreateTemp("", "gosh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(regularFile.Name())
+	defer regularFile.Close()
+
+	if err := checkStreamSocket(int(regularFile.Fd())); err == nil {
+		t.Fatalf("Regular file was accepted")
+	}
+}
+
+func TestSystemdListenFileNotActivated(t *testing.T) {
+	tests := []struct {
+		listenPid string
+		listenFds string
+		valid     bool
+	}{
+		{"", "", true},
+		{strconv.Itoa(os.Getpid() + 1), "1", true},
+		{"nope", "1", false},
+		{strconv.Itoa(os.Getpid()), "nope", false},
+		{strconv.Itoa(os.Getpid()), "2", false},
+	}
+
+	for _, test := range tests {
+		t.Setenv("LISTEN_PID", test.listenPid)
+		t.Setenv("LISTEN_FDS", test.listenFds)
+
+		f, err := systemdListenFile()
+		if (err == nil) != test.valid {
+			t.Fatalf("LISTEN_PID=%q LISTEN_FDS=%q: expected valid %t, got error %v",
+				test.listenPid, test.listenFds, test.valid, err)
+		}
+		if f != nil {
+			t.Fatalf("LISTEN_PID=%q LISTEN_FDS=%q: unexpected file", test.listenPid, test.listenFds)
+		}
+
+		for _, env := range []string{"LISTEN_PID", "LISTEN_FDS"} {
+			if _, ok := os.LookupEnv(env); ok {
+				t.Fatalf("Environment variable %s was not unset", env)
+			}
+		}
+	}
+}
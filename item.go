@@ -1,23 +1,41 @@
 package main
 
 import (
+	"bufio"
+	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"math"
+	"mime"
+	"mime/multipart"
 	"net"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/akamensky/base58"
+	"golang.org/x/crypto/bcrypt"
 )
 
 const (
 	formFile             string = "file"
 	formBurnAfterReading string = "burn"
 	formLifetime         string = "time"
+	formFilename         string = "filename"
+	formMaxDownloads     string = "maxDownloads"
+	formPassword         string = "password"
 )
 
 // OwnerType describes a possible type of an owner, as an IP address. This can
@@ -33,8 +51,103 @@ const (
 // ownerHeaders are all kinds of OwnerTypes which are header fields.
 var ownerHeaders = []OwnerType{Forwarded, XForwardedFor}
 
+// parseTrustedProxies parses a list of CIDRs, e.g., "10.0.0.0/8", or single IP
+// addresses of trusted reverse proxies.
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("cannot parse trusted proxy %q", proxy)
+			}
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse trusted proxy %q: %w", proxy, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// isTrustedProxy checks if the IP address belongs to a trusted proxy.
+func isTrustedProxy(ip net.IP, trustedProxies []*net.IPNet) bool {
+	for _, ipNet := range trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedHops extracts the hops of a header's values, ordered from the
+// client to the last proxy.
+func forwardedHops(headerKey OwnerType, values []string) (hops []string) {
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			element = strings.TrimSpace(element)
+			if element == "" {
+				continue
+			}
+
+			if headerKey == Forwarded && strings.Contains(element, "=") {
+				node := ""
+				for _, pair := range strings.Split(element, ";") {
+					key, val, _ := strings.Cut(strings.TrimSpace(pair), "=")
+					if strings.EqualFold(key, "for") {
+						node = strings.Trim(val, `"`)
+					}
+				}
+				element = node
+			}
+			hops = append(hops, element)
+		}
+	}
+	return
+}
+
+// parseHop parses a single hop's IP address, which might include a port. An
+// unknown or obfuscated hop results in a nil IP address without an error.
+func parseHop(headerKey OwnerType, hop string) (net.IP, error) {
+	if headerKey == Forwarded && (strings.EqualFold(hop, "unknown") || strings.HasPrefix(hop, "_")) {
+		return nil, nil
+	}
+
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	ip := net.ParseIP(strings.Trim(hop, "[]"))
+	if ip == nil {
+		return nil, fmt.Errorf("cannot parse remote IP %q from header %q", hop, headerKey)
+	}
+	return ip, nil
+}
+
+// rightmostUntrustedHop returns the IP address of the right-most hop not being
+// a trusted proxy, as all hops left of it might be forged. If all hops are
+// trusted, the left-most one is returned.
+func rightmostUntrustedHop(headerKey OwnerType, hops []string, trustedProxies []*net.IPNet) (ip net.IP, err error) {
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, err = parseHop(headerKey, hops[i])
+		if err != nil || ip == nil || !isTrustedProxy(ip, trustedProxies) {
+			return
+		}
+	}
+	return
+}
+
 // NewOwnerTypes creates a map of OwnerTypes to IP addresses based on a Request.
-func NewOwnerTypes(r *http.Request) (owners map[OwnerType]net.IP, err error) {
+//
+// Forwarding headers are only considered for a trusted proxy, limited to their
+// right-most maxHops hops unless maxHops is zero.
+func NewOwnerTypes(r *http.Request, trustedProxies []*net.IPNet, maxHops int) (owners map[OwnerType]net.IP, err error) {
 	owners = make(map[OwnerType]net.IP)
 
 	// First, extract the RemoteAddr.
@@ -48,38 +161,94 @@ func NewOwnerTypes(r *http.Request) (owners map[OwnerType]net.IP, err error) {
 		owners[RemoteAddr] = remoteAddrIp
 	}
 
+	if !isTrustedProxy(owners[RemoteAddr], trustedProxies) {
+		return
+	}
+
 	// Then, check f.e. additional IP address indicating header.
 	for _, headerKey := range ownerHeaders {
-		headerVal := r.Header.Get(string(headerKey))
-		if headerVal == "" {
+		hops := forwardedHops(headerKey, r.Header.Values(string(headerKey)))
+		if len(hops) == 0 {
 			continue
+		} else if maxHops > 0 && len(hops) > maxHops {
+			hops = hops[len(hops)-maxHops:]
 		}
 
-		headerIp := net.ParseIP(headerVal)
-		if headerIp == nil {
-			err = fmt.Errorf("cannot parse remote IP %q from header %q", headerVal, headerKey)
+		var headerIp net.IP
+		headerIp, err = rightmostUntrustedHop(headerKey, hops, trustedProxies)
+		if err != nil {
 			return
+		} else if headerIp != nil {
+			owners[headerKey] = headerIp
 		}
-		owners[headerKey] = headerIp
 	}
 
 	return
 }
 
+// clientIP returns the client's IP address, preferring the forwarding headers
+// of a trusted proxy over the RemoteAddr.
+func clientIP(owners map[OwnerType]net.IP) net.IP {
+	for _, ot := range []OwnerType{Forwarded, XForwardedFor, RemoteAddr} {
+		if ip, ok := owners[ot]; ok {
+			return ip
+		}
+	}
+	return nil
+}
+
 // Item describes an uploaded file.
 type Item struct {
 	ID string `badgerhold:"key"`
 
+	// DeletionKey authorizes its uploader to delete the Item or to change its
+	// settings. It is empty if deletion keys were disabled.
 	DeletionKey string
 
 	BurnAfterReading bool
 
+	// BurnAttempts counts failed downloads of a BurnAfterReading Item, starting
+	// at FirstBurnAttempt. They are recorded by the Store's RecordBurnAttempt.
+	BurnAttempts     int
+	FirstBurnAttempt time.Time
+
+	// MaxDownloads limits the Item's Downloads, after which it is deleted. A
+	// value of zero does not limit the Downloads.
+	MaxDownloads int
+	// Downloads counts all complete downloads, recorded by the Store's
+	// RecordDownload after being served, or reserved before for an Item with
+	// MaxDownloads.
+	Downloads int
+
+	// PasswordHash is the bcrypt hash of an optional download password.
+	PasswordHash string
+
 	Filename    string
-	ContentType string
+	ContentType string `badgerholdIndex:"ContentType"`
+
+	// ContentHash is the hex encoded SHA-256 hash of the file, set by the Store.
+	ContentHash string
+
+	// Blob names the file within the Store, which might be shared with other
+	// Items of the same content. It is set by the Store.
+	Blob string
+
+	// Size of the file in bytes. If set before being passed to the Store, it is
+	// the expected size and a deviating file will be rejected.
+	Size int64
+
+	// UploadChecksum is an optional checksum supplied by the uploader. The Store
+	// rejects a deviating file and clears this field afterwards.
+	UploadChecksum Checksum
 
 	Created time.Time
+	// Expires is zero for an Item without any automatic expiry.
 	Expires time.Time `badgerholdIndex:"Expires"`
 
+	// LastAccess is the time of the last download, updated at most once per
+	// TouchInterval by the Store's Touch or together with the Downloads.
+	LastAccess time.Time
+
 	Owner map[OwnerType]net.IP
 }
 
@@ -88,9 +257,542 @@ var (
 
 	ErrFileTooBig = errors.New("File size is greater than maxium filesize")
 
+	ErrLifetimeExtended = errors.New("Lifetime cannot be extended")
+
+	ErrChecksumMissing = errors.New("Upload checksum is required but missing")
+
+	ErrContentTypeMissing = errors.New("Missing Content-Type in file header")
+
+	ErrContentTypeMismatch = errors.New("Content-Type in file header mismatches the file's content")
+
+	ErrChecksumInvalid = errors.New("Upload checksum is invalid")
+
+	ErrMultipartInvalid = errors.New("Request is no valid multipart/form-data")
+
+	ErrFileFieldMissing = errors.New("Request has no file field")
+
+	ErrFieldAfterFile = errors.New("Checksum fields must precede the file")
+
+	ErrTrailingFieldInvalid = errors.New("Form field succeeding the file is invalid")
+
+	ErrTooManyFiles = errors.New("Request has too many files")
+
+	ErrMaxDownloadsInvalid = errors.New("Maximum downloads must be a positive number")
+
+	ErrDownloadsExhausted = errors.New("Item has no downloads left")
+
+	ErrPasswordTooLong = errors.New("Password must not exceed 72 bytes")
+
 	filenamePattern = regexp.MustCompile(`[^0-9A-Za-z-_.]`)
 )
 
+// sanitizeFilename strips all path components and replaces each character not
+// matching the filenamePattern by an underscore. An empty filename or one only
+// naming a directory, e.g., "..", results in no filename.
+func sanitizeFilename(filename string) string {
+	filename = filepath.Base(filepath.Clean(filename))
+	if filename == "." || filename == ".." || filename == string(filepath.Separator) {
+		return ""
+	}
+	return filenamePattern.ReplaceAllString(filename, "_")
+}
+
+// checksumAlgorithms are the supported algorithms for an UploadChecksum.
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// Checksum is an expected hex encoded hash of a file by its algorithm's name,
+// being a key of checksumAlgorithms. An empty Algorithm means no checksum.
+type Checksum struct {
+	Algorithm string
+	Hash      string
+}
+
+// newHash creates a hash.Hash for this Checksum's Algorithm.
+func (c Checksum) newHash() (hash.Hash, error) {
+	newHash, ok := checksumAlgorithms[c.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", c.Algorithm)
+	}
+	return newHash(), nil
+}
+
+// checksumFromForm returns the first Checksum for one of the algorithms,
+// each being submitted as a form field of its name, e.g., "sha256".
+func checksumFromForm(form url.Values, algorithms []string) (c Checksum, err error) {
+	for _, algorithm := range algorithms {
+		hexHash := form.Get(algorithm)
+		if hexHash == "" {
+			continue
+		}
+
+		c = Checksum{Algorithm: algorithm, Hash: strings.ToLower(hexHash)}
+
+		h, err := c.newHash()
+		if err != nil {
+			return Checksum{}, err
+		}
+		if rawHash, err := hex.DecodeString(c.Hash); err != nil || len(rawHash) != h.Size() {
+			return Checksum{}, ErrChecksumInvalid
+		}
+		return c, nil
+	}
+	return
+}
+
+// ContentTypeMode defines how to handle an upload without a Content-Type.
+type ContentTypeMode string
+
+const (
+	// ContentTypeReject rejects such uploads with ErrContentTypeMissing.
+	ContentTypeReject ContentTypeMode = "reject"
+	// ContentTypeSniff detects the Content-Type based on the file's content.
+	ContentTypeSniff ContentTypeMode = "sniff"
+	// ContentTypeFallback uses a configured fallback Content-Type.
+	ContentTypeFallback ContentTypeMode = "fallback"
+)
+
+// ContentTypeMismatchMode defines how to handle an upload whose declared
+// Content-Type mismatches its sniffed type.
+type ContentTypeMismatchMode string
+
+const (
+	// ContentTypeMismatchKeep keeps the declared Content-Type.
+	ContentTypeMismatchKeep ContentTypeMismatchMode = "keep"
+	// ContentTypeMismatchOverwrite replaces it by the sniffed Content-Type.
+	ContentTypeMismatchOverwrite ContentTypeMismatchMode = "overwrite"
+	// ContentTypeMismatchReject rejects such uploads with ErrContentTypeMismatch.
+	ContentTypeMismatchReject ContentTypeMismatchMode = "reject"
+)
+
+// genericSniffedTypes are sniffed for files without a recognized signature or
+// for containers of many formats, e.g., a ZIP for an OpenDocument file. Thus,
+// they do not contradict a more specific declared Content-Type.
+var genericSniffedTypes = map[string]struct{}{
+	"application/octet-stream": {},
+	"application/x-gzip":       {},
+	"application/zip":          {},
+	"text/plain":               {},
+	"text/xml":                 {},
+}
+
+// contentTypeMismatches checks if a declared Content-Type is contradicted by
+// the sniffed one, being specific and differing from the declared media type.
+func contentTypeMismatches(declared, sniffed string) bool {
+	if _, generic := genericSniffedTypes[sniffed]; generic {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(declared)
+	return err != nil || mediaType != sniffed
+}
+
+// sniffLen is the number of bytes considered by sniffContentType.
+const sniffLen = 512
+
+// sniffContentType detects the media type of the file's first bytes, without
+// any parameters, e.g., "text/plain" instead of "text/plain; charset=utf-8".
+// Thus, it can be matched against the mime_drop and mime_map.
+func sniffContentType(head []byte) (string, error) {
+	if len(head) > sniffLen {
+		head = head[:sniffLen]
+	}
+
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	return mediaType, err
+}
+
+// DefaultDeletionKeyLength is the number of random bytes of a DeletionKey,
+// unless configured otherwise. The key may be between MinDeletionKeyLength and
+// MaxDeletionKeyLength bytes long.
+const (
+	DefaultDeletionKeyLength = 24
+	MinDeletionKeyLength     = 8
+	MaxDeletionKeyLength     = 64
+)
+
+// ItemOpts are the restrictions and defaults for new Items.
+type ItemOpts struct {
+	// MaxSize is the maximum file size in bytes.
+	MaxSize int64
+
+	// DefaultLifetime is used if the uploader has not requested a lifetime.
+	DefaultLifetime time.Duration
+	// MaxLifetime is the longest lifetime an uploader might request. Both might
+	// be DurationNever, resulting in Items without an Expires.
+	MaxLifetime time.Duration
+
+	// ChecksumAlgorithms are accepted for an uploader's checksum, which is
+	// mandatory if ChecksumRequired is set.
+	ChecksumAlgorithms []string
+	ChecksumRequired   bool
+
+	// MaxForwardedHops limits the hops of each forwarding header being parsed
+	// for the Owner, starting from the right. A value of zero parses all hops.
+	MaxForwardedHops int
+
+	// TrustedProxies are allowed to set forwarding headers for the Owner.
+	TrustedProxies []*net.IPNet
+
+	// MissingContentType handles uploads without a Content-Type, defaulting to
+	// ContentTypeReject. ContentTypeFallback uses the FallbackContentType.
+	MissingContentType  ContentTypeMode
+	FallbackContentType string
+
+	// MismatchingContentType handles uploads whose declared Content-Type is
+	// contradicted by their content, defaulting to ContentTypeMismatchKeep.
+	MismatchingContentType ContentTypeMismatchMode
+
+	// DeletionKeyLength is the number of random bytes of a DeletionKey,
+	// defaulting to DefaultDeletionKeyLength. No DeletionKey is generated if
+	// DisableDeletionKeys is set.
+	DeletionKeyLength   int
+	DisableDeletionKeys bool
+}
+
+// NeverExpires checks if this Item has no automatic expiry, indicated by a zero
+// Expires.
+func (i Item) NeverExpires() bool {
+	return i.Expires.IsZero()
+}
+
+// expired checks if this Item has expired by now.
+func (i Item) expired(now time.Time) bool {
+	return !i.NeverExpires() && i.Expires.Before(now)
+}
+
+// TouchInterval throttles updates of an Item's LastAccess to limit writes.
+const TouchInterval = time.Minute
+
+// touch sets the LastAccess to now, unless it was updated within the last
+// TouchInterval. It returns true if the Item was changed.
+func (i *Item) touch(now time.Time) bool {
+	if !i.LastAccess.IsZero() && now.Sub(i.LastAccess) < TouchInterval {
+		return false
+	}
+
+	i.LastAccess = now.UTC()
+	return true
+}
+
+// recordDownload counts a served download of an Item without MaxDownloads and
+// touches its LastAccess.
+func (i *Item) recordDownload(now time.Time) {
+	i.Downloads++
+	i.touch(now)
+}
+
+// recordBurnAttempt counts a failed download of a BurnAfterReading Item.
+func (i *Item) recordBurnAttempt(now time.Time) {
+	if i.BurnAttempts == 0 {
+		i.FirstBurnAttempt = now.UTC()
+	}
+	i.BurnAttempts++
+}
+
+// reserveDownload counts a download of an Item with MaxDownloads before it is
+// served. If no downloads are left, ErrDownloadsExhausted is returned.
+func (i *Item) reserveDownload(now time.Time) error {
+	if i.MaxDownloads > 0 && i.Downloads >= i.MaxDownloads {
+		return ErrDownloadsExhausted
+	}
+	i.recordDownload(now)
+	return nil
+}
+
+// releaseDownload reverts a reserved download, which was not served.
+func (i *Item) releaseDownload() {
+	if i.Downloads > 0 {
+		i.Downloads--
+	}
+}
+
+// checkPassword checks a download password against the Item's PasswordHash.
+// Items without a PasswordHash accept any password.
+func (i Item) checkPassword(password string) bool {
+	if i.PasswordHash == "" {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(i.PasswordHash), []byte(password)) == nil
+}
+
+// checkDeletionKey compares a deletion key against the Item's DeletionKey in
+// constant time. Items without a DeletionKey reject all keys.
+func (i Item) checkDeletionKey(delKey string) bool {
+	if i.DeletionKey == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(i.DeletionKey), []byte(delKey)) == 1
+}
+
+// lastDownload checks if the Item's last download was reserved.
+func (i Item) lastDownload() bool {
+	return i.MaxDownloads > 0 && i.Downloads >= i.MaxDownloads
+}
+
+// BurnRetries tolerates failed downloads of BurnAfterReading Items, e.g., due
+// to a dropped connection, by burning them only after a complete download or
+// when the retries are exhausted.
+type BurnRetries struct {
+	// Attempts is the maximum number of download attempts. Values up to one
+	// burn an Item after its first download, independent of its success.
+	Attempts int
+
+	// Window limits retries to this duration after the first failed attempt.
+	// A value of zero does not limit the time.
+	Window time.Duration
+}
+
+// windowExceeded checks if the retry Window after an Item's first failed
+// download has passed.
+func (br BurnRetries) windowExceeded(i Item, now time.Time) bool {
+	return br.Window > 0 && i.BurnAttempts > 0 && now.Sub(i.FirstBurnAttempt) >= br.Window
+}
+
+// exhausted checks if an Item with recorded failed attempts must be burned.
+func (br BurnRetries) exhausted(i Item, now time.Time) bool {
+	return br.Attempts <= 1 || i.BurnAttempts >= br.Attempts || br.windowExceeded(i, now)
+}
+
+// ItemSettings are those settings of an Item which might be updated after its
+// upload. Unset fields are left unchanged.
+type ItemSettings struct {
+	SetBurnAfterReading bool
+	BurnAfterReading    bool
+
+	// Expires might only be brought forward, never be postponed.
+	Expires *time.Time
+
+	// The following fields are only set for an upload's form fields succeeding
+	// its file, being validated like those preceding it by the webserver.
+	// Thus, the Lifetime replaces the Item's one without restriction.
+	Lifetime     time.Duration
+	MaxDownloads int
+	PasswordHash string
+	Filename     string
+}
+
+// apply these ItemSettings to the Item or return ErrLifetimeExtended.
+func (settings ItemSettings) apply(item *Item) error {
+	if settings.Expires != nil && !item.NeverExpires() && settings.Expires.After(item.Expires) {
+		return ErrLifetimeExtended
+	}
+
+	if settings.SetBurnAfterReading {
+		item.BurnAfterReading = settings.BurnAfterReading
+	}
+	if settings.Expires != nil {
+		item.Expires = settings.Expires.UTC()
+	}
+
+	if settings.Lifetime == DurationNever {
+		item.Expires = time.Time{}
+	} else if settings.Lifetime > 0 {
+		item.Expires = item.Created.Add(settings.Lifetime)
+	}
+	if settings.MaxDownloads > 0 {
+		item.MaxDownloads = settings.MaxDownloads
+	}
+	if settings.PasswordHash != "" {
+		item.PasswordHash = settings.PasswordHash
+	}
+	if settings.Filename != "" {
+		item.Filename = settings.Filename
+	}
+	return nil
+}
+
+const (
+	// maxFormFields limits the form fields preceding an upload's files as well
+	// as those succeeding its first file.
+	maxFormFields = 32
+	// maxFormFieldSize limits the size of each form field's value in bytes.
+	maxFormFieldSize = 4096
+
+	// maxUploadFiles limits the files of a single upload request.
+	maxUploadFiles = 16
+
+	// maxUploadOverhead is the amount of bytes an upload's body might exceed
+	// its files' MaxSize by, covering its form fields and multipart framing.
+	maxUploadOverhead = 2*maxFormFields*maxFormFieldSize + maxUploadFiles*(4<<10) + 64<<10
+)
+
+// maxUploadBody limits the body of an upload request with files of up to
+// maxSize each. The sum saturates instead of overflowing for a huge maxSize.
+func maxUploadBody(maxSize int64) int64 {
+	if maxSize > (math.MaxInt64-maxUploadOverhead)/maxUploadFiles {
+		return math.MaxInt64
+	}
+	return maxSize*maxUploadFiles + maxUploadOverhead
+}
+
+// uploadReadError wraps an error of reading an upload's body into kind. If the
+// body was cut off by its http.MaxBytesReader, ErrFileTooBig is returned.
+func uploadReadError(kind, err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return ErrFileTooBig
+	}
+	return fmt.Errorf("%w: %v", kind, err)
+}
+
+// readUploadFields reads the form fields preceding the file part, which is
+// returned unread to be streamed.
+func readUploadFields(r *http.Request, mr *multipart.Reader) (form url.Values, file *multipart.Part, err error) {
+	form = r.URL.Query()
+
+	for fields := 0; ; {
+		part, partErr := mr.NextPart()
+		if partErr == io.EOF {
+			return nil, nil, ErrFileFieldMissing
+		} else if partErr != nil {
+			return nil, nil, uploadReadError(ErrMultipartInvalid, partErr)
+		}
+
+		if part.FileName() != "" {
+			if part.FormName() == formFile {
+				return form, part, nil
+			}
+			if _, err := io.Copy(io.Discard, part); err != nil {
+				return nil, nil, uploadReadError(ErrMultipartInvalid, err)
+			}
+			continue
+		}
+
+		fields++
+		if fields > maxFormFields {
+			return nil, nil, fmt.Errorf("%w: more than %d fields", ErrMultipartInvalid, maxFormFields)
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize+1))
+		if err != nil {
+			return nil, nil, uploadReadError(ErrMultipartInvalid, err)
+		} else if len(value) > maxFormFieldSize {
+			return nil, nil, fmt.Errorf("%w: field %q exceeds %d bytes", ErrMultipartInvalid, part.FormName(), maxFormFieldSize)
+		}
+		form.Add(part.FormName(), string(value))
+	}
+}
+
+// uploadFile streams an upload's file part. Form fields succeeding the file
+// are parsed into settings after the file was read.
+type uploadFile struct {
+	mr        *multipart.Reader
+	part      *multipart.Part
+	buf       *bufio.Reader
+	remaining int64
+	opts      ItemOpts
+
+	trailing url.Values
+	settings ItemSettings
+
+	multiple bool
+	next     *multipart.Part
+	done     bool
+}
+
+func newUploadFile(mr *multipart.Reader, part *multipart.Part, opts ItemOpts) *uploadFile {
+	return &uploadFile{
+		mr:        mr,
+		part:      part,
+		buf:       bufio.NewReaderSize(part, sniffLen),
+		remaining: opts.MaxSize,
+		opts:      opts,
+		trailing:  url.Values{},
+	}
+}
+
+// Peek returns the next n bytes without consuming them, e.g., for sniffing.
+func (f *uploadFile) Peek(n int) ([]byte, error) {
+	return f.buf.Peek(n)
+}
+
+func (f *uploadFile) Read(p []byte) (int, error) {
+	// Reading one more byte than remaining detects an exceeded maxSize.
+	if int64(len(p)) > f.remaining+1 {
+		p = p[:f.remaining+1]
+	}
+
+	n, err := f.buf.Read(p)
+	f.remaining -= int64(n)
+	if f.remaining < 0 {
+		return n + int(f.remaining), ErrFileTooBig
+	}
+
+	if err == io.EOF && !f.done {
+		if trailingErr := f.readTrailing(); trailingErr != nil {
+			err = trailingErr
+		}
+	} else if err != nil && err != io.EOF {
+		err = uploadReadError(ErrIncompleteFile, err)
+	}
+	return n, err
+}
+
+// readTrailing reads the parts succeeding the file, either up to the form's
+// end or, if multiple is set, up to the next file.
+func (f *uploadFile) readTrailing() error {
+	fields := 0
+	for _, values := range f.trailing {
+		fields += len(values)
+	}
+
+	for {
+		part, err := f.mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return uploadReadError(ErrIncompleteFile, err)
+		}
+
+		if part.FileName() != "" {
+			if f.multiple && part.FormName() == formFile {
+				f.next = part
+				break
+			}
+			if _, err := io.Copy(io.Discard, part); err != nil {
+				return uploadReadError(ErrIncompleteFile, err)
+			}
+			continue
+		}
+
+		fields++
+		if fields > maxFormFields {
+			return fmt.Errorf("%w: more than %d fields after the file", ErrMultipartInvalid, maxFormFields)
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize+1))
+		if err != nil {
+			return uploadReadError(ErrIncompleteFile, err)
+		} else if len(value) > maxFormFieldSize {
+			return fmt.Errorf("%w: field %q exceeds %d bytes", ErrMultipartInvalid, part.FormName(), maxFormFieldSize)
+		}
+		f.trailing.Add(part.FormName(), string(value))
+	}
+
+	f.done = true
+
+	if len(f.trailing) == 0 {
+		return nil
+	}
+	settings, err := newTrailingSettings(f.trailing, f.opts)
+	if err == ErrFieldAfterFile {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("%w: %w", ErrTrailingFieldInvalid, err)
+	}
+	f.settings = settings
+	return nil
+}
+
+func (f *uploadFile) Close() error {
+	return f.part.Close()
+}
+
 // NewItemFromRequest creates a new Item based on a Request.
 //
 // The ID will be left empty. Furthermore, if no error has occurred, a file
@@ -98,72 +800,382 @@ var (
 // This file must be closed afterwards.
 //
 // Note, this Item must be passed to the Store to be safed and get an ID.
-func NewItemFromRequest(r *http.Request, maxSize int64, maxLifetime time.Duration) (item Item, file io.ReadCloser, err error) {
-	err = r.ParseMultipartForm(maxSize)
+func NewItemFromRequest(r *http.Request, opts ItemOpts) (item Item, file io.ReadCloser, err error) {
+	mr, err := r.MultipartReader()
 	if err != nil {
+		err = fmt.Errorf("%w: %v", ErrMultipartInvalid, err)
 		return
 	}
 
-	file, fileHeader, err := r.FormFile(formFile)
+	form, part, err := readUploadFields(r, mr)
 	if err != nil {
 		return
 	}
 
+	upload := newUploadFile(mr, part, opts)
+	item, err = newItemFromUploadFile(r, form, part.FileName(), part.Header.Get("Content-Type"), upload, opts)
+	if err != nil {
+		return
+	}
+	return item, upload, nil
+}
+
+// peekReadCloser is an upload's file, allowing to peek at its head.
+type peekReadCloser interface {
+	io.ReadCloser
+	Peek(n int) ([]byte, error)
+}
+
+// newItemFromUploadFile creates a new Item for an upload's file based on the
+// upload's form and the file's declared name and Content-Type. On error, the
+// file is closed.
+func newItemFromUploadFile(r *http.Request, form url.Values, filename, contentType string, upload peekReadCloser, opts ItemOpts) (item Item, err error) {
 	defer func() {
 		if err != nil {
 			item = Item{}
-			file.Close()
-			file = nil
+			upload.Close()
 		}
 	}()
 
-	if fileHeader.Size > maxSize {
-		err = ErrFileTooBig
+	head, err := upload.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		err = uploadReadError(ErrIncompleteFile, err)
 		return
-	}
-	if fileHeader.Size <= 0 {
+	} else if len(head) == 0 {
 		err = errors.New("file size is zero")
 		return
 	}
 
-	delKeyBuff := make([]byte, 24)
-	_, err = rand.Read(delKeyBuff)
+	item, err = newItemFromForm(r, form, filename, opts)
 	if err != nil {
 		return
 	}
-	item.DeletionKey = string(base58.Encode(delKeyBuff))
 
-	if burnAfterReading := r.FormValue(formBurnAfterReading); burnAfterReading == "1" {
-		item.BurnAfterReading = true
+	item.ContentType, err = uploadContentType(contentType, head, opts)
+	return
+}
+
+// ItemUploads iterates the files of an upload with multiple file fields, all
+// sharing the same form fields, e.g., their lifetime.
+type ItemUploads struct {
+	r    *http.Request
+	opts ItemOpts
+
+	mr    *multipart.Reader
+	form  url.Values
+	next  *multipart.Part
+	prev  *uploadFile
+	files int
+
+	trailing url.Values
+	settings ItemSettings
+}
+
+// NewItemUploads reads an upload's form fields preceding its files to iterate
+// its files by Next.
+func NewItemUploads(r *http.Request, opts ItemOpts) (*ItemUploads, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMultipartInvalid, err)
+	}
+
+	form, part, err := readUploadFields(r, mr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ItemUploads{r: r, opts: opts, mr: mr, form: form, next: part, trailing: url.Values{}}, nil
+}
+
+// Next creates the Item of the upload's next file, being returned as a file
+// like io.ReadCloser, as NewItemFromRequest does. After the last file, io.EOF
+// is returned. More than maxUploadFiles files result in ErrTooManyFiles.
+func (uploads *ItemUploads) Next() (item Item, file io.ReadCloser, err error) {
+	if uploads.prev != nil {
+		// A previous file not being read completely is skipped.
+		if _, err = io.Copy(io.Discard, uploads.prev); err != nil {
+			return Item{}, nil, err
+		}
+		uploads.next = uploads.prev.next
+		uploads.settings = uploads.prev.settings
+		uploads.prev = nil
+	}
+
+	if uploads.next == nil {
+		return Item{}, nil, io.EOF
+	}
+
+	uploads.files++
+	if uploads.files > maxUploadFiles {
+		return Item{}, nil, ErrTooManyFiles
 	}
 
-	item.Filename = filenamePattern.ReplaceAllString(
-		filepath.Base(filepath.Clean(fileHeader.Filename)), "_")
+	upload := newUploadFile(uploads.mr, uploads.next, uploads.opts)
+	upload.trailing = uploads.trailing
+	upload.multiple = true
+	uploads.next = nil
 
-	item.ContentType = fileHeader.Header.Get("Content-Type")
-	if item.ContentType == "" {
-		err = errors.New("missing Content-Type in file header")
+	item, err = newItemFromUploadFile(uploads.r, uploads.form,
+		upload.part.FileName(), upload.part.Header.Get("Content-Type"), upload, uploads.opts)
+	if err != nil {
 		return
 	}
 
+	uploads.prev = upload
+	return item, upload, nil
+}
+
+// Trailing returns the ItemSettings of the form fields succeeding the first
+// file, if there are any. After Next returned io.EOF, those must be applied to
+// all of the upload's Items, e.g., by Store.UpdateSettings.
+func (uploads *ItemUploads) Trailing() (ItemSettings, bool) {
+	return uploads.settings, len(uploads.trailing) > 0
+}
+
+// rawUploadFile streams an upload's raw request body. Reading more than its
+// maxSize results in ErrFileTooBig and an aborted upload in ErrIncompleteFile.
+type rawUploadFile struct {
+	body      io.ReadCloser
+	buf       *bufio.Reader
+	remaining int64
+}
+
+func newRawUploadFile(body io.ReadCloser, maxSize int64) *rawUploadFile {
+	return &rawUploadFile{
+		body:      body,
+		buf:       bufio.NewReaderSize(body, sniffLen),
+		remaining: maxSize,
+	}
+}
+
+// Peek returns the next n bytes without consuming them, e.g., for sniffing.
+func (f *rawUploadFile) Peek(n int) ([]byte, error) {
+	return f.buf.Peek(n)
+}
+
+func (f *rawUploadFile) Read(p []byte) (int, error) {
+	// Reading one more byte than remaining detects an exceeded maxSize.
+	if int64(len(p)) > f.remaining+1 {
+		p = p[:f.remaining+1]
+	}
+
+	n, err := f.buf.Read(p)
+	f.remaining -= int64(n)
+	if f.remaining < 0 {
+		return n + int(f.remaining), ErrFileTooBig
+	}
+
+	if err != nil && err != io.EOF {
+		err = uploadReadError(ErrIncompleteFile, err)
+	}
+	return n, err
+}
+
+func (f *rawUploadFile) Close() error {
+	return f.body.Close()
+}
+
+// NewItemFromRawRequest creates a new Item based on a Request whose body is
+// the raw file, e.g., a PUT request by "curl -T".
+func NewItemFromRawRequest(r *http.Request, filename string, opts ItemOpts) (item Item, file io.ReadCloser, err error) {
+	if r.ContentLength > opts.MaxSize {
+		err = ErrFileTooBig
+		return
+	}
+
+	if headerFilename := r.Header.Get("X-Filename"); headerFilename != "" {
+		filename = headerFilename
+	}
+
+	upload := newRawUploadFile(r.Body, opts.MaxSize)
+	item, err = newItemFromUploadFile(r, r.URL.Query(), filename, r.Header.Get("Content-Type"), upload, opts)
+	if err != nil {
+		return
+	}
+	return item, upload, nil
+}
+
+// newItemFromForm creates a new Item based on an upload's form fields, e.g.,
+// its lifetime, without its ContentType.
+func newItemFromForm(r *http.Request, form url.Values, filename string, opts ItemOpts) (item Item, err error) {
+	if !opts.DisableDeletionKeys {
+		delKeyLen := opts.DeletionKeyLength
+		if delKeyLen == 0 {
+			delKeyLen = DefaultDeletionKeyLength
+		}
+
+		delKeyBuff := make([]byte, delKeyLen)
+		_, err = rand.Read(delKeyBuff)
+		if err != nil {
+			return
+		}
+		item.DeletionKey = string(base58.Encode(delKeyBuff))
+	}
+
+	if burnAfterReading := form.Get(formBurnAfterReading); burnAfterReading == "1" {
+		item.BurnAfterReading = true
+	}
+
+	if maxDownloads := form.Get(formMaxDownloads); maxDownloads != "" {
+		item.MaxDownloads, err = parseMaxDownloads(maxDownloads)
+		if err != nil {
+			return
+		}
+	}
+
+	if password := form.Get(formPassword); password != "" {
+		item.PasswordHash, err = hashPassword(password)
+		if err != nil {
+			return
+		}
+	}
+
+	if customFilename := form.Get(formFilename); customFilename != "" {
+		filename = customFilename
+	}
+	item.Filename = sanitizeFilename(filename)
+
 	item.Created = time.Now().UTC()
 
-	if lifetime := r.FormValue(formLifetime); lifetime == "" {
-		item.Expires = item.Created.Add(maxLifetime)
-	} else if parseLt, parseLtErr := ParseDuration(lifetime); parseLtErr != nil {
-		err = parseLtErr
+	lifetime := opts.DefaultLifetime
+	if formLt := form.Get(formLifetime); formLt != "" {
+		lifetime, err = parseLifetime(formLt, opts)
+		if err != nil {
+			return
+		}
+	}
+	if lifetime != DurationNever {
+		item.Expires = item.Created.Add(lifetime)
+	}
+
+	item.UploadChecksum, err = checksumFromForm(form, opts.ChecksumAlgorithms)
+	if err != nil {
 		return
-	} else if parseLt > maxLifetime {
-		err = ErrLifetimeTooLong
+	} else if opts.ChecksumRequired && item.UploadChecksum.Algorithm == "" {
+		err = ErrChecksumMissing
 		return
-	} else {
-		item.Expires = item.Created.Add(parseLt)
 	}
 
-	item.Owner, err = NewOwnerTypes(r)
+	item.Owner, err = NewOwnerTypes(r, opts.TrustedProxies, opts.MaxForwardedHops)
 	if err != nil {
 		return
 	}
 
 	return
 }
+
+// newTrailingSettings creates the ItemSettings of an upload's form fields
+// succeeding its file, being validated like those of newItemFromForm. As the
+// file was already streamed, a checksum results in ErrFieldAfterFile.
+func newTrailingSettings(form url.Values, opts ItemOpts) (settings ItemSettings, err error) {
+	for _, algorithm := range opts.ChecksumAlgorithms {
+		if form.Has(algorithm) {
+			err = ErrFieldAfterFile
+			return
+		}
+	}
+
+	if form.Has(formBurnAfterReading) {
+		settings.SetBurnAfterReading = true
+		settings.BurnAfterReading = form.Get(formBurnAfterReading) == "1"
+	}
+
+	if maxDownloads := form.Get(formMaxDownloads); maxDownloads != "" {
+		settings.MaxDownloads, err = parseMaxDownloads(maxDownloads)
+		if err != nil {
+			return
+		}
+	}
+
+	if password := form.Get(formPassword); password != "" {
+		settings.PasswordHash, err = hashPassword(password)
+		if err != nil {
+			return
+		}
+	}
+
+	if customFilename := form.Get(formFilename); customFilename != "" {
+		settings.Filename = sanitizeFilename(customFilename)
+	}
+
+	if formLt := form.Get(formLifetime); formLt != "" {
+		settings.Lifetime, err = parseLifetime(formLt, opts)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// parseMaxDownloads parses the maxDownloads form field or returns
+// ErrMaxDownloadsInvalid.
+func parseMaxDownloads(value string) (int, error) {
+	maxDownloads, err := strconv.Atoi(value)
+	if err != nil || maxDownloads <= 0 {
+		return 0, ErrMaxDownloadsInvalid
+	}
+	return maxDownloads, nil
+}
+
+// hashPassword hashes the password form field by bcrypt or returns
+// ErrPasswordTooLong.
+func hashPassword(password string) (string, error) {
+	// bcrypt only considers a password's first 72 bytes.
+	if len(password) > 72 {
+		return "", ErrPasswordTooLong
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// parseLifetime parses the time form field, being limited by the ItemOpts'
+// MaxLifetime.
+func parseLifetime(value string, opts ItemOpts) (time.Duration, error) {
+	lifetime, err := ParseDuration(value)
+	if err != nil {
+		return 0, err
+	} else if lifetime > opts.MaxLifetime {
+		return 0, ErrLifetimeTooLong
+	}
+	return lifetime, nil
+}
+
+// uploadContentType determines an upload's Content-Type based on the declared
+// one and the file's head, its first bytes, as configured by the ItemOpts'
+// MissingContentType and MismatchingContentType.
+func uploadContentType(declared string, head []byte, opts ItemOpts) (string, error) {
+	if declared == "" {
+		switch opts.MissingContentType {
+		case ContentTypeSniff:
+			return sniffContentType(head)
+
+		case ContentTypeFallback:
+			return opts.FallbackContentType, nil
+
+		default:
+			return "", ErrContentTypeMissing
+		}
+	}
+
+	if opts.MismatchingContentType != ContentTypeMismatchOverwrite &&
+		opts.MismatchingContentType != ContentTypeMismatchReject {
+		return declared, nil
+	}
+
+	sniffed, err := sniffContentType(head)
+	if err != nil {
+		return "", err
+	}
+
+	if !contentTypeMismatches(declared, sniffed) {
+		return declared, nil
+	} else if opts.MismatchingContentType == ContentTypeMismatchReject {
+		return "", ErrContentTypeMismatch
+	}
+	return sniffed, nil
+}
@@ -2,17 +2,29 @@ package main
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"math/rand"
 	"mime/multipart"
 	"net"
 	"net/http"
+	"net/textproto"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestOwnerType(t *testing.T) {
+	trustedProxies, err := parseTrustedProxies([]string{"127.0.0.1", "fe80::/64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	header1 := make(http.Header)
 
 	owners1 := make(map[OwnerType]net.IP)
@@ -52,7 +64,7 @@ func TestOwnerType(t *testing.T) {
 			Header:     test.headers,
 		}
 
-		ots, err := NewOwnerTypes(&r)
+		ots, err := NewOwnerTypes(&r, trustedProxies, 0)
 		if (err == nil) == test.errors {
 			t.Fatalf("Should error: %t, error: %v", test.errors, err)
 		}
@@ -67,6 +79,260 @@ func TestOwnerType(t *testing.T) {
 	}
 }
 
+func TestOwnerTypeTrustedProxies(t *testing.T) {
+	trustedProxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "2001:db8:ffff::/48"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string][]string
+
+		ots    map[OwnerType]string
+		errors bool
+	}{
+		{"untrusted", "192.0.2.1:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			map[OwnerType]string{RemoteAddr: "192.0.2.1"}, false},
+		{"untrusted invalid", "192.0.2.1:1234", map[string][]string{"X-Forwarded-For": {"nope"}},
+			map[OwnerType]string{RemoteAddr: "192.0.2.1"}, false},
+		{"single", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			map[OwnerType]string{RemoteAddr: "10.0.0.1", XForwardedFor: "198.51.100.1"}, false},
+		{"chain", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"forged, 198.51.100.1, 10.0.0.5"}},
+			map[OwnerType]string{RemoteAddr: "10.0.0.1", XForwardedFor: "198.51.100.1"}, false},
+		{"chain lines", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1", "198.51.100.2"}},
+			map[OwnerType]string{RemoteAddr: "10.0.0.1", XForwardedFor: "198.51.100.2"}, false},
+		{"all trusted", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.4"}},
+			map[OwnerType]string{RemoteAddr: "10.0.0.1", XForwardedFor: "10.0.0.3"}, false},
+		{"invalid hop", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1, nope"}},
+			nil, true},
+		{"forwarded", "10.0.0.1:1234", map[string][]string{"Forwarded": {`for=192.0.2.60;proto=http, for="[2001:db8::17]:4711", for=10.0.0.5`}},
+			map[OwnerType]string{RemoteAddr: "10.0.0.1", Forwarded: "2001:db8::17"}, false},
+		{"forwarded unknown", "10.0.0.1:1234", map[string][]string{"Forwarded": {"for=unknown"}},
+			map[OwnerType]string{RemoteAddr: "10.0.0.1"}, false},
+		{"forwarded ipv6 proxy", "[2001:db8:ffff::1]:1234", map[string][]string{"Forwarded": {"for=198.51.100.1:80"}},
+			map[OwnerType]string{RemoteAddr: "2001:db8:ffff::1", Forwarded: "198.51.100.1"}, false},
+	}
+
+	for _, test := range tests {
+		r := http.Request{RemoteAddr: test.remoteAddr, Header: make(http.Header)}
+		for k, vs := range test.headers {
+			for _, v := range vs {
+				r.Header.Add(k, v)
+			}
+		}
+
+		ots, err := NewOwnerTypes(&r, trustedProxies, 0)
+		if (err == nil) == test.errors {
+			t.Fatalf("%s: should error: %t, error: %v", test.name, test.errors, err)
+		} else if test.errors {
+			continue
+		}
+
+		if len(ots) != len(test.ots) {
+			t.Fatalf("%s: expected %v, got %v", test.name, test.ots, ots)
+		}
+		for ot, ip := range test.ots {
+			if !ots[ot].Equal(net.ParseIP(ip)) {
+				t.Fatalf("%s: expected %s for %s, got %v", test.name, ip, ot, ots[ot])
+			}
+		}
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets, err := parseTrustedProxies([]string{"192.0.2.1", "2001:db8::1", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []string{"192.0.2.1", "2001:db8::1", "10.23.42.1"} {
+		if !isTrustedProxy(net.ParseIP(ip), nets) {
+			t.Fatalf("%s should be trusted", ip)
+		}
+	}
+	for _, ip := range []string{"192.0.2.2", "2001:db8::2", "11.0.0.1"} {
+		if isTrustedProxy(net.ParseIP(ip), nets) {
+			t.Fatalf("%s should not be trusted", ip)
+		}
+	}
+
+	for _, proxy := range []string{"nope", "10.0.0.0/33", ""} {
+		if _, err := parseTrustedProxies([]string{proxy}); err == nil {
+			t.Fatalf("%q should be invalid", proxy)
+		}
+	}
+}
+
+func TestOwnerTypesMaxHops(t *testing.T) {
+	trustedProxies, err := parseTrustedProxies([]string{"127.0.0.1", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The client is followed by an excessive number of trusted proxies.
+	header := make(http.Header)
+	header.Add(string(XForwardedFor), "192.0.2.1")
+	for i := 0; i < 1024; i++ {
+		header.Add(string(XForwardedFor), fmt.Sprintf("10.23.%d.%d", i/256, i%256))
+	}
+
+	tests := []struct {
+		maxHops int
+		owner   string
+	}{
+		{0, "192.0.2.1"},
+		{1025, "192.0.2.1"},
+		{1024, "10.23.0.0"},
+		{16, "10.23.3.240"},
+		{1, "10.23.3.255"},
+	}
+
+	for _, test := range tests {
+		r := http.Request{RemoteAddr: "127.0.0.1:2342", Header: header}
+
+		owners, err := NewOwnerTypes(&r, trustedProxies, test.maxHops)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !owners[XForwardedFor].Equal(net.ParseIP(test.owner)) {
+			t.Fatalf("Max %d: expected %s, got %v", test.maxHops, test.owner, owners[XForwardedFor])
+		}
+		if !owners[RemoteAddr].Equal(net.ParseIP("127.0.0.1")) {
+			t.Fatalf("Max %d: RemoteAddr was altered to %v", test.maxHops, owners[RemoteAddr])
+		}
+	}
+}
+
+func TestItemTouch(t *testing.T) {
+	start := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		now        time.Time
+		changed    bool
+		lastAccess time.Time
+	}{
+		{start, true, start},
+		{start.Add(time.Second), false, start},
+		{start.Add(TouchInterval - time.Nanosecond), false, start},
+		{start.Add(TouchInterval), true, start.Add(TouchInterval)},
+		{start.Add(TouchInterval + 30*time.Second), false, start.Add(TouchInterval)},
+		{start.Add(3 * TouchInterval), true, start.Add(3 * TouchInterval)},
+	}
+
+	var item Item
+	for i, step := range steps {
+		if changed := item.touch(step.now); changed != step.changed {
+			t.Fatalf("Step %d: expected changed %t, got %t", i, step.changed, changed)
+		}
+		if !item.LastAccess.Equal(step.lastAccess) {
+			t.Fatalf("Step %d: expected LastAccess %v, got %v", i, step.lastAccess, item.LastAccess)
+		}
+	}
+}
+
+func TestItemRecordDownload(t *testing.T) {
+	start := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Each download is counted, while the LastAccess is throttled.
+	var item Item
+	for i, now := range []time.Time{start, start.Add(time.Second), start.Add(TouchInterval)} {
+		item.recordDownload(now)
+
+		if item.Downloads != i+1 {
+			t.Fatalf("Download %d: expected %d downloads, got %d", i, i+1, item.Downloads)
+		} else if expected := now.Truncate(TouchInterval); !item.LastAccess.Equal(expected) {
+			t.Fatalf("Download %d: expected LastAccess %v, got %v", i, expected, item.LastAccess)
+		}
+	}
+}
+
+func TestBurnRetries(t *testing.T) {
+	start := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		retries   BurnRetries
+		attempts  int
+		now       time.Time
+		exceeded  bool
+		exhausted bool
+	}{
+		{"disabled", BurnRetries{}, 1, start, false, true},
+		{"single attempt", BurnRetries{Attempts: 1}, 1, start, false, true},
+		{"first of three", BurnRetries{Attempts: 3}, 1, start, false, false},
+		{"second of three", BurnRetries{Attempts: 3}, 2, start, false, false},
+		{"third of three", BurnRetries{Attempts: 3}, 3, start, false, true},
+		{"within window", BurnRetries{Attempts: 3, Window: time.Minute}, 1, start.Add(time.Minute - time.Nanosecond), false, false},
+		{"after window", BurnRetries{Attempts: 3, Window: time.Minute}, 1, start.Add(time.Minute), true, true},
+		{"no attempt yet", BurnRetries{Attempts: 3, Window: time.Minute}, 0, start.Add(time.Hour), false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var item Item
+			for i := 0; i < test.attempts; i++ {
+				item.recordBurnAttempt(start)
+			}
+
+			if item.BurnAttempts != test.attempts {
+				t.Fatalf("Expected %d attempts, got %d", test.attempts, item.BurnAttempts)
+			}
+			if test.attempts > 0 && !item.FirstBurnAttempt.Equal(start) {
+				t.Fatalf("Expected first attempt %v, got %v", start, item.FirstBurnAttempt)
+			}
+
+			if exceeded := test.retries.windowExceeded(item, test.now); exceeded != test.exceeded {
+				t.Fatalf("Expected window exceeded %t, got %t", test.exceeded, exceeded)
+			}
+			if exhausted := test.retries.exhausted(item, test.now); exhausted != test.exhausted {
+				t.Fatalf("Expected exhausted %t, got %t", test.exhausted, exhausted)
+			}
+		})
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		filename string
+		expected string
+	}{
+		{"foo.txt", "foo.txt"},
+		{"../../etc/passwd", "passwd"},
+		{"hello world.txt", "hello_world.txt"},
+		{"", ""},
+		{".", ""},
+		{"..", ""},
+		{"/", ""},
+		{"foo/..", ""},
+	}
+
+	for _, test := range tests {
+		if filename := sanitizeFilename(test.filename); filename != test.expected {
+			t.Fatalf("%q: expected %q, got %q", test.filename, test.expected, filename)
+		}
+	}
+}
+
+func TestMaxUploadBody(t *testing.T) {
+	tests := []struct {
+		maxSize int64
+		limit   int64
+	}{
+		{0, maxUploadOverhead},
+		{1024, 1024*maxUploadFiles + maxUploadOverhead},
+		{math.MaxInt64 / 2, math.MaxInt64},
+		{math.MaxInt64, math.MaxInt64},
+	}
+
+	for _, test := range tests {
+		if limit := maxUploadBody(test.maxSize); limit != test.limit {
+			t.Fatalf("Max size %d: expected limit %d, got %d", test.maxSize, test.limit, limit)
+		}
+	}
+}
+
 func TestItem(t *testing.T) {
 	const maxFilesize = 1024
 
@@ -93,18 +359,6 @@ func TestItem(t *testing.T) {
 		buff := &bytes.Buffer{}
 		writer := multipart.NewWriter(buff)
 
-		tmpFileData := make([]byte, test.size)
-		rand.New(rand.NewSource(0)).Read(tmpFileData)
-
-		if f, err := writer.CreateFormFile(formFile, test.filename); err != nil {
-			t.Fatal(err)
-		} else {
-			tmpFileBuff := bytes.NewBuffer(tmpFileData)
-			if _, err := io.Copy(f, tmpFileBuff); err != nil {
-				t.Fatal(err)
-			}
-		}
-
 		if test.burnAfterReading {
 			if w, err := writer.CreateFormField(formBurnAfterReading); err != nil {
 				t.Fatal(err)
@@ -121,6 +375,18 @@ func TestItem(t *testing.T) {
 			}
 		}
 
+		tmpFileData := make([]byte, test.size)
+		rand.New(rand.NewSource(0)).Read(tmpFileData)
+
+		if f, err := writer.CreateFormFile(formFile, test.filename); err != nil {
+			t.Fatal(err)
+		} else {
+			tmpFileBuff := bytes.NewBuffer(tmpFileData)
+			if _, err := io.Copy(f, tmpFileBuff); err != nil {
+				t.Fatal(err)
+			}
+		}
+
 		if err := writer.Close(); err != nil {
 			t.Fatal(err)
 		}
@@ -131,7 +397,15 @@ func TestItem(t *testing.T) {
 			r.Header.Set("Content-Type", writer.FormDataContentType())
 			r.RemoteAddr = "[fe80::42]:2342"
 
-			i, f, err := NewItemFromRequest(r, maxFilesize, time.Hour)
+			i, f, err := NewItemFromRequest(r, ItemOpts{
+				MaxSize:         maxFilesize,
+				DefaultLifetime: time.Hour,
+				MaxLifetime:     time.Hour,
+			})
+			if err == nil {
+				// The file size is only checked while streaming the file.
+				_, err = io.ReadAll(f)
+			}
 			if (err == nil) != test.valid {
 				t.Fatalf("Is valid: %t, error: %v", test.valid, err)
 			}
@@ -168,3 +442,479 @@ func TestItem(t *testing.T) {
 		}
 	}
 }
+
+func TestItemFilename(t *testing.T) {
+	tests := []struct {
+		filename       string
+		customFilename string
+
+		expected string
+	}{
+		{"tmp12345", "", "tmp12345"},
+		{"tmp12345", "report.pdf", "report.pdf"},
+		{"tmp12345", "../../etc/passwd", "passwd"},
+		{"tmp12345", "/foo/bar/", "bar"},
+		{"tmp12345", "<script>alert(1)</script>.html", "script_.html"},
+		{"tmp12345", "hello world.txt", "hello_world.txt"},
+	}
+
+	for _, test := range tests {
+		buff := &bytes.Buffer{}
+		writer := multipart.NewWriter(buff)
+
+		if test.customFilename != "" {
+			if w, err := writer.CreateFormField(formFilename); err != nil {
+				t.Fatal(err)
+			} else if _, err := w.Write([]byte(test.customFilename)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if f, err := writer.CreateFormFile(formFile, test.filename); err != nil {
+			t.Fatal(err)
+		} else if _, err := f.Write([]byte("hello world")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := http.NewRequest("POST", "http://foo.bar/", buff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", writer.FormDataContentType())
+		r.RemoteAddr = "[fe80::42]:2342"
+
+		i, f, err := NewItemFromRequest(r, ItemOpts{MaxSize: 1024, DefaultLifetime: time.Hour, MaxLifetime: time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = f.Close()
+
+		if i.Filename != test.expected {
+			t.Fatalf("Item Filename mismatches, got %q and expected %q", i.Filename, test.expected)
+		}
+	}
+}
+
+func TestItemDefaultLifetime(t *testing.T) {
+	opts := ItemOpts{
+		MaxSize:         1024,
+		DefaultLifetime: time.Hour,
+		MaxLifetime:     24 * time.Hour,
+	}
+
+	tests := []struct {
+		lifetime string
+
+		expected time.Duration
+		err      error
+	}{
+		{"", time.Hour, nil},
+		{"1m", time.Minute, nil},
+		{"2h", 2 * time.Hour, nil},
+		{"24h", 24 * time.Hour, nil},
+		{"25h", 0, ErrLifetimeTooLong},
+		{"1w", 0, ErrLifetimeTooLong},
+	}
+
+	for _, test := range tests {
+		buff := &bytes.Buffer{}
+		writer := multipart.NewWriter(buff)
+
+		if test.lifetime != "" {
+			if w, err := writer.CreateFormField(formLifetime); err != nil {
+				t.Fatal(err)
+			} else if _, err := w.Write([]byte(test.lifetime)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if f, err := writer.CreateFormFile(formFile, "test.txt"); err != nil {
+			t.Fatal(err)
+		} else if _, err := f.Write([]byte("hello world")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := http.NewRequest("POST", "http://foo.bar/", buff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", writer.FormDataContentType())
+		r.RemoteAddr = "[fe80::42]:2342"
+
+		i, f, err := NewItemFromRequest(r, opts)
+		if err != test.err {
+			t.Fatalf("Lifetime %q: expected error %v, got %v", test.lifetime, test.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		_ = f.Close()
+
+		if iDur := i.Expires.Sub(i.Created); iDur != test.expected {
+			t.Fatalf("Lifetime %q: expected duration of %v, got %v", test.lifetime, test.expected, iDur)
+		}
+	}
+}
+
+func TestItemMissingContentType(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        ContentTypeMode
+		fallback    string
+		data        []byte
+		contentType string
+		err         error
+	}{
+		{"default", "", "", []byte("hello world"), "", ErrContentTypeMissing},
+		{"reject", ContentTypeReject, "", []byte("hello world"), "", ErrContentTypeMissing},
+		{"sniff-text", ContentTypeSniff, "", []byte("hello world"), "text/plain", nil},
+		{"sniff-html", ContentTypeSniff, "", []byte("<html><body>hi</body></html>"), "text/html", nil},
+		{"sniff-png", ContentTypeSniff, "", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x00"), "image/png", nil},
+		{"sniff-binary", ContentTypeSniff, "", []byte{0x00, 0x01, 0x02, 0x03}, "application/octet-stream", nil},
+		{"fallback", ContentTypeFallback, "application/x-gosh", []byte("hello world"), "application/x-gosh", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buff := &bytes.Buffer{}
+			writer := multipart.NewWriter(buff)
+
+			// Unlike CreateFormFile, no Content-Type is being set.
+			header := make(textproto.MIMEHeader)
+			header.Set("Content-Disposition", `form-data; name="file"; filename="test"`)
+			if w, err := writer.CreatePart(header); err != nil {
+				t.Fatal(err)
+			} else if _, err := w.Write(test.data); err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := http.NewRequest("POST", "http://foo.bar/", buff)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Set("Content-Type", writer.FormDataContentType())
+			r.RemoteAddr = "[fe80::42]:2342"
+
+			i, f, err := NewItemFromRequest(r, ItemOpts{
+				MaxSize:             1024,
+				DefaultLifetime:     time.Hour,
+				MaxLifetime:         time.Hour,
+				MissingContentType:  test.mode,
+				FallbackContentType: test.fallback,
+			})
+			if err != test.err {
+				t.Fatalf("Expected error %v, got %v", test.err, err)
+			} else if err != nil {
+				return
+			}
+			defer f.Close()
+
+			if i.ContentType != test.contentType {
+				t.Fatalf("Expected Content-Type %q, got %q", test.contentType, i.ContentType)
+			}
+
+			// Sniffing must not consume the file.
+			if data, err := io.ReadAll(f); err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(data, test.data) {
+				t.Fatalf("File data mismatches, got %q", data)
+			}
+		})
+	}
+}
+
+func TestItemMismatchingContentType(t *testing.T) {
+	html := []byte("<html><body><script>alert(1)</script></body></html>")
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x00")
+
+	tests := []struct {
+		name        string
+		mode        ContentTypeMismatchMode
+		declared    string
+		data        []byte
+		contentType string
+		err         error
+	}{
+		{"default", "", "image/png", html, "image/png", nil},
+		{"keep", ContentTypeMismatchKeep, "image/png", html, "image/png", nil},
+		{"overwrite", ContentTypeMismatchOverwrite, "image/png", html, "text/html", nil},
+		{"overwrite-match", ContentTypeMismatchOverwrite, "image/png", png, "image/png", nil},
+		{"overwrite-params", ContentTypeMismatchOverwrite, "text/html; charset=utf-8", html, "text/html; charset=utf-8", nil},
+		{"overwrite-generic", ContentTypeMismatchOverwrite, "application/json", []byte(`{"foo": 23}`), "application/json", nil},
+		{"reject", ContentTypeMismatchReject, "image/png", html, "", ErrContentTypeMismatch},
+		{"reject-invalid", ContentTypeMismatchReject, "image/", png, "", ErrContentTypeMismatch},
+		{"reject-match", ContentTypeMismatchReject, "image/png", png, "image/png", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buff := &bytes.Buffer{}
+			writer := multipart.NewWriter(buff)
+
+			header := make(textproto.MIMEHeader)
+			header.Set("Content-Disposition", `form-data; name="file"; filename="test"`)
+			header.Set("Content-Type", test.declared)
+			if w, err := writer.CreatePart(header); err != nil {
+				t.Fatal(err)
+			} else if _, err := w.Write(test.data); err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := http.NewRequest("POST", "http://foo.bar/", buff)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Set("Content-Type", writer.FormDataContentType())
+			r.RemoteAddr = "[fe80::42]:2342"
+
+			i, f, err := NewItemFromRequest(r, ItemOpts{
+				MaxSize:                1024,
+				DefaultLifetime:        time.Hour,
+				MaxLifetime:            time.Hour,
+				MismatchingContentType: test.mode,
+			})
+			if err != test.err {
+				t.Fatalf("Expected error %v, got %v", test.err, err)
+			} else if err != nil {
+				return
+			}
+			defer f.Close()
+
+			if i.ContentType != test.contentType {
+				t.Fatalf("Expected Content-Type %q, got %q", test.contentType, i.ContentType)
+			}
+
+			// Sniffing must not consume the file.
+			if data, err := io.ReadAll(f); err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(data, test.data) {
+				t.Fatalf("File data mismatches, got %q", data)
+			}
+		})
+	}
+}
+
+func TestItemMalformedRequest(t *testing.T) {
+	multipartBody := func(field string) (io.Reader, string) {
+		buff := &bytes.Buffer{}
+		writer := multipart.NewWriter(buff)
+		if w, err := writer.CreateFormFile(field, "test.txt"); err != nil {
+			t.Fatal(err)
+		} else if _, err := w.Write([]byte("hello world")); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buff, writer.FormDataContentType()
+	}
+
+	tests := []struct {
+		name string
+		body func() (io.Reader, string)
+		err  error
+	}{
+		{"no content type", func() (io.Reader, string) {
+			return strings.NewReader("hello world"), ""
+		}, ErrMultipartInvalid},
+		{"url encoded", func() (io.Reader, string) {
+			return strings.NewReader("file=hello"), "application/x-www-form-urlencoded"
+		}, ErrMultipartInvalid},
+		{"truncated multipart", func() (io.Reader, string) {
+			return strings.NewReader("--nope\r\nContent-Disposition: form-data; name=\"file\"; filename=\"a\"\r\n\r\nhello"), "multipart/form-data; boundary=nope"
+		}, ErrIncompleteFile},
+		{"wrong field", func() (io.Reader, string) {
+			return multipartBody("upload")
+		}, ErrFileFieldMissing},
+		{"file field", func() (io.Reader, string) {
+			return multipartBody("file")
+		}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, contentType := test.body()
+			r, err := http.NewRequest("POST", "http://foo.bar/", body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contentType != "" {
+				r.Header.Set("Content-Type", contentType)
+			}
+			r.RemoteAddr = "[fe80::42]:2342"
+
+			_, f, err := NewItemFromRequest(r, ItemOpts{
+				MaxSize:         1024,
+				DefaultLifetime: time.Hour,
+				MaxLifetime:     time.Hour,
+			})
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Expected error %v, got %v", test.err, err)
+			}
+			if f != nil {
+				f.Close()
+			}
+		})
+	}
+}
+
+func TestItemStreaming(t *testing.T) {
+	tests := []struct {
+		name        string
+		fieldsFirst bool
+		field       string
+		value       string
+		size        int
+		err         error
+	}{
+		{"fields first", true, formBurnAfterReading, "1", 1024, nil},
+		{"field after file", false, formBurnAfterReading, "1", 1024, nil},
+		{"lifetime after file", false, formLifetime, "30m", 1024, nil},
+		{"invalid field after file", false, formLifetime, "2h", 1024, ErrLifetimeTooLong},
+		{"checksum after file", false, "sha256", sha256Hex(bytes.Repeat([]byte("a"), 1024)), 1024, ErrFieldAfterFile},
+		{"too big", true, formBurnAfterReading, "1", 1025, ErrFileTooBig},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buff := &bytes.Buffer{}
+			writer := multipart.NewWriter(buff)
+
+			writeField := func() {
+				if err := writer.WriteField(test.field, test.value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if test.fieldsFirst {
+				writeField()
+			}
+			if w, err := writer.CreateFormFile(formFile, "test.txt"); err != nil {
+				t.Fatal(err)
+			} else if _, err := w.Write(bytes.Repeat([]byte("a"), test.size)); err != nil {
+				t.Fatal(err)
+			}
+			if !test.fieldsFirst {
+				writeField()
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := http.NewRequest("POST", "http://foo.bar/", buff)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Set("Content-Type", writer.FormDataContentType())
+			r.RemoteAddr = "[fe80::42]:2342"
+
+			i, f, err := NewItemFromRequest(r, ItemOpts{
+				MaxSize:            1024,
+				DefaultLifetime:    time.Hour,
+				MaxLifetime:        time.Hour,
+				ChecksumAlgorithms: []string{"sha256"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			if i.BurnAfterReading != test.fieldsFirst {
+				t.Fatalf("Expected BurnAfterReading %t, got %t", test.fieldsFirst, i.BurnAfterReading)
+			}
+
+			data, err := io.ReadAll(f)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Expected error %v, got %v", test.err, err)
+			}
+			if len(data) > 1024 {
+				t.Fatalf("Read %d bytes, exceeding the maximum size", len(data))
+			}
+
+			// Form fields succeeding the file are parsed into its settings.
+			if test.fieldsFirst || test.err != nil {
+				return
+			}
+			settings := f.(*uploadFile).settings
+			if err := settings.apply(&i); err != nil {
+				t.Fatal(err)
+			}
+			if test.field == formBurnAfterReading && !i.BurnAfterReading {
+				t.Fatalf("Trailing burn field was not applied: %v", settings)
+			}
+			if lifetime := i.Expires.Sub(i.Created); test.field == formLifetime && lifetime != 30*time.Minute {
+				t.Fatalf("Expected trailing lifetime of 30m, got %v", lifetime)
+			}
+		})
+	}
+}
+
+func TestItemPassword(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := Item{PasswordHash: string(hash)}
+
+	for password, valid := range map[string]bool{"hunter2": true, "hunter3": false, "": false} {
+		if ok := item.checkPassword(password); ok != valid {
+			t.Fatalf("Password %q: expected %t, got %t", password, valid, ok)
+		}
+	}
+
+	if !(Item{}).checkPassword("") {
+		t.Fatal("Item without a password rejected a download")
+	}
+}
+
+func TestItemDeletionKey(t *testing.T) {
+	item := Item{DeletionKey: "key"}
+
+	for delKey, valid := range map[string]bool{"key": true, "kex": false, "ke": false, "": false} {
+		if ok := item.checkDeletionKey(delKey); ok != valid {
+			t.Fatalf("Deletion key %q: expected %t, got %t", delKey, valid, ok)
+		}
+	}
+
+	if (Item{}).checkDeletionKey("") {
+		t.Fatal("Item without a deletion key accepted an empty one")
+	}
+}
+
+func TestItemReserveDownload(t *testing.T) {
+	item := Item{MaxDownloads: 2}
+	for i, expected := range []error{nil, nil, ErrDownloadsExhausted, ErrDownloadsExhausted} {
+		if err := item.reserveDownload(time.Now()); err != expected {
+			t.Fatalf("Reservation %d: expected %v, got %v", i, expected, err)
+		}
+		if last := item.lastDownload(); last != (i >= 1) {
+			t.Fatalf("Reservation %d: expected last download %t, got %t", i, i >= 1, last)
+		}
+	}
+	if item.Downloads != 2 {
+		t.Fatalf("Expected 2 downloads, got %d", item.Downloads)
+	}
+
+	unlimited := Item{}
+	for i := 0; i < 8; i++ {
+		if err := unlimited.reserveDownload(time.Now()); err != nil {
+			t.Fatal(err)
+		} else if unlimited.lastDownload() {
+			t.Fatal("Unlimited Item reached its last download")
+		}
+	}
+}
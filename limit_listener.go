@@ -0,0 +1,145 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"sync"
+)
+
+// limitListener wraps a net.Listener to limit concurrent connections, both
+// globally and per remote IP address.
+//
+// When reaching the global limit, Accept blocks until another connection is
+// closed. Thus, excess connections are being held in the kernel's backlog.
+// Excess connections from a single IP address are closed directly.
+type limitListener struct {
+	net.Listener
+
+	// sem is a semaphore for the global limit, or nil.
+	sem chan struct{}
+
+	maxPerIP int
+	mu       sync.Mutex
+	perIP    map[string]int
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// newLimitListener wraps the net.Listener in a limitListener. A limit of zero
+// disables it. If both limits are disabled, the listener is returned as is.
+func newLimitListener(ln net.Listener, maxConns, maxConnsPerIP int) net.Listener {
+	if maxConns <= 0 && maxConnsPerIP <= 0 {
+		return ln
+	}
+
+	l := &limitListener{
+		Listener: ln,
+		maxPerIP: maxConnsPerIP,
+		perIP:    make(map[string]int),
+		done:     make(chan struct{}),
+	}
+	if maxConns > 0 {
+		l.sem = make(chan struct{}, maxConns)
+	}
+	return l
+}
+
+// acquire a global slot or return false if the listener was closed.
+func (l *limitListener) acquire() bool {
+	if l.sem == nil {
+		return true
+	}
+
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+// release a global slot.
+func (l *limitListener) release() {
+	if l.sem != nil {
+		<-l.sem
+	}
+}
+
+// remoteIP of a connection, or an empty string, e.g., for Unix domain sockets.
+func remoteIP(conn net.Conn) string {
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return ""
+	}
+	return addr.IP.String()
+}
+
+// Accept waits for a global slot and the next connection within the limits.
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		if !l.acquire() {
+			return nil, net.ErrClosed
+		}
+
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			l.release()
+			return nil, err
+		}
+
+		ip := remoteIP(conn)
+		if l.maxPerIP > 0 && ip != "" {
+			l.mu.Lock()
+			if l.perIP[ip] >= l.maxPerIP {
+				l.mu.Unlock()
+
+				slog.Debug("Closing connection exceeding the per IP limit", slog.String("ip", ip))
+				_ = conn.Close()
+				l.release()
+				continue
+			}
+			l.perIP[ip]++
+			l.mu.Unlock()
+		} else {
+			ip = ""
+		}
+
+		return &limitConn{Conn: conn, listener: l, ip: ip}, nil
+	}
+}
+
+// Close the listener and wake up a blocking Accept.
+func (l *limitListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// limitConn releases its limitListener's slots when being closed.
+type limitConn struct {
+	net.Conn
+
+	listener *limitListener
+	ip       string
+
+	releaseOnce sync.Once
+}
+
+// Close the connection and release its slots.
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+
+	c.releaseOnce.Do(func() {
+		if c.ip != "" {
+			c.listener.mu.Lock()
+			if c.listener.perIP[c.ip]--; c.listener.perIP[c.ip] <= 0 {
+				delete(c.listener.perIP, c.ip)
+			}
+			c.listener.mu.Unlock()
+		}
+
+		c.listener.release()
+	})
+
+	return err
+}
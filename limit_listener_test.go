@@ -0,0 +1,185 @@
+package main
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeConn is a net.Conn with a configurable remote address.
+type fakeConn struct {
+	net.Conn
+	remote net.Addr
+	closed atomic.Bool
+}
+
+func (c *fakeConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *fakeConn) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+// fakeListener returns the connections being sent into its channel.
+type fakeListener struct {
+	conns chan net.Conn
+}
+
+func (l *fakeListener) Accept() (net.Conn, error) {
+	conn, ok := <-l.conns
+	if !ok {
+		return nil, net.ErrClosed
+	}
+	return conn, nil
+}
+
+func (l *fakeListener) Close() error {
+	return nil
+}
+
+func (l *fakeListener) Addr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func newFakeConn(ip string) *fakeConn {
+	return &fakeConn{remote: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}}
+}
+
+// acceptTimeout accepts a connection or returns nil after a short timeout.
+func acceptTimeout(t *testing.T, ln net.Listener) net.Conn {
+	connCh := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			connCh <- nil
+			return
+		}
+		connCh <- conn
+	}()
+
+	select {
+	case conn := <-connCh:
+		return conn
+	case <-time.After(100 * time.Millisecond):
+		return nil
+	}
+}
+
+func TestLimitListenerDisabled(t *testing.T) {
+	fake := &fakeListener{}
+	if ln := newLimitListener(fake, 0, 0); ln != net.Listener(fake) {
+		t.Fatal("Listener was wrapped without any limit")
+	}
+}
+
+func TestLimitListenerPerIP(t *testing.T) {
+	fake := &fakeListener{conns: make(chan net.Conn, 16)}
+	ln := newLimitListener(fake, 0, 2)
+
+	sources := []string{"192.0.2.1", "192.0.2.1", "192.0.2.1", "192.0.2.2", "2001:db8::1"}
+	fakeConns := make([]*fakeConn, len(sources))
+	for i, ip := range sources {
+		fakeConns[i] = newFakeConn(ip)
+		fake.conns <- fakeConns[i]
+	}
+
+	// The third connection from 192.0.2.1 is closed and skipped.
+	var accepted []net.Conn
+	for i := 0; i < 4; i++ {
+		conn := acceptTimeout(t, ln)
+		if conn == nil {
+			t.Fatalf("Connection %d was not accepted", i)
+		}
+		accepted = append(accepted, conn)
+	}
+	if !fakeConns[2].closed.Load() {
+		t.Fatal("Excess connection was not closed")
+	}
+	if accepted[2].RemoteAddr().String() != fakeConns[3].remote.String() {
+		t.Fatalf("Unexpected third connection from %v", accepted[2].RemoteAddr())
+	}
+
+	// After closing one connection, 192.0.2.1 might connect again.
+	_ = accepted[0].Close()
+	_ = accepted[0].Close()
+
+	again := newFakeConn("192.0.2.1")
+	fake.conns <- again
+	if conn := acceptTimeout(t, ln); conn == nil {
+		t.Fatal("Connection was not accepted after closing another one")
+	}
+
+	excess := newFakeConn("192.0.2.1")
+	fake.conns <- excess
+	if conn := acceptTimeout(t, ln); conn != nil {
+		t.Fatalf("Excess connection from %v was accepted", conn.RemoteAddr())
+	}
+	if !excess.closed.Load() {
+		t.Fatal("Excess connection was not closed")
+	}
+}
+
+func TestLimitListenerGlobal(t *testing.T) {
+	fake := &fakeListener{conns: make(chan net.Conn, 16)}
+	ln := newLimitListener(fake, 3, 0)
+
+	for i := 0; i < 5; i++ {
+		fake.conns <- newFakeConn("192.0.2.1")
+	}
+
+	var accepted []net.Conn
+	for i := 0; i < 3; i++ {
+		conn := acceptTimeout(t, ln)
+		if conn == nil {
+			t.Fatalf("Connection %d was not accepted", i)
+		}
+		accepted = append(accepted, conn)
+	}
+
+	// The fourth connection is held until another one is closed.
+	connCh := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		connCh <- conn
+	}()
+
+	select {
+	case conn := <-connCh:
+		t.Fatalf("Connection exceeding the global limit was accepted: %v", conn)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_ = accepted[0].Close()
+
+	select {
+	case conn := <-connCh:
+		if conn == nil {
+			t.Fatal("Held connection failed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Held connection was not accepted after closing another one")
+	}
+
+	// A blocked Accept returns after closing the listener.
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			_ = conn.Close()
+		}
+		connCh <- conn
+	}()
+	time.Sleep(50 * time.Millisecond)
+	_ = ln.Close()
+
+	select {
+	case conn := <-connCh:
+		if conn != nil {
+			t.Fatal("Accept returned a connection after closing")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Accept still blocks after closing")
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// rotatingFile is an io.Writer appending to a log file, which is rotated when
+// exceeding its maximum size or age. Rotated files get a numeric suffix, e.g.,
+// "gosh.log.1" being the most recent one, and only maxBackups are kept.
+//
+// All file operations are relative to the directory's file descriptor, being
+// opened on creation. Thus, the rotatingFile continues to work within the
+// monitor's chroot, as long as the directory is writable for its user.
+type rotatingFile struct {
+	mu sync.Mutex
+
+	dirFd int
+	name  string
+
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	f      *os.File
+	size   int64
+	opened time.Time
+
+	// now is time.Now, replaceable for testing.
+	now func() time.Time
+}
+
+// newRotatingFile opens or creates the log file at the path. A maxSize or
+// maxAge of zero disables the respective rotation trigger.
+func newRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	dirFd, err := unix.Open(filepath.Dir(path), unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open log directory: %w", err)
+	}
+
+	rf := &rotatingFile{
+		dirFd: dirFd,
+		name:  filepath.Base(path),
+
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+
+		now: time.Now,
+	}
+
+	err = rf.open()
+	if err != nil {
+		_ = unix.Close(dirFd)
+		return nil, err
+	}
+	return rf, nil
+}
+
+// open the log file for appending.
+func (rf *rotatingFile) open() error {
+	fd, err := unix.Openat(rf.dirFd, rf.name, unix.O_WRONLY|unix.O_CREAT|unix.O_APPEND|unix.O_CLOEXEC, 0640)
+	if err != nil {
+		return fmt.Errorf("cannot open log file: %w", err)
+	}
+	f := os.NewFile(uintptr(fd), rf.name)
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	rf.f = f
+	rf.size = fi.Size()
+	rf.opened = rf.now()
+	return nil
+}
+
+// backupName of the nth rotated log file.
+func (rf *rotatingFile) backupName(n int) string {
+	return fmt.Sprintf("%s.%d", rf.name, n)
+}
+
+// shouldRotate checks if writing n more bytes requires a rotation first.
+func (rf *rotatingFile) shouldRotate(n int) bool {
+	if rf.size == 0 {
+		return false
+	}
+
+	if rf.maxSize > 0 && rf.size+int64(n) > rf.maxSize {
+		return true
+	}
+	return rf.maxAge > 0 && rf.now().Sub(rf.opened) >= rf.maxAge
+}
+
+// rotate closes the current log file, shifts the backups, and opens a new one.
+//
+// A new log file is being opened, even if shifting the backups has failed.
+func (rf *rotatingFile) rotate() error {
+	var errs []error
+	ignoreNotExist := func(err error) {
+		if err != nil && !errors.Is(err, unix.ENOENT) {
+			errs = append(errs, err)
+		}
+	}
+
+	errs = append(errs, rf.f.Close())
+	rf.f = nil
+
+	if rf.maxBackups <= 0 {
+		ignoreNotExist(unix.Unlinkat(rf.dirFd, rf.name, 0))
+	} else {
+		ignoreNotExist(unix.Unlinkat(rf.dirFd, rf.backupName(rf.maxBackups), 0))
+		for i := rf.maxBackups - 1; i >= 1; i-- {
+			ignoreNotExist(unix.Renameat(rf.dirFd, rf.backupName(i), rf.dirFd, rf.backupName(i+1)))
+		}
+		ignoreNotExist(unix.Renameat(rf.dirFd, rf.name, rf.dirFd, rf.backupName(1)))
+	}
+
+	errs = append(errs, rf.open())
+	return errors.Join(errs...)
+}
+
+// Write p to the log file, which might be rotated beforehand.
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.f != nil && rf.shouldRotate(len(p)) {
+		if err := rf.rotate(); err != nil {
+			// Logging this would end up here again.
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file: %v\n", err)
+		}
+	}
+
+	if rf.f == nil {
+		if err := rf.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Close the log file and its directory.
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	var err error
+	if rf.f != nil {
+		err = rf.f.Close()
+		rf.f = nil
+	}
+	return errors.Join(err, unix.Close(rf.dirFd))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	logDir := t.TempDir()
+	logPath := filepath.Join(logDir, "gosh.log")
+
+	rf, err := newRotatingFile(logPath, 16, time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	now := time.Now()
+	rf.now = func() time.Time { return now }
+	rf.opened = now
+
+	readLog := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(logDir, name))
+		if os.IsNotExist(err) {
+			return ""
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	steps := []struct {
+		name    string
+		line    string
+		advance time.Duration
+		files   [3]string
+	}{
+		{"first", "aaaaaaaa\n", 0, [3]string{"aaaaaaaa\n", "", ""}},
+		{"within-size", "bbbbbb\n", 0, [3]string{"aaaaaaaa\nbbbbbb\n", "", ""}},
+		{"exceeds-size", "c\n", 0, [3]string{"c\n", "aaaaaaaa\nbbbbbb\n", ""}},
+		{"within-age", "d\n", 59 * time.Minute, [3]string{"c\nd\n", "aaaaaaaa\nbbbbbb\n", ""}},
+		{"exceeds-age", "e\n", time.Minute, [3]string{"e\n", "c\nd\n", "aaaaaaaa\nbbbbbb\n"}},
+		{"drops-oldest", strings.Repeat("f", 16) + "\n", 0, [3]string{strings.Repeat("f", 16) + "\n", "e\n", "c\nd\n"}},
+	}
+
+	for _, step := range steps {
+		now = now.Add(step.advance)
+
+		if _, err := rf.Write([]byte(step.line)); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		for i, name := range []string{"gosh.log", "gosh.log.1", "gosh.log.2"} {
+			if data := readLog(name); data != step.files[i] {
+				t.Fatalf("%s: %s contains %q, expected %q", step.name, name, data, step.files[i])
+			}
+		}
+	}
+
+	if entries, err := os.ReadDir(logDir); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 3 {
+		t.Fatalf("Log directory contains %d files, expected 3", len(entries))
+	}
+}
+
+func TestRotatingFileNoBackups(t *testing.T) {
+	logDir := t.TempDir()
+	logPath := filepath.Join(logDir, "gosh.log")
+
+	if err := os.WriteFile(logPath, []byte("existing\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	rf, err := newRotatingFile(logPath, 10, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	if _, err := rf.Write([]byte("new\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := os.ReadFile(logPath); err != nil {
+		t.Fatal(err)
+	} else if string(data) != "new\n" {
+		t.Fatalf("Log file contains %q", data)
+	}
+
+	if entries, err := os.ReadDir(logDir); err != nil {
+		t.Fatal(err)
+	} else if len(entries) != 1 {
+		t.Fatalf("Log directory contains %d files, expected 1", len(entries))
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SmtpConfig configures the SmtpMailer.
+type SmtpConfig struct {
+	// Address of the SMTP server as "host:port". As the webserver is chrooted,
+	// the host should be an IP address without requiring a DNS resolver.
+	Address string `yaml:"address"`
+
+	// Username and Password for an optional PLAIN authentication, only being
+	// used over TLS or to localhost.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	// From is the sender's email address.
+	From string `yaml:"from"`
+}
+
+// smtpTimeout limits sending a single email, including connecting.
+const smtpTimeout = 30 * time.Second
+
+// SmtpMailer sends plain text emails by an SMTP server, using STARTTLS if
+// being offered.
+type SmtpMailer struct {
+	addr string
+	host string
+	auth smtp.Auth
+	from *mail.Address
+}
+
+// NewSmtpMailer creates a SmtpMailer for the SmtpConfig.
+func NewSmtpMailer(conf SmtpConfig) (*SmtpMailer, error) {
+	host, _, err := net.SplitHostPort(conf.Address)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse SMTP address %q: %w", conf.Address, err)
+	}
+
+	from, err := mail.ParseAddress(conf.From)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse SMTP from address %q: %w", conf.From, err)
+	}
+
+	var auth smtp.Auth
+	if conf.Username != "" {
+		auth = smtp.PlainAuth("", conf.Username, conf.Password, host)
+	}
+
+	return &SmtpMailer{
+		addr: conf.Address,
+		host: host,
+		auth: auth,
+		from: from,
+	}, nil
+}
+
+// buildMail creates a plain text email. The subject is encoded and the
+// optional replyTo is a parsed address, preventing a header injection.
+func (m *SmtpMailer) buildMail(to *mail.Address, replyTo *mail.Address, subject, body string, now time.Time) ([]byte, error) {
+	msgIdBuff := make([]byte, 16)
+	if _, err := rand.Read(msgIdBuff); err != nil {
+		return nil, err
+	}
+	_, fromDomain, _ := strings.Cut(m.from.Address, "@")
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", m.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	if replyTo != nil {
+		fmt.Fprintf(&buf, "Reply-To: %s\r\n", replyTo)
+	}
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(msgIdBuff), fromDomain)
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&buf, "Content-Transfer-Encoding: 8bit\r\n")
+	fmt.Fprintf(&buf, "\r\n")
+
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return buf.Bytes(), nil
+}
+
+// Send an email to the recipient, optionally with a Reply-To address.
+func (m *SmtpMailer) Send(to string, replyTo *mail.Address, subject, body string) error {
+	toAddr, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("cannot parse recipient %q: %w", to, err)
+	}
+
+	msg, err := m.buildMail(toAddr, replyTo, subject, body, time.Now())
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", m.addr, smtpTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(smtpTimeout)); err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+			return err
+		}
+	}
+	if m.auth != nil {
+		if err := c.Auth(m.auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(m.from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(toAddr.Address); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
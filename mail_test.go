@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestSmtpServer starts a minimal SMTP server on localhost, passing each
+// received message's data to the returned channel.
+func newTestSmtpServer(t *testing.T) (addr string, msgs <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	msgCh := make(chan string, 8)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSmtp(conn, msgCh)
+		}
+	}()
+
+	return ln.Addr().String(), msgCh
+}
+
+// serveTestSmtp answers a single SMTP session without any extensions.
+func serveTestSmtp(conn net.Conn, msgCh chan<- string) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		_, _ = conn.Write([]byte(line + "\r\n"))
+	}
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		cmd, _, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO", "HELO", "MAIL", "RCPT", "RSET", "NOOP":
+			reply("250 OK")
+
+		case "DATA":
+			reply("354 Go ahead")
+
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			msgCh <- data.String()
+			reply("250 OK")
+
+		case "QUIT":
+			reply("221 Bye")
+			return
+
+		default:
+			reply("502 Unsupported")
+		}
+	}
+}
+
+func TestSmtpMailerBuildMail(t *testing.T) {
+	mailer, err := NewSmtpMailer(SmtpConfig{Address: "127.0.0.1:25", From: "gosh@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	to := &mail.Address{Address: "abuse@example.com"}
+	replyTo := &mail.Address{Name: "Eve\r\nBcc: victim@example.com", Address: "eve@example.com"}
+	msg, err := mailer.buildMail(to, replyTo, "Report\r\nBcc: victim@example.com", "line 1\nline 2\n", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bcc := parsed.Header.Get("Bcc"); bcc != "" {
+		t.Fatalf("Header was injected: %q", bcc)
+	}
+	if from := parsed.Header.Get("From"); from != "<gosh@example.com>" {
+		t.Fatalf("Unexpected From header %q", from)
+	}
+	if replyTo, err := parsed.Header.AddressList("Reply-To"); err != nil || replyTo[0].Address != "eve@example.com" {
+		t.Fatalf("Unexpected Reply-To header %v: %v", replyTo, err)
+	}
+	if !strings.Contains(string(msg), "\r\n\r\nline 1\r\nline 2\r\n") {
+		t.Fatalf("Body lacks CRLF line endings: %q", msg)
+	}
+}
+
+func TestSmtpMailerSend(t *testing.T) {
+	addr, msgs := newTestSmtpServer(t)
+
+	mailer, err := NewSmtpMailer(SmtpConfig{Address: addr, From: "gosh@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mailer.Send("abuse@example.com", nil, "Hello", "Hello World\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-msgs:
+		if !strings.Contains(msg, "Subject: Hello\r\n") || !strings.HasSuffix(msg, "\r\n\r\nHello World\r\n") {
+			t.Fatalf("Unexpected message %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No message was received")
+	}
+
+	for _, conf := range []SmtpConfig{
+		{Address: "127.0.0.1", From: "gosh@example.com"},
+		{Address: "127.0.0.1:25", From: "gosh"},
+	} {
+		if _, err := NewSmtpMailer(conf); err == nil {
+			t.Fatalf("Invalid config %v was accepted", conf)
+		}
+	}
+}
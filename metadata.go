@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// This file contains functions to strip metadata, e.g., EXIF including a GPS
+// location, from uploaded images without decoding and re-encoding them.
+//
+// Both supported formats are being processed as a stream of segments resp.
+// chunks, which are either copied or skipped. Thus, neither the CPU nor the
+// memory usage depends on the image's dimensions.
+
+// ErrMetadataFormat is returned if an image cannot be parsed for stripping.
+var ErrMetadataFormat = errors.New("Image format cannot be parsed for stripping metadata")
+
+// stripMetadataFuncs maps supported MIME types to their stripping function.
+var stripMetadataFuncs = map[string]func(io.Writer, io.Reader) error{
+	"image/jpeg": stripJpegMetadata,
+	"image/png":  stripPngMetadata,
+}
+
+// canStripMetadata checks if metadata can be stripped for this MIME type.
+func canStripMetadata(contentType string) bool {
+	_, ok := stripMetadataFuncs[contentType]
+	return ok
+}
+
+// stripMetadata copies the image from src to dst without its metadata.
+func stripMetadata(dst io.Writer, src io.Reader, contentType string) error {
+	f, ok := stripMetadataFuncs[contentType]
+	if !ok {
+		return fmt.Errorf("stripping metadata is not supported for %q", contentType)
+	}
+	return f(dst, src)
+}
+
+// stripJpegMetadata strips all APPn segments except APP0 (JFIF), APP2 (ICC
+// color profile), and APP14 (Adobe color transform) as well as comments.
+//
+// All segments after the first Start of Scan segment are copied verbatim.
+func stripJpegMetadata(dst io.Writer, src io.Reader) error {
+	const (
+		markerTem  = 0x01
+		markerRst0 = 0xd0
+		markerRst7 = 0xd7
+		markerSoi  = 0xd8
+		markerEoi  = 0xd9
+		markerSos  = 0xda
+		markerCom  = 0xfe
+		markerApp  = 0xe0
+	)
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(src, head[:2]); err != nil {
+		return fmt.Errorf("%w: %v", ErrMetadataFormat, err)
+	} else if head[0] != 0xff || head[1] != markerSoi {
+		return fmt.Errorf("%w: missing JPEG SOI marker", ErrMetadataFormat)
+	}
+	if _, err := dst.Write(head[:2]); err != nil {
+		return err
+	}
+
+	for {
+		if _, err := io.ReadFull(src, head[:2]); err != nil {
+			return fmt.Errorf("%w: %v", ErrMetadataFormat, err)
+		} else if head[0] != 0xff {
+			return fmt.Errorf("%w: invalid JPEG marker %x", ErrMetadataFormat, head[:2])
+		}
+
+		// Each marker might be preceded by any number of 0xFF fill bytes.
+		for head[1] == 0xff {
+			if _, err := io.ReadFull(src, head[1:2]); err != nil {
+				return fmt.Errorf("%w: %v", ErrMetadataFormat, err)
+			}
+		}
+
+		// Standalone markers carry no length and are kept as they are.
+		marker := head[1]
+		if marker == markerTem || (marker >= markerRst0 && marker <= markerRst7) || marker == markerSoi {
+			if _, err := dst.Write(head[:2]); err != nil {
+				return err
+			}
+			continue
+		} else if marker == markerEoi {
+			if _, err := dst.Write(head[:2]); err != nil {
+				return err
+			}
+			_, err := io.Copy(dst, src)
+			return err
+		}
+
+		if _, err := io.ReadFull(src, head[2:]); err != nil {
+			return fmt.Errorf("%w: %v", ErrMetadataFormat, err)
+		}
+		length := int64(binary.BigEndian.Uint16(head[2:])) - 2
+		if length < 0 {
+			return fmt.Errorf("%w: invalid JPEG segment length", ErrMetadataFormat)
+		}
+
+		skip := marker == markerCom ||
+			(marker > markerApp && marker <= markerApp+15 && marker != markerApp+2 && marker != markerApp+14)
+		if skip {
+			if _, err := io.CopyN(io.Discard, src, length); err != nil {
+				return fmt.Errorf("%w: %v", ErrMetadataFormat, err)
+			}
+			continue
+		}
+
+		if _, err := dst.Write(head); err != nil {
+			return err
+		}
+
+		if marker == markerSos {
+			_, err := io.Copy(dst, src)
+			return err
+		}
+
+		if _, err := io.CopyN(dst, src, length); err != nil {
+			return fmt.Errorf("%w: %v", ErrMetadataFormat, err)
+		}
+	}
+}
+
+// stripPngMetadata strips all textual, EXIF, and time chunks from a PNG.
+func stripPngMetadata(dst io.Writer, src io.Reader) error {
+	pngSignature := []byte("\x89PNG\r\n\x1a\n")
+	skipChunks := map[string]struct{}{
+		"tEXt": {},
+		"zTXt": {},
+		"iTXt": {},
+		"eXIf": {},
+		"tIME": {},
+	}
+
+	sig := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(src, sig); err != nil {
+		return fmt.Errorf("%w: %v", ErrMetadataFormat, err)
+	} else if !bytes.Equal(sig, pngSignature) {
+		return fmt.Errorf("%w: missing PNG signature", ErrMetadataFormat)
+	}
+	if _, err := dst.Write(sig); err != nil {
+		return err
+	}
+
+	head := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(src, head); err != nil {
+			return fmt.Errorf("%w: %v", ErrMetadataFormat, err)
+		}
+
+		// Chunk's data is followed by a four byte long CRC.
+		length := int64(binary.BigEndian.Uint32(head[:4])) + 4
+		chunkType := string(head[4:])
+
+		if _, skip := skipChunks[chunkType]; skip {
+			if _, err := io.CopyN(io.Discard, src, length); err != nil {
+				return fmt.Errorf("%w: %v", ErrMetadataFormat, err)
+			}
+			continue
+		}
+
+		if _, err := dst.Write(head); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(dst, src, length); err != nil {
+			return fmt.Errorf("%w: %v", ErrMetadataFormat, err)
+		}
+
+		if chunkType == "IEND" {
+			return nil
+		}
+	}
+}
+
+// stripMetadataFile returns a reader for the file without its metadata and
+// whether the file is being stripped.
+//
+// A seekable file is checked in a first pass. If the file cannot be parsed,
+// e.g., because its claimed MIME type is wrong, or if its type is not
+// supported, the unaltered file is returned.
+//
+// A streamed file, being able to Peek, is only checked by its first bytes. If
+// it cannot be parsed afterwards, the original file is passed on, unless more
+// than stripMetadataHoldback bytes were already stripped. Then, reading fails
+// with ErrMetadataFormat.
+func stripMetadataFile(file io.ReadCloser, contentType string) (io.ReadCloser, bool) {
+	if !canStripMetadata(contentType) {
+		return file, false
+	}
+
+	rs, ok := file.(io.ReadSeeker)
+	if !ok {
+		return stripMetadataStream(file, contentType)
+	}
+
+	checkErr := stripMetadata(io.Discard, rs, contentType)
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		slog.Error("Failed to seek file after checking metadata", slog.Any("error", err))
+		return file, false
+	}
+	if checkErr != nil {
+		slog.Warn("Failed to strip metadata, keeping the original file",
+			slog.String("mime", contentType), slog.Any("error", checkErr))
+		return file, false
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		err := stripMetadata(pw, rs, contentType)
+		_ = file.Close()
+		_ = pw.CloseWithError(err)
+	}()
+	return pr, true
+}
+
+// stripMetadataHoldback limits the bytes of a streamed file being held back
+// while stripping its metadata. Up to this size, the original file is passed
+// on if it cannot be parsed. Beyond, the stripped file is passed on and a later
+// parsing failure fails its upload with ErrMetadataFormat.
+const stripMetadataHoldback = 16 << 20
+
+// stripMetadataStream strips metadata in a single pass from a file which
+// cannot be seeked, but allows to Peek at its first bytes to verify its type.
+//
+// Both the stripped output and the original bytes are held back, up to the
+// stripMetadataHoldback, to fall back to the original file if stripping fails.
+func stripMetadataStream(file io.ReadCloser, contentType string) (io.ReadCloser, bool) {
+	peeker, ok := file.(interface{ Peek(int) ([]byte, error) })
+	if !ok {
+		slog.Warn("Cannot strip metadata from a non-seekable file")
+		return file, false
+	}
+
+	head, err := peeker.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		slog.Warn("Failed to peek at file for stripping metadata", slog.Any("error", err))
+		return file, false
+	}
+	if sniffed, err := sniffContentType(head); err != nil || sniffed != contentType {
+		slog.Warn("Cannot strip metadata of a file with a mismatching MIME type",
+			slog.String("mime", contentType), slog.String("sniffed", sniffed))
+		return file, false
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		src := &errRecordingReader{Reader: file}
+		holdback := &metadataHoldback{src: src, dst: pw, limit: stripMetadataHoldback}
+
+		err := stripMetadata(holdback, holdback, contentType)
+		if err == nil {
+			// Reading until EOF surfaces errors of a streamed upload, e.g.,
+			// ErrTrailingFieldInvalid, as PNG stripping stops at the IEND chunk.
+			_, err = io.Copy(io.Discard, src)
+		}
+		if err == nil {
+			err = holdback.commit()
+		} else if errors.Is(err, ErrMetadataFormat) && src.err == nil {
+			if restored, restoreErr := holdback.restore(); restored {
+				slog.Warn("Failed to strip metadata, keeping the original file",
+					slog.String("mime", contentType), slog.Any("error", err))
+
+				err = restoreErr
+				if err == nil {
+					_, err = io.Copy(pw, src)
+				}
+			}
+		}
+		if src.err != nil {
+			// Prefer the file's error, e.g., ErrFileTooBig, over its effect.
+			err = src.err
+		}
+		_ = file.Close()
+		_ = pw.CloseWithError(err)
+	}()
+	return pr, true
+}
+
+// metadataHoldback is both the source and the destination of stripping a
+// streamed file's metadata. It holds back the stripped output as well as the
+// original bytes read, until either is passed on to dst by commit resp.
+// restore. When the original exceeds the limit, the stripped output is being
+// committed.
+type metadataHoldback struct {
+	src   io.Reader
+	dst   io.Writer
+	limit int
+
+	original  bytes.Buffer
+	stripped  bytes.Buffer
+	committed bool
+}
+
+func (h *metadataHoldback) Read(p []byte) (int, error) {
+	n, err := h.src.Read(p)
+	if !h.committed {
+		h.original.Write(p[:n])
+		if h.original.Len() > h.limit {
+			if commitErr := h.commit(); commitErr != nil {
+				return n, commitErr
+			}
+		}
+	}
+	return n, err
+}
+
+func (h *metadataHoldback) Write(p []byte) (int, error) {
+	if h.committed {
+		return h.dst.Write(p)
+	}
+	return h.stripped.Write(p)
+}
+
+// commit passes on the held back stripped output. Afterwards, the original
+// cannot be restored anymore.
+func (h *metadataHoldback) commit() error {
+	if h.committed {
+		return nil
+	}
+	h.committed = true
+
+	h.original = bytes.Buffer{}
+	_, err := h.stripped.WriteTo(h.dst)
+	h.stripped = bytes.Buffer{}
+	return err
+}
+
+// restore passes on the held back original bytes, returning false if the
+// stripped output was already committed.
+func (h *metadataHoldback) restore() (bool, error) {
+	if h.committed {
+		return false, nil
+	}
+	h.committed = true
+
+	h.stripped = bytes.Buffer{}
+	_, err := h.original.WriteTo(h.dst)
+	h.original = bytes.Buffer{}
+	return true, err
+}
+
+// errRecordingReader records the last error of its Reader other than io.EOF.
+type errRecordingReader struct {
+	io.Reader
+	err error
+}
+
+func (r *errRecordingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// nopSeekCloser mimics a seekable multipart.File.
+type nopSeekCloser struct {
+	*bytes.Reader
+}
+
+func (nopSeekCloser) Close() error {
+	return nil
+}
+
+// peekCloser mimics a streamed upload, being neither seekable nor rewindable.
+type peekCloser struct {
+	*bufio.Reader
+}
+
+func (peekCloser) Close() error {
+	return nil
+}
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 16; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * 16), uint8(y * 16), 0x80, 0xff})
+		}
+	}
+	return img
+}
+
+// testJpegWithExif creates a JPEG with an EXIF APP1 segment and a comment.
+func testJpegWithExif(t *testing.T, secret []byte) []byte {
+	var buff bytes.Buffer
+	if err := jpeg.Encode(&buff, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	raw := buff.Bytes()
+
+	var out bytes.Buffer
+	out.Write(raw[:2])
+	for _, seg := range []struct {
+		marker  byte
+		payload []byte
+	}{
+		{0xe1, append([]byte("Exif\x00\x00"), secret...)},
+		{0xfe, secret},
+	} {
+		out.Write([]byte{0xff, seg.marker})
+		_ = binary.Write(&out, binary.BigEndian, uint16(len(seg.payload)+2))
+		out.Write(seg.payload)
+	}
+	out.Write(raw[2:])
+	return out.Bytes()
+}
+
+// testJpegWithFillBytes inserts a standalone RST0 marker after the JPEG's SOI
+// marker as well as 0xFF fill bytes before its next marker.
+func testJpegWithFillBytes(data []byte) []byte {
+	var out bytes.Buffer
+	out.Write(data[:2])
+	out.Write([]byte{0xff, 0xd0, 0xff, 0xff})
+	out.Write(data[2:])
+	return out.Bytes()
+}
+
+// testPngWithText creates a PNG with a tEXt and an eXIf chunk.
+func testPngWithText(t *testing.T, secret []byte) []byte {
+	var buff bytes.Buffer
+	if err := png.Encode(&buff, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	raw := buff.Bytes()
+
+	// Insert the chunks directly after the 8 byte signature and 25 byte IHDR.
+	var out bytes.Buffer
+	out.Write(raw[:33])
+	for _, chunkType := range []string{"tEXt", "eXIf"} {
+		data := append([]byte(chunkType), secret...)
+		_ = binary.Write(&out, binary.BigEndian, uint32(len(secret)))
+		out.Write(data)
+		_ = binary.Write(&out, binary.BigEndian, crc32.ChecksumIEEE(data))
+	}
+	out.Write(raw[33:])
+	return out.Bytes()
+}
+
+func TestStripMetadata(t *testing.T) {
+	secret := []byte("GPS 52.5200 N 13.4050 E")
+
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+		decode      func(io.Reader) (image.Image, error)
+	}{
+		{"jpeg", "image/jpeg", testJpegWithExif(t, secret), jpeg.Decode},
+		{"jpeg-fill-bytes", "image/jpeg", testJpegWithFillBytes(testJpegWithExif(t, secret)), jpeg.Decode},
+		{"png", "image/png", testPngWithText(t, secret), png.Decode},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := test.decode(bytes.NewReader(test.data)); err != nil {
+				t.Fatalf("input image is invalid: %v", err)
+			}
+			if !bytes.Contains(test.data, secret) {
+				t.Fatal("input image misses metadata")
+			}
+
+			var out bytes.Buffer
+			if err := stripMetadata(&out, bytes.NewReader(test.data), test.contentType); err != nil {
+				t.Fatal(err)
+			}
+
+			if bytes.Contains(out.Bytes(), secret) {
+				t.Fatal("metadata were not stripped")
+			}
+			if out.Len() >= len(test.data) {
+				t.Fatalf("stripped image is not smaller, %d >= %d", out.Len(), len(test.data))
+			}
+			if _, err := test.decode(&out); err != nil {
+				t.Fatalf("stripped image is invalid: %v", err)
+			}
+		})
+	}
+}
+
+func TestStripMetadataInvalid(t *testing.T) {
+	jpegData := testJpegWithExif(t, []byte("secret"))
+	pngData := testPngWithText(t, []byte("secret"))
+
+	tests := []struct {
+		contentType string
+		data        []byte
+	}{
+		{"image/jpeg", []byte("not a jpeg")},
+		{"image/jpeg", pngData},
+		{"image/jpeg", jpegData[:10]},
+		{"image/png", []byte("not a png")},
+		{"image/png", jpegData},
+		{"image/png", pngData[:40]},
+	}
+
+	for _, test := range tests {
+		err := stripMetadata(io.Discard, bytes.NewReader(test.data), test.contentType)
+		if !errors.Is(err, ErrMetadataFormat) {
+			t.Fatalf("expected ErrMetadataFormat for %s, got %v", test.contentType, err)
+		}
+	}
+}
+
+func TestStripMetadataFile(t *testing.T) {
+	secret := []byte("secret")
+	jpegData := testJpegWithExif(t, secret)
+
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+		stripped    bool
+	}{
+		{"jpeg", "image/jpeg", jpegData, true},
+		{"wrong-mime", "image/png", jpegData, false},
+		{"unsupported", "text/plain", []byte("secret text"), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, stripping := stripMetadataFile(nopSeekCloser{bytes.NewReader(test.data)}, test.contentType)
+			if stripping != test.stripped {
+				t.Fatalf("expected stripping to be %t", test.stripped)
+			}
+
+			out, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = f.Close()
+
+			if stripped := !bytes.Equal(out, test.data); stripped != test.stripped {
+				t.Fatalf("expected stripped to be %t", test.stripped)
+			}
+			if test.stripped && bytes.Contains(out, secret) {
+				t.Fatal("metadata were not stripped")
+			}
+		})
+	}
+}
+
+func TestStripMetadataStream(t *testing.T) {
+	secret := []byte("secret")
+	jpegData := testJpegWithExif(t, secret)
+
+	// A file failing to be stripped, e.g., being truncated within its EXIF
+	// segment, is passed on unaltered.
+	truncated := jpegData[:10]
+
+	tests := []struct {
+		name        string
+		contentType string
+		original    []byte
+		src         io.Reader
+		stripped    bool
+		err         error
+	}{
+		{"jpeg", "image/jpeg", jpegData, bytes.NewReader(jpegData), true, nil},
+		{"wrong-mime", "image/png", jpegData, bytes.NewReader(jpegData), false, nil},
+		{"unparsable", "image/jpeg", truncated, bytes.NewReader(truncated), false, nil},
+		{"read-error", "image/jpeg", jpegData, io.MultiReader(bytes.NewReader(jpegData), iotest.ErrReader(ErrFileTooBig)), true, ErrFileTooBig},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, _ := stripMetadataFile(peekCloser{bufio.NewReader(test.src)}, test.contentType)
+
+			out, err := io.ReadAll(f)
+			_ = f.Close()
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			} else if err != nil {
+				return
+			}
+
+			if stripped := !bytes.Equal(out, test.original); stripped != test.stripped {
+				t.Fatalf("expected stripped to be %t", test.stripped)
+			}
+			if test.stripped && bytes.Contains(out, secret) {
+				t.Fatal("metadata were not stripped")
+			}
+		})
+	}
+}
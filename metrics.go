@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// uploadSizeBuckets are the upper bounds of the upload size histogram in bytes.
+var uploadSizeBuckets = []int64{
+	1 << 10,   // 1 KiB
+	16 << 10,  // 16 KiB
+	256 << 10, // 256 KiB
+	1 << 20,   // 1 MiB
+	16 << 20,  // 16 MiB
+	256 << 20, // 256 MiB
+	1 << 30,   // 1 GiB
+}
+
+// histogram is a cumulative Prometheus histogram for a fixed set of buckets.
+type histogram struct {
+	mu      sync.Mutex
+	buckets []int64
+	counts  []uint64
+	count   uint64
+	sum     int64
+}
+
+// newHistogram creates an empty histogram for the sorted bucket bounds.
+func newHistogram(buckets []int64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+// observe a single value.
+func (h *histogram) observe(v int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// write the histogram in the Prometheus text format.
+func (h *histogram) write(w io.Writer, name, help string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%d\"} %d\n", name, bound, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %d\n%s_count %d\n", name, h.sum, name, h.count)
+}
+
+// metrics of the webserver, exposed in the Prometheus text format together
+// with the store's StoreStats.
+type metrics struct {
+	uploads   atomic.Uint64
+	downloads atomic.Uint64
+	deletions atomic.Uint64
+
+	uploadSizes *histogram
+}
+
+// newMetrics creates zeroed metrics.
+func newMetrics() *metrics {
+	return &metrics{uploadSizes: newHistogram(uploadSizeBuckets)}
+}
+
+// write all metrics and the store's stats in the Prometheus text format.
+func (m *metrics) write(w io.Writer, stats StoreStats) {
+	writeMetric(w, "gosh_uploads_total", "counter", "Total number of uploaded Items.", strconv.FormatUint(m.uploads.Load(), 10))
+	writeMetric(w, "gosh_downloads_total", "counter", "Total number of served Item downloads.", strconv.FormatUint(m.downloads.Load(), 10))
+	writeMetric(w, "gosh_deletions_total", "counter", "Total number of Items deleted by request.", strconv.FormatUint(m.deletions.Load(), 10))
+	writeMetric(w, "gosh_items_current", "gauge", "Number of Items within the store.", strconv.Itoa(stats.Items))
+	writeMetric(w, "gosh_store_bytes", "gauge", "Total size of all Items' files in bytes.", strconv.FormatInt(stats.Bytes, 10))
+	m.uploadSizes.write(w, "gosh_upload_size_bytes", "Size of uploaded files in bytes.")
+}
+
+// writeMetric writes a single metric without labels in the Prometheus text
+// format.
+func writeMetric(w io.Writer, name, metricType, help, value string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, metricType, name, value)
+}
+
+// countingReadCloser counts the bytes read from the wrapped io.ReadCloser.
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (crc *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := crc.ReadCloser.Read(p)
+	crc.n += int64(n)
+	return n, err
+}
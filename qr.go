@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"rsc.io/qr"
+)
+
+// qrSvg renders a QR code as an SVG image with a quiet zone of four modules.
+func qrSvg(code *qr.Code) []byte {
+	var path strings.Builder
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Black(x, y) {
+				fmt.Fprintf(&path, "M%d %dh1v1h-1z", x+4, y+4)
+			}
+		}
+	}
+
+	size := code.Size + 8
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="%s"/></svg>`,
+		size, size, path.String()))
+}
+
+// handleQr responds with a QR code of an Item's fetch URL for "/qr/ID", as a
+// PNG image or, with the svg query parameter, as an SVG image.
+//
+// The QR code only encodes the URL, without requesting the Item from the
+// Store. Thus, it does not reveal if an ID exists. If download tokens are
+// enabled, the request must carry the Item's valid token, which becomes part
+// of the encoded URL.
+func (serv *Server) handleQr(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		slog.Debug("Request with unsupported method", slog.String("method", r.Method))
+
+		httpMethodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	_, reqPath, _ := strings.Cut(r.URL.Path, serv.urlPrefix)
+	reqId := strings.TrimPrefix(reqPath, "/qr/")
+
+	if reqId == "" || strings.Contains(reqId, "/") || (serv.validId != nil && !serv.validId(reqId)) {
+		slog.Debug("Requested QR code of a malformed ID", slog.String("path", r.URL.Path))
+
+		http.Error(w, msgNotExists, http.StatusNotFound)
+		return
+	}
+
+	fetchUrl := fmt.Sprintf("%s://%s%s/%s", WebProtocol(r), r.Host, serv.urlPrefix, url.PathEscape(reqId))
+
+	if serv.downloadTokens != nil {
+		token := r.URL.Query().Get("token")
+		err := serv.downloadTokens.verify(reqId, token, time.Now())
+		if err != nil {
+			slog.Debug("Requested QR code with an unacceptable download token",
+				slog.String("id", reqId), slog.Any("error", err))
+
+			http.Error(w, msgTokenInvalid, http.StatusForbidden)
+			return
+		}
+		fetchUrl += "?token=" + url.QueryEscape(token)
+	}
+
+	code, err := qr.Encode(fetchUrl, qr.M)
+	if err != nil {
+		slog.Error("Failed to encode QR code", slog.Any("error", err))
+
+		http.Error(w, msgGenericError, http.StatusInternalServerError)
+		return
+	}
+
+	var data []byte
+	if r.URL.Query().Has("svg") {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		data = qrSvg(code)
+	} else {
+		w.Header().Set("Content-Type", "image/png")
+		data = code.PNG()
+	}
+
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = w.Write(data)
+}
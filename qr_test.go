@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"rsc.io/qr"
+)
+
+func TestServerQr(t *testing.T) {
+	server := newTestServerMemoryStore(t, nil)
+
+	// The QR code is independent of the Item's existence.
+	for _, id := range []string{"abcd", "nope"} {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/qr/"+id, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d", id, rec.Code)
+		} else if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+			t.Fatalf("%s: unexpected Content-Type %q", id, ct)
+		}
+
+		if _, err := png.Decode(bytes.NewReader(rec.Body.Bytes())); err != nil {
+			t.Fatalf("%s: invalid PNG: %v", id, err)
+		}
+
+		code, err := qr.Encode("http://example.com/"+id, qr.M)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(rec.Body.Bytes(), code.PNG()) {
+			t.Fatalf("%s: QR code does not encode the fetch URL", id)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/qr/abcd?svg", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/svg+xml" {
+		t.Fatalf("unexpected SVG response %d: %q", rec.Code, rec.Header().Get("Content-Type"))
+	} else if !strings.HasPrefix(rec.Body.String(), "<svg ") {
+		t.Fatalf("unexpected SVG %q", rec.Body)
+	}
+
+	for _, path := range []string{"/qr/", "/qr/abcd/efgh"} {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected status 404, got %d", path, rec.Code)
+		}
+	}
+}
+
+func TestServerQrDownloadTokens(t *testing.T) {
+	server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+		conf.DownloadTokens.Enabled = true
+		conf.DownloadTokens.Lifetime = 10 * time.Minute
+		conf.secret = Secret(strings.Repeat("a", secretMinLength))
+	})
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/qr/abcd", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected status 403 without token, got %d", rec.Code)
+	}
+
+	token := server.downloadTokens.sign("abcd", time.Now().Add(time.Minute))
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/qr/abcd?token="+token, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200 with token, got %d", rec.Code)
+	}
+
+	code, err := qr.Encode("http://example.com/abcd?token="+token, qr.M)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(rec.Body.Bytes(), code.PNG()) {
+		t.Fatal("QR code does not encode the fetch URL with its token")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket rate limiter per key, e.g., an IP address.
+//
+// Each bucket holds up to limit tokens and is refilled by limit tokens per
+// interval. Buckets being full again are removed from time to time.
+type rateLimiter struct {
+	mu sync.Mutex
+
+	limit    float64
+	interval time.Duration
+
+	buckets   map[string]*rateBucket
+	lastSweep time.Time
+
+	// now is time.Now, replaceable for testing.
+	now func() time.Time
+}
+
+// rateBucket is the state of a single key's token bucket.
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter allowing limit events per interval for each key.
+func newRateLimiter(limit int, interval time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:    float64(limit),
+		interval: interval,
+		buckets:  make(map[string]*rateBucket),
+		now:      time.Now,
+	}
+}
+
+// refill the bucket's tokens for the elapsed time until now.
+func (rl *rateLimiter) refill(b *rateBucket, now time.Time) {
+	elapsed := now.Sub(b.last)
+	b.tokens = math.Min(rl.limit, b.tokens+rl.limit*float64(elapsed)/float64(rl.interval))
+	b.last = now
+}
+
+// sweep removes full buckets, as they do not differ from new ones.
+func (rl *rateLimiter) sweep(now time.Time) {
+	for key, b := range rl.buckets {
+		rl.refill(b, now)
+		if b.tokens >= rl.limit {
+			delete(rl.buckets, key)
+		}
+	}
+	rl.lastSweep = now
+}
+
+// allow an event for the key by taking a token. Otherwise, false is returned
+// together with the duration until the next token is available.
+func (rl *rateLimiter) allow(key string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	if now.Sub(rl.lastSweep) >= rl.interval {
+		rl.sweep(now)
+	}
+
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &rateBucket{tokens: rl.limit, last: now}
+		rl.buckets[key] = b
+	}
+	rl.refill(b, now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) * float64(rl.interval) / rl.limit)
+	return false, wait
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	rl := newRateLimiter(2, time.Minute)
+	rl.now = func() time.Time { return now }
+
+	steps := []struct {
+		advance time.Duration
+		key     string
+		allowed bool
+		wait    time.Duration
+	}{
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", false, 30 * time.Second},
+		{0, "b", true, 0},
+		{10 * time.Second, "a", false, 20 * time.Second},
+		{20 * time.Second, "a", true, 0},
+		{0, "a", false, 30 * time.Second},
+		{5 * time.Minute, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", false, 30 * time.Second},
+	}
+
+	for i, step := range steps {
+		now = now.Add(step.advance)
+
+		allowed, wait := rl.allow(step.key)
+		if allowed != step.allowed {
+			t.Fatalf("Step %d: expected allowed %t, got %t", i, step.allowed, allowed)
+		}
+		if wait != step.wait {
+			t.Fatalf("Step %d: expected wait %v, got %v", i, step.wait, wait)
+		}
+	}
+
+	// After the sweep, only the recently used bucket is left.
+	if _, ok := rl.buckets["b"]; ok {
+		t.Fatalf("Full bucket was not swept: %v", rl.buckets)
+	}
+	if _, ok := rl.buckets["a"]; !ok {
+		t.Fatalf("Used bucket was swept: %v", rl.buckets)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// reportReasonMaxLen limits the characters of an abuse report's reason.
+const reportReasonMaxLen = 4000
+
+// reportMaxBody limits the form of an abuse report, leaving space for the
+// reason's URL encoding and the reporter's address.
+const reportMaxBody = 16 << 10
+
+// reportMailTpl is the email's body of an abuse report, including the Item's
+// metadata.
+var reportMailTpl = template.Must(template.New("report").Parse(`An abuse report was received for {{.URL}}
+
+Reason:
+{{.Reason}}
+
+Reporter:    {{with .Reporter}}{{.}}{{else}}anonymous{{end}}
+Reporter IP: {{.ReporterIP}}
+Received:    {{.Received.Format "2006-01-02 15:04:05 MST"}}
+{{with .Item}}
+Item:
+  ID:           {{.ID}}
+  Filename:     {{.Filename}}
+  Content-Type: {{.ContentType}}
+  Size:         {{.Size}} bytes
+  SHA-256:      {{.ContentHash}}
+  Created:      {{.Created.Format "2006-01-02 15:04:05 MST"}}
+  Expires:      {{if .NeverExpires}}never{{else}}{{.Expires.Format "2006-01-02 15:04:05 MST"}}{{end}}
+  Downloads:    {{.Downloads}}
+  Burn:         {{.BurnAfterReading}}
+{{- range $type, $ip := .Owner}}
+  Owner:        {{$ip}} ({{$type}})
+{{- end}}
+{{end}}`))
+
+// abuseReport is a queued report of an Item, being mailed by the
+// AbuseReporter.
+type abuseReport struct {
+	ID         string
+	URL        string
+	Reason     string
+	Reporter   *mail.Address
+	ReporterIP string
+	Received   time.Time
+
+	// Item is set by the AbuseReporter before mailing the report.
+	Item *Item
+}
+
+// AbuseReporter mails abuse reports of Items to the contact address.
+//
+// Like the Webhook, reports are queued in a bounded queue and being sent by a
+// background goroutine. Thus, a reporter cannot learn from the response or its
+// timing if the reported Item exists. Reports of non-existing Items are
+// dropped.
+type AbuseReporter struct {
+	store   *StoreRpcClient
+	mailer  *SmtpMailer
+	contact string
+
+	queue   chan abuseReport
+	stopAck chan struct{}
+}
+
+// NewAbuseReporter creates an AbuseReporter mailing to the contact address and
+// starts its goroutine.
+func NewAbuseReporter(store *StoreRpcClient, mailer *SmtpMailer, contact string, queueSize int) (*AbuseReporter, error) {
+	if _, err := mail.ParseAddress(contact); err != nil {
+		return nil, fmt.Errorf("contact %q is no valid email address: %w", contact, err)
+	}
+	if queueSize <= 0 {
+		return nil, fmt.Errorf("abuse report queue size must be positive, not %d", queueSize)
+	}
+
+	ar := &AbuseReporter{
+		store:   store,
+		mailer:  mailer,
+		contact: contact,
+
+		queue:   make(chan abuseReport, queueSize),
+		stopAck: make(chan struct{}),
+	}
+
+	go ar.worker()
+
+	return ar, nil
+}
+
+// Report queues an abuseReport without blocking. False is returned if the
+// queue is full.
+func (ar *AbuseReporter) Report(report abuseReport) bool {
+	select {
+	case ar.queue <- report:
+		return true
+	default:
+		return false
+	}
+}
+
+// worker mails the queued reports until the queue is closed.
+func (ar *AbuseReporter) worker() {
+	defer close(ar.stopAck)
+
+	for report := range ar.queue {
+		if err := ar.send(report); err != nil {
+			slog.Warn("Failed to mail abuse report", slog.String("id", report.ID), slog.Any("error", err))
+		}
+	}
+}
+
+// send a single report, including the Item's metadata.
+func (ar *AbuseReporter) send(report abuseReport) error {
+	item, err := ar.store.Get(report.ID, context.Background())
+	if err == ErrNotFound {
+		slog.Info("Dropping abuse report of a non-existing ID", slog.String("id", report.ID))
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("cannot request reported Item: %w", err)
+	}
+	report.Item = &item
+
+	var body bytes.Buffer
+	if err := reportMailTpl.Execute(&body, report); err != nil {
+		return err
+	}
+
+	err = ar.mailer.Send(ar.contact, report.Reporter, "gosh abuse report for "+report.ID, body.String())
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Mailed abuse report", slog.String("id", report.ID))
+	return nil
+}
+
+// Close the AbuseReporter after mailing all queued reports.
+func (ar *AbuseReporter) Close() error {
+	close(ar.queue)
+	<-ar.stopAck
+	return nil
+}
+
+// Defaults of the abuse_reports' queue and rate limit, the latter being three
+// reports per hour and IP address.
+const (
+	defaultReportQueueSize = 16
+	defaultReportLimit     = 3
+	defaultReportInterval  = time.Hour
+)
+
+// newAbuseReporting creates the AbuseReporter and its rateLimiter for the
+// WebserverConfig's abuse_reports, being mailed to its contact address.
+func newAbuseReporting(store *StoreRpcClient, conf WebserverConfig) (*AbuseReporter, *rateLimiter, error) {
+	reportConf := conf.AbuseReports
+	if reportConf.QueueSize < 0 || reportConf.RateLimit.Reports < 0 || reportConf.RateLimit.Interval < 0 {
+		return nil, nil, fmt.Errorf("abuse_reports queue_size and rate_limit must not be negative")
+	}
+
+	queueSize := reportConf.QueueSize
+	if queueSize == 0 {
+		queueSize = defaultReportQueueSize
+	}
+	limit, interval := reportConf.RateLimit.Reports, reportConf.RateLimit.Interval
+	if limit == 0 {
+		limit = defaultReportLimit
+	}
+	if interval == 0 {
+		interval = defaultReportInterval
+	}
+
+	mailer, err := NewSmtpMailer(reportConf.Smtp)
+	if err != nil {
+		return nil, nil, err
+	}
+	reporter, err := NewAbuseReporter(store, mailer, conf.Contact, queueSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return reporter, newRateLimiter(limit, interval), nil
+}
+
+// checkReportRate checks the abuse report rate limit for the request's IP
+// address. On exceeding it, a 429 error is sent and false is returned.
+func (serv *Server) checkReportRate(w http.ResponseWriter, r *http.Request) bool {
+	ip := serv.clientIP(r)
+
+	allowed, wait := serv.reportLimiter.allow(ip)
+	if allowed {
+		return true
+	}
+
+	slog.Info("Rejected abuse report exceeding the rate limit", slog.String("ip", ip))
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, msgReportRateLimited, http.StatusTooManyRequests)
+	return false
+}
+
+// handleReport shows a form to report an Item's abuse for a GET request on
+// "/report/<id>", which is mailed to the contact address by a POST request.
+//
+// Each well-formed report is accepted without requesting the Store. Thus, it
+// is not revealed if an ID exists.
+func (serv *Server) handleReport(w http.ResponseWriter, r *http.Request) {
+	if serv.abuseReporter == nil {
+		slog.Debug("Requested abuse report, but abuse reports are disabled")
+
+		http.Error(w, msgNotExists, http.StatusNotFound)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		slog.Debug("Request with unsupported method", slog.String("method", r.Method))
+
+		httpMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+		return
+	}
+
+	_, reqPath, _ := strings.Cut(r.URL.Path, serv.urlPrefix)
+	reqId := strings.TrimPrefix(reqPath, "/report/")
+
+	if reqId == "" || strings.Contains(reqId, "/") || (serv.validId != nil && !serv.validId(reqId)) {
+		slog.Debug("Requested abuse report of a malformed ID", slog.String("path", r.URL.Path))
+
+		http.Error(w, msgNotExists, http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "text/html;charset=UTF-8")
+		w.Header().Set("Content-Security-Policy", formPageCsp)
+		w.WriteHeader(http.StatusOK)
+
+		if err := reportFormTpl.Execute(w, struct{ ID string }{reqId}); err != nil {
+			slog.Error("Failed to execute template", slog.Any("error", err))
+		}
+		return
+	}
+
+	if !serv.checkReportRate(w, r) {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, reportMaxBody)
+	if err := r.ParseForm(); err != nil {
+		slog.Debug("Failed to parse abuse report", slog.Any("error", err))
+
+		http.Error(w, msgReportInvalid, http.StatusBadRequest)
+		return
+	}
+
+	reason := strings.TrimSpace(r.PostForm.Get("reason"))
+	if reason == "" || len([]rune(reason)) > reportReasonMaxLen {
+		slog.Debug("Abuse report has an invalid reason", slog.Int("length", len(reason)))
+
+		http.Error(w, msgReportInvalid, http.StatusBadRequest)
+		return
+	}
+
+	var reporter *mail.Address
+	if email := strings.TrimSpace(r.PostForm.Get("email")); email != "" {
+		var err error
+		reporter, err = mail.ParseAddress(email)
+		if err != nil {
+			slog.Debug("Abuse report has an invalid email address", slog.Any("error", err))
+
+			http.Error(w, msgReportInvalid, http.StatusBadRequest)
+			return
+		}
+	}
+
+	report := abuseReport{
+		ID:         reqId,
+		URL:        fmt.Sprintf("%s://%s%s/%s", WebProtocol(r), r.Host, serv.urlPrefix, url.PathEscape(reqId)),
+		Reason:     reason,
+		Reporter:   reporter,
+		ReporterIP: serv.clientIP(r),
+		Received:   time.Now().UTC(),
+	}
+	if !serv.abuseReporter.Report(report) {
+		slog.Warn("Abuse report queue is full, rejecting report", slog.String("id", reqId))
+
+		http.Error(w, msgReportBusy, http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, msgReportSuccess)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newReportRequest(target string, form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestServerReport(t *testing.T) {
+	addr, msgs := newTestSmtpServer(t)
+
+	server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+		conf.AbuseReports.Enabled = true
+		conf.AbuseReports.RateLimit.Reports = 4
+		conf.AbuseReports.Smtp = SmtpConfig{Address: addr, From: "gosh@example.com"}
+	})
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", []byte("hello world"), map[string]string{"filename": "hello.txt"}))
+	itemId := strings.TrimPrefix(strings.TrimSpace(rec.Body.String()), "http://example.com/")
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/report/"+itemId, nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `name="reason"`) {
+		t.Fatalf("Expected report form, got %d: %q", rec.Code, rec.Body.String())
+	}
+
+	// Each well-formed report is accepted, independent of the ID's existence.
+	// A report of a non-existing ID is not mailed, while the rate limit is
+	// reached after four reports.
+	requests := []struct {
+		name string
+		id   string
+		form url.Values
+		code int
+	}{
+		{"missing reason", itemId, url.Values{"reason": {" "}}, http.StatusBadRequest},
+		{"invalid email", itemId, url.Values{"reason": {"spam"}, "email": {"nope"}}, http.StatusBadRequest},
+		{"non-existing", "nope", url.Values{"reason": {"spam"}}, http.StatusOK},
+		{"existing", itemId, url.Values{"reason": {"illegal content"}, "email": {"alice@example.com"}}, http.StatusOK},
+		{"rate limited", itemId, url.Values{"reason": {"spam"}}, http.StatusTooManyRequests},
+	}
+
+	for _, req := range requests {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, newReportRequest("/report/"+req.id, req.form))
+		if rec.Code != req.code {
+			t.Fatalf("%s: expected status %d, got %d", req.name, req.code, rec.Code)
+		}
+	}
+
+	select {
+	case msg := <-msgs:
+		for _, part := range []string{
+			"To: <nobody@example.com>\r\n",
+			"Reply-To: <alice@example.com>\r\n",
+			"Subject: gosh abuse report for " + itemId + "\r\n",
+			"http://example.com/" + itemId + "\r\n",
+			"illegal content\r\n",
+			"Filename:     hello.txt\r\n",
+		} {
+			if !strings.Contains(msg, part) {
+				t.Fatalf("Mail misses %q: %q", part, msg)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No report was mailed")
+	}
+
+	select {
+	case msg := <-msgs:
+		t.Fatalf("Unexpected mail %q", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestServerReportDisabled(t *testing.T) {
+	server := newTestServer(t, nil, nil)
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, newReportRequest("/report/abcd", url.Values{"reason": {"spam"}}))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
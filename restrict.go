@@ -13,4 +13,11 @@ const (
 	restrict_linux_seccomp
 	// restrict_openbsd_pledge: (string, string) as promises and execpromises for pledge(2)
 	restrict_openbsd_pledge
+	// restrict_freebsd_capsicum: ([]syscall.Conn, string, bool) as files, their rights for
+	// cap_rights_limit(2), and whether to call cap_enter(2) afterwards
+	restrict_freebsd_capsicum
 )
+
+// capsicumSocketRights are the rights required by Go's runtime to use a stream
+// socket, passed as restrict_freebsd_capsicum's rights.
+const capsicumSocketRights = "read write event fcntl fstat getsockopt setsockopt shutdown getpeername getsockname"
@@ -0,0 +1,76 @@
+//go:build freebsd
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// capsicumRightNames maps the names of the supported capability rights, used
+// as the argument of restrict_freebsd_capsicum, to rights(4).
+var capsicumRightNames = map[string]uint64{
+	"accept":      unix.CAP_ACCEPT,
+	"event":       unix.CAP_EVENT,
+	"fcntl":       unix.CAP_FCNTL,
+	"fstat":       unix.CAP_FSTAT,
+	"getpeername": unix.CAP_GETPEERNAME,
+	"getsockname": unix.CAP_GETSOCKNAME,
+	"getsockopt":  unix.CAP_GETSOCKOPT,
+	"read":        unix.CAP_READ,
+	"setsockopt":  unix.CAP_SETSOCKOPT,
+	"shutdown":    unix.CAP_SHUTDOWN,
+	"write":       unix.CAP_WRITE,
+}
+
+// capsicum limits the files' rights by cap_rights_limit(2) and enters the
+// capability mode by cap_enter(2), if enter is set. Afterwards, no global
+// namespaces, e.g., paths, are accessible anymore.
+func capsicum(files []syscall.Conn, rightNames string, enter bool) error {
+	var rightValues []uint64
+	for _, name := range strings.Fields(rightNames) {
+		right, ok := capsicumRightNames[name]
+		if !ok {
+			return fmt.Errorf("unknown capability right %q", name)
+		}
+		rightValues = append(rightValues, right)
+	}
+
+	rights, err := unix.CapRightsInit(rightValues)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		rawConn, err := file.SyscallConn()
+		if err != nil {
+			return err
+		}
+
+		var limitErr error
+		err = rawConn.Control(func(fd uintptr) {
+			limitErr = unix.CapRightsLimit(fd, rights)
+		})
+		if err != nil {
+			return err
+		} else if limitErr != nil {
+			return limitErr
+		}
+	}
+
+	if !enter {
+		return nil
+	}
+	return unix.CapEnter()
+}
+
+func restrict(op restriction, args ...interface{}) error {
+	if op != restrict_freebsd_capsicum {
+		return nil
+	}
+
+	return capsicum(args[0].([]syscall.Conn), args[1].(string), args[2].(bool))
+}
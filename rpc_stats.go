@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RpcOpStats are the collected statistics of a single StoreRpcClient
+// operation, e.g., an RPC call or a FD transfer. Failures count each returned
+// error, including an ErrNotFound.
+type RpcOpStats struct {
+	Calls    uint64
+	Failures uint64
+
+	Total time.Duration
+	Max   time.Duration
+}
+
+// Mean latency of this operation.
+func (ops RpcOpStats) Mean() time.Duration {
+	if ops.Calls == 0 {
+		return 0
+	}
+	return ops.Total / time.Duration(ops.Calls)
+}
+
+// RpcStats collects per operation latencies and failures of a StoreRpcClient.
+//
+// RPC calls are named after their method, e.g., "Get". FD transfers have the
+// suffix "/fd", e.g., "GetFile/fd", to distinguish them from the RPC call.
+type RpcStats struct {
+	mu  sync.Mutex
+	ops map[string]*RpcOpStats
+}
+
+// NewRpcStats creates an empty RpcStats.
+func NewRpcStats() *RpcStats {
+	return &RpcStats{ops: make(map[string]*RpcOpStats)}
+}
+
+// record a finished operation.
+func (stats *RpcStats) record(op string, d time.Duration, err error) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	opStats, ok := stats.ops[op]
+	if !ok {
+		opStats = &RpcOpStats{}
+		stats.ops[op] = opStats
+	}
+
+	opStats.Calls++
+	if err != nil {
+		opStats.Failures++
+	}
+	opStats.Total += d
+	if d > opStats.Max {
+		opStats.Max = d
+	}
+}
+
+// Snapshot returns a copy of the current statistics per operation.
+func (stats *RpcStats) Snapshot() map[string]RpcOpStats {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	snapshot := make(map[string]RpcOpStats, len(stats.ops))
+	for op, opStats := range stats.ops {
+		snapshot[op] = *opStats
+	}
+	return snapshot
+}
+
+// LogSummary logs the current statistics of each operation.
+func (stats *RpcStats) LogSummary() {
+	snapshot := stats.Snapshot()
+
+	ops := make([]string, 0, len(snapshot))
+	for op := range snapshot {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	for _, op := range ops {
+		opStats := snapshot[op]
+		slog.Info("Store RPC statistics",
+			slog.String("operation", op),
+			slog.Uint64("calls", opStats.Calls),
+			slog.Uint64("failures", opStats.Failures),
+			slog.Duration("mean", opStats.Mean()),
+			slog.Duration("max", opStats.Max))
+	}
+}
+
+// noopObservation is returned by observe if nothing needs to be recorded.
+func noopObservation(error) {}
+
+// observe starts measuring an operation and returns a function to be called
+// with its result when finished.
+//
+// Latencies are only measured if either RpcStats are enabled or debug logging
+// is active. Otherwise, a no-op function is returned.
+func (client *StoreRpcClient) observe(op string) func(error) {
+	debug := slog.Default().Enabled(context.Background(), slog.LevelDebug)
+	if client.stats == nil && !debug {
+		return noopObservation
+	}
+
+	start := time.Now()
+	return func(err error) {
+		d := time.Since(start)
+
+		if client.stats != nil {
+			client.stats.record(op, d, err)
+		}
+		if debug {
+			slog.Debug("Store RPC operation finished",
+				slog.String("operation", op), slog.Duration("latency", d), slog.Any("error", err))
+		}
+	}
+}
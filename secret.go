@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"golang.org/x/crypto/hkdf"
+	"gopkg.in/yaml.v3"
+)
+
+// secretMinLength is the minimum length of a configured Secret in bytes.
+const secretMinLength = 32
+
+// ErrNoSecret is returned when deriving a key from an unconfigured Secret.
+var ErrNoSecret = errors.New("No secret is configured")
+
+// Secret is a per-deployment secret, used to derive purpose-specific keys.
+//
+// A Secret is never printed, neither by fmt, log/slog, nor when being
+// marshalled as YAML.
+type Secret []byte
+
+// UnmarshalYAML reads a Secret from a YAML string.
+func (s *Secret) UnmarshalYAML(value *yaml.Node) error {
+	var raw string
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	*s = Secret(raw)
+	return nil
+}
+
+// MarshalYAML redacts the Secret.
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+// String redacts the Secret.
+func (s Secret) String() string {
+	if len(s) == 0 {
+		return ""
+	}
+	return "[REDACTED]"
+}
+
+// LogValue redacts the Secret for log/slog.
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+// validate checks if a configured Secret is long enough.
+func (s Secret) validate() error {
+	if len(s) > 0 && len(s) < secretMinLength {
+		return fmt.Errorf("secret must be at least %d bytes long, got %d", secretMinLength, len(s))
+	}
+	return nil
+}
+
+// DeriveKey derives a key of the given length for a purpose by HKDF-SHA256.
+//
+// Each feature requiring a key should use its own unique purpose. If no Secret
+// is configured, ErrNoSecret is returned. Thus, a feature should derive its key
+// during startup to fail early.
+func (s Secret) DeriveKey(purpose string, length int) ([]byte, error) {
+	if len(s) == 0 {
+		return nil, ErrNoSecret
+	}
+
+	key := make([]byte, length)
+	_, err := io.ReadFull(hkdf.New(sha256.New, s, nil, []byte("gosh "+purpose)), key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
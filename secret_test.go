@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSecretDeriveKey(t *testing.T) {
+	secret := Secret("0123456789abcdef0123456789abcdef")
+
+	keyA1, err := secret.DeriveKey("a", 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyA2, err := secret.DeriveKey("a", 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyB, err := secret.DeriveKey("b", 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keyA1) != 32 {
+		t.Fatalf("Derived key has length %d", len(keyA1))
+	}
+	if !bytes.Equal(keyA1, keyA2) {
+		t.Fatalf("Derived keys for the same purpose differ")
+	}
+	if bytes.Equal(keyA1, keyB) {
+		t.Fatalf("Derived keys for different purposes are equal")
+	}
+
+	if _, err := Secret(nil).DeriveKey("a", 32); err != ErrNoSecret {
+		t.Fatalf("Expected ErrNoSecret, got %v", err)
+	}
+}
+
+func TestSecretRedacted(t *testing.T) {
+	const rawSecret = "0123456789abcdef0123456789abcdef"
+
+	conf := Config{Secret: Secret(rawSecret)}
+
+	var logBuff bytes.Buffer
+	slog.New(slog.NewJSONHandler(&logBuff, nil)).Info("test", slog.Any("config", conf), slog.Any("secret", conf.Secret))
+
+	yamlOut, err := yaml.Marshal(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputs := map[string]string{
+		"fmt":  fmt.Sprintf("%v %+v %s", conf, conf, conf.Secret),
+		"slog": logBuff.String(),
+		"yaml": string(yamlOut),
+	}
+	for name, out := range outputs {
+		if strings.Contains(out, rawSecret) {
+			t.Fatalf("%s output contains the secret: %s", name, out)
+		}
+	}
+}
+
+func TestLoadConfigSecret(t *testing.T) {
+	dir, err := os.MkdirTemp("", "gosh-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secretFile := filepath.Join(dir, "secret")
+	err = os.WriteFile(secretFile, []byte("0123456789abcdef0123456789abcdef\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		config string
+		secret string
+		valid  bool
+	}{
+		{"user: nobody", "", true},
+		{"secret: 0123456789abcdef0123456789abcdef", "0123456789abcdef0123456789abcdef", true},
+		{"secret: tooshort", "", false},
+		{"secret_file: " + secretFile, "0123456789abcdef0123456789abcdef", true},
+		{"secret_file: " + filepath.Join(dir, "nope"), "", false},
+		{"secret: 0123456789abcdef0123456789abcdef\nsecret_file: " + secretFile, "", false},
+	}
+
+	for _, test := range tests {
+		configFile := filepath.Join(dir, "gosh.yml")
+		if err := os.WriteFile(configFile, []byte(test.config), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		conf, err := loadConfig(configFile)
+		if (err == nil) != test.valid {
+			t.Fatalf("Config %q: expected valid %t, got error %v", test.config, test.valid, err)
+		}
+		if test.valid && string(conf.Secret) != test.secret {
+			t.Fatalf("Config %q: secret mismatches", test.config)
+		}
+	}
+}
@@ -2,30 +2,188 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"io/fs"
 	"log/slog"
 	"math/big"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/akamensky/base58"
+	"github.com/dgraph-io/badger/v4"
 	"github.com/timshannon/badgerhold/v4"
 )
 
 const (
 	DirDatabase = "db"
 	DirStorage  = "data"
+	DirSpool    = "spool"
+	DirUploads  = "uploads"
+
+	// MaxShardDepth is the maximum amount of storage subdirectory levels.
+	MaxShardDepth = 4
+	// shardLength is the amount of an ID's bytes used for each shard level.
+	shardLength = 2
 )
 
 // ErrNotFound is returned by the `Store.Get` method if there is no Item for
 // the requested ID.
 var ErrNotFound = errors.New("No Item found for this ID")
 
+// ErrIncompleteFile is returned by the `Store.Put` method if the file's size
+// differs from the Item's expected Size.
+var ErrIncompleteFile = errors.New("File size differs from the expected size")
+
+// ErrUploadChecksum is returned by the `Store.Put` method if the file does not
+// match the Item's UploadChecksum.
+var ErrUploadChecksum = errors.New("File does not match the upload checksum")
+
+// ErrStoreFull is returned by the `Store.Put` method if the file would exceed
+// the Store's MaxStoreSize.
+var ErrStoreFull = errors.New("Store has reached its maximum size")
+
+// newUploadHasher creates a hash.Hash for the Checksum or returns nil if no
+// Checksum is set.
+func newUploadHasher(c Checksum) (hash.Hash, error) {
+	if c.Algorithm == "" {
+		return nil, nil
+	}
+	return c.newHash()
+}
+
+// checkUploadChecksum compares the hash.Hash's sum against the Checksum and
+// returns an error wrapping ErrUploadChecksum on a mismatch.
+func checkUploadChecksum(c Checksum, h hash.Hash) error {
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != c.Hash {
+		return fmt.Errorf("%w: %s is %s instead of %s", ErrUploadChecksum, c.Algorithm, sum, c.Hash)
+	}
+	return nil
+}
+
+// StoreStats are the current figures of a Storer, e.g., for metrics.
+type StoreStats struct {
+	// Items is the number of Items, including expired ones not yet deleted.
+	Items int
+	// Bytes is the total size of all Items' files, counting shared ones once.
+	Bytes int64
+}
+
+// ListOrder sorts the Items of a List.
+type ListOrder string
+
+const (
+	// ListByCreated sorts Items by their creation, oldest first.
+	ListByCreated ListOrder = "created"
+	// ListByDownloads sorts Items by their Downloads, most first.
+	ListByDownloads ListOrder = "downloads"
+	// ListByLastAccess sorts Items by their LastAccess, never downloaded and
+	// least recently downloaded first.
+	ListByLastAccess ListOrder = "last_access"
+)
+
+// ListQuery describes a List of Items, ordered by Order, skipping the first
+// Offset ones, and returning up to Limit ones.
+type ListQuery struct {
+	Offset int
+	Limit  int
+	Order  ListOrder
+
+	// NeverDownloaded only lists Items without any Downloads.
+	NeverDownloaded bool
+}
+
+// Storer is the interface of a storage for Items and their files, e.g., the
+// Store or the MemoryStore, as being used by the StoreRpcServer.
+type Storer interface {
+	// Get an Item by its ID or ErrNotFound.
+	Get(id string) (Item, error)
+
+	// GetFile returns an Item's file. It must be an *os.File to be passed as a
+	// FD to another process.
+	GetFile(id string) (*os.File, error)
+
+	// Put a new Item and its file, which will be closed, and return its ID.
+	// If the Item's Size is set but differs, ErrIncompleteFile is returned.
+	// If its UploadChecksum is set but mismatches, ErrUploadChecksum is.
+	Put(i Item, file io.ReadCloser) (string, error)
+
+	// UpdateSettings of an Item and return the updated Item. A postponed
+	// expiry results in ErrLifetimeExtended.
+	UpdateSettings(id string, settings ItemSettings) (Item, error)
+
+	// RecordDownload atomically counts a served download of an Item without
+	// MaxDownloads and touches its LastAccess.
+	RecordDownload(id string) error
+
+	// Touch records an access of an Item by its LastAccess, throttled by the
+	// TouchInterval.
+	Touch(id string) error
+
+	// RecordBurnAttempt atomically counts a failed download of an Item and
+	// returns the updated Item.
+	RecordBurnAttempt(id string) (Item, error)
+
+	// ReserveDownload atomically counts a download of an Item before it is
+	// served and returns the updated Item. An Item without any downloads left
+	// results in ErrDownloadsExhausted.
+	ReserveDownload(id string) (Item, error)
+
+	// ReleaseDownload atomically reverts a reserved download of an Item, which
+	// could not be served.
+	ReleaseDownload(id string) error
+
+	// FindByContentType returns all unexpired Items of this Content-Type.
+	FindByContentType(contentType string) ([]Item, error)
+
+	// Delete an Item and its file.
+	Delete(id string) error
+
+	// Rollback deletes a newly put Item and its file, e.g., of a failed
+	// upload, without calling the deletion hook. As the Item's creation was
+	// never announced, neither is its deletion.
+	Rollback(id string) error
+
+	// Stats returns the current number of Items and their files' total size.
+	Stats() (StoreStats, error)
+
+	// List returns Items as described by the ListQuery. Expired Items might be
+	// included until deleted.
+	List(query ListQuery) ([]Item, error)
+
+	// CreateUpload stages a new resumable Upload of an Item's file with this
+	// length, to be completed before it expires, and returns its token.
+	CreateUpload(i Item, length int64, expires time.Time) (string, error)
+
+	// GetUpload returns an Upload by its token, including its Head.
+	GetUpload(token string) (Upload, error)
+
+	// AppendUpload appends the file's data at the offset to an Upload and
+	// returns its new Offset, even for a cut short file.
+	AppendUpload(token string, offset int64, file io.ReadCloser) (int64, error)
+
+	// CompleteUpload puts a completely received Upload's file as the given Item
+	// and returns its ID. An incomplete Upload results in ErrUploadOffset. If
+	// strip is set, the file's metadata is stripped before.
+	CompleteUpload(token string, i Item, strip bool) (string, error)
+
+	// DeleteUpload drops an Upload and its staged file.
+	DeleteUpload(token string) error
+
+	// Close the Storer.
+	Close() error
+}
+
 // BadgerLogWapper implements badger.Logger to forward logs to log/slog.
 type BadgerLogWapper struct {
 	*slog.Logger
@@ -47,6 +205,9 @@ func (logger *BadgerLogWapper) Debugf(f string, args ...interface{}) {
 	logger.Logger.Debug(fmt.Sprintf(f, args...), slog.String("producer", "badger"))
 }
 
+// base58Alphabet is used by the base58 encoding of the "random" ID generator.
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
 // randomIdGenerator returns an ID generator for the "random" type.
 func randomIdGenerator(length int) func() (string, error) {
 	return func() (string, error) {
@@ -65,6 +226,10 @@ func randomIdGenerator(length int) func() (string, error) {
 
 // wordlistIdGenerator returns an ID generator for the "wordlist" type.
 func wordlistIdGenerator(sourceFile string, length int) (func() (string, error), error) {
+	if sourceFile == "" {
+		return nil, fmt.Errorf("wordlist ID generator requires a file")
+	}
+
 	f, err := os.Open(sourceFile)
 	if err != nil {
 		return nil, err
@@ -75,13 +240,22 @@ func wordlistIdGenerator(sourceFile string, length int) (func() (string, error),
 
 	words := make([]string, 0, 1024)
 	for scanner.Scan() {
-		words = append(words, scanner.Text())
+		// Empty words would result in IDs like "foo--bar".
+		if word := strings.TrimSpace(scanner.Text()); word != "" {
+			words = append(words, word)
+		}
 	}
 	err = scanner.Err()
 	if err != nil {
 		return nil, err
 	}
 
+	if len(words) == 0 {
+		return nil, fmt.Errorf("wordlist %q contains no words", sourceFile)
+	} else if length <= 0 {
+		return nil, fmt.Errorf("wordlist length must be positive")
+	}
+
 	return func() (string, error) {
 		parts := make([]string, length)
 		for i := 0; i < length; i++ {
@@ -96,6 +270,228 @@ func wordlistIdGenerator(sourceFile string, length int) (func() (string, error),
 	}, nil
 }
 
+// uuidIdGenerator returns an ID generator for the "uuid" type, creating random
+// RFC 4122 version 4 UUIDs, e.g., "f47ac10b-58cc-4372-a567-0e02b2c3d479".
+func uuidIdGenerator() func() (string, error) {
+	return func() (string, error) {
+		var uuid [16]byte
+		_, err := rand.Read(uuid[:])
+		if err != nil {
+			return "", err
+		}
+
+		uuid[6] = (uuid[6] & 0x0f) | 0x40 // version 4
+		uuid[8] = (uuid[8] & 0x3f) | 0x80 // variant RFC 4122
+
+		h := hex.EncodeToString(uuid[:])
+		return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32], nil
+	}
+}
+
+// isUuid checks if the ID is formatted like a uuidIdGenerator's UUID.
+func isUuid(id string) bool {
+	if len(id) != 36 {
+		return false
+	}
+	for i, r := range id {
+		switch i {
+		case 8, 13, 18, 23:
+			if r != '-' {
+				return false
+			}
+		case 14:
+			if r != '4' {
+				return false
+			}
+		case 19:
+			if !strings.ContainsRune("89ab", r) {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdef", r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// nanoidDefaultAlphabet is the URL-safe alphabet of Nano ID.
+const nanoidDefaultAlphabet = "_-0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// nanoidAlphabet returns the alphabet for a "nanoid" ID generator, defaulting
+// to the nanoidDefaultAlphabet. As IDs are part of both URLs and file names,
+// only the characters of the default alphabet are allowed.
+func nanoidAlphabet(alphabet string) (string, error) {
+	if alphabet == "" {
+		return nanoidDefaultAlphabet, nil
+	}
+
+	seen := make(map[rune]struct{})
+	for _, r := range alphabet {
+		if !strings.ContainsRune(nanoidDefaultAlphabet, r) {
+			return "", fmt.Errorf("nanoid alphabet contains the unsupported character %q", r)
+		} else if _, ok := seen[r]; ok {
+			return "", fmt.Errorf("nanoid alphabet contains the character %q twice", r)
+		}
+		seen[r] = struct{}{}
+	}
+	if len(alphabet) < 2 {
+		return "", fmt.Errorf("nanoid alphabet requires at least two characters")
+	}
+	return alphabet, nil
+}
+
+// nanoidIdGenerator returns an ID generator for the "nanoid" type, picking
+// length characters from the alphabet.
+func nanoidIdGenerator(alphabet string, length int) (func() (string, error), error) {
+	alphabet, err := nanoidAlphabet(alphabet)
+	if err != nil {
+		return nil, err
+	}
+	if length <= 0 {
+		return nil, fmt.Errorf("nanoid length must be positive")
+	}
+
+	alphabetLen := big.NewInt(int64(len(alphabet)))
+	return func() (string, error) {
+		id := make([]byte, length)
+		for i := range id {
+			n, err := rand.Int(rand.Reader, alphabetLen)
+			if err != nil {
+				return "", err
+			}
+			id[i] = alphabet[n.Int64()]
+		}
+		return string(id), nil
+	}, nil
+}
+
+// idValidator returns a function checking if an ID might have been created by
+// the configured ID generator. It only rejects obviously invalid IDs, e.g., of
+// a wrong length or with characters not being part of the encoding.
+func idValidator(conf IdGeneratorConfig) (func(string) bool, error) {
+	if conf.Type == "uuid" {
+		// The UUID's length is fixed, independent of the configured Length.
+		return isUuid, nil
+	} else if conf.Length <= 0 {
+		return nil, fmt.Errorf("ID generator length must be positive")
+	}
+
+	switch conf.Type {
+	case "random":
+		// Leading zero bytes are encoded as a single character each, thus
+		// shorter IDs are possible.
+		maxLength := len(base58.Encode(bytes.Repeat([]byte{0xff}, conf.Length)))
+		return func(id string) bool {
+			if len(id) == 0 || len(id) > maxLength {
+				return false
+			}
+			for _, r := range id {
+				if !strings.ContainsRune(base58Alphabet, r) {
+					return false
+				}
+			}
+			return true
+		}, nil
+
+	case "wordlist":
+		// As words might contain a dash, an ID has at least Length parts.
+		return func(id string) bool {
+			parts := strings.Split(id, "-")
+			if len(parts) < conf.Length {
+				return false
+			}
+			for _, part := range parts {
+				if part == "" {
+					return false
+				}
+			}
+			return true
+		}, nil
+
+	case "nanoid":
+		alphabet, err := nanoidAlphabet(conf.Alphabet)
+		if err != nil {
+			return nil, err
+		}
+		return func(id string) bool {
+			if len(id) != conf.Length {
+				return false
+			}
+			for _, r := range id {
+				if !strings.ContainsRune(alphabet, r) {
+					return false
+				}
+			}
+			return true
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown ID generator type %q", conf.Type)
+	}
+}
+
+// ItemEvent describes why a hook for an Item was called.
+type ItemEvent string
+
+const (
+	ItemDeleted ItemEvent = "deleted"
+	ItemExpired ItemEvent = "expired"
+	ItemEvicted ItemEvent = "evicted"
+
+	ItemCreated    ItemEvent = "created"
+	ItemDownloaded ItemEvent = "downloaded"
+	ItemBurned     ItemEvent = "burned"
+)
+
+// QuotaPolicy defines how to handle a new Item exceeding the MaxStoreSize.
+type QuotaPolicy string
+
+const (
+	// QuotaReject rejects such Items with ErrStoreFull.
+	QuotaReject QuotaPolicy = "reject"
+	// QuotaEvict evicts the Items expiring next until there is enough room.
+	QuotaEvict QuotaPolicy = "evict"
+)
+
+// StoreOpts are optional settings for a Store.
+type StoreOpts struct {
+	// AutoCleanup specifies if both a background cleanup job will be launched
+	// as well as deleting expired Items after being retrieved.
+	AutoCleanup bool
+
+	// CleanupInterval is the interval of the background cleanup job. Zero
+	// disables the job, while expired Items are still deleted on retrieval.
+	CleanupInterval time.Duration
+
+	// DeletionHook is called for each deleted or expired Item. It is called
+	// synchronously and must not block.
+	DeletionHook func(ItemEvent, Item)
+
+	// Backend stores the files. If unset, they are stored in the Store's
+	// directory, respecting the ShardDepth.
+	Backend Backend
+
+	// ShardDepth is the amount of subdirectory levels, each named after the
+	// next two bytes of the ID, files are stored within. Zero disables it.
+	ShardDepth int
+
+	// DeletionRetries is the amount of retries by the background cleanup job
+	// for files which could not be deleted after their Item. Zero disables
+	// retries, resulting in an error for the deletion instead.
+	DeletionRetries int
+
+	// MaxStoreSize limits the total size of all files in bytes, handled by the
+	// QuotaPolicy. Zero disables this limit.
+	MaxStoreSize int64
+	QuotaPolicy  QuotaPolicy
+
+	// Reconcile the Backend's files with the database while opening the
+	// Store, see Store.Reconcile.
+	Reconcile bool
+}
+
 // Store stores an index of all Items as well as the pure files.
 type Store struct {
 	baseDir string
@@ -104,29 +500,73 @@ type Store struct {
 
 	idGenerator func() (string, error)
 
-	cleanup bool
-	stopSyn chan struct{}
-	stopAck chan struct{}
+	deletionHook func(ItemEvent, Item)
+
+	backend Backend
+
+	deletionRetries int
+
+	maxStoreSize int64
+	quotaPolicy  QuotaPolicy
+	// quotaMu serializes checking and reserving the quota, while usage is
+	// the total size of all blobs, being decreased by deletions at any time.
+	quotaMu sync.Mutex
+	usage   atomic.Int64
+
+	// blobsMu serializes linking and unlinking blobs, see linkBlob, and
+	// guards blobsBusy, the hashes of contents being put, see lockContent.
+	// It must not be held while acquiring quotaMu.
+	blobsMu   sync.Mutex
+	blobsBusy map[string]chan struct{}
+
+	// uploadsBusy are the tokens of Uploads being modified, see lockUpload.
+	uploadsMu   sync.Mutex
+	uploadsBusy map[string]struct{}
+
+	cleanup         bool
+	cleanupInterval time.Duration
+	stopSyn         chan struct{}
+	stopAck         chan struct{}
 }
 
 // NewStore opens or initializes a Store in the given directory.
-//
-// autoCleanup specifies if both a background cleanup job will be launched as
-// well as deleting expired Items after being retrieved.
 func NewStore(
 	baseDir string,
 	idGenerator func() (string, error),
-	autoCleanup bool,
+	opts StoreOpts,
 ) (s *Store, err error) {
+	if opts.ShardDepth < 0 || opts.ShardDepth > MaxShardDepth {
+		return nil, fmt.Errorf("shard depth must be between 0 and %d, not %d", MaxShardDepth, opts.ShardDepth)
+	}
+
+	switch opts.QuotaPolicy {
+	case "":
+		opts.QuotaPolicy = QuotaReject
+	case QuotaReject, QuotaEvict:
+	default:
+		return nil, fmt.Errorf("unknown quota policy %q", opts.QuotaPolicy)
+	}
+
 	s = &Store{
-		baseDir:     baseDir,
-		idGenerator: idGenerator,
-		cleanup:     autoCleanup,
+		baseDir:      baseDir,
+		idGenerator:  idGenerator,
+		deletionHook: opts.DeletionHook,
+		backend:      opts.Backend,
+		cleanup:      opts.AutoCleanup,
+
+		cleanupInterval: opts.CleanupInterval,
+		deletionRetries: opts.DeletionRetries,
+
+		maxStoreSize: opts.MaxStoreSize,
+		quotaPolicy:  opts.QuotaPolicy,
+
+		uploadsBusy: make(map[string]struct{}),
+		blobsBusy:   make(map[string]chan struct{}),
 	}
 
 	slog.Info("Opening Store", slog.String("directory", baseDir))
 
-	for _, dir := range []string{baseDir, s.databaseDir(), s.storageDir()} {
+	for _, dir := range []string{baseDir, s.databaseDir(), s.storageDir(), s.uploadsDir()} {
 		_, stat := os.Stat(dir)
 		if !os.IsNotExist(stat) {
 			continue
@@ -139,20 +579,63 @@ func NewStore(
 		}
 	}
 
-	opts := badgerhold.DefaultOptions
-	opts.Dir = s.databaseDir()
-	opts.ValueDir = opts.Dir
-	opts.Logger = &BadgerLogWapper{slog.Default()}
-	opts.Options.BaseLevelSize = 1 << 21    // 2MiB
-	opts.Options.ValueLogFileSize = 1 << 24 // 16MiB
-	opts.Options.BaseTableSize = 1 << 20    // 1MiB
+	if s.backend == nil {
+		s.backend, err = newFileBackend(s.storageDir(), opts.ShardDepth)
+		if err != nil {
+			slog.Error("Cannot move files to their shard", slog.Any("error", err))
+			return
+		}
+	}
+
+	bhOpts := badgerhold.DefaultOptions
+	bhOpts.Dir = s.databaseDir()
+	bhOpts.ValueDir = bhOpts.Dir
+	bhOpts.Logger = &BadgerLogWapper{slog.Default()}
+	bhOpts.Options.BaseLevelSize = 1 << 21    // 2MiB
+	bhOpts.Options.ValueLogFileSize = 1 << 24 // 16MiB
+	bhOpts.Options.BaseTableSize = 1 << 20    // 1MiB
+
+	s.bh, err = badgerhold.Open(bhOpts)
+	if err != nil {
+		return
+	}
+
+	err = s.migrateIndexes()
+	if err != nil {
+		slog.Error("Cannot update the database's indexes", slog.Any("error", err))
+		_ = s.bh.Close()
+		return
+	}
+
+	err = s.deleteInterrupted()
+	if err != nil {
+		slog.Error("Cannot delete Items of interrupted insertions", slog.Any("error", err))
+		_ = s.bh.Close()
+		return
+	}
 
-	s.bh, err = badgerhold.Open(opts)
+	err = s.bh.ForEach(nil, func(b *blob) error {
+		s.usage.Add(b.Size)
+		return nil
+	})
 	if err != nil {
+		slog.Error("Cannot sum up the Store's usage", slog.Any("error", err))
+		_ = s.bh.Close()
 		return
 	}
+	if opts.Reconcile {
+		err = s.Reconcile()
+		if err != nil {
+			slog.Error("Cannot reconcile the Store's files with its database", slog.Any("error", err))
+			_ = s.bh.Close()
+			return
+		}
+	}
+
+	slog.Info("Opened Store",
+		slog.Int64("usage", s.Usage()), slog.Int64("max_store_size", s.maxStoreSize))
 
-	if s.cleanup {
+	if s.cleanup && s.cleanupInterval > 0 {
 		s.stopSyn = make(chan struct{})
 		s.stopAck = make(chan struct{})
 
@@ -163,18 +646,126 @@ func NewStore(
 }
 
 // databaseDir returns the database subdirectory.
-func (s Store) databaseDir() string {
+func (s *Store) databaseDir() string {
 	return filepath.Join(s.baseDir, DirDatabase)
 }
 
 // storageDir returns the file storage subdirectory.
-func (s Store) storageDir() string {
+func (s *Store) storageDir() string {
 	return filepath.Join(s.baseDir, DirStorage)
 }
 
+// storeSchemaKey is the badgerhold key of the database's storeSchema.
+const storeSchemaKey = "schema"
+
+// storeSchemaVersion is incremented for each new Item index or field requiring
+// a migration. Indexes are only maintained for inserted or updated Items, thus
+// existing ones are reindexed.
+const storeSchemaVersion = 2
+
+// storeSchema describes the database's state for migrations.
+type storeSchema struct {
+	Version int
+}
+
+// migrateIndexes reindexes all Items and backfills their blobs if the
+// database's storeSchema is older than the storeSchemaVersion.
+func (s *Store) migrateIndexes() error {
+	var schema storeSchema
+	err := s.bh.Get(storeSchemaKey, &schema)
+	if err != nil && err != badgerhold.ErrNotFound {
+		return err
+	}
+
+	if schema.Version >= storeSchemaVersion {
+		return nil
+	}
+
+	var items []Item
+	err = s.bh.Find(&items, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range items {
+		if i.Blob == "" {
+			if i.Size == 0 {
+				if i.Size, err = s.fileSize(i.ID); err != nil {
+					slog.Warn("Cannot determine the file size of Item",
+						slog.String("id", i.ID), slog.Any("error", err))
+				}
+			}
+
+			err = s.bh.Upsert(i.ID, blob{Name: i.ID, Hash: i.ContentHash, Size: i.Size, Refs: 1})
+			if err != nil {
+				return err
+			}
+			i.Blob = i.ID
+		}
+
+		// Updating an Item rebuilds all its index entries.
+		err = s.bh.Update(i.ID, i)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(items) > 0 {
+		slog.Info("Reindexed Items for a new database schema",
+			slog.Int("items", len(items)), slog.Int("version", storeSchemaVersion))
+	}
+
+	return s.bh.Upsert(storeSchemaKey, storeSchema{Version: storeSchemaVersion})
+}
+
+// fileSize returns the size of a stored file. Unless the Backend returns an
+// *os.File, the file is read completely.
+func (s *Store) fileSize(name string) (int64, error) {
+	f, err := s.backend.Get(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if f, ok := f.(*os.File); ok {
+		if fi, err := f.Stat(); err == nil {
+			return fi.Size(), nil
+		}
+	}
+	return io.Copy(io.Discard, f)
+}
+
+// deleteInterrupted deletes Items whose insertion was interrupted, e.g., by a
+// killed store. Such Items were never linked to a blob and their files, if
+// any, are incomplete.
+func (s *Store) deleteInterrupted() error {
+	var items []Item
+	err := s.bh.Find(&items, badgerhold.Where("Blob").Eq(""))
+	if err != nil {
+		return err
+	}
+
+	for _, i := range items {
+		err = s.bh.Delete(i.ID, Item{})
+		if err != nil {
+			return err
+		}
+
+		if err := s.backend.Delete(i.ID); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("Failed to remove file of interrupted insertion",
+				slog.String("id", i.ID), slog.Any("error", err))
+		}
+	}
+
+	if len(items) > 0 {
+		slog.Warn("Deleted Items of interrupted insertions", slog.Int("items", len(items)))
+	}
+	return nil
+}
+
 // cleanupExired runs in a background goroutine to clean up expired Items.
 func (s *Store) cleanupExired() {
-	var ticker = time.NewTicker(time.Minute)
+	var ticker = time.NewTicker(s.cleanupInterval)
 	defer ticker.Stop()
 
 	for {
@@ -187,6 +778,14 @@ func (s *Store) cleanupExired() {
 			if err := s.deleteExpired(); err != nil {
 				slog.Error("Deletion of expired Items failed", slog.Any("error", err))
 			}
+			if err := s.deleteExpiredUploads(); err != nil {
+				slog.Error("Deletion of expired Uploads failed", slog.Any("error", err))
+			}
+			if err := s.retryDeletions(); err != nil {
+				slog.Error("Retrying deletion of files failed", slog.Any("error", err))
+			}
+			slog.Debug("Store usage",
+				slog.Int64("usage", s.Usage()), slog.Int64("max_store_size", s.maxStoreSize))
 		}
 	}
 }
@@ -199,7 +798,15 @@ func (s *Store) createID() (string, error) {
 			return "", err
 		}
 
-		err = s.bh.Get(id, Item{})
+		err = s.bh.Get(id, &Item{})
+		if err == badgerhold.ErrNotFound {
+			// A blob outliving its first Item would be overwritten.
+			err = s.bh.Get(id, &blob{})
+		}
+		if err == badgerhold.ErrNotFound {
+			// A file still waiting for its deletion would be overwritten.
+			err = s.bh.Get(id, &pendingDeletion{})
+		}
 		switch err {
 		case nil:
 			// Continue if this ID is already in use
@@ -222,7 +829,7 @@ func (s *Store) createID() (string, error) {
 func (s *Store) Close() error {
 	slog.Info("Closing Store")
 
-	if s.cleanup {
+	if s.stopSyn != nil {
 		close(s.stopSyn)
 		<-s.stopAck
 	}
@@ -244,11 +851,11 @@ func (s *Store) Get(id string) (i Item, err error) {
 		return
 	}
 
-	if s.cleanup && i.Expires.Before(time.Now()) {
+	if s.cleanup && i.expired(time.Now()) {
 		slog.Info("Requested Item is expired, will be deleted",
 			slog.String("id", id), slog.Any("expires", i.Expires))
 
-		err = s.Delete(i.ID)
+		err = s.delete(i.ID, ItemExpired)
 		if err != nil {
 			slog.Error("Failed to delete expired Item", slog.String("id", id), slog.Any("error", err))
 			return
@@ -262,7 +869,39 @@ func (s *Store) Get(id string) (i Item, err error) {
 
 // GetFile creates a ReadCloser for a stored Item file by this ID.
 func (s *Store) GetFile(id string) (*os.File, error) {
-	return os.Open(filepath.Join(s.storageDir(), id))
+	var i Item
+	err := s.bh.Get(id, &i)
+	if err == badgerhold.ErrNotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	rc, err := s.backend.Get(blobName(i))
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := rc.(*os.File); ok {
+		return f, nil
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		_ = rc.Close()
+		return nil, err
+	}
+
+	go func() {
+		// An error happens if the reading end was closed early.
+		if _, err := io.Copy(w, rc); err != nil {
+			slog.Debug("Streaming file from the backend was aborted",
+				slog.String("id", id), slog.Any("error", err))
+		}
+		_ = rc.Close()
+		_ = w.Close()
+	}()
+
+	return r, nil
 }
 
 // Put a new Item inside the Store.
@@ -272,6 +911,15 @@ func (s *Store) GetFile(id string) (*os.File, error) {
 func (s *Store) Put(i Item, file io.ReadCloser) (id string, err error) {
 	slog.Debug("Requested insertion of Item into the Store")
 
+	uploadChecksum := i.UploadChecksum
+	i.UploadChecksum = Checksum{}
+
+	uploadHasher, err := newUploadHasher(uploadChecksum)
+	if err != nil {
+		_ = file.Close()
+		return
+	}
+
 	id, err = s.createID()
 	if err != nil {
 		slog.Error("Failed to create an ID for a new Item", slog.Any("error", err))
@@ -288,71 +936,499 @@ func (s *Store) Put(i Item, file io.ReadCloser) (id string, err error) {
 		return
 	}
 
-	f, err := os.Create(filepath.Join(s.storageDir(), i.ID))
+	var reserved int64
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		slog.Warn("Rolling back failed insertion of Item",
+			slog.String("id", i.ID), slog.Any("error", err))
+
+		s.usage.Add(-reserved)
+
+		if rmErr := s.backend.Delete(i.ID); rmErr != nil && !errors.Is(rmErr, fs.ErrNotExist) {
+			slog.Error("Failed to remove file of failed Item",
+				slog.String("id", i.ID), slog.Any("error", rmErr))
+		}
+		if delErr := s.bh.Delete(i.ID, Item{}); delErr != nil {
+			slog.Error("Failed to delete failed Item from database",
+				slog.String("id", i.ID), slog.Any("error", delErr))
+		}
+
+		id = ""
+	}()
+
+	hasher := sha256.New()
+	counter := &countingWriter{}
+	writers := []io.Writer{hasher, counter}
+	if uploadHasher != nil {
+		writers = append(writers, uploadHasher)
+	}
+
+	err = s.backend.Put(i.ID, io.TeeReader(file, io.MultiWriter(writers...)))
+	_ = file.Close()
+	if err != nil {
+		return
+	}
+	n := counter.n
+
+	if i.Size > 0 && n != i.Size {
+		err = fmt.Errorf("%w: %d of %d bytes", ErrIncompleteFile, n, i.Size)
+		return
+	}
+
+	if uploadHasher != nil {
+		err = checkUploadChecksum(uploadChecksum, uploadHasher)
+		if err != nil {
+			return
+		}
+	}
+
+	i.Size = n
+	i.ContentHash = hex.EncodeToString(hasher.Sum(nil))
+
+	unlock := s.lockContent(i.ContentHash)
+	defer unlock()
+
+	// A duplicate requires no quota, unless its blob is gone before linking.
+	_, duplicate, err := s.findBlob(i.ContentHash, i.Size)
+	if err != nil {
+		return
+	}
+	if !duplicate {
+		err = s.reserveQuota(n)
+		if err != nil {
+			return
+		}
+		reserved = n
+	}
+
+	deduplicated, err := s.linkBlob(&i)
 	if err != nil {
-		slog.Error("Failed to create file",
+		slog.Error("Failed to link Item's file",
 			slog.String("id", i.ID), slog.Any("error", err))
 		return
 	}
 
-	_, err = io.Copy(f, file)
+	if !deduplicated {
+		s.usage.Add(n - reserved)
+		return
+	}
+
+	slog.Debug("Item's file is shared with an identical one",
+		slog.String("id", i.ID), slog.String("blob", i.Blob))
+
+	s.usage.Add(-reserved)
+	if rmErr := s.backend.Delete(i.ID); rmErr != nil {
+		slog.Error("Failed to remove duplicate file of Item",
+			slog.String("id", i.ID), slog.Any("error", rmErr))
+	}
+
+	return
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
+
+// reserveQuota accounts size bytes against the maxStoreSize, evicting Items
+// outside of quotaMu for QuotaEvict.
+func (s *Store) reserveQuota(size int64) error {
+	if s.maxStoreSize <= 0 {
+		s.usage.Add(size)
+		return nil
+	}
+
+	for {
+		victim, err := s.reserveQuotaOrEvict(size)
+		if err != nil || victim == "" {
+			return err
+		}
+
+		// A concurrent reservation might have evicted the same Item.
+		err = s.delete(victim, ItemEvicted)
+		if err != nil && !errors.Is(err, badgerhold.ErrNotFound) {
+			return err
+		}
+	}
+}
+
+// reserveQuotaOrEvict reserves size bytes if they fit into the maxStoreSize.
+// Otherwise, the ID of the next Item to be evicted is returned.
+func (s *Store) reserveQuotaOrEvict(size int64) (string, error) {
+	s.quotaMu.Lock()
+	defer s.quotaMu.Unlock()
+
+	if s.Usage()+size <= s.maxStoreSize {
+		s.usage.Add(size)
+		return "", nil
+	} else if s.quotaPolicy != QuotaEvict || size > s.maxStoreSize {
+		return "", ErrStoreFull
+	}
+
+	// Items being inserted are not linked to a blob yet and are never evicted,
+	// even if their expected Size is already set. Items without an expiry are
+	// evicted last, oldest first.
+	var items []Item
+	err := s.bh.Find(&items, badgerhold.Where("Blob").Ne("").
+		And("Expires").Ne(time.Time{}).SortBy("Expires").Limit(1))
+	if err == nil && len(items) == 0 {
+		err = s.bh.Find(&items, badgerhold.Where("Blob").Ne("").SortBy("Created").Limit(1))
+	}
+	if err != nil {
+		return "", err
+	} else if len(items) == 0 {
+		return "", ErrStoreFull
+	}
+
+	slog.Info("Evicting Item to free space in the Store",
+		slog.String("id", items[0].ID), slog.Int64("size", items[0].Size), slog.Int64("usage", s.Usage()))
+	return items[0].ID, nil
+}
+
+// Usage returns the total size of all Items' files in bytes. Files shared by
+// multiple Items are only counted once.
+func (s *Store) Usage() int64 {
+	return s.usage.Load()
+}
+
+// Stats returns the number of Items and the Usage.
+func (s *Store) Stats() (StoreStats, error) {
+	count, err := s.bh.Count(&Item{}, nil)
+	if err != nil {
+		return StoreStats{}, err
+	}
+	return StoreStats{Items: int(count), Bytes: s.Usage()}, nil
+}
+
+// UpdateSettings of an existing Item and return the updated Item.
+func (s *Store) UpdateSettings(id string, settings ItemSettings) (i Item, err error) {
+	slog.Debug("Requested settings update of Item", slog.String("id", id))
+
+	i, err = s.Get(id)
 	if err != nil {
 		return
 	}
 
-	err = file.Close()
+	err = settings.apply(&i)
 	if err != nil {
 		return
 	}
 
-	err = f.Close()
+	err = s.bh.Update(i.ID, i)
 	if err != nil {
+		slog.Error("Failed to update Item's settings",
+			slog.String("id", i.ID), slog.Any("error", err))
 		return
 	}
 
 	return
 }
 
-// deleteExpired checks the Store for expired Items and deletes them.
+// RecordDownload counts a served download of an Item within a transaction.
+func (s *Store) RecordDownload(id string) error {
+	_, err := s.updateItem(id, func(i *Item) error {
+		i.recordDownload(time.Now())
+		return nil
+	})
+	if err != nil && err != ErrNotFound {
+		slog.Error("Failed to record download of Item",
+			slog.String("id", id), slog.Any("error", err))
+	}
+	return err
+}
+
+// errTouchThrottled aborts the update of a recently touched Item.
+var errTouchThrottled = errors.New("Item was touched recently")
+
+// Touch updates the Item's LastAccess, unless it was updated within the last
+// TouchInterval.
+func (s *Store) Touch(id string) error {
+	_, err := s.updateItem(id, func(i *Item) error {
+		if !i.touch(time.Now()) {
+			return errTouchThrottled
+		}
+		return nil
+	})
+	if err == errTouchThrottled {
+		return nil
+	} else if err != nil && err != ErrNotFound {
+		slog.Error("Failed to update Item's last access",
+			slog.String("id", id), slog.Any("error", err))
+	}
+	return err
+}
+
+// updateItem atomically modifies an Item by f within a transaction and returns
+// the updated Item. Concurrent updates conflict and are retried. If f returns
+// an error, the Item is left unchanged.
+func (s *Store) updateItem(id string, f func(*Item) error) (i Item, err error) {
+	for {
+		err = s.bh.Badger().Update(func(tx *badger.Txn) error {
+			if err := s.bh.TxGet(tx, id, &i); err != nil {
+				return err
+			}
+
+			if err := f(&i); err != nil {
+				return err
+			}
+			return s.bh.TxUpdate(tx, id, i)
+		})
+		if err != badger.ErrConflict {
+			break
+		}
+	}
+
+	if err == badgerhold.ErrNotFound {
+		err = ErrNotFound
+	}
+	return
+}
+
+// RecordBurnAttempt counts a failed download of an Item within a transaction.
+func (s *Store) RecordBurnAttempt(id string) (i Item, err error) {
+	slog.Debug("Record failed download attempt of Item", slog.String("id", id))
+
+	i, err = s.updateItem(id, func(i *Item) error {
+		i.recordBurnAttempt(time.Now())
+		return nil
+	})
+	if err != nil && err != ErrNotFound {
+		slog.Error("Failed to record failed download attempt of Item",
+			slog.String("id", id), slog.Any("error", err))
+	}
+	return
+}
+
+// ReserveDownload counts a download of an Item within a transaction.
+func (s *Store) ReserveDownload(id string) (i Item, err error) {
+	slog.Debug("Reserve download of Item", slog.String("id", id))
+
+	i, err = s.updateItem(id, func(i *Item) error {
+		return i.reserveDownload(time.Now())
+	})
+	if err != nil && err != ErrNotFound && err != ErrDownloadsExhausted {
+		slog.Error("Failed to reserve download of Item",
+			slog.String("id", id), slog.Any("error", err))
+	}
+	return
+}
+
+// ReleaseDownload reverts a reserved download of an Item within a transaction.
+func (s *Store) ReleaseDownload(id string) error {
+	slog.Debug("Release reserved download of Item", slog.String("id", id))
+
+	_, err := s.updateItem(id, func(i *Item) error {
+		i.releaseDownload()
+		return nil
+	})
+	if err != nil && err != ErrNotFound {
+		slog.Error("Failed to release reserved download of Item",
+			slog.String("id", id), slog.Any("error", err))
+	}
+	return err
+}
+
+// FindByContentType returns all unexpired Items of this Content-Type by using
+// the ContentType index.
+func (s *Store) FindByContentType(contentType string) ([]Item, error) {
+	slog.Debug("Requested Items by Content-Type", slog.String("content-type", contentType))
+
+	var items []Item
+	err := s.bh.Find(&items, badgerhold.Where("ContentType").Eq(contentType).Index("ContentType"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Items without an expiry cannot be queried by a simple comparison.
+	now := time.Now()
+	unexpired := items[:0]
+	for _, i := range items {
+		if !i.expired(now) {
+			unexpired = append(unexpired, i)
+		}
+	}
+	return unexpired, nil
+}
+
+// List returns Items as described by the ListQuery.
+func (s *Store) List(query ListQuery) ([]Item, error) {
+	q := &badgerhold.Query{}
+	if query.NeverDownloaded {
+		q = badgerhold.Where("Downloads").Eq(0)
+	}
+
+	switch query.Order {
+	case ListByDownloads:
+		q = q.SortBy("Downloads", "Created", "ID").Reverse()
+	case ListByLastAccess:
+		q = q.SortBy("LastAccess", "Created", "ID")
+	default:
+		q = q.SortBy("Created", "ID")
+	}
+
+	var items []Item
+	err := s.bh.Find(&items, q.Skip(query.Offset).Limit(query.Limit))
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// expiryBatchSize limits the expired Items being deleted by deleteExpired at
+// once. Remaining Items are left for the cleanup job's next run, not to block
+// concurrent requests for too long.
+const expiryBatchSize = 512
+
+// deleteExpired checks the Store for expired Items and deletes up to
+// expiryBatchSize of them, together with their orphaned files.
 func (s *Store) deleteExpired() error {
 	var items []Item
-	err := s.bh.Find(&items, badgerhold.Where("Expires").Lt(time.Now()))
+	err := s.bh.Find(&items, badgerhold.Where("Expires").Lt(time.Now()).And("Expires").Ne(time.Time{}).
+		Limit(expiryBatchSize))
+	if err != nil || len(items) == 0 {
+		return err
+	}
+
+	ids := make([]string, len(items))
+	for n, i := range items {
+		ids[n] = i.ID
+	}
+
+	items, orphans, err := s.unlinkBlobs(ids)
 	if err != nil {
+		slog.Error("Failed to delete expired Items from database",
+			slog.Int("items", len(ids)), slog.Any("error", err))
 		return err
 	}
+	slog.Debug("Deleted expired Items",
+		slog.Int("items", len(items)), slog.Int("files", len(orphans)))
 
-	for _, i := range items {
-		slog.Debug("Delete expired Item", slog.String("id", i.ID))
-		err := s.Delete(i.ID)
-		if err != nil {
-			return err
+	var errs []error
+	for _, orphan := range orphans {
+		if err := s.deleteFile(orphan); err != nil {
+			errs = append(errs, err)
 		}
 	}
 
-	return nil
+	if s.deletionHook != nil {
+		for _, i := range items {
+			s.deletionHook(ItemExpired, i)
+		}
+	}
+
+	return errors.Join(errs...)
 }
 
 // Delte an Item. Both the database entry and the file will be removed.
 func (s *Store) Delete(id string) (err error) {
+	return s.delete(id, ItemDeleted)
+}
+
+// Rollback deletes a newly put Item without calling the deletion hook.
+func (s *Store) Rollback(id string) (err error) {
+	return s.delete(id, "")
+}
+
+// delete an Item and call the deletion hook with the given event afterwards.
+// An empty event, used by Rollback, does not call the hook.
+func (s *Store) delete(id string, event ItemEvent) (err error) {
 	slog.Debug("Requested deletion of Item", slog.String("id", id))
 
-	err = s.bh.Delete(&id, Item{})
+	// The Item is required for the deletion hook. Its file is only deleted
+	// together with its blob's last reference.
+	item, orphan, err := s.unlinkBlob(id)
 	if err != nil {
 		slog.Error("Failed to delete Item from database",
 			slog.String("id", id), slog.Any("error", err))
 		return
 	}
 
-	err = os.Remove(filepath.Join(s.storageDir(), id))
-	if err != nil {
-		slog.Error("Failed to delete Item's file",
-			slog.String("id", id), slog.Any("error", err))
-		return
+	if orphan == "" {
+		slog.Debug("Item's file is still shared with other Items", slog.String("id", id))
+	} else {
+		err = s.deleteFile(orphan)
+		if err != nil {
+			return
+		}
+	}
+
+	if s.deletionHook != nil && event != "" {
+		s.deletionHook(event, item)
 	}
 
 	return
 }
 
+// deleteFile deletes a file from the Backend, being retried later on failure.
+func (s *Store) deleteFile(name string) (err error) {
+	err = s.backend.Delete(name)
+	if err != nil && s.deletionRetries > 0 {
+		slog.Warn("Failed to delete Item's file, retrying later",
+			slog.String("id", name), slog.Any("error", err))
+
+		err = s.bh.Upsert(name, pendingDeletion{ID: name})
+		if err != nil {
+			slog.Error("Failed to record Item's file for a later deletion",
+				slog.String("id", name), slog.Any("error", err))
+		}
+	} else if err != nil {
+		slog.Error("Failed to delete Item's file",
+			slog.String("id", name), slog.Any("error", err))
+	}
+	return
+}
+
+// pendingDeletion is a file whose deletion has failed after its Item was
+// already deleted from the database. It is retried by retryDeletions.
+type pendingDeletion struct {
+	ID       string `badgerhold:"key"`
+	Attempts int
+}
+
+// retryDeletions retries to delete the files of all pendingDeletions. After
+// the Store's deletionRetries, a file is given up and stays orphaned.
+func (s *Store) retryDeletions() error {
+	var pending []pendingDeletion
+	err := s.bh.Find(&pending, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pending {
+		err := s.backend.Delete(p.ID)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			p.Attempts++
+			if p.Attempts < s.deletionRetries {
+				slog.Warn("Failed to delete Item's file again, retrying later",
+					slog.String("id", p.ID), slog.Int("attempts", p.Attempts), slog.Any("error", err))
+
+				if err := s.bh.Update(p.ID, p); err != nil {
+					return err
+				}
+				continue
+			}
+
+			slog.Error("Giving up deleting Item's file, it stays orphaned",
+				slog.String("id", p.ID), slog.Int("attempts", p.Attempts), slog.Any("error", err))
+		} else {
+			slog.Info("Deleted Item's file after a retry", slog.String("id", p.ID))
+		}
+
+		if err := s.bh.Delete(p.ID, pendingDeletion{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // BadgerHold returns a reference to the underlying BadgerHold instance.
 func (s *Store) BadgerHold() *badgerhold.Store {
 	return s.bh
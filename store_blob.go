@@ -0,0 +1,290 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/timshannon/badgerhold/v4"
+)
+
+// blob is a file within the Backend, shared by all Items of the same content.
+type blob struct {
+	Name string `badgerhold:"key"`
+	Hash string `badgerholdIndex:"Hash"`
+	Size int64
+
+	// Refs is the number of Items referencing this blob.
+	Refs int
+}
+
+// blobName returns the name of an Item's file within the Backend. Items being
+// inserted have no Blob yet and own a file named after their ID.
+func blobName(i Item) string {
+	if i.Blob == "" {
+		return i.ID
+	}
+	return i.Blob
+}
+
+// lockContent serializes Puts of the same content by its hash until the
+// returned function is called. Otherwise, concurrent duplicates would all
+// reserve quota for a new blob before any of them was linked.
+func (s *Store) lockContent(hash string) (unlock func()) {
+	s.blobsMu.Lock()
+	for {
+		busy, ok := s.blobsBusy[hash]
+		if !ok {
+			break
+		}
+
+		s.blobsMu.Unlock()
+		<-busy
+		s.blobsMu.Lock()
+	}
+
+	busy := make(chan struct{})
+	s.blobsBusy[hash] = busy
+	s.blobsMu.Unlock()
+
+	return func() {
+		s.blobsMu.Lock()
+		delete(s.blobsBusy, hash)
+		s.blobsMu.Unlock()
+
+		close(busy)
+	}
+}
+
+// update runs a read-write transaction, being retried on conflicts with
+// concurrent transactions, as badgerhold does for its own updates.
+func (s *Store) update(f func(tx *badger.Txn) error) error {
+	for {
+		err := s.bh.Badger().Update(f)
+		if err != badger.ErrConflict {
+			return err
+		}
+	}
+}
+
+// findBlob returns a blob of this content, if any.
+func (s *Store) findBlob(hash string, size int64) (b blob, found bool, err error) {
+	if hash == "" {
+		return
+	}
+
+	var blobs []blob
+	err = s.bh.Find(&blobs, badgerhold.Where("Hash").Eq(hash).Index("Hash").And("Size").Eq(size).Limit(1))
+	if err != nil || len(blobs) == 0 {
+		return
+	}
+	return blobs[0], true, nil
+}
+
+// linkBlob sets the Item's Blob to an existing blob of the same content or to
+// a new one, returning if the Item's own file became a duplicate.
+func (s *Store) linkBlob(i *Item) (deduplicated bool, err error) {
+	// Concurrent Puts of the same content must not both create a new blob.
+	s.blobsMu.Lock()
+	defer s.blobsMu.Unlock()
+
+	b, deduplicated, err := s.findBlob(i.ContentHash, i.Size)
+	if err != nil {
+		return
+	}
+
+	if deduplicated {
+		b.Refs++
+	} else {
+		b = blob{Name: i.ID, Hash: i.ContentHash, Size: i.Size, Refs: 1}
+	}
+	i.Blob = b.Name
+
+	err = s.update(func(tx *badger.Txn) error {
+		if err := s.bh.TxUpsert(tx, b.Name, b); err != nil {
+			return err
+		}
+		return s.bh.TxUpdate(tx, i.ID, *i)
+	})
+	if err != nil {
+		i.Blob = ""
+		return false, err
+	}
+	return
+}
+
+// unlinkBlob deletes an Item and its blob reference, returning the blob's name
+// if its file is orphaned.
+func (s *Store) unlinkBlob(id string) (i Item, orphan string, err error) {
+	s.blobsMu.Lock()
+	defer s.blobsMu.Unlock()
+
+	var size int64
+	err = s.update(func(tx *badger.Txn) (err error) {
+		i, orphan, size, err = s.txUnlinkBlob(tx, id)
+		return
+	})
+	if err != nil {
+		return Item{}, "", err
+	}
+
+	s.usage.Add(-size)
+	return
+}
+
+// unlinkBlobs is unlinkBlob for multiple Items, skipping those already deleted.
+func (s *Store) unlinkBlobs(ids []string) (items []Item, orphans []string, err error) {
+	s.blobsMu.Lock()
+	defer s.blobsMu.Unlock()
+
+	var size int64
+	err = s.update(func(tx *badger.Txn) error {
+		items, orphans, size = nil, nil, 0
+
+		for _, id := range ids {
+			i, orphan, orphanSize, err := s.txUnlinkBlob(tx, id)
+			if errors.Is(err, badgerhold.ErrNotFound) {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			items = append(items, i)
+			if orphan != "" {
+				orphans = append(orphans, orphan)
+				size += orphanSize
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.usage.Add(-size)
+	return
+}
+
+// txUnlinkBlob deletes an Item and drops its blob reference within the
+// transaction. The blob's name and size are returned if it became an orphan.
+func (s *Store) txUnlinkBlob(tx *badger.Txn, id string) (i Item, orphan string, size int64, err error) {
+	// The Item is fetched again, as it might have been linked meanwhile.
+	if err = s.bh.TxGet(tx, id, &i); err != nil {
+		return
+	}
+	if err = s.bh.TxDelete(tx, id, Item{}); err != nil {
+		return
+	}
+
+	var b blob
+	err = s.bh.TxGet(tx, blobName(i), &b)
+	if errors.Is(err, badgerhold.ErrNotFound) {
+		// An Item being inserted owns its file without a blob.
+		return i, blobName(i), 0, nil
+	} else if err != nil {
+		return
+	}
+
+	b.Refs--
+	if b.Refs > 0 {
+		return i, "", 0, s.bh.TxUpdate(tx, b.Name, b)
+	}
+	return i, b.Name, b.Size, s.bh.TxDelete(tx, b.Name, blob{})
+}
+
+// ErrReconcileUnsupported is returned by Store.Reconcile if its Backend
+// cannot list its files.
+var ErrReconcileUnsupported = errors.New("Backend does not support listing its files")
+
+// Reconcile deletes files without a blob, blobs without a file, and Items
+// without a blob. It must not run concurrently to Put.
+func (s *Store) Reconcile() error {
+	lb, ok := s.backend.(listingBackend)
+	if !ok {
+		return ErrReconcileUnsupported
+	}
+
+	s.blobsMu.Lock()
+	defer s.blobsMu.Unlock()
+
+	names, err := lb.List()
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string]struct{}, len(names))
+	var orphanedFiles int
+	for _, name := range names {
+		err := s.bh.Get(name, &blob{})
+		if err == nil {
+			files[name] = struct{}{}
+			continue
+		} else if !errors.Is(err, badgerhold.ErrNotFound) {
+			return err
+		}
+
+		slog.Debug("Deleting file without a blob", slog.String("id", name))
+		if err := s.backend.Delete(name); err != nil {
+			return err
+		}
+		err = s.bh.Delete(name, pendingDeletion{})
+		if err != nil && !errors.Is(err, badgerhold.ErrNotFound) {
+			return err
+		}
+		orphanedFiles++
+	}
+
+	var blobs []blob
+	err = s.bh.Find(&blobs, nil)
+	if err != nil {
+		return err
+	}
+
+	var missingFiles int
+	for _, b := range blobs {
+		if _, ok := files[b.Name]; ok {
+			continue
+		}
+
+		slog.Debug("Deleting blob with a missing file", slog.String("id", b.Name))
+		if err := s.bh.Delete(b.Name, blob{}); err != nil {
+			return err
+		}
+		s.usage.Add(-b.Size)
+		missingFiles++
+	}
+
+	var items []Item
+	err = s.bh.Find(&items, badgerhold.Where("Blob").Ne(""))
+	if err != nil {
+		return err
+	}
+
+	var orphanedItems []Item
+	for _, i := range items {
+		err := s.bh.Get(i.Blob, &blob{})
+		if err == nil {
+			continue
+		} else if !errors.Is(err, badgerhold.ErrNotFound) {
+			return err
+		}
+
+		slog.Debug("Deleting Item without a blob", slog.String("id", i.ID))
+		if err := s.bh.Delete(i.ID, Item{}); err != nil {
+			return err
+		}
+		orphanedItems = append(orphanedItems, i)
+	}
+
+	slog.Info("Reconciled the Store's files with its database",
+		slog.Int("orphaned_files", orphanedFiles),
+		slog.Int("missing_files", missingFiles),
+		slog.Int("orphaned_items", len(orphanedItems)))
+
+	if s.deletionHook != nil {
+		for _, i := range orphanedItems {
+			s.deletionHook(ItemDeleted, i)
+		}
+	}
+	return nil
+}
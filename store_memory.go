@@ -0,0 +1,597 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Storer keeping both the Items and their files in memory.
+//
+// Everything is lost on shutdown. Thus, it is suited for tests and tiny or
+// ephemeral deployments. As files are being kept in memory, the configured
+// maximum file size should be small.
+type MemoryStore struct {
+	mu    sync.Mutex
+	items map[string]Item
+	blobs map[string][]byte
+
+	uploads map[string]*memoryUpload
+
+	idGenerator func() (string, error)
+
+	deletionHook func(ItemEvent, Item)
+
+	cleanup         bool
+	cleanupInterval time.Duration
+	stopSyn         chan struct{}
+	stopAck         chan struct{}
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore(idGenerator func() (string, error), opts StoreOpts) *MemoryStore {
+	s := &MemoryStore{
+		items:        make(map[string]Item),
+		blobs:        make(map[string][]byte),
+		uploads:      make(map[string]*memoryUpload),
+		idGenerator:  idGenerator,
+		deletionHook: opts.DeletionHook,
+		cleanup:      opts.AutoCleanup,
+
+		cleanupInterval: opts.CleanupInterval,
+	}
+
+	slog.Info("Opening in-memory Store")
+
+	if s.cleanup && s.cleanupInterval > 0 {
+		s.stopSyn = make(chan struct{})
+		s.stopAck = make(chan struct{})
+
+		go s.cleanupExired()
+	}
+
+	return s
+}
+
+// cleanupExired runs in a background goroutine to clean up expired Items.
+func (s *MemoryStore) cleanupExired() {
+	var ticker = time.NewTicker(s.cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopSyn:
+			close(s.stopAck)
+			return
+
+		case <-ticker.C:
+			if err := s.deleteExpired(); err != nil {
+				slog.Error("Deletion of expired Items failed", slog.Any("error", err))
+			}
+			s.deleteExpiredUploads()
+		}
+	}
+}
+
+// createID creates an unused ID for a new Item. The mutex must be held.
+func (s *MemoryStore) createID() (string, error) {
+	for i := 0; i < 32; i++ {
+		id, err := s.idGenerator()
+		if err != nil {
+			return "", err
+		}
+
+		if _, ok := s.items[id]; !ok {
+			return id, nil
+		}
+	}
+
+	return "", errors.New("failed to calculate a free ID")
+}
+
+// Close the MemoryStore and drop all of its Items.
+func (s *MemoryStore) Close() error {
+	slog.Info("Closing in-memory Store")
+
+	if s.stopSyn != nil {
+		close(s.stopSyn)
+		<-s.stopAck
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items = make(map[string]Item)
+	s.blobs = make(map[string][]byte)
+	s.uploads = make(map[string]*memoryUpload)
+
+	return nil
+}
+
+// Get an Item by its ID. The Item's file can be accessed with GetFile.
+func (s *MemoryStore) Get(id string) (Item, error) {
+	slog.Debug("Requested Item from Store", slog.String("id", id))
+
+	s.mu.Lock()
+	i, ok := s.items[id]
+	s.mu.Unlock()
+
+	if !ok {
+		slog.Debug("Requested Item was not found", slog.String("id", id))
+		return Item{}, ErrNotFound
+	}
+
+	if s.cleanup && i.expired(time.Now()) {
+		slog.Info("Requested Item is expired, will be deleted",
+			slog.String("id", id), slog.Any("expires", i.Expires))
+
+		_ = s.delete(id, ItemExpired)
+		return Item{}, ErrNotFound
+	}
+
+	return i, nil
+}
+
+// GetFile returns the reading end of a pipe for a stored Item's file.
+//
+// As the file must be passed as a FD to another process, there is no way
+// around an *os.File. A background goroutine writes the file into the pipe.
+func (s *MemoryStore) GetFile(id string) (*os.File, error) {
+	s.mu.Lock()
+	blob, ok := s.blobs[id]
+	s.mu.Unlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		// An error happens if the reading end was closed early.
+		_, _ = io.Copy(w, bytes.NewReader(blob))
+		_ = w.Close()
+	}()
+
+	return r, nil
+}
+
+// Put a new Item and its file, which will be closed afterwards, inside the
+// MemoryStore. The file's SHA-256 hash is stored as the Item's ContentHash.
+//
+// If the Item's Size is set and the file's size differs, ErrIncompleteFile is
+// returned and nothing is stored. The same applies to ErrUploadChecksum for a
+// mismatching UploadChecksum.
+func (s *MemoryStore) Put(i Item, file io.ReadCloser) (id string, err error) {
+	slog.Debug("Requested insertion of Item into the Store")
+
+	uploadChecksum := i.UploadChecksum
+	i.UploadChecksum = Checksum{}
+
+	uploadHasher, err := newUploadHasher(uploadChecksum)
+	if err != nil {
+		_ = file.Close()
+		return
+	}
+
+	blob, err := io.ReadAll(file)
+	_ = file.Close()
+	if err != nil {
+		return
+	}
+
+	if i.Size > 0 && int64(len(blob)) != i.Size {
+		err = fmt.Errorf("%w: %d of %d bytes", ErrIncompleteFile, len(blob), i.Size)
+		return
+	}
+
+	if uploadHasher != nil {
+		_, _ = uploadHasher.Write(blob)
+		err = checkUploadChecksum(uploadChecksum, uploadHasher)
+		if err != nil {
+			return
+		}
+	}
+
+	i.Size = int64(len(blob))
+	hash := sha256.Sum256(blob)
+	i.ContentHash = hex.EncodeToString(hash[:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, err = s.createID()
+	if err != nil {
+		slog.Error("Failed to create an ID for a new Item", slog.Any("error", err))
+		return
+	}
+
+	i.ID = id
+	s.items[id] = i
+	s.blobs[id] = blob
+
+	slog.Debug("Inserted Item with assigned ID", slog.String("id", id))
+	return
+}
+
+// UpdateSettings of an existing Item and return the updated Item.
+//
+// The Item's lifetime can only be shortened, otherwise ErrLifetimeExtended is
+// returned.
+func (s *MemoryStore) UpdateSettings(id string, settings ItemSettings) (Item, error) {
+	slog.Debug("Requested settings update of Item", slog.String("id", id))
+
+	if _, err := s.Get(id); err != nil {
+		return Item{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.items[id]
+	if !ok {
+		return Item{}, ErrNotFound
+	}
+
+	if err := settings.apply(&i); err != nil {
+		return Item{}, err
+	}
+
+	s.items[id] = i
+	return i, nil
+}
+
+// updateItem atomically modifies an Item by f and returns the updated Item.
+// If f returns an error, the Item is left unchanged.
+func (s *MemoryStore) updateItem(id string, f func(*Item) error) (Item, error) {
+	if _, err := s.Get(id); err != nil {
+		return Item{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.items[id]
+	if !ok {
+		return Item{}, ErrNotFound
+	}
+
+	if err := f(&i); err != nil {
+		return Item{}, err
+	}
+
+	s.items[id] = i
+	return i, nil
+}
+
+// RecordBurnAttempt counts a failed download of an Item.
+func (s *MemoryStore) RecordBurnAttempt(id string) (Item, error) {
+	return s.updateItem(id, func(i *Item) error {
+		i.recordBurnAttempt(time.Now())
+		return nil
+	})
+}
+
+// RecordDownload counts a served download of an Item.
+func (s *MemoryStore) RecordDownload(id string) error {
+	_, err := s.updateItem(id, func(i *Item) error {
+		i.recordDownload(time.Now())
+		return nil
+	})
+	return err
+}
+
+// Touch updates the Item's LastAccess, unless it was updated within the last
+// TouchInterval.
+func (s *MemoryStore) Touch(id string) error {
+	_, err := s.updateItem(id, func(i *Item) error {
+		i.touch(time.Now())
+		return nil
+	})
+	return err
+}
+
+// ReserveDownload counts a download of an Item.
+func (s *MemoryStore) ReserveDownload(id string) (Item, error) {
+	return s.updateItem(id, func(i *Item) error {
+		return i.reserveDownload(time.Now())
+	})
+}
+
+// ReleaseDownload reverts a reserved download of an Item.
+func (s *MemoryStore) ReleaseDownload(id string) error {
+	_, err := s.updateItem(id, func(i *Item) error {
+		i.releaseDownload()
+		return nil
+	})
+	return err
+}
+
+// FindByContentType returns all unexpired Items of this Content-Type.
+func (s *MemoryStore) FindByContentType(contentType string) ([]Item, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var items []Item
+	for _, i := range s.items {
+		if i.ContentType == contentType && !i.expired(now) {
+			items = append(items, i)
+		}
+	}
+	return items, nil
+}
+
+// Stats returns the number of Items and the total size of their files.
+func (s *MemoryStore) Stats() (StoreStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := StoreStats{Items: len(s.items)}
+	for _, blob := range s.blobs {
+		stats.Bytes += int64(len(blob))
+	}
+	return stats, nil
+}
+
+// List returns Items as described by the ListQuery.
+func (s *MemoryStore) List(query ListQuery) ([]Item, error) {
+	s.mu.Lock()
+	items := make([]Item, 0, len(s.items))
+	for _, i := range s.items {
+		if !query.NeverDownloaded || i.Downloads == 0 {
+			items = append(items, i)
+		}
+	}
+	s.mu.Unlock()
+
+	byCreated := func(a, b Item) bool {
+		if !a.Created.Equal(b.Created) {
+			return a.Created.Before(b.Created)
+		}
+		return a.ID < b.ID
+	}
+
+	sort.Slice(items, func(a, b int) bool {
+		switch query.Order {
+		case ListByDownloads:
+			if items[a].Downloads != items[b].Downloads {
+				return items[a].Downloads > items[b].Downloads
+			}
+			return byCreated(items[b], items[a])
+		case ListByLastAccess:
+			if !items[a].LastAccess.Equal(items[b].LastAccess) {
+				return items[a].LastAccess.Before(items[b].LastAccess)
+			}
+		}
+		return byCreated(items[a], items[b])
+	})
+
+	if query.Offset >= len(items) {
+		return nil, nil
+	}
+	items = items[query.Offset:]
+	if query.Limit < len(items) {
+		items = items[:query.Limit]
+	}
+	return items, nil
+}
+
+// deleteExpired checks the MemoryStore for expired Items and deletes them.
+func (s *MemoryStore) deleteExpired() error {
+	now := time.Now()
+
+	var ids []string
+	s.mu.Lock()
+	for id, i := range s.items {
+		if i.expired(now) {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, id := range ids {
+		slog.Debug("Delete expired Item", slog.String("id", id))
+		err := s.delete(id, ItemExpired)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete an Item and its file.
+func (s *MemoryStore) Delete(id string) error {
+	return s.delete(id, ItemDeleted)
+}
+
+// Rollback deletes a newly put Item without calling the deletion hook.
+func (s *MemoryStore) Rollback(id string) error {
+	return s.delete(id, "")
+}
+
+// delete an Item and call the deletion hook with the given event afterwards.
+// An empty event, used by Rollback, does not call the hook.
+func (s *MemoryStore) delete(id string, event ItemEvent) error {
+	slog.Debug("Requested deletion of Item", slog.String("id", id))
+
+	s.mu.Lock()
+	item, ok := s.items[id]
+	delete(s.items, id)
+	delete(s.blobs, id)
+	s.mu.Unlock()
+
+	if !ok {
+		return ErrNotFound
+	}
+
+	if s.deletionHook != nil && event != "" {
+		s.deletionHook(event, item)
+	}
+
+	return nil
+}
+
+// memoryUpload is an Upload staged in the MemoryStore.
+type memoryUpload struct {
+	Upload
+	data []byte
+	// busy is set while being modified without holding the mutex.
+	busy bool
+}
+
+// getUpload returns an unexpired and idle Upload. The mutex must be held.
+func (s *MemoryStore) getUpload(token string) (*memoryUpload, error) {
+	u, ok := s.uploads[token]
+	if !ok || u.Expires.Before(time.Now()) {
+		return nil, ErrNotFound
+	} else if u.busy {
+		return nil, ErrUploadBusy
+	}
+	return u, nil
+}
+
+// CreateUpload stages a new Upload of an Item's file of the given length and
+// returns its token. It must be completed before it expires.
+func (s *MemoryStore) CreateUpload(i Item, length int64, expires time.Time) (string, error) {
+	token, err := newUploadToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.uploads[token] = &memoryUpload{
+		Upload: Upload{Token: token, Item: i, Length: length, Expires: expires.UTC()},
+	}
+	return token, nil
+}
+
+// GetUpload returns an Upload by its token, including its Head.
+func (s *MemoryStore) GetUpload(token string) (Upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.uploads[token]
+	if !ok || u.Expires.Before(time.Now()) {
+		return Upload{}, ErrNotFound
+	}
+
+	upload := u.Upload
+	upload.Head = bytes.Clone(u.data[:min(len(u.data), sniffLen)])
+	return upload, nil
+}
+
+// AppendUpload appends the file's data to an Upload at the offset, which must
+// be its current Offset. The file will be closed.
+func (s *MemoryStore) AppendUpload(token string, offset int64, file io.ReadCloser) (int64, error) {
+	defer file.Close()
+
+	s.mu.Lock()
+	u, err := s.getUpload(token)
+	if err != nil {
+		s.mu.Unlock()
+		return 0, err
+	} else if offset != u.Offset {
+		s.mu.Unlock()
+		return u.Offset, ErrUploadOffset
+	}
+	u.busy = true
+	remaining := u.Length - u.Offset
+	s.mu.Unlock()
+
+	data, err := io.ReadAll(io.LimitReader(file, remaining))
+	if err == nil {
+		if extra, _ := file.Read(make([]byte, 1)); extra > 0 {
+			data, err = nil, ErrUploadLength
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u.busy = false
+	u.data = append(u.data, data...)
+	u.Offset += int64(len(data))
+	return u.Offset, err
+}
+
+// CompleteUpload puts the file of a completely received Upload as the given
+// Item, optionally without its metadata, and returns the new ID.
+func (s *MemoryStore) CompleteUpload(token string, i Item, strip bool) (string, error) {
+	s.mu.Lock()
+	u, err := s.getUpload(token)
+	if err == nil && u.Offset != u.Length {
+		err = ErrUploadOffset
+	}
+	if err != nil {
+		s.mu.Unlock()
+		return "", err
+	}
+	u.busy = true
+	s.mu.Unlock()
+
+	data := u.data
+	if strip && canStripMetadata(i.ContentType) {
+		var stripped bytes.Buffer
+		if err := stripMetadata(&stripped, bytes.NewReader(data), i.ContentType); err != nil {
+			slog.Warn("Failed to strip metadata, keeping the original file",
+				slog.String("mime", i.ContentType), slog.Any("error", err))
+		} else {
+			data = stripped.Bytes()
+		}
+	}
+
+	i.Size = int64(len(data))
+	id, err := s.Put(i, io.NopCloser(bytes.NewReader(data)))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u.busy = false
+	if err != nil {
+		return "", err
+	}
+	delete(s.uploads, token)
+	return id, nil
+}
+
+// DeleteUpload drops an Upload and its staged file.
+func (s *MemoryStore) DeleteUpload(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.getUpload(token); err != nil {
+		return err
+	}
+	delete(s.uploads, token)
+	return nil
+}
+
+// deleteExpiredUploads drops all idle Uploads which were not completed in time.
+func (s *MemoryStore) deleteExpiredUploads() {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for token, u := range s.uploads {
+		if !u.busy && u.Expires.Before(now) {
+			delete(s.uploads, token)
+		}
+	}
+}
@@ -2,18 +2,24 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/rpc"
 	"os"
-	"strings"
 	"sync"
 	"time"
-
-	"golang.org/x/sys/unix"
 )
 
+// ErrStoreBusy is returned by StoreRpcServer.GetFile if the limit of
+// concurrent FD transfers is reached.
+var ErrStoreBusy = errors.New("Store is busy, too many concurrent file transfers")
+
+// DefaultRpcTimeout is the StoreRpcClient's default timeout for each request.
+const DefaultRpcTimeout = 3 * time.Second
+
 // unixConnFromFile converts a file (FD) into an Unix domain socket.
 func unixConnFromFile(f *os.File) (*net.UnixConn, error) {
 	fConn, err := net.FileConn(f)
@@ -28,38 +34,6 @@ func unixConnFromFile(f *os.File) (*net.UnixConn, error) {
 	return conn, nil
 }
 
-// sendFd sends an open File (resp. its FD) over an Unix domain socket.
-func sendFd(f *os.File, conn *net.UnixConn) error {
-	oob := unix.UnixRights(int(f.Fd()))
-	_, _, err := conn.WriteMsgUnix(nil, oob, nil)
-	return err
-}
-
-// recvFd receives a File (resp. its FD) from an Unix domain socket.
-func recvFd(conn *net.UnixConn) (*os.File, error) {
-	oob := make([]byte, 128)
-	_, oobn, _, _, err := conn.ReadMsgUnix(nil, oob)
-	if err != nil {
-		return nil, err
-	}
-
-	cmsgs, err := unix.ParseSocketControlMessage(oob[0:oobn])
-	if err != nil {
-		return nil, err
-	} else if len(cmsgs) != 1 {
-		return nil, fmt.Errorf("ParseSocketControlMessage: wrong length %d", len(cmsgs))
-	}
-
-	fds, err := unix.ParseUnixRights(&cmsgs[0])
-	if err != nil {
-		return nil, err
-	} else if len(fds) != 1 {
-		return nil, fmt.Errorf("ParseUnixRights: wrong length %d", len(fds))
-	}
-
-	return os.NewFile(uintptr(fds[0]), ""), nil
-}
-
 // StoreRpcServer serves a Store over a net/rpc with two connections, one for
 // the actual RPC calls (HTTP) and one to pass file descriptors (FDs).
 //
@@ -69,22 +43,34 @@ func recvFd(conn *net.UnixConn) (*os.File, error) {
 type StoreRpcServer struct {
 	rpcConn *net.UnixConn
 	fdConn  *net.UnixConn
+	fds     *fdMux
 
-	store     *Store
+	store     Storer
 	rpcServer *rpc.Server
+
+	// fdTransfers is a semaphore to limit concurrent FD transfers, or nil.
+	fdTransfers chan struct{}
 }
 
 // NewStoreRpcServer creates a StoreRpcServer which directly starts listening
 // until Close is called.
-func NewStoreRpcServer(store *Store, rpcConn, fdConn *net.UnixConn) *StoreRpcServer {
+//
+// The maxFdTransfers limits the concurrent GetFile calls, as each one holds an
+// open file to be sent. A value of zero disables this limit.
+func NewStoreRpcServer(store Storer, rpcConn, fdConn *net.UnixConn, maxFdTransfers int) *StoreRpcServer {
 	server := &StoreRpcServer{
 		rpcConn: rpcConn,
 		fdConn:  fdConn,
+		fds:     newFdMux(fdConn),
 
 		store:     store,
 		rpcServer: rpc.NewServer(),
 	}
 
+	if maxFdTransfers > 0 {
+		server.fdTransfers = make(chan struct{}, maxFdTransfers)
+	}
+
 	_ = server.rpcServer.Register(server)
 	go server.rpcServer.ServeConn(rpcConn)
 
@@ -106,26 +92,104 @@ func (server *StoreRpcServer) Close() error {
 type StoreRpcClient struct {
 	rpcClient *rpc.Client
 	fdConn    *net.UnixConn
+	fds       *fdMux
+
+	timeout time.Duration
+	opts    StoreRpcClientOpts
+
+	// stats are optional, enabled by EnableStats.
+	stats *RpcStats
+}
+
+// StoreRpcClientOpts configure the timeouts of a StoreRpcClient's requests.
+//
+// Each zero timeout falls back to the Timeout, which itself defaults to the
+// DefaultRpcTimeout. For example, a huge Put over a slow disk might require a
+// longer PutTimeout, while a Get should fail fast.
+type StoreRpcClientOpts struct {
+	Timeout time.Duration
+
+	// GetTimeout is used for both Get and GetFile, PutTimeout also for
+	// AppendUpload and CompleteUpload.
+	GetTimeout    time.Duration
+	PutTimeout    time.Duration
+	DeleteTimeout time.Duration
 }
 
 // NewStoreRpcClient creates a StoreRpcClient.
-func NewStoreRpcClient(rpcConn, fdConn *net.UnixConn) *StoreRpcClient {
+func NewStoreRpcClient(rpcConn, fdConn *net.UnixConn, opts StoreRpcClientOpts) *StoreRpcClient {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultRpcTimeout
+	}
+
 	return &StoreRpcClient{
 		rpcClient: rpc.NewClient(rpcConn),
 		fdConn:    fdConn,
+		fds:       newFdMux(fdConn),
+		timeout:   timeout,
+		opts:      opts,
+	}
+}
+
+// timeoutFor returns the timeout of a method's request.
+func (client *StoreRpcClient) timeoutFor(method string) time.Duration {
+	var timeout time.Duration
+	switch method {
+	case "Get", "GetFile":
+		timeout = client.opts.GetTimeout
+	case "Put", "AppendUpload", "CompleteUpload":
+		timeout = client.opts.PutTimeout
+	case "Delete", "Rollback":
+		timeout = client.opts.DeleteTimeout
 	}
+
+	if timeout <= 0 {
+		return client.timeout
+	}
+	return timeout
+}
+
+// SetTimeout changes the fallback timeout for each request without its own
+// timeout from the StoreRpcClientOpts. It must be called before the client is
+// being used.
+//
+// A timed out request results in an error wrapping context.DeadlineExceeded.
+func (client *StoreRpcClient) SetTimeout(timeout time.Duration) {
+	client.timeout = timeout
+}
+
+// EnableStats starts collecting RpcStats for this client's operations. It
+// must be called before the client is being used.
+func (client *StoreRpcClient) EnableStats() *RpcStats {
+	client.stats = NewRpcStats()
+	return client.stats
 }
 
 // call the net/rpc function with a timeout context.
-func (client *StoreRpcClient) call(method string, args interface{}, reply interface{}, ctx context.Context) error {
-	timeout, timeoutCancel := context.WithTimeout(ctx, 3*time.Second)
+//
+// If the context carries a traceSpan, the call is traced as its child. Then,
+// tracedArgs pass the call's span to the server.
+func (client *StoreRpcClient) call(method string, args interface{}, reply interface{}, ctx context.Context) (err error) {
+	done := client.observe(method)
+	defer func() { done(err) }()
+
+	if span := spanFromContext(ctx).child("rpc " + method); span != nil {
+		defer func() { span.end(err) }()
+
+		if traced, ok := args.(tracedArgs); ok {
+			traced.setTrace(span.traceparent())
+		}
+	}
+
+	timeout, timeoutCancel := context.WithTimeout(ctx, client.timeoutFor(method))
 	defer timeoutCancel()
 
 	call := client.rpcClient.Go("StoreRpcServer."+method, args, reply, nil)
 
 	select {
 	case <-timeout.Done():
-		return ctx.Err()
+		return timeout.Err()
 
 	case reply := <-call.Done:
 		return reply.Error
@@ -140,9 +204,20 @@ func (client *StoreRpcClient) Close() error {
 	return nil
 }
 
+// GetArgs are the arguments of the Get RPC method.
+type GetArgs struct {
+	ID    string
+	Trace string
+}
+
+func (args *GetArgs) setTrace(traceparent string) { args.Trace = traceparent }
+
 // Get wraps Store.Get and returns an Item for the requested ID.
-func (server *StoreRpcServer) Get(id string, item *Item) error {
-	i, err := server.store.Get(id)
+func (server *StoreRpcServer) Get(args GetArgs, item *Item) (err error) {
+	span := remoteTraceSpan("store Get", args.Trace)
+	defer func() { span.end(err) }()
+
+	i, err := server.store.Get(args.ID)
 	if err != nil {
 		return err
 	}
@@ -153,7 +228,7 @@ func (server *StoreRpcServer) Get(id string, item *Item) error {
 // Get an Item by its ID from the server.
 func (client *StoreRpcClient) Get(id string, ctx context.Context) (Item, error) {
 	var item Item
-	err := client.call("Get", id, &item, ctx)
+	err := client.call("Get", &GetArgs{ID: id}, &item, ctx)
 
 	// The original error type gets lost..
 	if err != nil && err.Error() == "No Item found for this ID" {
@@ -163,15 +238,41 @@ func (client *StoreRpcClient) Get(id string, ctx context.Context) (Item, error)
 	return item, err
 }
 
-// GetFile wraps Store.GetFile and sends a FD for the file back.
-func (server *StoreRpcServer) GetFile(id string, _ *int) error {
-	f, err := server.store.GetFile(id)
+// GetFileArgs are the arguments of the GetFile RPC method. The Tag identifies
+// the FD transfer, allowing concurrent GetFile calls.
+type GetFileArgs struct {
+	ID    string
+	Tag   uint64
+	Trace string
+}
+
+func (args *GetFileArgs) setTrace(traceparent string) { args.Trace = traceparent }
+
+// GetFile wraps Store.GetFile and sends a FD for the file back, tagged by the
+// args.
+//
+// If too many files are already being transferred, ErrStoreBusy is returned.
+func (server *StoreRpcServer) GetFile(args GetFileArgs, _ *int) (err error) {
+	span := remoteTraceSpan("store GetFile", args.Trace)
+	defer func() { span.end(err) }()
+
+	if server.fdTransfers != nil {
+		select {
+		case server.fdTransfers <- struct{}{}:
+			defer func() { <-server.fdTransfers }()
+
+		default:
+			return ErrStoreBusy
+		}
+	}
+
+	f, err := server.store.GetFile(args.ID)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	err = sendFd(f, server.fdConn)
+	err = server.fds.send(f, args.Tag)
 	if err != nil {
 		return err
 	}
@@ -181,27 +282,60 @@ func (server *StoreRpcServer) GetFile(id string, _ *int) error {
 
 // GetFile returns an *os.File for the requested ID from the server.
 func (client *StoreRpcClient) GetFile(id string, ctx context.Context) (*os.File, error) {
-	err := client.call("GetFile", id, nil, ctx)
-	if err != nil {
+	tag := client.fds.nextTag()
+
+	err := client.call("GetFile", &GetFileArgs{ID: id, Tag: tag}, nil, ctx)
+	if err != nil && err.Error() == ErrStoreBusy.Error() {
+		return nil, ErrStoreBusy
+	} else if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		// The server might still send the FD after the client gave up.
+		client.fds.abandon(tag)
+		return nil, err
+	} else if err != nil {
 		return nil, err
 	}
 
-	return recvFd(client.fdConn)
+	// The server has sent the FD before answering the call.
+	done := client.observe("GetFile/fd")
+	f, err := client.fds.recv(tag, client.timeoutFor("GetFile"))
+	done(err)
+
+	return f, err
+}
+
+// PutArgs are the arguments of the Put RPC method. The Tag identifies the FD
+// transfer of the file's pipe, allowing concurrent Put calls.
+type PutArgs struct {
+	Item  Item
+	Tag   uint64
+	Trace string
 }
 
+func (args *PutArgs) setTrace(traceparent string) { args.Trace = traceparent }
+
 // Put wraps Store.Put but reads the input data from a pipe2(2).
 //
 // Honestly speaking, the pipe2 part is one of my most favourite hacks as the
 // StoreRpcClient creates a new pipe - which are just two FDs - and passes the
 // reading end over the Unix domain socket to the server to be read into the DB.
-func (server *StoreRpcServer) Put(item Item, id *string) error {
-	fd, err := recvFd(server.fdConn)
+func (server *StoreRpcServer) Put(args PutArgs, id *string) (err error) {
+	span := remoteTraceSpan("store Put", args.Trace)
+	defer func() { span.end(err) }()
+
+	fd, err := server.fds.recv(args.Tag, DefaultRpcTimeout)
 	if err != nil {
 		return err
 	}
 
-	itemId, err := server.store.Put(item, fd)
-	if err != nil {
+	itemId, err := server.store.Put(args.Item, fd)
+	if errors.Is(err, ErrIncompleteFile) {
+		// Strip details to allow the StoreRpcClient to identify this error.
+		return ErrIncompleteFile
+	} else if errors.Is(err, ErrUploadChecksum) {
+		return ErrUploadChecksum
+	} else if errors.Is(err, ErrStoreFull) {
+		return ErrStoreFull
+	} else if err != nil {
 		return err
 	}
 	*id = itemId
@@ -209,19 +343,22 @@ func (server *StoreRpcServer) Put(item Item, id *string) error {
 	return nil
 }
 
-// Put a new Item and its data into the server's storage and return the new ID.
-func (client *StoreRpcClient) Put(item Item, file io.ReadCloser, ctx context.Context) (string, error) {
+// stream the file through a new pipe2(2) to the server, whose reading end is
+// sent as a tagged FD along the method's call. All errors are returned, e.g.,
+// of the call itself, of sending the FD, or of reading the file.
+func (client *StoreRpcClient) stream(method string, file io.ReadCloser, args func(tag uint64) interface{}, reply interface{}, ctx context.Context) []error {
 	var (
-		wg     sync.WaitGroup
-		itemId string
-		errs   []interface{}
+		wg   sync.WaitGroup
+		errs []error
 	)
 
 	dataReader, dataWriter, err := pipe2()
 	if err != nil {
-		return "", err
+		return []error{err}
 	}
 
+	tag := client.fds.nextTag()
+
 	const producers = 3
 	errChan := make(chan error, producers)
 	finChan := make(chan struct{})
@@ -229,21 +366,21 @@ func (client *StoreRpcClient) Put(item Item, file io.ReadCloser, ctx context.Con
 
 	go func() {
 		_, err := io.Copy(dataWriter, file)
-		err2 := dataWriter.Close()
-		if err != nil || err2 != nil {
-			errChan <- fmt.Errorf("%v %v", err, err2)
-		}
-		errChan <- nil
+		errChan <- errors.Join(err, dataWriter.Close())
 		wg.Done()
 	}()
 
 	go func() {
-		errChan <- sendFd(dataReader, client.fdConn)
+		done := client.observe(method + "/fd")
+		err := client.fds.send(dataReader, tag)
+		done(err)
+
+		errChan <- err
 		wg.Done()
 	}()
 
 	go func() {
-		errChan <- client.call("Put", item, &itemId, ctx)
+		errChan <- client.call(method, args(tag), reply, ctx)
 		wg.Done()
 	}()
 
@@ -255,7 +392,7 @@ func (client *StoreRpcClient) Put(item Item, file io.ReadCloser, ctx context.Con
 		close(finChan)
 	}()
 
-	timeout, timeoutCancel := context.WithTimeout(ctx, 3*time.Second)
+	timeout, timeoutCancel := context.WithTimeout(ctx, client.timeoutFor(method))
 	defer timeoutCancel()
 
 	select {
@@ -267,25 +404,393 @@ func (client *StoreRpcClient) Put(item Item, file io.ReadCloser, ctx context.Con
 	}
 
 	for i := 0; i < producers; i++ {
-		err := <-errChan
-		if err != nil {
+		if err := <-errChan; err != nil {
 			errs = append(errs, err)
 		}
 	}
+	return errs
+}
+
+// rpcSentinel returns the sentinel error matching err, either wrapped or by
+// its message as the original error type gets lost over RPC. Otherwise, err
+// is returned unaltered.
+func rpcSentinel(err error, sentinels ...error) error {
+	if err == nil {
+		return nil
+	}
+	for _, sentinel := range sentinels {
+		if errors.Is(err, sentinel) || err.Error() == sentinel.Error() {
+			return sentinel
+		}
+	}
+	return err
+}
+
+// Put a new Item and its data into the server's storage and return the new ID.
+func (client *StoreRpcClient) Put(item Item, file io.ReadCloser, ctx context.Context) (string, error) {
+	var (
+		itemId string
+		errs   []error
+	)
+
+	putArgs := func(tag uint64) interface{} { return &PutArgs{Item: item, Tag: tag} }
+	for _, err := range client.stream("Put", file, putArgs, &itemId, ctx) {
+		if err.Error() == ErrIncompleteFile.Error() {
+			return "", ErrIncompleteFile
+		} else if err.Error() == ErrUploadChecksum.Error() {
+			return "", ErrUploadChecksum
+		} else if err.Error() == ErrStoreFull.Error() {
+			return "", ErrStoreFull
+		}
+		errs = append(errs, err)
+	}
 
 	if len(errs) > 0 {
-		return "", fmt.Errorf(strings.Repeat("%v ", len(errs)), errs...)
+		// The Store only sees the end of the file if reading it failed, e.g.,
+		// for a streamed upload exceeding its size. Thus, roll it back.
+		if itemId != "" {
+			if err := client.Rollback(itemId, context.Background()); err != nil {
+				slog.Error("Failed to delete Item of a failed insertion",
+					slog.String("id", itemId), slog.Any("error", err))
+			}
+		}
+		return "", errors.Join(errs...)
 	}
 
 	return itemId, nil
 }
 
+// UpdateSettingsArgs are the arguments of the UpdateSettings RPC method.
+type UpdateSettingsArgs struct {
+	ID       string
+	Settings ItemSettings
+}
+
+// UpdateSettings wraps Store.UpdateSettings.
+func (server *StoreRpcServer) UpdateSettings(args UpdateSettingsArgs, item *Item) error {
+	i, err := server.store.UpdateSettings(args.ID, args.Settings)
+	if err != nil {
+		return err
+	}
+	*item = i
+	return nil
+}
+
+// UpdateSettings of an Item on the server and return the updated Item.
+func (client *StoreRpcClient) UpdateSettings(id string, settings ItemSettings, ctx context.Context) (Item, error) {
+	var item Item
+	err := client.call("UpdateSettings", UpdateSettingsArgs{ID: id, Settings: settings}, &item, ctx)
+
+	if err != nil && err.Error() == ErrNotFound.Error() {
+		err = ErrNotFound
+	} else if err != nil && err.Error() == ErrLifetimeExtended.Error() {
+		err = ErrLifetimeExtended
+	}
+
+	return item, err
+}
+
+// RecordDownload wraps Store.RecordDownload.
+func (server *StoreRpcServer) RecordDownload(id string, _ *int) error {
+	return server.store.RecordDownload(id)
+}
+
+// RecordDownload counts a served download of an Item on the server.
+func (client *StoreRpcClient) RecordDownload(id string, ctx context.Context) error {
+	err := client.call("RecordDownload", id, nil, ctx)
+	if err != nil && err.Error() == ErrNotFound.Error() {
+		err = ErrNotFound
+	}
+	return err
+}
+
+// Touch wraps Store.Touch.
+func (server *StoreRpcServer) Touch(id string, _ *int) error {
+	return server.store.Touch(id)
+}
+
+// Touch records an access of an Item on the server.
+func (client *StoreRpcClient) Touch(id string, ctx context.Context) error {
+	err := client.call("Touch", id, nil, ctx)
+	if err != nil && err.Error() == ErrNotFound.Error() {
+		err = ErrNotFound
+	}
+	return err
+}
+
+// RecordBurnAttempt wraps Store.RecordBurnAttempt.
+func (server *StoreRpcServer) RecordBurnAttempt(id string, item *Item) error {
+	i, err := server.store.RecordBurnAttempt(id)
+	if err != nil {
+		return err
+	}
+	*item = i
+	return nil
+}
+
+// RecordBurnAttempt counts a failed download of an Item on the server and
+// returns the updated Item.
+func (client *StoreRpcClient) RecordBurnAttempt(id string, ctx context.Context) (Item, error) {
+	var item Item
+	err := client.call("RecordBurnAttempt", id, &item, ctx)
+	if err != nil && err.Error() == ErrNotFound.Error() {
+		err = ErrNotFound
+	}
+	return item, err
+}
+
+// ReserveDownload wraps Store.ReserveDownload.
+func (server *StoreRpcServer) ReserveDownload(id string, item *Item) error {
+	i, err := server.store.ReserveDownload(id)
+	if err != nil {
+		return err
+	}
+	*item = i
+	return nil
+}
+
+// ReserveDownload counts a download of an Item on the server before it is
+// served and returns the updated Item.
+func (client *StoreRpcClient) ReserveDownload(id string, ctx context.Context) (Item, error) {
+	var item Item
+	err := client.call("ReserveDownload", id, &item, ctx)
+	if err != nil && err.Error() == ErrNotFound.Error() {
+		err = ErrNotFound
+	} else if err != nil && err.Error() == ErrDownloadsExhausted.Error() {
+		err = ErrDownloadsExhausted
+	}
+	return item, err
+}
+
+// ReleaseDownload wraps Store.ReleaseDownload.
+func (server *StoreRpcServer) ReleaseDownload(id string, _ *int) error {
+	return server.store.ReleaseDownload(id)
+}
+
+// ReleaseDownload reverts a reserved download of an Item on the server.
+func (client *StoreRpcClient) ReleaseDownload(id string, ctx context.Context) error {
+	err := client.call("ReleaseDownload", id, nil, ctx)
+	if err != nil && err.Error() == ErrNotFound.Error() {
+		err = ErrNotFound
+	}
+	return err
+}
+
+// FindByContentType wraps Store.FindByContentType.
+func (server *StoreRpcServer) FindByContentType(contentType string, items *[]Item) error {
+	found, err := server.store.FindByContentType(contentType)
+	if err != nil {
+		return err
+	}
+	*items = found
+	return nil
+}
+
+// FindByContentType returns all unexpired Items of this Content-Type from the
+// server.
+func (client *StoreRpcClient) FindByContentType(contentType string, ctx context.Context) ([]Item, error) {
+	var items []Item
+	err := client.call("FindByContentType", contentType, &items, ctx)
+	return items, err
+}
+
+// DeleteArgs are the arguments of the Delete RPC method.
+type DeleteArgs struct {
+	ID    string
+	Trace string
+}
+
+func (args *DeleteArgs) setTrace(traceparent string) { args.Trace = traceparent }
+
 // Delete wraps Store.Delete.
-func (server *StoreRpcServer) Delete(id string, _ *int) error {
-	return server.store.Delete(id)
+func (server *StoreRpcServer) Delete(args DeleteArgs, _ *int) (err error) {
+	span := remoteTraceSpan("store Delete", args.Trace)
+	defer func() { span.end(err) }()
+
+	return server.store.Delete(args.ID)
 }
 
 // Delete both an Item as well as its file from the server.
 func (client *StoreRpcClient) Delete(id string, ctx context.Context) error {
-	return client.call("Delete", id, nil, ctx)
+	return client.call("Delete", &DeleteArgs{ID: id}, nil, ctx)
+}
+
+// Rollback wraps Store.Rollback.
+func (server *StoreRpcServer) Rollback(args DeleteArgs, _ *int) (err error) {
+	span := remoteTraceSpan("store Rollback", args.Trace)
+	defer func() { span.end(err) }()
+
+	return server.store.Rollback(args.ID)
+}
+
+// Rollback deletes a newly put Item of a failed upload from the server,
+// without calling its deletion hook.
+func (client *StoreRpcClient) Rollback(id string, ctx context.Context) error {
+	return client.call("Rollback", &DeleteArgs{ID: id}, nil, ctx)
+}
+
+// Stats wraps Store.Stats.
+func (server *StoreRpcServer) Stats(_ int, stats *StoreStats) error {
+	s, err := server.store.Stats()
+	if err != nil {
+		return err
+	}
+	*stats = s
+	return nil
+}
+
+// Stats returns the current number of Items and their files' total size from
+// the server.
+func (client *StoreRpcClient) Stats(ctx context.Context) (StoreStats, error) {
+	var stats StoreStats
+	err := client.call("Stats", 0, &stats, ctx)
+	return stats, err
+}
+
+// List wraps Store.List.
+func (server *StoreRpcServer) List(query ListQuery, items *[]Item) error {
+	found, err := server.store.List(query)
+	if err != nil {
+		return err
+	}
+	*items = found
+	return nil
+}
+
+// List returns Items as described by the ListQuery from the server.
+func (client *StoreRpcClient) List(query ListQuery, ctx context.Context) ([]Item, error) {
+	var items []Item
+	err := client.call("List", query, &items, ctx)
+	return items, err
+}
+
+// Ping is a no-op to check if the server is available.
+func (server *StoreRpcServer) Ping(_ int, _ *int) error {
+	return nil
+}
+
+// Ping the server to check if it is available and answers requests.
+func (client *StoreRpcClient) Ping(ctx context.Context) error {
+	return client.call("Ping", 0, nil, ctx)
+}
+
+// CreateUploadArgs are the arguments of the CreateUpload RPC method.
+type CreateUploadArgs struct {
+	Item    Item
+	Length  int64
+	Expires time.Time
+}
+
+// CreateUpload wraps Store.CreateUpload.
+func (server *StoreRpcServer) CreateUpload(args CreateUploadArgs, token *string) error {
+	t, err := server.store.CreateUpload(args.Item, args.Length, args.Expires)
+	if err != nil {
+		return err
+	}
+	*token = t
+	return nil
+}
+
+// CreateUpload stages a new Upload on the server and returns its token.
+func (client *StoreRpcClient) CreateUpload(item Item, length int64, expires time.Time, ctx context.Context) (string, error) {
+	var token string
+	err := client.call("CreateUpload", CreateUploadArgs{Item: item, Length: length, Expires: expires}, &token, ctx)
+	return token, err
+}
+
+// GetUpload wraps Store.GetUpload.
+func (server *StoreRpcServer) GetUpload(token string, upload *Upload) error {
+	u, err := server.store.GetUpload(token)
+	if err != nil {
+		return err
+	}
+	*upload = u
+	return nil
+}
+
+// GetUpload returns an Upload by its token from the server.
+func (client *StoreRpcClient) GetUpload(token string, ctx context.Context) (Upload, error) {
+	var upload Upload
+	err := client.call("GetUpload", token, &upload, ctx)
+	return upload, rpcSentinel(err, ErrNotFound)
+}
+
+// AppendUploadArgs are the arguments of the AppendUpload RPC method. The Tag
+// identifies the FD transfer of the data's pipe, as for PutArgs.
+type AppendUploadArgs struct {
+	Token  string
+	Offset int64
+	Tag    uint64
+}
+
+// AppendUpload wraps Store.AppendUpload but reads the data from a pipe2(2),
+// as Put does.
+func (server *StoreRpcServer) AppendUpload(args AppendUploadArgs, offset *int64) error {
+	fd, err := server.fds.recv(args.Tag, DefaultRpcTimeout)
+	if err != nil {
+		return err
+	}
+
+	o, err := server.store.AppendUpload(args.Token, args.Offset, fd)
+	if err != nil {
+		return rpcSentinel(err, ErrNotFound, ErrUploadOffset, ErrUploadBusy, ErrUploadLength)
+	}
+	*offset = o
+	return nil
+}
+
+// AppendUpload appends the data to an Upload on the server and returns its new
+// Offset. On errors, the Upload's Offset must be requested by GetUpload.
+func (client *StoreRpcClient) AppendUpload(token string, offset int64, file io.ReadCloser, ctx context.Context) (int64, error) {
+	var newOffset int64
+
+	appendArgs := func(tag uint64) interface{} {
+		return AppendUploadArgs{Token: token, Offset: offset, Tag: tag}
+	}
+	errs := client.stream("AppendUpload", file, appendArgs, &newOffset, ctx)
+	for _, err := range errs {
+		if sentinel := rpcSentinel(err, ErrNotFound, ErrUploadOffset, ErrUploadBusy, ErrUploadLength); sentinel != err {
+			return 0, sentinel
+		}
+	}
+	return newOffset, errors.Join(errs...)
+}
+
+// CompleteUploadArgs are the arguments of the CompleteUpload RPC method.
+type CompleteUploadArgs struct {
+	Token         string
+	Item          Item
+	StripMetadata bool
+}
+
+// CompleteUpload wraps Store.CompleteUpload.
+func (server *StoreRpcServer) CompleteUpload(args CompleteUploadArgs, id *string) error {
+	itemId, err := server.store.CompleteUpload(args.Token, args.Item, args.StripMetadata)
+	if err != nil {
+		return rpcSentinel(err, ErrNotFound, ErrUploadOffset, ErrUploadBusy,
+			ErrIncompleteFile, ErrUploadChecksum, ErrStoreFull)
+	}
+	*id = itemId
+	return nil
+}
+
+// CompleteUpload puts a completely received Upload as the Item on the server,
+// optionally stripping its metadata, and returns the new ID.
+func (client *StoreRpcClient) CompleteUpload(token string, item Item, stripMetadata bool, ctx context.Context) (string, error) {
+	var itemId string
+	args := CompleteUploadArgs{Token: token, Item: item, StripMetadata: stripMetadata}
+	err := client.call("CompleteUpload", args, &itemId, ctx)
+	return itemId, rpcSentinel(err, ErrNotFound, ErrUploadOffset, ErrUploadBusy,
+		ErrIncompleteFile, ErrUploadChecksum, ErrStoreFull)
+}
+
+// DeleteUpload wraps Store.DeleteUpload.
+func (server *StoreRpcServer) DeleteUpload(token string, _ *int) error {
+	return rpcSentinel(server.store.DeleteUpload(token), ErrNotFound, ErrUploadBusy)
+}
+
+// DeleteUpload drops an Upload on the server.
+func (client *StoreRpcClient) DeleteUpload(token string, ctx context.Context) error {
+	err := client.call("DeleteUpload", token, nil, ctx)
+	return rpcSentinel(err, ErrNotFound, ErrUploadBusy)
 }
@@ -4,13 +4,24 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"os"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
 
+// withoutBlob clears an Item's Blob, which depends on previously stored files
+// of the same content within a shared Store.
+func withoutBlob(i Item) Item {
+	i.Blob = ""
+	return i
+}
+
 // testStoreRpcSessionGet sets up a valid Item first, then tests Get.
 //
 // The logic is borrowed from store_test.go's TestStore.
@@ -24,12 +35,14 @@ func testStoreRpcSessionGet(t *testing.T, server *StoreRpcServer, client *StoreR
 		t.Error(err)
 	}
 	item.ID = itemId
+	item.ContentHash = sha256Hex(itemDataRaw)
+	item.Size = int64(len(itemDataRaw))
 
 	itemX, err := client.Get(itemId, context.Background())
 	if err != nil {
 		t.Error(err)
 	}
-	if !reflect.DeepEqual(item, itemX) {
+	if !reflect.DeepEqual(item, withoutBlob(itemX)) {
 		t.Errorf("Fetched Item mismatches: got %v and expected %v", itemX, item)
 	}
 }
@@ -47,12 +60,14 @@ func testStoreRpcSessionGetFile(t *testing.T, server *StoreRpcServer, client *St
 		t.Error(err)
 	}
 	item.ID = itemId
+	item.ContentHash = sha256Hex(itemDataRaw)
+	item.Size = int64(len(itemDataRaw))
 
 	itemX, err := client.Get(itemId, context.Background())
 	if err != nil {
 		t.Error(err)
 	}
-	if !reflect.DeepEqual(item, itemX) {
+	if !reflect.DeepEqual(item, withoutBlob(itemX)) {
 		t.Errorf("Fetched Item mismatches: got %v and expected %v", itemX, item)
 	}
 
@@ -93,12 +108,14 @@ func testStoreRpcSessionPut(size int) func(*testing.T, *StoreRpcServer, *StoreRp
 			t.Error(err)
 		}
 		item.ID = itemId
+		item.ContentHash = sha256Hex(itemDataRaw)
+		item.Size = int64(len(itemDataRaw))
 
 		itemX, err := client.Get(itemId, context.Background())
 		if err != nil {
 			t.Error(err)
 		}
-		if !reflect.DeepEqual(item, itemX) {
+		if !reflect.DeepEqual(item, withoutBlob(itemX)) {
 			t.Errorf("Fetched Item mismatches: got %v and expected %v", itemX, item)
 		}
 
@@ -133,12 +150,14 @@ func testStoreRpcSessionDelete(t *testing.T, server *StoreRpcServer, client *Sto
 		t.Error(err)
 	}
 	item.ID = itemId
+	item.ContentHash = sha256Hex(itemDataRaw)
+	item.Size = int64(len(itemDataRaw))
 
 	itemX, err := client.Get(itemId, context.Background())
 	if err != nil {
 		t.Error(err)
 	}
-	if !reflect.DeepEqual(item, itemX) {
+	if !reflect.DeepEqual(item, withoutBlob(itemX)) {
 		t.Errorf("Fetched Item mismatches: got %v and expected %v", itemX, item)
 	}
 
@@ -152,6 +171,269 @@ func testStoreRpcSessionDelete(t *testing.T, server *StoreRpcServer, client *Sto
 	}
 }
 
+// testStoreRpcSessionUpdateSettings tests updating an Item's settings.
+func testStoreRpcSessionUpdateSettings(t *testing.T, server *StoreRpcServer, client *StoreRpcClient) {
+	item := Item{Expires: time.Now().Add(time.Hour).UTC()}
+	itemId, err := server.store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shorter := time.Now().Add(time.Minute).UTC()
+	itemX, err := client.UpdateSettings(itemId, ItemSettings{SetBurnAfterReading: true, BurnAfterReading: true, Expires: &shorter}, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !itemX.BurnAfterReading || !itemX.Expires.Equal(shorter) {
+		t.Fatalf("Settings were not updated: %v", itemX)
+	}
+
+	if itemX, err := client.Get(itemId, context.Background()); err != nil {
+		t.Fatal(err)
+	} else if !itemX.BurnAfterReading || !itemX.Expires.Equal(shorter) {
+		t.Fatalf("Settings were not stored: %v", itemX)
+	}
+
+	// Only updating one setting keeps the other one.
+	if itemX, err := client.UpdateSettings(itemId, ItemSettings{SetBurnAfterReading: true}, context.Background()); err != nil {
+		t.Fatal(err)
+	} else if itemX.BurnAfterReading || !itemX.Expires.Equal(shorter) {
+		t.Fatalf("Settings were not partially updated: %v", itemX)
+	}
+
+	longer := time.Now().Add(time.Hour).UTC()
+	if _, err := client.UpdateSettings(itemId, ItemSettings{Expires: &longer}, context.Background()); err != ErrLifetimeExtended {
+		t.Fatalf("Expected ErrLifetimeExtended, got %v", err)
+	}
+
+	if _, err := client.UpdateSettings("nope", ItemSettings{SetBurnAfterReading: true}, context.Background()); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+// testStoreRpcSessionRecordDownload tests concurrently recorded downloads.
+func testStoreRpcSessionRecordDownload(t *testing.T, server *StoreRpcServer, client *StoreRpcClient) {
+	const downloads = 16
+
+	item := Item{Expires: time.Now().Add(time.Hour).UTC()}
+	itemId, err := server.store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if itemX, err := client.Get(itemId, context.Background()); err != nil {
+		t.Fatal(err)
+	} else if itemX.Downloads != 0 || !itemX.LastAccess.IsZero() {
+		t.Fatalf("New Item has %d downloads, last at %v", itemX.Downloads, itemX.LastAccess)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, downloads)
+	for i := 0; i < downloads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- client.RecordDownload(itemId, context.Background())
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if itemX, err := client.Get(itemId, context.Background()); err != nil {
+		t.Fatal(err)
+	} else if itemX.Downloads != downloads {
+		t.Fatalf("Expected %d downloads, got %d", downloads, itemX.Downloads)
+	} else if time.Since(itemX.LastAccess) > time.Minute {
+		t.Fatalf("LastAccess was not updated: %v", itemX.LastAccess)
+	}
+
+	if err := client.RecordDownload("nope", context.Background()); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+// testStoreRpcSessionTouch tests the throttled update of an Item's LastAccess.
+func testStoreRpcSessionTouch(t *testing.T, server *StoreRpcServer, client *StoreRpcClient) {
+	item := Item{Expires: time.Now().Add(time.Hour).UTC()}
+	itemId, err := server.store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Touch(itemId, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	firstAccess, err := client.Get(itemId, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if time.Since(firstAccess.LastAccess) > time.Minute {
+		t.Fatalf("LastAccess was not updated: %v", firstAccess.LastAccess)
+	} else if firstAccess.Downloads != 0 {
+		t.Fatalf("Touch counted %d downloads", firstAccess.Downloads)
+	}
+
+	// A second Touch within the TouchInterval must be throttled.
+	if err := client.Touch(itemId, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if itemX, err := client.Get(itemId, context.Background()); err != nil {
+		t.Fatal(err)
+	} else if !itemX.LastAccess.Equal(firstAccess.LastAccess) {
+		t.Fatalf("LastAccess was updated from %v to %v", firstAccess.LastAccess, itemX.LastAccess)
+	}
+
+	if err := client.Touch("nope", context.Background()); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+// testStoreRpcSessionRecordBurnAttempt tests concurrently recorded attempts.
+func testStoreRpcSessionRecordBurnAttempt(t *testing.T, server *StoreRpcServer, client *StoreRpcClient) {
+	const attempts = 16
+
+	item := Item{BurnAfterReading: true, Expires: time.Now().Add(time.Hour).UTC()}
+	itemId, err := server.store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.RecordBurnAttempt(itemId, context.Background())
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if itemX, err := client.Get(itemId, context.Background()); err != nil {
+		t.Fatal(err)
+	} else if itemX.BurnAttempts != attempts {
+		t.Fatalf("Expected %d attempts, got %d", attempts, itemX.BurnAttempts)
+	} else if time.Since(itemX.FirstBurnAttempt) > time.Minute {
+		t.Fatalf("FirstBurnAttempt was not set: %v", itemX.FirstBurnAttempt)
+	}
+
+	if _, err := client.RecordBurnAttempt("nope", context.Background()); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+// testStoreRpcSessionReserveDownload tests that concurrent reservations do not
+// exceed an Item's MaxDownloads.
+func testStoreRpcSessionReserveDownload(t *testing.T, server *StoreRpcServer, client *StoreRpcClient) {
+	const (
+		maxDownloads = 5
+		requests     = 16
+	)
+
+	item := Item{MaxDownloads: maxDownloads, Expires: time.Now().Add(time.Hour).UTC()}
+	itemId, err := server.store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+	lasts := make(chan bool, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			item, err := client.ReserveDownload(itemId, context.Background())
+			errs <- err
+			lasts <- err == nil && item.lastDownload()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	close(lasts)
+
+	var reserved, exhausted, last int
+	for err := range errs {
+		switch err {
+		case nil:
+			reserved++
+		case ErrDownloadsExhausted:
+			exhausted++
+		default:
+			t.Fatal(err)
+		}
+	}
+	for isLast := range lasts {
+		if isLast {
+			last++
+		}
+	}
+
+	if reserved != maxDownloads || exhausted != requests-maxDownloads {
+		t.Fatalf("Expected %d reserved and %d exhausted, got %d and %d",
+			maxDownloads, requests-maxDownloads, reserved, exhausted)
+	}
+	if last != 1 {
+		t.Fatalf("Expected exactly one last download, got %d", last)
+	}
+
+	// A released download can be reserved again.
+	if err := client.ReleaseDownload(itemId, context.Background()); err != nil {
+		t.Fatal(err)
+	} else if item, err := client.ReserveDownload(itemId, context.Background()); err != nil {
+		t.Fatal(err)
+	} else if !item.lastDownload() {
+		t.Fatal("Reserved download after a release is not the last one")
+	}
+
+	if _, err := client.ReserveDownload("nope", context.Background()); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+	if err := client.ReleaseDownload("nope", context.Background()); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+// testStoreRpcSessionFindByContentType tests querying Items by Content-Type.
+func testStoreRpcSessionFindByContentType(t *testing.T, server *StoreRpcServer, client *StoreRpcClient) {
+	for _, contentType := range []string{"text/plain", "image/png", "image/png"} {
+		item := Item{ContentType: contentType, Expires: time.Now().Add(time.Hour).UTC()}
+		if _, err := server.store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for contentType, expected := range map[string]int{"text/plain": 1, "image/png": 2, "text/html": 0} {
+		items, err := client.FindByContentType(contentType, context.Background())
+		if err != nil {
+			t.Fatal(err)
+		} else if len(items) != expected {
+			t.Fatalf("Expected %d Items of %q, got %d", expected, contentType, len(items))
+		}
+	}
+}
+
+// testStoreRpcSessionPing checks if the server answers a Ping.
+func testStoreRpcSessionPing(t *testing.T, _ *StoreRpcServer, client *StoreRpcClient) {
+	if err := client.Ping(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
 // testStoreRpcSessionSession mimics store_test.go's TestStore.
 func testStoreRpcSessionSession(t *testing.T, server *StoreRpcServer, client *StoreRpcClient) {
 	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
@@ -167,10 +449,12 @@ func testStoreRpcSessionSession(t *testing.T, server *StoreRpcServer, client *St
 		t.Error(err)
 	}
 	item.ID = itemId
+	item.ContentHash = sha256Hex(itemDataRaw)
+	item.Size = int64(len(itemDataRaw))
 
 	if itemX, err := client.Get(itemId, context.Background()); err != nil {
 		t.Error(err)
-	} else if !reflect.DeepEqual(item, itemX) {
+	} else if !reflect.DeepEqual(item, withoutBlob(itemX)) {
 		t.Errorf("Fetched Item mismatches: got %v and expected %v", itemX, item)
 	}
 
@@ -197,11 +481,16 @@ func testStoreRpcSessionSession(t *testing.T, server *StoreRpcServer, client *St
 	}
 
 	item.Expires = time.Now().Add(-1 * time.Minute).UTC()
-	if _, err := client.Put(item, itemData, context.Background()); err != nil {
+	if _, err := client.Put(item, newDummyReadCloser(bytes.NewBuffer(itemDataRaw)), context.Background()); err != nil {
 		t.Error(err)
 	}
 
-	if err := server.store.deleteExpired(); err != nil {
+	expirer, ok := server.store.(interface{ deleteExpired() error })
+	if !ok {
+		t.Fatalf("Storer %T cannot delete expired Items", server.store)
+	}
+
+	if err := expirer.deleteExpired(); err != nil {
 		t.Error(err)
 	} else if _, err := client.Get(item.ID, context.Background()); err != ErrNotFound {
 		t.Error(err)
@@ -209,10 +498,33 @@ func testStoreRpcSessionSession(t *testing.T, server *StoreRpcServer, client *St
 }
 
 func TestStoreRpcSession(t *testing.T) {
+	backends := []struct {
+		name     string
+		newStore func(t *testing.T) Storer
+	}{
+		{"badger", func(t *testing.T) Storer {
+			storageDir, err := os.MkdirTemp("", "db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = os.RemoveAll(storageDir) })
+
+			store, err := NewStore(storageDir, randomIdGenerator(4), StoreOpts{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		}},
+		{"memory", func(t *testing.T) Storer {
+			return NewMemoryStore(randomIdGenerator(4), StoreOpts{})
+		}},
+	}
+
 	tests := []struct {
 		name string
 		f    func(*testing.T, *StoreRpcServer, *StoreRpcClient)
 	}{
+		{"Ping", testStoreRpcSessionPing},
 		{"Get", testStoreRpcSessionGet},
 		{"GetFile", testStoreRpcSessionGetFile},
 		{"Put-0", testStoreRpcSessionPut(0)},
@@ -221,62 +533,322 @@ func TestStoreRpcSession(t *testing.T) {
 		{"Put-1m", testStoreRpcSessionPut(1024 * 1024)},
 		{"Put-100m", testStoreRpcSessionPut(100 * 1024 * 1024)},
 		{"Delete", testStoreRpcSessionDelete},
+		{"UpdateSettings", testStoreRpcSessionUpdateSettings},
+		{"RecordDownload", testStoreRpcSessionRecordDownload},
+		{"Touch", testStoreRpcSessionTouch},
+		{"RecordBurnAttempt", testStoreRpcSessionRecordBurnAttempt},
+		{"FindByContentType", testStoreRpcSessionFindByContentType},
+		{"ReserveDownload", testStoreRpcSessionReserveDownload},
 		{"Session", testStoreRpcSessionSession},
 	}
 
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			serverRpcSocket, clientRpcSocket, err := socketpair()
-			if err != nil {
-				t.Fatal(err)
-			}
-			serverFdSocket, clientFdSocket, err := socketpair()
-			if err != nil {
-				t.Fatal(err)
-			}
+	for _, backend := range backends {
+		for _, test := range tests {
+			t.Run(backend.name+"/"+test.name, func(t *testing.T) {
+				serverRpcSocket, clientRpcSocket, err := socketpair()
+				if err != nil {
+					t.Fatal(err)
+				}
+				serverFdSocket, clientFdSocket, err := socketpair()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				serverRpcUnixSocket, err := unixConnFromFile(serverRpcSocket)
+				if err != nil {
+					t.Fatal(err)
+				}
+				clientRpcUnixSocket, err := unixConnFromFile(clientRpcSocket)
+				if err != nil {
+					t.Fatal(err)
+				}
+				serverFdUnixSocket, err := unixConnFromFile(serverFdSocket)
+				if err != nil {
+					t.Fatal(err)
+				}
+				clientFdUnixSocket, err := unixConnFromFile(clientFdSocket)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				store := backend.newStore(t)
+
+				server := NewStoreRpcServer(store, serverRpcUnixSocket, serverFdUnixSocket, 0)
+				client := NewStoreRpcClient(clientRpcUnixSocket, clientFdUnixSocket, StoreRpcClientOpts{})
+
+				test.f(t, server, client)
+
+				err = client.Close()
+				if err != nil {
+					t.Error(err)
+				}
+				err = server.Close()
+				if err != nil {
+					t.Error(err)
+				}
+			})
+		}
+	}
+}
 
-			serverRpcUnixSocket, err := unixConnFromFile(serverRpcSocket)
-			if err != nil {
-				t.Fatal(err)
-			}
-			clientRpcUnixSocket, err := unixConnFromFile(clientRpcSocket)
-			if err != nil {
-				t.Fatal(err)
-			}
-			serverFdUnixSocket, err := unixConnFromFile(serverFdSocket)
+// newUnixConnPairs creates both the RPC and the FD Unix domain socket pairs,
+// as used between a StoreRpcServer and a StoreRpcClient.
+func newUnixConnPairs(t *testing.T) (serverRpc, clientRpc, serverFd, clientFd *net.UnixConn) {
+	conns := make([]*net.UnixConn, 4)
+	for i := 0; i < 2; i++ {
+		server, client, err := socketpair()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for j, f := range []*os.File{server, client} {
+			conns[2*i+j], err = unixConnFromFile(f)
 			if err != nil {
 				t.Fatal(err)
 			}
-			clientFdUnixSocket, err := unixConnFromFile(clientFdSocket)
+		}
+	}
+
+	return conns[0], conns[1], conns[2], conns[3]
+}
+
+func TestStoreRpcMaxFdTransfers(t *testing.T) {
+	const (
+		maxFdTransfers = 4
+		requests       = 64
+	)
+
+	serverRpc, clientRpc, serverFd, clientFd := newUnixConnPairs(t)
+
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, randomIdGenerator(4), StoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewStoreRpcServer(store, serverRpc, serverFd, maxFdTransfers)
+	defer server.Close()
+	client := NewStoreRpcClient(clientRpc, clientFd, StoreRpcClientOpts{})
+	defer client.Close()
+
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+	itemId, err := server.store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Occupy all slots; the next request must be rejected.
+	for i := 0; i < maxFdTransfers; i++ {
+		server.fdTransfers <- struct{}{}
+	}
+	if _, err := client.GetFile(itemId, context.Background()); err != ErrStoreBusy {
+		t.Fatalf("Expected ErrStoreBusy, got %v", err)
+	}
+	for i := 0; i < maxFdTransfers; i++ {
+		<-server.fdTransfers
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			f, err := client.GetFile(itemId, context.Background())
 			if err != nil {
-				t.Fatal(err)
+				errs <- err
+				return
 			}
+			errs <- f.Close()
+		}()
+	}
+	wg.Wait()
+	close(errs)
 
-			storageDir, err := os.MkdirTemp("", "db")
+	for err := range errs {
+		if err != nil && err != ErrStoreBusy {
+			t.Fatalf("Expected either no error or ErrStoreBusy, got %v", err)
+		}
+	}
+
+	if len(server.fdTransfers) != 0 {
+		t.Fatalf("%d FD transfer slots were not released", len(server.fdTransfers))
+	}
+}
+
+func TestStoreRpcPutIncomplete(t *testing.T) {
+	serverRpc, clientRpc, serverFd, clientFd := newUnixConnPairs(t)
+
+	store := NewMemoryStore(randomIdGenerator(4), StoreOpts{})
+	server := NewStoreRpcServer(store, serverRpc, serverFd, 0)
+	defer server.Close()
+
+	client := NewStoreRpcClient(clientRpc, clientFd, StoreRpcClientOpts{})
+	defer client.Close()
+
+	item := Item{Size: 11, Expires: time.Now().Add(time.Minute).UTC()}
+	file := &failingReadCloser{r: bytes.NewBufferString("hello"), err: io.ErrUnexpectedEOF}
+
+	if _, err := client.Put(item, file, context.Background()); err != ErrIncompleteFile {
+		t.Fatalf("Expected ErrIncompleteFile, got %v", err)
+	}
+
+	if n := len(store.items) + len(store.blobs); n != 0 {
+		t.Fatalf("Store contains %d entries after a failed Put", n)
+	}
+}
+
+func TestStoreRpcStats(t *testing.T) {
+	serverRpc, clientRpc, serverFd, clientFd := newUnixConnPairs(t)
+
+	server := NewStoreRpcServer(NewMemoryStore(randomIdGenerator(4), StoreOpts{}), serverRpc, serverFd, 0)
+	defer server.Close()
+
+	client := NewStoreRpcClient(clientRpc, clientFd, StoreRpcClientOpts{})
+	defer client.Close()
+	stats := client.EnableStats()
+
+	if _, err := client.Get("nope", context.Background()); err != ErrNotFound {
+		t.Fatal(err)
+	}
+
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+	itemId, err := client.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")), context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := client.GetFile(itemId, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, f)
+	_ = f.Close()
+
+	expected := map[string]RpcOpStats{
+		"Get":        {Calls: 1, Failures: 1},
+		"Put":        {Calls: 1},
+		"Put/fd":     {Calls: 1},
+		"GetFile":    {Calls: 1},
+		"GetFile/fd": {Calls: 1},
+	}
+
+	snapshot := stats.Snapshot()
+	if len(snapshot) != len(expected) {
+		t.Fatalf("Expected %d operations, got %v", len(expected), snapshot)
+	}
+	for op, exp := range expected {
+		got, ok := snapshot[op]
+		if !ok {
+			t.Fatalf("Operation %q is missing", op)
+		}
+		if got.Calls != exp.Calls || got.Failures != exp.Failures {
+			t.Fatalf("Operation %q: expected %d calls and %d failures, got %d and %d",
+				op, exp.Calls, exp.Failures, got.Calls, got.Failures)
+		}
+		if got.Total <= 0 || got.Max <= 0 || got.Mean() > got.Max {
+			t.Fatalf("Operation %q: implausible latencies %v", op, got)
+		}
+	}
+}
+
+func TestStoreRpcClientTimeouts(t *testing.T) {
+	serverRpc, clientRpc, serverFd, clientFd := newUnixConnPairs(t)
+
+	store := slowStore{Storer: NewMemoryStore(randomIdGenerator(4), StoreOpts{}), delay: 100 * time.Millisecond}
+	server := NewStoreRpcServer(store, serverRpc, serverFd, 0)
+	defer server.Close()
+	client := NewStoreRpcClient(clientRpc, clientFd, StoreRpcClientOpts{
+		Timeout:    20 * time.Millisecond,
+		PutTimeout: time.Second,
+	})
+	defer client.Close()
+
+	// A slow Put succeeds within its own timeout, while a Get falls back.
+	itemId, err := client.Put(
+		Item{Expires: time.Now().Add(time.Minute).UTC()},
+		newDummyReadCloser(bytes.NewBufferString("hello world")),
+		context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Get(itemId, context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	for method, timeout := range map[string]time.Duration{
+		"Get":    20 * time.Millisecond,
+		"Put":    time.Second,
+		"Delete": 20 * time.Millisecond,
+	} {
+		if got := client.timeoutFor(method); got != timeout {
+			t.Fatalf("%s: expected timeout %v, got %v", method, timeout, got)
+		}
+	}
+}
+
+func TestStoreRpcConcurrentFdTransfers(t *testing.T) {
+	const requests = 32
+
+	serverRpc, clientRpc, serverFd, clientFd := newUnixConnPairs(t)
+
+	server := NewStoreRpcServer(NewMemoryStore(randomIdGenerator(4), StoreOpts{}), serverRpc, serverFd, 0)
+	defer server.Close()
+	client := NewStoreRpcClient(clientRpc, clientFd, StoreRpcClientOpts{})
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			data := fmt.Sprintf("file number %d", i)
+			itemId, err := client.Put(
+				Item{Filename: data, Expires: time.Now().Add(time.Minute).UTC()},
+				newDummyReadCloser(bytes.NewBufferString(data)),
+				context.Background())
 			if err != nil {
-				t.Fatal(err)
+				errs <- err
+				return
 			}
 
-			store, err := NewStore(storageDir, randomIdGenerator(4), false)
+			item, err := client.Get(itemId, context.Background())
 			if err != nil {
-				t.Fatal(err)
+				errs <- err
+				return
 			}
 
-			server := NewStoreRpcServer(store, serverRpcUnixSocket, serverFdUnixSocket)
-			client := NewStoreRpcClient(clientRpcUnixSocket, clientFdUnixSocket)
-
-			test.f(t, server, client)
-
-			err = client.Close()
+			f, err := client.GetFile(itemId, context.Background())
 			if err != nil {
-				t.Error(err)
+				errs <- err
+				return
 			}
-			err = server.Close()
+			fileData, err := io.ReadAll(f)
+			_ = f.Close()
 			if err != nil {
-				t.Error(err)
+				errs <- err
+				return
+			}
+
+			// Both the Item and its file must belong to this request.
+			if item.Filename != data || string(fileData) != data {
+				errs <- fmt.Errorf("request %d got Item %q with file %q", i, item.Filename, fileData)
 			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
 
-			_ = os.RemoveAll(storageDir)
-		})
+	for err := range errs {
+		t.Error(err)
 	}
 }
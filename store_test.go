@@ -2,10 +2,18 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -27,6 +35,12 @@ func (drc dummyReadCloser) Close() error {
 	return nil
 }
 
+// sha256Hex returns the hex encoded SHA-256 hash, as used for Item.ContentHash.
+func sha256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
 func TestStore(t *testing.T) {
 	loggerLevel := new(slog.LevelVar)
 	loggerLevel.Set(slog.LevelDebug)
@@ -43,7 +57,7 @@ func TestStore(t *testing.T) {
 	}
 	defer os.RemoveAll(storageDir)
 
-	store, err := NewStore(storageDir, randomIdGenerator(4), false)
+	store, err := NewStore(storageDir, randomIdGenerator(4), StoreOpts{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -57,6 +71,9 @@ func TestStore(t *testing.T) {
 		t.Fatal(err)
 	}
 	item.ID = itemId
+	item.ContentHash = sha256Hex(itemDataRaw)
+	item.Blob = itemId
+	item.Size = int64(len(itemDataRaw))
 
 	if itemX, err := store.Get(itemId); err != nil {
 		t.Fatal(err)
@@ -87,7 +104,7 @@ func TestStore(t *testing.T) {
 	}
 
 	item.Expires = time.Now().Add(-1 * time.Minute).UTC()
-	if _, err := store.Put(item, itemData); err != nil {
+	if _, err := store.Put(item, newDummyReadCloser(bytes.NewBuffer(itemDataRaw))); err != nil {
 		t.Fatal(err)
 	}
 
@@ -111,7 +128,7 @@ func TestStoreCreateId(t *testing.T) {
 	}
 	defer os.RemoveAll(storageDir)
 
-	store, err := NewStore(storageDir, randomIdGenerator(4), false)
+	store, err := NewStore(storageDir, randomIdGenerator(4), StoreOpts{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -134,3 +151,1670 @@ func TestStoreCreateId(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestStoreCreateIdExhausted(t *testing.T) {
+	// Only the four IDs "aa", "ab", "ba", and "bb" are possible.
+	idGenerator, err := nanoidIdGenerator("ab", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewStore(t.TempDir(), idGenerator, StoreOpts{ShardDepth: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	put := func() (string, error) {
+		return store.Put(
+			Item{Expires: time.Now().Add(time.Hour).UTC()},
+			newDummyReadCloser(bytes.NewBufferString("hello world")))
+	}
+
+	idCheck := make(map[string]struct{})
+	for i := 0; i < 3; i++ {
+		id, err := put()
+		if err != nil {
+			t.Fatal(err)
+		} else if _, exists := idCheck[id]; exists {
+			t.Fatalf("ID %s does already exist", id)
+		}
+		idCheck[id] = struct{}{}
+	}
+
+	// The fourth ID might be found by chance, while a fifth is impossible.
+	_, _ = put()
+	if _, err := put(); err == nil {
+		t.Fatal("Expected an error after all IDs are in use")
+	}
+}
+
+func TestStoreDeletionHook(t *testing.T) {
+	type hookCall struct {
+		event ItemEvent
+		item  Item
+	}
+	var calls []hookCall
+
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, randomIdGenerator(4), StoreOpts{
+		DeletionHook: func(event ItemEvent, item Item) {
+			calls = append(calls, hookCall{event, item})
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	item := Item{Filename: "foo.txt", Expires: time.Now().Add(time.Minute).UTC()}
+	itemId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Delete(itemId); err != nil {
+		t.Fatal(err)
+	}
+
+	// A rolled back Item was never announced, thus the hook is not called.
+	rolledBackId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Rollback(rolledBackId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(rolledBackId); err != ErrNotFound {
+		t.Fatalf("Expected rolled back Item to be gone, got %v", err)
+	}
+
+	item.Expires = time.Now().Add(-time.Minute).UTC()
+	expiredId, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.deleteExpired(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		event ItemEvent
+		id    string
+	}{
+		{ItemDeleted, itemId},
+		{ItemExpired, expiredId},
+	}
+
+	if len(calls) != len(expected) {
+		t.Fatalf("Expected %d hook calls, got %d", len(expected), len(calls))
+	}
+	for i, exp := range expected {
+		if calls[i].event != exp.event || calls[i].item.ID != exp.id {
+			t.Fatalf("Hook call %d: expected %s for %s, got %s for %s",
+				i, exp.event, exp.id, calls[i].event, calls[i].item.ID)
+		}
+		if calls[i].item.Filename != item.Filename {
+			t.Fatalf("Hook call %d: Filename mismatches, got %q", i, calls[i].item.Filename)
+		}
+		if calls[i].item.ContentHash != sha256Hex([]byte("hello world")) {
+			t.Fatalf("Hook call %d: ContentHash mismatches, got %q", i, calls[i].item.ContentHash)
+		}
+	}
+}
+
+// failingReadCloser returns its data followed by the error, mimicking an
+// uploader disconnecting mid-upload.
+type failingReadCloser struct {
+	r   io.Reader
+	err error
+}
+
+func (frc *failingReadCloser) Read(p []byte) (int, error) {
+	n, err := frc.r.Read(p)
+	if err == io.EOF {
+		err = frc.err
+	}
+	return n, err
+}
+
+func (frc *failingReadCloser) Close() error {
+	return nil
+}
+
+func TestStorePutIncomplete(t *testing.T) {
+	tests := []struct {
+		name string
+		size int64
+		err  error
+	}{
+		{"short", 11, io.EOF},
+		{"failing", 0, io.ErrUnexpectedEOF},
+		{"failing-short", 11, io.ErrUnexpectedEOF},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storageDir, err := os.MkdirTemp("", "db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(storageDir)
+
+			store, err := NewStore(storageDir, randomIdGenerator(4), StoreOpts{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			item := Item{Size: test.size, Expires: time.Now().Add(time.Minute).UTC()}
+			file := &failingReadCloser{r: bytes.NewBufferString("hello"), err: test.err}
+
+			id, err := store.Put(item, file)
+			if err == nil {
+				t.Fatalf("Incomplete Put succeeded with ID %q", id)
+			} else if test.err == io.EOF && !errors.Is(err, ErrIncompleteFile) {
+				t.Fatalf("Expected ErrIncompleteFile, got %v", err)
+			}
+
+			if n, err := store.BadgerHold().Count(&Item{}, nil); err != nil {
+				t.Fatal(err)
+			} else if n != 0 {
+				t.Fatalf("Database contains %d Items after a failed Put", n)
+			}
+
+			if files, err := os.ReadDir(store.storageDir()); err != nil {
+				t.Fatal(err)
+			} else if len(files) != 0 {
+				t.Fatalf("Storage contains %d files after a failed Put", len(files))
+			}
+		})
+	}
+}
+
+func TestStorePutInterrupted(t *testing.T) {
+	storageDir := t.TempDir()
+
+	store, err := NewStore(storageDir, randomIdGenerator(4), StoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := store.Put(
+		Item{Expires: time.Now().Add(time.Hour).UTC()},
+		newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// While being written, the file must not be visible at its path.
+	store.idGenerator = func() (string, error) { return "partial", nil }
+	pr, pw := io.Pipe()
+	putErr := make(chan error)
+	go func() {
+		_, err := store.Put(Item{Expires: time.Now().Add(time.Hour).UTC()}, pr)
+		putErr <- err
+	}()
+
+	if _, err := pw.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(testFileBackend(t, store).filePath("partial")); !os.IsNotExist(err) {
+		t.Fatalf("Partially written file is visible: %v", err)
+	}
+	_ = pw.CloseWithError(io.ErrUnexpectedEOF)
+	if err := <-putErr; err == nil {
+		t.Fatal("Interrupted Put succeeded")
+	}
+	if n := countStoredFiles(t, store); n != 1 {
+		t.Fatalf("Expected one stored file after a failed Put, got %d", n)
+	}
+
+	// Mimic a store being killed while writing a file.
+	if err := store.bh.Insert("killed", Item{ID: "killed"}); err != nil {
+		t.Fatal(err)
+	}
+	tempFile := filepath.Join(store.storageDir(), tempFilePrefix+"killed-1234")
+	if err := os.WriteFile(tempFile, []byte("hel"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewStore(storageDir, randomIdGenerator(4), StoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := store.Get("killed"); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound for the interrupted Item, got %v", err)
+	}
+	if _, err := os.Stat(tempFile); !os.IsNotExist(err) {
+		t.Fatalf("Temporary file was not removed: %v", err)
+	}
+	if _, err := store.Get(id); err != nil {
+		t.Fatal(err)
+	}
+	if n := countStoredFiles(t, store); n != 1 {
+		t.Fatalf("Expected one stored file, got %d", n)
+	}
+	if usage := store.Usage(); usage != 11 {
+		t.Fatalf("Expected usage of 11 bytes, got %d", usage)
+	}
+}
+
+func TestStoreSharding(t *testing.T) {
+	tests := []struct {
+		depth int
+		id    string
+		path  string
+	}{
+		{0, "abcdef", "abcdef"},
+		{1, "abcdef", "ab/abcdef"},
+		{2, "abcdef", "ab/cd/abcdef"},
+		{3, "abc", "ab/c_/__/abc"},
+		{1, "..foo", "__/..foo"},
+	}
+
+	for _, test := range tests {
+		fb := fileBackend{dir: "/store", shardDepth: test.depth}
+		if path := fb.filePath(test.id); path != filepath.Join("/store", test.path) {
+			t.Fatalf("Depth %d, ID %q: unexpected path %q", test.depth, test.id, path)
+		}
+	}
+
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	openStore := func(depth int) *Store {
+		store, err := NewStore(storageDir, randomIdGenerator(4), StoreOpts{ShardDepth: depth})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+
+	itemDataRaw := []byte("hello world")
+	item := Item{Expires: time.Now().Add(time.Minute).UTC()}
+
+	// Put an Item into a flat store and migrate it along different depths.
+	store := openStore(0)
+	itemId, err := store.Put(item, newDummyReadCloser(bytes.NewBuffer(itemDataRaw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, depth := range []int{2, 1, 0, 3} {
+		store = openStore(depth)
+
+		if _, err := os.Stat(testFileBackend(t, store).filePath(itemId)); err != nil {
+			t.Fatalf("Depth %d: file was not moved: %v", depth, err)
+		}
+		if entries, err := os.ReadDir(store.storageDir()); err != nil {
+			t.Fatal(err)
+		} else if len(entries) != 1 {
+			t.Fatalf("Depth %d: storage directory has %d entries", depth, len(entries))
+		}
+
+		f, err := store.GetFile(itemId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(f)
+		_ = f.Close()
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(data, itemDataRaw) {
+			t.Fatalf("Depth %d: data mismatch", depth)
+		}
+
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Put, get and delete another Item within the sharded store.
+	store = openStore(2)
+	defer store.Close()
+
+	shardedId, err := store.Put(item, newDummyReadCloser(bytes.NewBuffer(itemDataRaw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(filepath.Dir(filepath.Dir(testFileBackend(t, store).filePath(shardedId)))) != store.storageDir() {
+		t.Fatalf("File %q is not stored two levels deep", testFileBackend(t, store).filePath(shardedId))
+	}
+	if _, err := store.Get(shardedId); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(shardedId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(testFileBackend(t, store).filePath(shardedId)); !os.IsNotExist(err) {
+		t.Fatalf("File still exists after deletion: %v", err)
+	}
+
+	if _, err := NewStore(storageDir, randomIdGenerator(4), StoreOpts{ShardDepth: MaxShardDepth + 1}); err == nil {
+		t.Fatal("Invalid shard depth was accepted")
+	}
+}
+
+func TestStorePutUploadChecksum(t *testing.T) {
+	itemDataRaw := []byte("hello world")
+
+	tests := []struct {
+		name     string
+		checksum Checksum
+		err      error
+	}{
+		{"none", Checksum{}, nil},
+		{"sha256", Checksum{"sha256", sha256Hex(itemDataRaw)}, nil},
+		{"md5", Checksum{"md5", "5eb63bbbe01eeed093cb22bb8f5acdc3"}, nil},
+		{"sha256-mismatch", Checksum{"sha256", sha256Hex([]byte("hello world!"))}, ErrUploadChecksum},
+		{"sha1-mismatch", Checksum{"sha1", "0000000000000000000000000000000000000000"}, ErrUploadChecksum},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storageDir, err := os.MkdirTemp("", "db")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(storageDir)
+
+			store, err := NewStore(storageDir, randomIdGenerator(4), StoreOpts{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			item := Item{UploadChecksum: test.checksum, Expires: time.Now().Add(time.Minute).UTC()}
+			id, err := store.Put(item, newDummyReadCloser(bytes.NewBuffer(itemDataRaw)))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Expected %v, got %v", test.err, err)
+			}
+
+			if test.err != nil {
+				if n, err := store.BadgerHold().Count(&Item{}, nil); err != nil {
+					t.Fatal(err)
+				} else if n != 0 {
+					t.Fatalf("Database contains %d Items after a mismatching Put", n)
+				}
+				return
+			}
+
+			if itemX, err := store.Get(id); err != nil {
+				t.Fatal(err)
+			} else if itemX.UploadChecksum != (Checksum{}) {
+				t.Fatalf("UploadChecksum was stored: %v", itemX.UploadChecksum)
+			} else if itemX.ContentHash != sha256Hex(itemDataRaw) {
+				t.Fatalf("ContentHash mismatches: %q", itemX.ContentHash)
+			}
+		})
+	}
+}
+
+func TestWordlistIdGenerator(t *testing.T) {
+	dir := t.TempDir()
+
+	wordlist := filepath.Join(dir, "words")
+	if err := os.WriteFile(wordlist, []byte("correct\nhorse\n\n  \nbattery\nstaple\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, length := range []int{1, 3, 5} {
+		idGenerator, err := wordlistIdGenerator(wordlist, length)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 64; i++ {
+			id, err := idGenerator()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			words := strings.Split(id, "-")
+			if len(words) != length {
+				t.Fatalf("ID %q has %d instead of %d words", id, len(words), length)
+			}
+			for _, word := range words {
+				switch word {
+				case "correct", "horse", "battery", "staple":
+				default:
+					t.Fatalf("ID %q contains the unknown word %q", id, word)
+				}
+			}
+		}
+	}
+
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, []byte("\n \n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		file   string
+		length int
+	}{
+		{"no file", "", 3},
+		{"missing file", filepath.Join(dir, "missing"), 3},
+		{"empty file", empty, 3},
+		{"no length", wordlist, 0},
+	}
+	for _, test := range tests {
+		if _, err := wordlistIdGenerator(test.file, test.length); err == nil {
+			t.Fatalf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestIdValidator(t *testing.T) {
+	wordlist := filepath.Join(t.TempDir(), "words")
+	if err := os.WriteFile(wordlist, []byte("foo\nbar\nbaz-qux\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	randomGen := randomIdGenerator(4)
+	wordlistGen, err := wordlistIdGenerator(wordlist, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nanoidGen, err := nanoidIdGenerator("", 21)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nanoidHexGen, err := nanoidIdGenerator("0123456789abcdef", 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		conf  IdGeneratorConfig
+		gen   func() (string, error)
+		valid []string
+		junk  []string
+	}{
+		{
+			IdGeneratorConfig{Type: "random", Length: 4},
+			randomGen,
+			[]string{"1", "3yQ", "7YXq9G"},
+			[]string{"", "7YXq9GG", "wp-login.php", "0OIl", ".env"},
+		},
+		{
+			IdGeneratorConfig{Type: "wordlist", Length: 3},
+			wordlistGen,
+			[]string{"foo-bar-foo", "foo-baz-qux-bar"},
+			[]string{"", "foo", "foo-bar", "foo--bar", "-foo-bar"},
+		},
+		{
+			IdGeneratorConfig{Type: "uuid"},
+			uuidIdGenerator(),
+			[]string{"f47ac10b-58cc-4372-a567-0e02b2c3d479"},
+			[]string{"", "-", "f47ac10b58cc4372a5670e02b2c3d479", "F47AC10B-58CC-4372-A567-0E02B2C3D479", "f47ac10b-58cc-1372-a567-0e02b2c3d479", "f47ac10b-58cc-4372-c567-0e02b2c3d479"},
+		},
+		{
+			IdGeneratorConfig{Type: "nanoid", Length: 21},
+			nanoidGen,
+			[]string{"V1StGXR8_Z5jdHi6B-myT"},
+			[]string{"", "V1StGXR8_Z5jdHi6B-my", "V1StGXR8_Z5jdHi6B-my.", "../../../../etc/passwd"},
+		},
+		{
+			IdGeneratorConfig{Type: "nanoid", Length: 8, Alphabet: "0123456789abcdef"},
+			nanoidHexGen,
+			[]string{"deadbeef"},
+			[]string{"DEADBEEF", "deadbeeg", "-"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.conf.Type, func(t *testing.T) {
+			validId, err := idValidator(test.conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 256; i++ {
+				id, err := test.gen()
+				if err != nil {
+					t.Fatal(err)
+				}
+				test.valid = append(test.valid, id)
+			}
+
+			for _, id := range test.valid {
+				if !validId(id) {
+					t.Fatalf("ID %q was rejected", id)
+				}
+			}
+			for _, id := range test.junk {
+				if validId(id) {
+					t.Fatalf("ID %q was accepted", id)
+				}
+			}
+		})
+	}
+
+	for _, conf := range []IdGeneratorConfig{
+		{Type: "random"},
+		{Type: "magic", Length: 4},
+		{Type: "nanoid", Length: 4, Alphabet: "ab/"},
+		{Type: "nanoid", Length: 4, Alphabet: "aa"},
+		{Type: "nanoid", Length: 4, Alphabet: "a"},
+	} {
+		if _, err := idValidator(conf); err == nil {
+			t.Fatalf("Expected an error for %v", conf)
+		}
+	}
+}
+
+func TestStoreFindByContentType(t *testing.T) {
+	storageDir := t.TempDir()
+
+	store, err := NewStore(storageDir, randomIdGenerator(4), StoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := []Item{
+		{ContentType: "text/plain", Expires: time.Now().Add(time.Hour).UTC()},
+		{ContentType: "text/plain", Expires: time.Now().Add(time.Hour).UTC()},
+		{ContentType: "image/png", Expires: time.Now().Add(time.Hour).UTC()},
+		{ContentType: "text/plain", Expires: time.Now().Add(-time.Hour).UTC()},
+		{ContentType: "text/plain"},
+	}
+	for _, item := range items {
+		if _, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(store *Store) {
+		for contentType, expected := range map[string]int{"text/plain": 3, "image/png": 1, "text/html": 0} {
+			found, err := store.FindByContentType(contentType)
+			if err != nil {
+				t.Fatal(err)
+			} else if len(found) != expected {
+				t.Fatalf("Expected %d Items of %q, got %d", expected, contentType, len(found))
+			}
+			for _, item := range found {
+				if item.ContentType != contentType {
+					t.Fatalf("Found Item of %q for %q", item.ContentType, contentType)
+				}
+			}
+		}
+	}
+	check(store)
+
+	// Mimic a database from before the ContentType index.
+	err = store.bh.Badger().DropPrefix([]byte("_bhIndex:Item:ContentType:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.bh.Delete(storeSchemaKey, storeSchema{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewStore(storageDir, randomIdGenerator(4), StoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	check(store)
+
+	var schema storeSchema
+	if err := store.bh.Get(storeSchemaKey, &schema); err != nil {
+		t.Fatal(err)
+	} else if schema.Version != storeSchemaVersion {
+		t.Fatalf("Expected schema version %d, got %d", storeSchemaVersion, schema.Version)
+	}
+}
+
+// testFileBackend returns the Store's default fileBackend.
+func testFileBackend(t *testing.T, s *Store) *fileBackend {
+	fb, ok := s.backend.(*fileBackend)
+	if !ok {
+		t.Fatalf("Store's Backend is a %T", s.backend)
+	}
+	return fb
+}
+
+func TestStoreDeletionRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		retries  int
+		failures int
+		deleted  bool
+	}{
+		{"disabled", 0, 1, false},
+		{"eventual cleanup", 3, 2, true},
+		{"given up", 3, 4, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{DeletionRetries: test.retries})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+
+			id, err := store.Put(
+				Item{Expires: time.Now().Add(time.Hour).UTC()},
+				newDummyReadCloser(bytes.NewBufferString("hello world")))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			failures := test.failures
+			testFileBackend(t, store).removeFile = func(name string) error {
+				if failures > 0 {
+					failures--
+					return errors.New("transient I/O error")
+				}
+				return os.Remove(name)
+			}
+
+			err = store.Delete(id)
+			if test.retries == 0 {
+				if err == nil {
+					t.Fatal("Expected the file deletion error without retries")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := store.Get(id); err != ErrNotFound {
+				t.Fatalf("Expected ErrNotFound, got %v", err)
+			}
+			// The pending deletion's ID must not be reused.
+			ids := []string{id, "fresh"}
+			store.idGenerator = func() (string, error) {
+				next := ids[0]
+				ids = ids[1:]
+				return next, nil
+			}
+			if newId, err := store.createID(); err != nil {
+				t.Fatal(err)
+			} else if newId != "fresh" {
+				t.Fatalf("Expected ID %q, got %q", "fresh", newId)
+			}
+
+			for i := 0; i < test.retries; i++ {
+				if err := store.retryDeletions(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err = os.Stat(testFileBackend(t, store).filePath(id))
+			if deleted := os.IsNotExist(err); deleted != test.deleted {
+				t.Fatalf("Expected file deleted %t, got %t", test.deleted, deleted)
+			}
+
+			var pending []pendingDeletion
+			if err := store.bh.Find(&pending, nil); err != nil {
+				t.Fatal(err)
+			} else if len(pending) != 0 {
+				t.Fatalf("Expected no pending deletions, got %v", pending)
+			}
+		})
+	}
+}
+
+func TestStoreQuota(t *testing.T) {
+	put := func(store *Store, lifetime time.Duration, data string) (string, error) {
+		return store.Put(
+			Item{Expires: time.Now().Add(lifetime).UTC()},
+			newDummyReadCloser(bytes.NewBufferString(data)))
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		store, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{MaxStoreSize: 16})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		if _, err := put(store, time.Hour, "hello world"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := put(store, time.Hour, "hello there"); err != ErrStoreFull {
+			t.Fatalf("Expected ErrStoreFull, got %v", err)
+		}
+		if usage := store.Usage(); usage != 11 {
+			t.Fatalf("Expected usage of 11 bytes, got %d", usage)
+		}
+	})
+
+	t.Run("evict", func(t *testing.T) {
+		var store *Store
+		var evicted []string
+		var quotaLocked bool
+		store, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{
+			MaxStoreSize: 16,
+			QuotaPolicy:  QuotaEvict,
+			DeletionHook: func(event ItemEvent, item Item) {
+				if event == ItemEvicted {
+					evicted = append(evicted, item.ID)
+				}
+
+				// The hook must not block other reservations.
+				if store.quotaMu.TryLock() {
+					store.quotaMu.Unlock()
+				} else {
+					quotaLocked = true
+				}
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		idLater, err := put(store, 2*time.Hour, "hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		idSooner, err := put(store, time.Hour, "howdy")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Only the Item expiring next must be evicted to free enough space.
+		idNew, err := put(store, time.Hour, "hello world")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(evicted) != 1 || evicted[0] != idSooner {
+			t.Fatalf("Expected eviction of %q, got %v", idSooner, evicted)
+		} else if quotaLocked {
+			t.Fatal("Item was evicted while holding the quota lock")
+		}
+		for _, id := range []string{idLater, idNew} {
+			if _, err := store.Get(id); err != nil {
+				t.Fatalf("Item %q was deleted: %v", id, err)
+			}
+		}
+		if usage := store.Usage(); usage != 16 {
+			t.Fatalf("Expected usage of 16 bytes, got %d", usage)
+		}
+
+		if _, err := put(store, time.Hour, "hello world, hello"); err != ErrStoreFull {
+			t.Fatalf("Expected ErrStoreFull for a too big file, got %v", err)
+		}
+	})
+
+	t.Run("evict with expected size", func(t *testing.T) {
+		var evicted []string
+		store, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{
+			MaxStoreSize: 16,
+			QuotaPolicy:  QuotaEvict,
+			DeletionHook: func(event ItemEvent, item Item) {
+				if event == ItemEvicted {
+					evicted = append(evicted, item.ID)
+				}
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		idOld, err := put(store, time.Hour, "hello world")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// An Item being inserted with its expected Size, e.g., of a completed
+		// Upload, must not evict itself, even when expiring first.
+		idNew, err := store.Put(
+			Item{Size: 11, Expires: time.Now().Add(time.Minute).UTC()},
+			newDummyReadCloser(bytes.NewBufferString("hello there")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(evicted) != 1 || evicted[0] != idOld {
+			t.Fatalf("Expected eviction of %q, got %v", idOld, evicted)
+		}
+		if _, err := store.Get(idNew); err != nil {
+			t.Fatalf("Item %q was deleted: %v", idNew, err)
+		}
+		if usage := store.Usage(); usage != 11 {
+			t.Fatalf("Expected usage of 11 bytes, got %d", usage)
+		}
+	})
+
+	t.Run("evict never expiring last", func(t *testing.T) {
+		var evicted []string
+		store, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{
+			MaxStoreSize: 16,
+			QuotaPolicy:  QuotaEvict,
+			DeletionHook: func(event ItemEvent, item Item) {
+				if event == ItemEvicted {
+					evicted = append(evicted, item.ID)
+				}
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		idNever, err := store.Put(Item{}, newDummyReadCloser(bytes.NewBufferString("hello")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		idLater, err := put(store, 2*time.Hour, "howdy")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := put(store, time.Hour, "hello world"); err != nil {
+			t.Fatal(err)
+		}
+		if len(evicted) != 1 || evicted[0] != idLater {
+			t.Fatalf("Expected eviction of %q, got %v", idLater, evicted)
+		}
+
+		if _, err := put(store, time.Hour, "hello world, hey"); err != nil {
+			t.Fatal(err)
+		}
+		if len(evicted) != 3 || evicted[2] != idNever {
+			t.Fatalf("Expected eviction of %q last, got %v", idNever, evicted)
+		}
+	})
+
+	t.Run("reopen", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := NewStore(dir, randomIdGenerator(4), StoreOpts{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := put(store, time.Hour, "hello world")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := put(store, time.Hour, "hello"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Delete(id); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		store, err = NewStore(dir, randomIdGenerator(4), StoreOpts{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		if usage := store.Usage(); usage != 5 {
+			t.Fatalf("Expected usage of 5 bytes, got %d", usage)
+		}
+	})
+
+	t.Run("unknown policy", func(t *testing.T) {
+		if _, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{QuotaPolicy: "nope"}); err == nil {
+			t.Fatal("Expected an error for an unknown quota policy")
+		}
+	})
+}
+
+func TestStoreNeverExpires(t *testing.T) {
+	stores := map[string]Storer{
+		"badger": func() Storer {
+			store, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{AutoCleanup: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		}(),
+		"memory": NewMemoryStore(randomIdGenerator(4), StoreOpts{AutoCleanup: true}),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+
+			idNever, err := store.Put(Item{}, newDummyReadCloser(bytes.NewBufferString("hello")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			idExpired, err := store.Put(Item{Expires: time.Now().Add(-time.Hour).UTC()},
+				newDummyReadCloser(bytes.NewBufferString("hello")))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := store.(interface{ deleteExpired() error }).deleteExpired(); err != nil {
+				t.Fatal(err)
+			}
+
+			if item, err := store.Get(idNever); err != nil {
+				t.Fatalf("Item without expiry was deleted: %v", err)
+			} else if !item.NeverExpires() {
+				t.Fatalf("Item got an expiry of %v", item.Expires)
+			}
+			if _, err := store.Get(idExpired); err != ErrNotFound {
+				t.Fatalf("Expected expired Item to be deleted, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStoreStats(t *testing.T) {
+	badger, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer badger.Close()
+
+	memory := NewMemoryStore(randomIdGenerator(4), StoreOpts{})
+	defer memory.Close()
+
+	for name, store := range map[string]Storer{"badger": badger, "memory": memory} {
+		var ids []string
+		for _, data := range []string{"hello", "hello world"} {
+			id, err := store.Put(
+				Item{Expires: time.Now().Add(time.Hour).UTC()},
+				newDummyReadCloser(bytes.NewBufferString(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+		}
+
+		if stats, err := store.Stats(); err != nil {
+			t.Fatal(err)
+		} else if stats != (StoreStats{Items: 2, Bytes: 16}) {
+			t.Fatalf("%s: unexpected stats %+v", name, stats)
+		}
+
+		if err := store.Delete(ids[0]); err != nil {
+			t.Fatal(err)
+		}
+
+		if stats, err := store.Stats(); err != nil {
+			t.Fatal(err)
+		} else if stats != (StoreStats{Items: 1, Bytes: 11}) {
+			t.Fatalf("%s: unexpected stats after deletion %+v", name, stats)
+		}
+	}
+}
+
+func TestStoreList(t *testing.T) {
+	badger, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer badger.Close()
+
+	memory := NewMemoryStore(randomIdGenerator(4), StoreOpts{})
+	defer memory.Close()
+
+	for name, store := range map[string]Storer{"badger": badger, "memory": memory} {
+		created := time.Now().UTC()
+
+		var ids []string
+		for i := 0; i < 4; i++ {
+			// The last two Items share their creation time, ordered by their ID.
+			itemCreated := created.Add(time.Duration(min(i, 2)) * time.Second)
+			id, err := store.Put(
+				Item{Created: itemCreated, Expires: created.Add(time.Hour)},
+				newDummyReadCloser(bytes.NewBufferString("hello world")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+		}
+		if ids[2] > ids[3] {
+			ids[2], ids[3] = ids[3], ids[2]
+		}
+
+		for _, test := range []struct {
+			offset, limit int
+			ids           []string
+		}{
+			{0, 10, ids},
+			{1, 2, ids[1:3]},
+			{3, 10, ids[3:]},
+			{4, 10, nil},
+		} {
+			items, err := store.List(ListQuery{Offset: test.offset, Limit: test.limit})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var gotIds []string
+			for _, item := range items {
+				gotIds = append(gotIds, item.ID)
+			}
+			if !reflect.DeepEqual(gotIds, test.ids) {
+				t.Fatalf("%s: List(%d, %d) returned %v instead of %v", name, test.offset, test.limit, gotIds, test.ids)
+			}
+		}
+	}
+}
+
+func TestStoreListDownloads(t *testing.T) {
+	badger, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer badger.Close()
+
+	memory := NewMemoryStore(randomIdGenerator(4), StoreOpts{})
+	defer memory.Close()
+
+	for name, store := range map[string]Storer{"badger": badger, "memory": memory} {
+		created := time.Now().UTC()
+
+		// The Items are downloaded once, never, and twice.
+		var ids []string
+		for i, downloads := range []int{1, 0, 2} {
+			id, err := store.Put(
+				Item{Created: created.Add(time.Duration(i) * time.Second), Expires: created.Add(time.Hour)},
+				newDummyReadCloser(bytes.NewBufferString("hello world")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+
+			for j := 0; j < downloads; j++ {
+				if err := store.RecordDownload(id); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		for _, test := range []struct {
+			query ListQuery
+			ids   []string
+		}{
+			{ListQuery{Limit: 10, Order: ListByCreated}, ids},
+			{ListQuery{Limit: 10, Order: ListByDownloads}, []string{ids[2], ids[0], ids[1]}},
+			{ListQuery{Limit: 10, Order: ListByLastAccess}, []string{ids[1], ids[0], ids[2]}},
+			{ListQuery{Limit: 10, NeverDownloaded: true}, ids[1:2]},
+			{ListQuery{Offset: 1, Limit: 1, Order: ListByDownloads}, ids[0:1]},
+		} {
+			items, err := store.List(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var gotIds []string
+			for _, item := range items {
+				gotIds = append(gotIds, item.ID)
+			}
+			if !reflect.DeepEqual(gotIds, test.ids) {
+				t.Fatalf("%s: List(%+v) returned %v instead of %v", name, test.query, gotIds, test.ids)
+			}
+		}
+	}
+}
+
+func TestStoreUpload(t *testing.T) {
+	stores := map[string]Storer{
+		"badger": func() Storer {
+			store, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		}(),
+		"memory": NewMemoryStore(randomIdGenerator(4), StoreOpts{}),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+
+			item := Item{ContentType: "text/plain", Expires: time.Now().Add(time.Hour).UTC()}
+			token, err := store.CreateUpload(item, 11, time.Now().Add(time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := store.CompleteUpload(token, item, false); err != ErrUploadOffset {
+				t.Fatalf("Expected ErrUploadOffset for an incomplete Upload, got %v", err)
+			}
+
+			offset, err := store.AppendUpload(token, 0, newDummyReadCloser(bytes.NewBufferString("hello")))
+			if err != nil || offset != 5 {
+				t.Fatalf("Expected offset 5, got %d: %v", offset, err)
+			}
+
+			if _, err := store.AppendUpload(token, 0, newDummyReadCloser(bytes.NewBufferString("hello"))); err != ErrUploadOffset {
+				t.Fatalf("Expected ErrUploadOffset, got %v", err)
+			}
+			if _, err := store.AppendUpload(token, 5, newDummyReadCloser(bytes.NewBufferString(" world and more"))); err != ErrUploadLength {
+				t.Fatalf("Expected ErrUploadLength, got %v", err)
+			}
+
+			upload, err := store.GetUpload(token)
+			if err != nil {
+				t.Fatal(err)
+			} else if upload.Offset != 5 || upload.Length != 11 || string(upload.Head) != "hello" {
+				t.Fatalf("Unexpected Upload %+v", upload)
+			}
+
+			offset, err = store.AppendUpload(token, 5, newDummyReadCloser(bytes.NewBufferString(" world")))
+			if err != nil || offset != 11 {
+				t.Fatalf("Expected offset 11, got %d: %v", offset, err)
+			}
+
+			id, err := store.CompleteUpload(token, item, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if item, err := store.Get(id); err != nil {
+				t.Fatal(err)
+			} else if item.Size != 11 {
+				t.Fatalf("Expected size 11, got %d", item.Size)
+			}
+
+			if _, err := store.GetUpload(token); err != ErrNotFound {
+				t.Fatalf("Expected completed Upload to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStoreUploadExpired(t *testing.T) {
+	stores := map[string]Storer{
+		"badger": func() Storer {
+			store, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		}(),
+		"memory": NewMemoryStore(randomIdGenerator(4), StoreOpts{}),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+
+			token, err := store.CreateUpload(Item{}, 5, time.Now().Add(-time.Minute))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.GetUpload(token); err != ErrNotFound {
+				t.Fatalf("Expected ErrNotFound for an expired Upload, got %v", err)
+			}
+
+			switch s := store.(type) {
+			case *Store:
+				if err := s.deleteExpiredUploads(); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := os.Stat(s.uploadPath(token)); !errors.Is(err, os.ErrNotExist) {
+					t.Fatalf("Expected staged file to be removed, got %v", err)
+				}
+			case *MemoryStore:
+				s.deleteExpiredUploads()
+				if len(s.uploads) != 0 {
+					t.Fatalf("Expected no Uploads, got %d", len(s.uploads))
+				}
+			}
+		})
+	}
+}
+
+// countStoredFiles counts the files within the Store's storage directory.
+func countStoredFiles(t *testing.T, s *Store) (n int) {
+	err := filepath.WalkDir(s.storageDir(), func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			n++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestStoreDeduplication(t *testing.T) {
+	store, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{MaxStoreSize: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	put := func(data string) string {
+		id, err := store.Put(
+			Item{Expires: time.Now().Add(time.Hour).UTC()},
+			newDummyReadCloser(bytes.NewBufferString(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	// The duplicate would exceed the MaxStoreSize if it was stored again.
+	idFirst, idSecond := put("hello world"), put("hello world")
+	if item, err := store.Get(idSecond); err != nil {
+		t.Fatal(err)
+	} else if item.Blob != idFirst || item.ContentHash != sha256Hex([]byte("hello world")) {
+		t.Fatalf("Expected Item sharing blob %q, got %v", idFirst, item)
+	}
+	if n := countStoredFiles(t, store); n != 1 {
+		t.Fatalf("Expected one stored file, got %d", n)
+	}
+	if usage := store.Usage(); usage != 11 {
+		t.Fatalf("Expected usage of 11 bytes, got %d", usage)
+	}
+
+	// The file must outlive the Item it was stored for first.
+	if err := store.Delete(idFirst); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := store.GetFile(idSecond); err != nil {
+		t.Fatal(err)
+	} else if data, err := io.ReadAll(f); err != nil || string(data) != "hello world" {
+		t.Fatalf("Unexpected file content %q: %v", data, err)
+	} else {
+		_ = f.Close()
+	}
+	if usage := store.Usage(); usage != 11 {
+		t.Fatalf("Expected usage of 11 bytes, got %d", usage)
+	}
+
+	// The blob's name must not be reused for a new Item.
+	ids := []string{idFirst, "fresh"}
+	store.idGenerator = func() (string, error) {
+		next := ids[0]
+		ids = ids[1:]
+		return next, nil
+	}
+	if newId, err := store.createID(); err != nil {
+		t.Fatal(err)
+	} else if newId != "fresh" {
+		t.Fatalf("Expected ID %q, got %q", "fresh", newId)
+	}
+
+	if err := store.Delete(idSecond); err != nil {
+		t.Fatal(err)
+	}
+	if n := countStoredFiles(t, store); n != 0 {
+		t.Fatalf("Expected no stored files, got %d", n)
+	}
+	if usage := store.Usage(); usage != 0 {
+		t.Fatalf("Expected usage of 0 bytes, got %d", usage)
+	}
+	if count, err := store.bh.Count(&blob{}, nil); err != nil {
+		t.Fatal(err)
+	} else if count != 0 {
+		t.Fatalf("Expected no blobs, got %d", count)
+	}
+}
+
+func TestStoreDeduplicationConcurrent(t *testing.T) {
+	store, err := NewStore(t.TempDir(), randomIdGenerator(8), StoreOpts{MaxStoreSize: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	const puts = 16
+
+	var wg sync.WaitGroup
+	ids := make(chan string, puts)
+	errs := make(chan error, puts)
+	for i := 0; i < puts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			id, err := store.Put(
+				Item{Expires: time.Now().Add(time.Hour).UTC()},
+				newDummyReadCloser(bytes.NewBufferString("hello world")))
+			if err != nil {
+				errs <- err
+				return
+			}
+			ids <- id
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	var blobs []blob
+	if err := store.bh.Find(&blobs, nil); err != nil {
+		t.Fatal(err)
+	} else if len(blobs) != 1 || blobs[0].Refs != puts {
+		t.Fatalf("Expected one blob of %d references, got %v", puts, blobs)
+	}
+	if n := countStoredFiles(t, store); n != 1 {
+		t.Fatalf("Expected one stored file, got %d", n)
+	}
+	if usage := store.Usage(); usage != 11 {
+		t.Fatalf("Expected usage of 11 bytes, got %d", usage)
+	}
+
+	for id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+
+			if err := store.Delete(id); err != nil {
+				t.Error(err)
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	if n := countStoredFiles(t, store); n != 0 {
+		t.Fatalf("Expected no stored files, got %d", n)
+	}
+	if usage := store.Usage(); usage != 0 {
+		t.Fatalf("Expected usage of 0 bytes, got %d", usage)
+	}
+}
+
+func TestStoreMigrateBlobs(t *testing.T) {
+	storageDir := t.TempDir()
+
+	store, err := NewStore(storageDir, randomIdGenerator(4), StoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, data := range []string{"hello", "hello world"} {
+		id, err := store.Put(
+			Item{Expires: time.Now().Add(time.Hour).UTC()},
+			newDummyReadCloser(bytes.NewBufferString(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	// Mimic a database from before files were shared or their sizes recorded.
+	for _, id := range ids {
+		if _, err := store.updateItem(id, func(i *Item) error {
+			i.Blob = ""
+			i.Size = 0
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.bh.DeleteMatching(&blob{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.bh.Upsert(storeSchemaKey, storeSchema{Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewStore(storageDir, randomIdGenerator(4), StoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if usage := store.Usage(); usage != 16 {
+		t.Fatalf("Expected usage of 16 bytes, got %d", usage)
+	}
+	for _, id := range ids {
+		if item, err := store.Get(id); err != nil {
+			t.Fatal(err)
+		} else if item.Blob != id {
+			t.Fatalf("Expected Item %q owning its blob, got %q", id, item.Blob)
+		} else if item.Size == 0 {
+			t.Fatalf("Expected Item %q with its file's size", id)
+		}
+	}
+
+	// A migrated file is shared with new duplicates.
+	id, err := store.Put(
+		Item{Expires: time.Now().Add(time.Hour).UTC()},
+		newDummyReadCloser(bytes.NewBufferString("hello")))
+	if err != nil {
+		t.Fatal(err)
+	} else if item, err := store.Get(id); err != nil {
+		t.Fatal(err)
+	} else if item.Blob != ids[0] {
+		t.Fatalf("Expected Item sharing blob %q, got %q", ids[0], item.Blob)
+	}
+
+	for _, id := range append(ids, id) {
+		if err := store.Delete(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countStoredFiles(t, store); n != 0 {
+		t.Fatalf("Expected no stored files, got %d", n)
+	}
+}
+
+func TestStoreReconcile(t *testing.T) {
+	storageDir := t.TempDir()
+
+	store, err := NewStore(storageDir, randomIdGenerator(4), StoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, data := range []string{"kept", "missing", "missing"} {
+		id, err := store.Put(
+			Item{Expires: time.Now().Add(time.Hour).UTC()},
+			newDummyReadCloser(bytes.NewBufferString(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	// One file without a blob, one blob without a file shared by two Items.
+	fb := testFileBackend(t, store)
+	if err := os.WriteFile(fb.filePath("orphan"), []byte("orphan"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(fb.filePath(ids[1])); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var deleted []string
+	store, err = NewStore(storageDir, randomIdGenerator(4), StoreOpts{
+		Reconcile: true,
+		DeletionHook: func(event ItemEvent, i Item) {
+			deleted = append(deleted, i.ID)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if n := countStoredFiles(t, store); n != 1 {
+		t.Fatalf("Expected one stored file, got %d", n)
+	}
+	if usage := store.Usage(); usage != 4 {
+		t.Fatalf("Expected usage of 4 bytes, got %d", usage)
+	}
+	if _, err := store.Get(ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids[1:] {
+		if _, err := store.Get(id); err != ErrNotFound {
+			t.Fatalf("Expected Item %q to be deleted, got %v", id, err)
+		}
+	}
+	if len(deleted) != 2 {
+		t.Fatalf("Expected two deleted Items, got %v", deleted)
+	}
+}
+
+func TestStoreCleanupInterval(t *testing.T) {
+	expired := make(chan string, 2)
+	opts := StoreOpts{
+		AutoCleanup:     true,
+		CleanupInterval: 10 * time.Millisecond,
+		DeletionHook: func(event ItemEvent, i Item) {
+			if event == ItemExpired {
+				expired <- i.ID
+			}
+		},
+	}
+
+	stores := map[string]Storer{
+		"badger": func() Storer {
+			store, err := NewStore(t.TempDir(), randomIdGenerator(4), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		}(),
+		"memory": NewMemoryStore(randomIdGenerator(4), opts),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			defer store.Close()
+
+			// Each expired Item must be deleted by another run of the job.
+			for _, data := range []string{"hello", "world"} {
+				id, err := store.Put(Item{Expires: time.Now().Add(-time.Hour).UTC()},
+					newDummyReadCloser(bytes.NewBufferString(data)))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				select {
+				case expiredId := <-expired:
+					if expiredId != id {
+						t.Fatalf("Expected expired Item %q, got %q", id, expiredId)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("Expired Item %q was not deleted", id)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreCleanupIntervalDisabled(t *testing.T) {
+	store, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{AutoCleanup: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if store.stopSyn != nil {
+		t.Fatal("Expected no background cleanup job")
+	}
+
+	id, err := store.Put(Item{Expires: time.Now().Add(-time.Hour).UTC()},
+		newDummyReadCloser(bytes.NewBufferString("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(id); err != ErrNotFound {
+		t.Fatalf("Expected expired Item to be deleted on retrieval, got %v", err)
+	}
+}
+
+func TestStoreDeleteExpiredBatch(t *testing.T) {
+	var expired []string
+	store, err := NewStore(t.TempDir(), randomIdGenerator(8), StoreOpts{
+		DeletionHook: func(event ItemEvent, i Item) {
+			if event == ItemExpired {
+				expired = append(expired, i.ID)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// Most Items share one file, while the other two own their files.
+	var ids []string
+	for n := 0; n < expiryBatchSize+2; n++ {
+		data := "shared"
+		if n >= expiryBatchSize {
+			data = fmt.Sprintf("own %d", n)
+		}
+
+		id, err := store.Put(
+			Item{Expires: time.Now().Add(-time.Hour).UTC()},
+			newDummyReadCloser(bytes.NewBufferString(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	// A failing file must not abort the deletion of the others.
+	failing := testFileBackend(t, store).filePath(ids[len(ids)-1])
+	testFileBackend(t, store).removeFile = func(name string) error {
+		if name == failing {
+			return errors.New("permanent I/O error")
+		}
+		return os.Remove(name)
+	}
+
+	errFirst := store.deleteExpired()
+	if len(expired) != expiryBatchSize {
+		t.Fatalf("Expected %d expired Items of the first batch, got %d", expiryBatchSize, len(expired))
+	}
+
+	// The failing file belongs to either batch.
+	errSecond := store.deleteExpired()
+	if (errFirst == nil) == (errSecond == nil) {
+		t.Fatalf("Expected one file deletion error, got %v and %v", errFirst, errSecond)
+	}
+	if len(expired) != len(ids) {
+		t.Fatalf("Expected %d expired Items, got %d", len(ids), len(expired))
+	}
+
+	if count, err := store.bh.Count(&Item{}, nil); err != nil {
+		t.Fatal(err)
+	} else if count != 0 {
+		t.Fatalf("Expected no Items, got %d", count)
+	}
+	if n := countStoredFiles(t, store); n != 1 {
+		t.Fatalf("Expected only the failing file, got %d files", n)
+	}
+	if usage := store.Usage(); usage != 0 {
+		t.Fatalf("Expected usage of 0 bytes, got %d", usage)
+	}
+}
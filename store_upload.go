@@ -0,0 +1,282 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/akamensky/base58"
+	"github.com/timshannon/badgerhold/v4"
+)
+
+// ErrUploadOffset is returned for an Upload being appended at another offset
+// than its current Offset, or being completed before all data was received.
+var ErrUploadOffset = errors.New("Upload offset mismatches")
+
+// ErrUploadLength is returned if more data is appended to an Upload than its
+// Length allows.
+var ErrUploadLength = errors.New("Upload exceeds its length")
+
+// ErrUploadBusy is returned for an Upload which is already being appended to,
+// completed, or deleted by another request.
+var ErrUploadBusy = errors.New("Upload is busy")
+
+// Upload is a resumable upload. Its file is staged in the Store until it was
+// received completely and is put as a new Item.
+type Upload struct {
+	Token string
+
+	// Item to be put after completion, without an ID yet.
+	Item Item
+
+	// Length is the file's total size, while Offset is the size received yet.
+	Length int64
+	Offset int64
+
+	// Expires is the deadline to complete this Upload before it is dropped.
+	Expires time.Time
+
+	// Head are the file's first bytes, up to sniffLen, e.g., for sniffing its
+	// Content-Type. It is only set when being returned by GetUpload.
+	Head []byte
+}
+
+// newUploadToken creates a random and unguessable token for a new Upload, as
+// it authorizes its access.
+func newUploadToken() (string, error) {
+	buff := make([]byte, 24)
+	if _, err := rand.Read(buff); err != nil {
+		return "", err
+	}
+	return string(base58.Encode(buff)), nil
+}
+
+// uploadsDir returns the subdirectory for the staged Uploads' files.
+func (s *Store) uploadsDir() string {
+	return filepath.Join(s.baseDir, DirUploads)
+}
+
+// uploadPath returns the path of an Upload's staged file.
+func (s *Store) uploadPath(token string) string {
+	return filepath.Join(s.uploadsDir(), token)
+}
+
+// lockUpload marks an Upload as being busy or returns ErrUploadBusy if it
+// already is. It must be released by unlockUpload.
+func (s *Store) lockUpload(token string) error {
+	s.uploadsMu.Lock()
+	defer s.uploadsMu.Unlock()
+
+	if _, busy := s.uploadsBusy[token]; busy {
+		return ErrUploadBusy
+	}
+	s.uploadsBusy[token] = struct{}{}
+	return nil
+}
+
+// unlockUpload releases an Upload locked by lockUpload.
+func (s *Store) unlockUpload(token string) {
+	s.uploadsMu.Lock()
+	defer s.uploadsMu.Unlock()
+
+	delete(s.uploadsBusy, token)
+}
+
+// getUpload returns an unexpired Upload without its Head.
+func (s *Store) getUpload(token string) (u Upload, err error) {
+	err = s.bh.Get(token, &u)
+	if err == badgerhold.ErrNotFound || (err == nil && u.Expires.Before(time.Now())) {
+		return Upload{}, ErrNotFound
+	}
+	return
+}
+
+// CreateUpload stages a new Upload of an Item's file of the given length and
+// returns its token. It must be completed before it expires.
+func (s *Store) CreateUpload(i Item, length int64, expires time.Time) (string, error) {
+	token, err := newUploadToken()
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.OpenFile(s.uploadPath(token), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	_ = f.Close()
+
+	u := Upload{Token: token, Item: i, Length: length, Expires: expires.UTC()}
+	if err := s.bh.Insert(token, u); err != nil {
+		_ = os.Remove(s.uploadPath(token))
+		return "", err
+	}
+
+	slog.Debug("Created Upload", slog.Int64("length", length), slog.Any("expires", u.Expires))
+	return token, nil
+}
+
+// GetUpload returns an Upload by its token, including its Head.
+func (s *Store) GetUpload(token string) (Upload, error) {
+	u, err := s.getUpload(token)
+	if err != nil {
+		return Upload{}, err
+	}
+
+	f, err := os.Open(s.uploadPath(token))
+	if err != nil {
+		return Upload{}, err
+	}
+	defer f.Close()
+
+	u.Head = make([]byte, min(u.Offset, sniffLen))
+	if _, err := io.ReadFull(f, u.Head); err != nil {
+		return Upload{}, err
+	}
+	return u, nil
+}
+
+// AppendUpload appends the file's data to an Upload at the offset, which must
+// be its current Offset. The file will be closed.
+//
+// The new Offset is returned. All data received is kept, even if the file was
+// cut short, e.g., by a disconnected uploader, allowing to resume the Upload.
+// Data exceeding the Upload's Length results in ErrUploadLength.
+func (s *Store) AppendUpload(token string, offset int64, file io.ReadCloser) (int64, error) {
+	defer file.Close()
+
+	if err := s.lockUpload(token); err != nil {
+		return 0, err
+	}
+	defer s.unlockUpload(token)
+
+	u, err := s.getUpload(token)
+	if err != nil {
+		return 0, err
+	} else if offset != u.Offset {
+		return u.Offset, ErrUploadOffset
+	}
+
+	f, err := os.OpenFile(s.uploadPath(token), os.O_WRONLY, 0)
+	if err != nil {
+		return u.Offset, err
+	}
+	defer f.Close()
+
+	// Drop any data beyond the Offset, e.g., after a previous failure.
+	if err := f.Truncate(u.Offset); err != nil {
+		return u.Offset, err
+	}
+	if _, err := f.Seek(u.Offset, io.SeekStart); err != nil {
+		return u.Offset, err
+	}
+
+	n, err := io.Copy(f, io.LimitReader(file, u.Length-u.Offset))
+	if err == nil {
+		if extra, _ := file.Read(make([]byte, 1)); extra > 0 {
+			return u.Offset, ErrUploadLength
+		}
+	}
+	if syncErr := f.Sync(); syncErr != nil {
+		return u.Offset, errors.Join(err, syncErr)
+	}
+
+	u.Offset += n
+	if updateErr := s.bh.Update(token, u); updateErr != nil {
+		return u.Offset - n, errors.Join(err, updateErr)
+	}
+	return u.Offset, err
+}
+
+// CompleteUpload puts the file of a completely received Upload as the given
+// Item, e.g., its staged Item with a sniffed ContentType, and returns the new
+// ID. An incomplete Upload results in ErrUploadOffset. If strip is set, the
+// staged file's metadata is stripped, keeping the original file on failure.
+//
+// Only after being put successfully, the Upload is removed.
+func (s *Store) CompleteUpload(token string, i Item, strip bool) (string, error) {
+	if err := s.lockUpload(token); err != nil {
+		return "", err
+	}
+	defer s.unlockUpload(token)
+
+	u, err := s.getUpload(token)
+	if err != nil {
+		return "", err
+	} else if u.Offset != u.Length {
+		return "", ErrUploadOffset
+	}
+
+	var f io.ReadCloser
+	f, err = os.Open(s.uploadPath(token))
+	if err != nil {
+		return "", err
+	}
+
+	i.Size = u.Length
+	if strip {
+		// A stripped file's Size is only known after being put.
+		var stripped bool
+		if f, stripped = stripMetadataFile(f, i.ContentType); stripped {
+			i.Size = 0
+		}
+	}
+
+	id, err := s.Put(i, f)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.deleteUpload(token); err != nil {
+		slog.Error("Failed to delete completed Upload", slog.String("id", id), slog.Any("error", err))
+	}
+	return id, nil
+}
+
+// DeleteUpload drops an Upload and its staged file.
+func (s *Store) DeleteUpload(token string) error {
+	if err := s.lockUpload(token); err != nil {
+		return err
+	}
+	defer s.unlockUpload(token)
+
+	if _, err := s.getUpload(token); err != nil {
+		return err
+	}
+	return s.deleteUpload(token)
+}
+
+// deleteUpload removes an Upload, which must be locked or expired.
+func (s *Store) deleteUpload(token string) error {
+	err := os.Remove(s.uploadPath(token))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return s.bh.Delete(token, Upload{})
+}
+
+// deleteExpiredUploads drops all Uploads which were not completed in time.
+func (s *Store) deleteExpiredUploads() error {
+	var uploads []Upload
+	err := s.bh.Find(&uploads, badgerhold.Where("Expires").Lt(time.Now()))
+	if err != nil {
+		return err
+	}
+
+	for _, u := range uploads {
+		if err := s.lockUpload(u.Token); err != nil {
+			continue
+		}
+
+		slog.Debug("Delete expired Upload")
+		err := s.deleteUpload(u.Token)
+		s.unlockUpload(u.Token)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
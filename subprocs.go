@@ -49,8 +49,9 @@ func socketpair() (parent, child *os.File, err error) {
 // forkChild forks off a subprocess for the given child subroutine.
 //
 // The child process' output will be printed to this process' output. The
-// extraFiles are additional file descriptors for communication.
-func forkChild(child string, extraFiles []*os.File) (*os.Process, error) {
+// extraFiles are additional file descriptors for communication and extraArgs
+// are additional command line arguments for the child.
+func forkChild(child string, extraFiles []*os.File, extraArgs ...string) (*os.Process, error) {
 	logParent, logChild, err := pipe2()
 	if err != nil {
 		return nil, err
@@ -102,9 +103,13 @@ func forkChild(child string, extraFiles []*os.File) (*os.Process, error) {
 		}
 	}()
 
-	cmd := exec.Command(os.Args[0], append(os.Args[1:], "-fork-child", child)...)
+	args := append([]string{}, os.Args[1:]...)
+	args = append(args, "-fork-child", child)
+	args = append(args, extraArgs...)
 
-	cmd.Env = []string{}
+	cmd := exec.Command(os.Args[0], args...)
+
+	cmd.Env = configEnviron()
 	cmd.Stdin = nil
 	cmd.Stdout = logChild
 	cmd.Stderr = logChild
@@ -186,3 +191,76 @@ func posixPermDrop(chroot, username, groupname string) error {
 
 	return nil
 }
+
+// raiseNofileLimit raises the soft limit of RLIMIT_NOFILE, the maximum number
+// of open file descriptors, to its hard limit and returns the new limit.
+func raiseNofileLimit() (unix.Rlimit, error) {
+	var lim unix.Rlimit
+	err := unix.Getrlimit(unix.RLIMIT_NOFILE, &lim)
+	if err != nil {
+		return lim, fmt.Errorf("getrlimit: %w", err)
+	}
+
+	if lim.Cur == lim.Max {
+		return lim, nil
+	}
+
+	lim.Cur = lim.Max
+	err = unix.Setrlimit(unix.RLIMIT_NOFILE, &lim)
+	if err != nil {
+		return lim, fmt.Errorf("setrlimit: %w", err)
+	}
+	return lim, nil
+}
+
+// nofileReserve are file descriptors reserved for each process' basic needs,
+// e.g., its standard streams, sockets to other processes, and log files.
+const nofileReserve = 64
+
+// requiredNofile calculates the RLIMIT_NOFILE soft limit required by the
+// configured concurrency. Each web server connection holds a socket and, while
+// serving a download, the file passed by the store. Each concurrent FD
+// transfer holds an open file within the store.
+//
+// If either the connections or FD transfers are unlimited, no limit can be
+// calculated and false is returned.
+func requiredNofile(conf Config) (uint64, bool) {
+	if conf.Webserver.MaxConnections <= 0 || conf.Store.MaxFdTransfers <= 0 {
+		return 0, false
+	}
+
+	webserver := 2 * uint64(conf.Webserver.MaxConnections)
+	store := uint64(conf.Store.MaxFdTransfers)
+	return nofileReserve + max(webserver, store), true
+}
+
+// setRlimitValue assigns to a unix.Rlimit field, being an int64 on FreeBSD
+// and an uint64 elsewhere.
+func setRlimitValue[T int64 | uint64](field *T, value uint64) {
+	*field = T(value)
+}
+
+// ensureNofileLimit raises the soft limit of RLIMIT_NOFILE to at least the
+// required limit and returns the effective limit. If the hard limit is below
+// the required limit, an error is returned.
+func ensureNofileLimit(required uint64) (unix.Rlimit, error) {
+	var lim unix.Rlimit
+	err := unix.Getrlimit(unix.RLIMIT_NOFILE, &lim)
+	if err != nil {
+		return lim, fmt.Errorf("getrlimit: %w", err)
+	}
+
+	if uint64(lim.Max) < required {
+		return lim, fmt.Errorf("hard file descriptor limit %d is below the required %d", lim.Max, required)
+	}
+	if uint64(lim.Cur) >= required {
+		return lim, nil
+	}
+
+	setRlimitValue(&lim.Cur, required)
+	err = unix.Setrlimit(unix.RLIMIT_NOFILE, &lim)
+	if err != nil {
+		return lim, fmt.Errorf("setrlimit: %w", err)
+	}
+	return lim, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// traceSpan is an operation within a trace, identified as by the W3C Trace
+// Context, e.g., an HTTP request or a store request on its behalf.
+//
+// A trace spans both the web server and the store. Thus, its context is passed
+// along the store's RPC as a traceparent. Each span is logged when it ends,
+// allowing to follow a slow request into the store by its trace_id.
+//
+// All methods accept a nil *traceSpan, being an untraced operation.
+type traceSpan struct {
+	name     string
+	traceId  [16]byte
+	spanId   [8]byte
+	parentId [8]byte
+	start    time.Time
+}
+
+// parseTraceparent parses a traceparent, "00-TRACE_ID-PARENT_ID-FLAGS".
+func parseTraceparent(traceparent string) (traceId [16]byte, parentId [8]byte, err error) {
+	parts := strings.Split(traceparent, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		err = fmt.Errorf("invalid traceparent %q", traceparent)
+		return
+	}
+
+	if _, err = hex.Decode(traceId[:], []byte(parts[1])); err != nil {
+		return
+	}
+	if _, err = hex.Decode(parentId[:], []byte(parts[2])); err != nil {
+		return
+	}
+	if traceId == [16]byte{} || parentId == [8]byte{} {
+		err = fmt.Errorf("invalid traceparent %q", traceparent)
+	}
+	return
+}
+
+// newTraceSpan starts a span as the child of a traceparent, e.g., from an
+// HTTP request's header. For an empty or invalid traceparent, a new trace is
+// started.
+func newTraceSpan(name, traceparent string) *traceSpan {
+	span := &traceSpan{name: name, start: time.Now()}
+
+	traceId, parentId, err := parseTraceparent(traceparent)
+	if err == nil {
+		span.traceId, span.parentId = traceId, parentId
+	} else {
+		_, _ = rand.Read(span.traceId[:])
+	}
+	_, _ = rand.Read(span.spanId[:])
+
+	return span
+}
+
+// remoteTraceSpan starts a span as the child of a traceparent passed by
+// another process. Without a valid traceparent, the operation is untraced and
+// nil is returned.
+func remoteTraceSpan(name, traceparent string) *traceSpan {
+	if _, _, err := parseTraceparent(traceparent); err != nil {
+		return nil
+	}
+	return newTraceSpan(name, traceparent)
+}
+
+// child starts a new span within the same trace.
+func (span *traceSpan) child(name string) *traceSpan {
+	if span == nil {
+		return nil
+	}
+	return newTraceSpan(name, span.traceparent())
+}
+
+// traceparent identifies this span as the parent of another process' span.
+func (span *traceSpan) traceparent() string {
+	if span == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%x-%x-01", span.traceId, span.spanId)
+}
+
+// end the span by logging it together with its duration and optional error.
+func (span *traceSpan) end(err error) {
+	if span == nil {
+		return
+	}
+
+	attrs := []any{
+		slog.String("span", span.name),
+		slog.String("trace_id", hex.EncodeToString(span.traceId[:])),
+		slog.String("span_id", hex.EncodeToString(span.spanId[:])),
+		slog.Float64("duration_ms", float64(time.Since(span.start).Microseconds())/1000),
+	}
+	if span.parentId != [8]byte{} {
+		attrs = append(attrs, slog.String("parent_id", hex.EncodeToString(span.parentId[:])))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+
+	slog.Info("Finished span", attrs...)
+}
+
+// traceSpanKey is the context.Context key of a traceSpan.
+type traceSpanKey struct{}
+
+// contextWithSpan returns a copy of the context carrying the span.
+func contextWithSpan(ctx context.Context, span *traceSpan) context.Context {
+	return context.WithValue(ctx, traceSpanKey{}, span)
+}
+
+// spanFromContext returns the context's span, or nil if it is untraced.
+func spanFromContext(ctx context.Context) *traceSpan {
+	span, _ := ctx.Value(traceSpanKey{}).(*traceSpan)
+	return span
+}
+
+// tracedArgs are RPC arguments carrying the traceparent of the calling span.
+type tracedArgs interface {
+	setTrace(traceparent string)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		traceparent string
+		valid       bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true},
+		{"", false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+	}
+
+	for _, test := range tests {
+		_, _, err := parseTraceparent(test.traceparent)
+		if (err == nil) != test.valid {
+			t.Fatalf("%q: expected valid %t, got error %v", test.traceparent, test.valid, err)
+		}
+	}
+}
+
+func TestTraceSpanChild(t *testing.T) {
+	parent := newTraceSpan("parent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if traceparent := parent.traceparent(); !strings.HasPrefix(traceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-") {
+		t.Fatalf("Expected the client's trace, got %q", traceparent)
+	}
+
+	child := remoteTraceSpan("child", parent.traceparent())
+	if child.traceId != parent.traceId || child.parentId != parent.spanId || child.spanId == parent.spanId {
+		t.Fatalf("Expected child of %v, got %v", parent, child)
+	}
+
+	if span := remoteTraceSpan("untraced", ""); span != nil {
+		t.Fatalf("Expected no span without a traceparent, got %v", span)
+	}
+	if span := (*traceSpan)(nil).child("untraced"); span != nil {
+		t.Fatalf("Expected no child of an untraced span, got %v", span)
+	}
+}
+
+func TestServerTracing(t *testing.T) {
+	server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+		conf.Tracing = true
+	})
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", []byte("hello world"), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	fetchUrl, err := url.Parse(strings.TrimSpace(rec.Body.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaultLogger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+
+	const traceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, fetchUrl.Path, nil)
+	req.Header.Set("traceparent", "00-"+traceId+"-00f067aa0ba902b7-01")
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	spans := make(map[string]map[string]any)
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		} else if record["msg"] == "Finished span" {
+			spans[record["span"].(string)] = record
+		}
+	}
+
+	// Each store operation is a child of its RPC call within the request.
+	for child, parent := range map[string]string{
+		"rpc Get":       "HTTP GET",
+		"store Get":     "rpc Get",
+		"rpc GetFile":   "HTTP GET",
+		"store GetFile": "rpc GetFile",
+	} {
+		if spans[child] == nil || spans[parent] == nil {
+			t.Fatalf("Expected spans %q and %q, got %v", child, parent, spans)
+		}
+		if spans[child]["trace_id"] != traceId {
+			t.Fatalf("Expected span %q within trace %q, got %v", child, traceId, spans[child])
+		}
+		if spans[child]["parent_id"] != spans[parent]["span_id"] {
+			t.Fatalf("Expected span %q as child of %q, got %v", child, parent, spans[child])
+		}
+	}
+	if spans["HTTP GET"]["parent_id"] != "00f067aa0ba902b7" {
+		t.Fatalf("Expected the client's span as parent, got %v", spans["HTTP GET"])
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tusVersion is the only supported version of the tus resumable upload
+// protocol, <https://tus.io/protocols/resumable-upload>.
+const tusVersion = "1.0.0"
+
+// tusExtensions are the supported extensions of the tus protocol.
+const tusExtensions = "creation,expiration,termination"
+
+// tusContentType is the required Content-Type of a PATCH request.
+const tusContentType = "application/offset+octet-stream"
+
+// tusFiletype is the Upload-Metadata key of the declared Content-Type. All
+// other keys are treated like the form fields of an upload, e.g., "filename".
+const tusFiletype = "filetype"
+
+// defaultTusLifetime is the time to complete a resumable upload, unless the
+// resumable_uploads lifetime is configured.
+const defaultTusLifetime = 24 * time.Hour
+
+// parseTusMetadata parses an Upload-Metadata header of comma-separated pairs
+// of a key and its base64 encoded value, which might be omitted.
+func parseTusMetadata(header string) (url.Values, error) {
+	form := make(url.Values)
+	if strings.TrimSpace(header) == "" {
+		return form, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, fmt.Errorf("metadata pair %q has no key", pair)
+		} else if form.Has(key) {
+			return nil, fmt.Errorf("metadata key %q is duplicated", key)
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("metadata value of %q is invalid: %w", key, err)
+		}
+		form.Set(key, string(decoded))
+	}
+	return form, nil
+}
+
+// setTusUploadHeaders sets the headers describing an Upload's state.
+func setTusUploadHeaders(w http.ResponseWriter, upload Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+}
+
+// httpTusError responds to a failed store request for an Upload.
+func httpTusError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, msgNotExists, http.StatusNotFound)
+
+	case errors.Is(err, ErrUploadOffset):
+		http.Error(w, msgUploadOffset, http.StatusConflict)
+
+	case errors.Is(err, ErrUploadBusy):
+		http.Error(w, msgUploadBusy, http.StatusLocked)
+
+	case errors.Is(err, ErrUploadLength):
+		http.Error(w, msgUploadExceeds, http.StatusRequestEntityTooLarge)
+
+	default:
+		slog.Error("Failed to handle Upload", slog.Any("error", err))
+
+		httpStoreError(w, err)
+	}
+}
+
+// handleTus serves resumable uploads by the tus protocol below the tusPath.
+//
+// An Upload is created by a POST on the tusPath itself, resulting in its own
+// URL, "<tusPath>/<token>". Its data is sent by PATCH requests, each resuming
+// at the Upload-Offset as being requested by HEAD. After its last byte was
+// received, the Upload becomes an Item.
+func (serv *Server) handleTus(w http.ResponseWriter, r *http.Request, subpath string) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(serv.itemOpts.MaxSize, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		slog.Debug("Rejected request of an unsupported tus version",
+			slog.String("version", r.Header.Get("Tus-Resumable")))
+
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, msgTusVersion, http.StatusPreconditionFailed)
+		return
+	}
+
+	if subpath == "" {
+		if r.Method != http.MethodPost {
+			httpMethodNotAllowed(w, http.MethodOptions, http.MethodPost)
+			return
+		}
+		serv.handleTusCreation(w, r)
+		return
+	}
+
+	token := strings.TrimPrefix(subpath, "/")
+	if token == "" || strings.Contains(token, "/") {
+		http.Error(w, msgNotExists, http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		if !serv.checkStoreReady(w) {
+			return
+		}
+
+		upload, err := serv.store.GetUpload(token, storeContext(r))
+		if err != nil {
+			httpTusError(w, err)
+			return
+		}
+
+		setTusUploadHeaders(w, upload)
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodPatch:
+		serv.handleTusPatch(w, r, token)
+
+	case http.MethodDelete:
+		if !serv.checkStoreReady(w) {
+			return
+		}
+
+		if err := serv.store.DeleteUpload(token, storeContext(r)); err != nil {
+			httpTusError(w, err)
+			return
+		}
+
+		slog.Info("Deleted Upload by request")
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		httpMethodNotAllowed(w, http.MethodOptions, http.MethodHead, http.MethodPatch, http.MethodDelete)
+	}
+}
+
+// handleTusCreation creates a new Upload based on its Upload-Length and the
+// Upload-Metadata, passing the same checks as a regular upload.
+//
+// As its file is not known yet, its Content-Type will be checked against it
+// only after the completion.
+func (serv *Server) handleTusCreation(w http.ResponseWriter, r *http.Request) {
+	if !serv.checkUploadOrigin(w, r) {
+		return
+	}
+	if !serv.checkUploadRate(w, r) {
+		return
+	}
+	if !serv.checkUploadAuth(w, r) {
+		return
+	}
+	if !serv.checkStoreReady(w) {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 1 {
+		slog.Info("New Upload without a valid Upload-Length was rejected")
+
+		http.Error(w, msgUploadLength, http.StatusBadRequest)
+		return
+	} else if length > serv.itemOpts.MaxSize {
+		slog.Info("New Upload with a too great file size was rejected")
+
+		http.Error(w, msgFileSizeExceeds, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	form, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		slog.Info("New Upload with invalid Upload-Metadata was rejected", slog.Any("error", err))
+
+		http.Error(w, msgUploadMetadata, http.StatusBadRequest)
+		return
+	}
+
+	item, err := newItemFromForm(r, form, "", serv.itemOpts)
+	if err != nil {
+		httpNewItemError(w, err)
+		return
+	}
+
+	item.ContentType = form.Get(tusFiletype)
+	if item.ContentType == "" && serv.itemOpts.MissingContentType == ContentTypeReject {
+		httpNewItemError(w, ErrContentTypeMissing)
+		return
+	} else if item.ContentType != "" && !serv.checkMimeDrop(w, item.ContentType) {
+		return
+	}
+
+	expires := time.Now().Add(serv.tusLifetime)
+	token, err := serv.store.CreateUpload(item, length, expires, storeContext(r))
+	if err != nil {
+		slog.Error("Failed to create Upload", slog.Any("error", err))
+
+		httpStoreError(w, err)
+		return
+	}
+
+	slog.Info("Created new Upload", slog.Int64("length", length), slog.Any("expires", expires))
+
+	location := fmt.Sprintf("%s://%s%s%s/%s", WebProtocol(r), r.Host, serv.urlPrefix, serv.tusPath, token)
+	w.Header().Set("Location", location)
+	w.Header().Set("Upload-Expires", expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleTusPatch appends the request's body to an Upload and completes it
+// after its last byte was received.
+//
+// A completed Upload, whose Item could not be put into the store, e.g., as it
+// is full, might be retried by another PATCH without any data.
+func (serv *Server) handleTusPatch(w http.ResponseWriter, r *http.Request, token string) {
+	if !serv.checkUploadSlot(w) {
+		return
+	}
+	defer serv.uploadSlots.release()
+
+	if !serv.checkStoreReady(w) {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != tusContentType {
+		http.Error(w, msgUploadContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, msgUploadOffset, http.StatusBadRequest)
+		return
+	}
+
+	_, err = serv.store.AppendUpload(token, offset, r.Body, storeContext(r))
+	if err != nil {
+		httpTusError(w, err)
+		return
+	}
+
+	upload, err := serv.store.GetUpload(token, storeContext(r))
+	if err != nil {
+		httpTusError(w, err)
+		return
+	}
+
+	setTusUploadHeaders(w, upload)
+	if upload.Offset < upload.Length {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	serv.completeTusUpload(w, r, upload)
+}
+
+// completeTusUpload puts a completely received Upload as a new Item. Its
+// Content-Type is determined now, as for a regular upload. If the Item is
+// rejected, the Upload is dropped.
+func (serv *Server) completeTusUpload(w http.ResponseWriter, r *http.Request, upload Upload) {
+	item := upload.Item
+
+	contentType, err := uploadContentType(item.ContentType, upload.Head, serv.itemOpts)
+	if err != nil || !serv.checkMimeDrop(w, contentType) {
+		if err != nil {
+			httpNewItemError(w, err)
+		}
+
+		if err := serv.store.DeleteUpload(upload.Token, storeContext(r)); err != nil {
+			slog.Error("Failed to delete rejected Upload", slog.Any("error", err))
+		}
+		return
+	}
+	item.ContentType = contentType
+
+	// The Item's lifetime starts with its completion, not with its Upload.
+	now := time.Now().UTC()
+	if !item.NeverExpires() {
+		item.Expires = now.Add(item.Expires.Sub(item.Created))
+	}
+	item.Created = now
+
+	// Stripping metadata would alter the file, invalidating its checksum. As
+	// the file is staged in the Store, it is stripped there.
+	stripMetadata := serv.stripMetadata && item.UploadChecksum.Algorithm == ""
+
+	itemId, err := serv.store.CompleteUpload(upload.Token, item, stripMetadata, storeContext(r))
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrUploadBusy) {
+		httpTusError(w, err)
+		return
+	} else if err != nil {
+		// A checksum mismatch cannot be fixed by retrying.
+		if errors.Is(err, ErrUploadChecksum) {
+			if err := serv.store.DeleteUpload(upload.Token, storeContext(r)); err != nil {
+				slog.Error("Failed to delete rejected Upload", slog.Any("error", err))
+			}
+		}
+
+		httpPutError(w, err)
+		return
+	}
+
+	slog.Info("Uploaded new Item",
+		slog.String("id", itemId), slog.Any("expires", item.Expires))
+
+	serv.metrics.uploads.Add(1)
+	serv.metrics.uploadSizes.observe(upload.Length)
+
+	item.ID = itemId
+	item.Size = upload.Length
+	if stripMetadata {
+		// The stripped file might be smaller than the Upload.
+		if stored, err := serv.store.Get(itemId, storeContext(r)); err == nil {
+			item.Size = stored.Size
+		}
+	}
+	serv.notify(ItemCreated, item)
+
+	fetchUrl, deleteUrl := serv.itemUrls(r, item)
+
+	w.Header().Set("X-Fetch-URL", fetchUrl)
+	if deleteUrl != "" {
+		w.Header().Set("X-Delete-URL", deleteUrl)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
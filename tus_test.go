@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTusMetadata(t *testing.T) {
+	b64 := base64.StdEncoding.EncodeToString
+
+	tests := []struct {
+		header string
+		form   url.Values
+		valid  bool
+	}{
+		{"", url.Values{}, true},
+		{"filename " + b64([]byte("a.txt")), url.Values{"filename": {"a.txt"}}, true},
+		{"filename " + b64([]byte("a.txt")) + ", burn " + b64([]byte("1")) + ",empty",
+			url.Values{"filename": {"a.txt"}, "burn": {"1"}, "empty": {""}}, true},
+		{"filename nope!", nil, false},
+		{"filename,filename", nil, false},
+		{"a,,b", nil, false},
+	}
+
+	for _, test := range tests {
+		form, err := parseTusMetadata(test.header)
+		if (err == nil) != test.valid {
+			t.Fatalf("%q: expected valid %t, got %v", test.header, test.valid, err)
+		} else if test.valid && !reflect.DeepEqual(form, test.form) {
+			t.Fatalf("%q: expected %v, got %v", test.header, test.form, form)
+		}
+	}
+}
+
+// newTusRequest creates a tus request with the Tus-Resumable header.
+func newTusRequest(method, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	if method == http.MethodPatch {
+		req.Header.Set("Content-Type", tusContentType)
+	}
+	return req
+}
+
+// createTusUpload creates an Upload and returns its path.
+func createTusUpload(t *testing.T, server *Server, length int, metadata string) string {
+	req := newTusRequest(http.MethodPost, "/-/tus", nil)
+	req.Header.Set("Upload-Length", strconv.Itoa(length))
+	req.Header.Set("Upload-Metadata", metadata)
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	} else if !strings.HasPrefix(location.Path, "/-/tus/") {
+		t.Fatalf("Unexpected Location %q", location)
+	}
+	return location.Path
+}
+
+func TestServerTus(t *testing.T) {
+	store, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServerStorer(t, store, func(conf *WebserverConfig) {
+		conf.ResumableUploads.Path = "/-/tus"
+		conf.idGenerator = IdGeneratorConfig{Type: "random", Length: 4}
+	})
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, "/-/tus", nil))
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Tus-Max-Size") != strconv.Itoa(1<<20) {
+		t.Fatalf("Unexpected OPTIONS response %d: %v", rec.Code, rec.Header())
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/-/tus", nil))
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("Expected status %d without Tus-Resumable, got %d", http.StatusPreconditionFailed, rec.Code)
+	}
+
+	metadata := "filename " + base64.StdEncoding.EncodeToString([]byte("hello.txt")) +
+		",filetype " + base64.StdEncoding.EncodeToString([]byte("text/plain"))
+	path := createTusUpload(t, server, 11, metadata)
+
+	patch := func(offset int, data string) *httptest.ResponseRecorder {
+		req := newTusRequest(http.MethodPatch, path, strings.NewReader(data))
+		req.Header.Set("Upload-Offset", strconv.Itoa(offset))
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := patch(0, "hello"); rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "5" {
+		t.Fatalf("Unexpected PATCH response %d: %v", rec.Code, rec.Header())
+	}
+	if rec := patch(0, "hello"); rec.Code != http.StatusConflict {
+		t.Fatalf("Expected status %d for a wrong offset, got %d", http.StatusConflict, rec.Code)
+	}
+	if rec := patch(5, " world, and more"); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected status %d for exceeding data, got %d", http.StatusRequestEntityTooLarge, rec.Code)
+	}
+
+	// A client resumes after requesting the current offset.
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, newTusRequest(http.MethodHead, path, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Upload-Offset") != "5" || rec.Header().Get("Upload-Length") != "11" {
+		t.Fatalf("Unexpected HEAD response %d: %v", rec.Code, rec.Header())
+	}
+
+	rec = patch(5, " world")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, rec.Code, rec.Body.String())
+	}
+
+	fetchUrl, err := url.Parse(rec.Header().Get("X-Fetch-URL"))
+	if err != nil || rec.Header().Get("X-Delete-URL") == "" {
+		t.Fatalf("Missing URLs in %v", rec.Header())
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fetchUrl.Path, nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello world" {
+		t.Fatalf("Unexpected download %d: %q", rec.Code, rec.Body.String())
+	} else if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Fatalf("Unexpected Content-Type %q", contentType)
+	} else if !strings.Contains(rec.Header().Get("Content-Disposition"), "hello.txt") {
+		t.Fatalf("Unexpected Content-Disposition %q", rec.Header().Get("Content-Disposition"))
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, newTusRequest(http.MethodHead, path, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected completed Upload to be gone, got status %d", rec.Code)
+	}
+}
+
+func TestServerTusRejected(t *testing.T) {
+	server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+		conf.ResumableUploads.Path = "/-/tus"
+		conf.idGenerator = IdGeneratorConfig{Type: "random", Length: 4}
+		conf.ItemConfig.MimeDrop = []string{"text/html"}
+		conf.ItemConfig.MissingContentType.Mode = string(ContentTypeSniff)
+	})
+
+	create := func(length, metadata string) int {
+		req := newTusRequest(http.MethodPost, "/-/tus", nil)
+		req.Header.Set("Upload-Length", length)
+		req.Header.Set("Upload-Metadata", metadata)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	b64 := base64.StdEncoding.EncodeToString
+	tests := []struct {
+		length   string
+		metadata string
+		expected int
+	}{
+		{"", "", http.StatusBadRequest},
+		{"0", "", http.StatusBadRequest},
+		{strconv.Itoa(1<<20 + 1), "", http.StatusRequestEntityTooLarge},
+		{"5", "filetype !", http.StatusBadRequest},
+		{"5", "filetype " + b64([]byte("text/html")), http.StatusBadRequest},
+		{"5", "time " + b64([]byte("2h")), http.StatusNotAcceptable},
+	}
+	for _, test := range tests {
+		if code := create(test.length, test.metadata); code != test.expected {
+			t.Fatalf("%q, %q: expected status %d, got %d", test.length, test.metadata, test.expected, code)
+		}
+	}
+
+	// Without a filetype, the completed file is sniffed and might be dropped.
+	data := []byte("<html><body>hi</body></html>")
+	path := createTusUpload(t, server, len(data), "")
+
+	req := newTusRequest(http.MethodPatch, path, bytes.NewReader(data))
+	req.Header.Set("Upload-Offset", "0")
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d for a dropped MIME, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, newTusRequest(http.MethodHead, path, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected rejected Upload to be gone, got status %d", rec.Code)
+	}
+
+	// An unfinished Upload might be terminated.
+	path = createTusUpload(t, server, 5, "")
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, newTusRequest(http.MethodDelete, path, nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, newTusRequest(http.MethodDelete, path, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestServerTusStripMetadata(t *testing.T) {
+	store, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServerStorer(t, store, func(conf *WebserverConfig) {
+		conf.ResumableUploads.Path = "/-/tus"
+		conf.idGenerator = IdGeneratorConfig{Type: "random", Length: 4}
+		conf.ItemConfig.StripMetadata = true
+	})
+
+	secret := []byte("GPS 52.5200 N 13.4050 E")
+	data := testJpegWithExif(t, secret)
+
+	metadata := "filetype " + base64.StdEncoding.EncodeToString([]byte("image/jpeg"))
+	path := createTusUpload(t, server, len(data), metadata)
+
+	req := newTusRequest(http.MethodPatch, path, bytes.NewReader(data))
+	req.Header.Set("Upload-Offset", "0")
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, rec.Code, rec.Body.String())
+	}
+
+	fetchUrl, err := url.Parse(rec.Header().Get("X-Fetch-URL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fetchUrl.Path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected download %d: %q", rec.Code, rec.Body.String())
+	} else if bytes.Contains(rec.Body.Bytes(), secret) {
+		t.Fatal("Metadata were not stripped")
+	} else if rec.Body.Len() >= len(data) {
+		t.Fatalf("Stripped image is not smaller, %d >= %d", rec.Body.Len(), len(data))
+	}
+}
+
+func TestNewServerResumableUploadsPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*WebserverConfig)
+	}{
+		{"trailing slash", func(conf *WebserverConfig) { conf.ResumableUploads.Path = "/-/tus/" }},
+		{"item ID", func(conf *WebserverConfig) { conf.ResumableUploads.Path = "/tus" }},
+		{"metrics below", func(conf *WebserverConfig) { conf.MetricsPath = "/-/tus/metrics" }},
+		{"below admin", func(conf *WebserverConfig) {
+			conf.Admin.Path = "/-"
+			conf.Admin.Token = "secret"
+		}},
+	}
+
+	for _, test := range tests {
+		var conf WebserverConfig
+		conf.ItemConfig.MaxSize = "1MiB"
+		conf.ItemConfig.MaxLifetime = time.Hour
+		conf.ResumableUploads.Path = "/-/tus"
+		conf.idGenerator = IdGeneratorConfig{Type: "random", Length: 4}
+		test.modify(&conf)
+
+		if _, err := NewServer(nil, conf, ""); err == nil {
+			t.Fatalf("%s: expected an error", test.name)
+		}
+	}
+}
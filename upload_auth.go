@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUnauthorized = errors.New("Upload is not authorized")
+
+// UploadAuthConfig configures the UploadAuthorizer of the given Type.
+type UploadAuthConfig struct {
+	Type string
+
+	// Token for the "token" UploadAuthorizer.
+	Token string
+
+	// Realm and Users, mapping user names to bcrypt hashes, for the "basic"
+	// UploadAuthorizer.
+	Realm string
+	Users map[string]string
+}
+
+// UploadAuthorizer decides if an upload request is authorized. It is called
+// before the request's body is read.
+type UploadAuthorizer interface {
+	// Authorize returns nil for an authorized request and ErrUnauthorized
+	// otherwise.
+	Authorize(r *http.Request) error
+}
+
+// uploadChallenger is an optional interface of an UploadAuthorizer to send a
+// WWW-Authenticate header for unauthorized requests.
+type uploadChallenger interface {
+	Challenge() string
+}
+
+// uploadAuthorizers is the registry of UploadAuthorizers by their type.
+var uploadAuthorizers = map[string]func(UploadAuthConfig) (UploadAuthorizer, error){
+	"none":  newNoneAuthorizer,
+	"token": newTokenAuthorizer,
+	"basic": newBasicAuthorizer,
+}
+
+// NewUploadAuthorizer creates the configured UploadAuthorizer from the
+// registry. An empty type defaults to "none".
+func NewUploadAuthorizer(conf UploadAuthConfig) (UploadAuthorizer, error) {
+	authType := conf.Type
+	if authType == "" {
+		authType = "none"
+	}
+
+	newAuthorizer, ok := uploadAuthorizers[authType]
+	if !ok {
+		types := make([]string, 0, len(uploadAuthorizers))
+		for t := range uploadAuthorizers {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+
+		return nil, fmt.Errorf("unknown upload_auth type %q, supported are %s", conf.Type, strings.Join(types, ", "))
+	}
+	return newAuthorizer(conf)
+}
+
+// noneAuthorizer authorizes each upload.
+type noneAuthorizer struct{}
+
+func newNoneAuthorizer(_ UploadAuthConfig) (UploadAuthorizer, error) {
+	return noneAuthorizer{}, nil
+}
+
+func (noneAuthorizer) Authorize(_ *http.Request) error {
+	return nil
+}
+
+// tokenAuthorizer requires a shared token as a bearer token in the
+// Authorization header, e.g., "Authorization: Bearer TOKEN".
+type tokenAuthorizer struct {
+	// tokenHash is the token's SHA-256 hash, allowing a constant time
+	// comparison independent of the token's length.
+	tokenHash [sha256.Size]byte
+}
+
+func newTokenAuthorizer(conf UploadAuthConfig) (UploadAuthorizer, error) {
+	if conf.Token == "" {
+		return nil, fmt.Errorf("upload_auth type token requires a token")
+	}
+
+	return &tokenAuthorizer{tokenHash: sha256.Sum256([]byte(conf.Token))}, nil
+}
+
+func (ta *tokenAuthorizer) Authorize(r *http.Request) error {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ErrUnauthorized
+	}
+
+	tokenHash := sha256.Sum256([]byte(token))
+	if subtle.ConstantTimeCompare(tokenHash[:], ta.tokenHash[:]) != 1 {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+func (ta *tokenAuthorizer) Challenge() string {
+	return "Bearer"
+}
+
+// basicAuthorizer requires HTTP basic authentication against users with their
+// bcrypt hashed passwords.
+type basicAuthorizer struct {
+	realm string
+	users map[string][]byte
+}
+
+func newBasicAuthorizer(conf UploadAuthConfig) (UploadAuthorizer, error) {
+	if len(conf.Users) == 0 {
+		return nil, fmt.Errorf("upload_auth type basic requires users")
+	}
+
+	users := make(map[string][]byte, len(conf.Users))
+	for user, hash := range conf.Users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("upload_auth user %q has no valid bcrypt hash: %w", user, err)
+		}
+		users[user] = []byte(hash)
+	}
+
+	realm := conf.Realm
+	if realm == "" {
+		realm = "gosh"
+	}
+
+	return &basicAuthorizer{realm: realm, users: users}, nil
+}
+
+func (ba *basicAuthorizer) Authorize(r *http.Request) error {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return ErrUnauthorized
+	}
+
+	hash, ok := ba.users[user]
+	if !ok {
+		return ErrUnauthorized
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+func (ba *basicAuthorizer) Challenge() string {
+	return fmt.Sprintf("Basic realm=%q", ba.realm)
+}
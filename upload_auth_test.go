@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestUploadAuthorizers(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		conf      UploadAuthConfig
+		modify    func(*http.Request)
+		err       error
+		challenge string
+	}{
+		{"default", UploadAuthConfig{}, nil, nil, ""},
+		{"none", UploadAuthConfig{Type: "none"}, nil, nil, ""},
+
+		{"token", UploadAuthConfig{Type: "token", Token: "s3cr3t"},
+			func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cr3t") }, nil, "Bearer"},
+		{"token scheme case", UploadAuthConfig{Type: "token", Token: "s3cr3t"},
+			func(r *http.Request) { r.Header.Set("Authorization", "bearer s3cr3t") }, nil, "Bearer"},
+		{"token missing", UploadAuthConfig{Type: "token", Token: "s3cr3t"},
+			nil, ErrUnauthorized, "Bearer"},
+		{"token wrong", UploadAuthConfig{Type: "token", Token: "s3cr3t"},
+			func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cr3") }, ErrUnauthorized, "Bearer"},
+		{"token wrong scheme", UploadAuthConfig{Type: "token", Token: "s3cr3t"},
+			func(r *http.Request) { r.Header.Set("Authorization", "Basic s3cr3t") }, ErrUnauthorized, "Bearer"},
+
+		{"basic", UploadAuthConfig{Type: "basic", Users: map[string]string{"alice": string(hash)}},
+			func(r *http.Request) { r.SetBasicAuth("alice", "hunter2") }, nil, `Basic realm="gosh"`},
+		{"basic realm", UploadAuthConfig{Type: "basic", Realm: "uploads", Users: map[string]string{"alice": string(hash)}},
+			func(r *http.Request) { r.SetBasicAuth("alice", "hunter2") }, nil, `Basic realm="uploads"`},
+		{"basic missing", UploadAuthConfig{Type: "basic", Users: map[string]string{"alice": string(hash)}},
+			nil, ErrUnauthorized, `Basic realm="gosh"`},
+		{"basic wrong password", UploadAuthConfig{Type: "basic", Users: map[string]string{"alice": string(hash)}},
+			func(r *http.Request) { r.SetBasicAuth("alice", "hunter3") }, ErrUnauthorized, `Basic realm="gosh"`},
+		{"basic unknown user", UploadAuthConfig{Type: "basic", Users: map[string]string{"alice": string(hash)}},
+			func(r *http.Request) { r.SetBasicAuth("bob", "hunter2") }, ErrUnauthorized, `Basic realm="gosh"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			authorizer, err := NewUploadAuthorizer(test.conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			if test.modify != nil {
+				test.modify(r)
+			}
+
+			if err := authorizer.Authorize(r); err != test.err {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+
+			var challenge string
+			if challenger, ok := authorizer.(uploadChallenger); ok {
+				challenge = challenger.Challenge()
+			}
+			if challenge != test.challenge {
+				t.Fatalf("expected challenge %q, got %q", test.challenge, challenge)
+			}
+		})
+	}
+}
+
+func TestUploadAuthorizersInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		conf UploadAuthConfig
+	}{
+		{"unknown type", UploadAuthConfig{Type: "magic"}},
+		{"token without token", UploadAuthConfig{Type: "token"}},
+		{"basic without users", UploadAuthConfig{Type: "basic"}},
+		{"basic invalid hash", UploadAuthConfig{Type: "basic", Users: map[string]string{"alice": "hunter2"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewUploadAuthorizer(test.conf); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -24,6 +24,10 @@ const (
 	timeYear  time.Duration = 12 * timeMonth
 )
 
+// DurationNever is the lifetime of an Item without any automatic expiry. It is
+// parsed from "never" and exceeds each finite maximum lifetime.
+const DurationNever time.Duration = math.MaxInt64
+
 var (
 	durations = map[string]time.Duration{
 		"s":  time.Second,
@@ -43,13 +47,16 @@ var (
 )
 
 // getHtmlDurationPattern creates a regular expression pattern to match duration
-// strings within the browser.
-func getHtmlDurationPattern() string {
+// strings within the browser, also matching "never" if allowNever is set.
+func getHtmlDurationPattern(allowNever bool) string {
 	var b strings.Builder
 
 	for _, durElem := range durationsOrder {
 		_, _ = fmt.Fprintf(&b, `(\d+%s)?`, durElem)
 	}
+	if allowNever {
+		b.WriteString(`|never`)
+	}
 
 	return b.String()
 }
@@ -75,8 +82,13 @@ func getDurationPattern() *regexp.Regexp {
 // ParseDuration parses a (positive) duration string, similar to the
 // `time.ParseDuration` method. A duration string is sequence of decimal
 // numbers and a unit suffix. Valid time units are "s", "m", "h", "d", "w",
-// "mo", "y".
+// "mo", "y". The literal "never" results in DurationNever.
 func ParseDuration(s string) (d time.Duration, err error) {
+	if s == "never" {
+		d = DurationNever
+		return
+	}
+
 	pattern := getDurationPattern()
 	if s == "" || !pattern.MatchString(s) {
 		err = ErrNoMatch
@@ -102,6 +114,10 @@ func ParseDuration(s string) (d time.Duration, err error) {
 
 // PrettyDuration returns a human readable representation of a time.Duration.
 func PrettyDuration(d time.Duration) string {
+	if d == DurationNever {
+		return "unlimited"
+	}
+
 	var b strings.Builder
 
 	for i, elemKey := range durationsOrder {
@@ -126,47 +142,74 @@ func PrettyDuration(d time.Duration) string {
 var (
 	bytePrefixes = []string{"B", "K", "M", "G", "T", "P"}
 
-	bytePattern = regexp.MustCompile(`\A(?P<size>\d+)(?P<unit>([KMGTP]i?)?B)\z`)
+	bytePattern = regexp.MustCompile(`(?i)\A(?P<size>\d+(\.\d+)?)(?P<unit>([KMGTP]i?)?B)?\z`)
+
+	ErrBytesizeOverflow = errors.New("Byte size exceeds the maximum")
 )
 
-// ParseBytesize parses a positive, human readable and whole byte amount in the
-// binary prefix notation. Legit values might be "1B", "23KiB"/"23KB" etc.
+// ParseBytesize parses a non-negative, human readable byte amount in the
+// binary prefix notation. Legit values might be "1B", "23KiB"/"23KB",
+// "1.5mib" etc. A fractional amount is rounded to whole bytes. The unit might
+// only be omitted for a bare "0".
 func ParseBytesize(s string) (size int64, err error) {
 	if !bytePattern.MatchString(s) {
 		err = ErrNoMatch
 		return
 	}
 
-	var unit string
+	var sizeStr, unit string
 
 	parts := bytePattern.FindStringSubmatch(s)
 	for i, elemKey := range bytePattern.SubexpNames() {
 		switch elemKey {
 		case "size":
-			if sizeParse, sizeErr := strconv.Atoi(parts[i]); sizeErr != nil {
-				err = sizeErr
-				return
-			} else {
-				size = int64(sizeParse)
-			}
+			sizeStr = parts[i]
 
 		case "unit":
-			unit = parts[i][:1]
+			if parts[i] != "" {
+				unit = strings.ToUpper(parts[i][:1])
+			}
 		}
 	}
 
-	if size == 0 || unit == "" {
-		err = fmt.Errorf("Not all values were found, size := %d, unit := %s", size, unit)
+	if unit == "" {
+		if strings.Trim(sizeStr, "0.") != "" {
+			err = fmt.Errorf("Missing unit for non-zero size %q", sizeStr)
+		}
 		return
 	}
 
+	factor := int64(1)
 	for _, pref := range bytePrefixes {
 		if pref == unit {
 			break
 		}
-		size *= 1024
+		factor *= 1024
 	}
 
+	// Whole amounts are calculated exactly, without a float's imprecision.
+	if !strings.Contains(sizeStr, ".") {
+		size, err = strconv.ParseInt(sizeStr, 10, 64)
+		if err != nil {
+			return
+		} else if size > math.MaxInt64/factor {
+			err = ErrBytesizeOverflow
+			return
+		}
+		size *= factor
+		return
+	}
+
+	sizeFloat, err := strconv.ParseFloat(sizeStr, 64)
+	if err != nil {
+		return
+	}
+	sizeFloat = math.Round(sizeFloat * float64(factor))
+	if sizeFloat >= math.MaxInt64 {
+		err = ErrBytesizeOverflow
+		return
+	}
+	size = int64(sizeFloat)
 	return
 }
 
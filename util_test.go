@@ -26,6 +26,8 @@ func TestParseDuration(t *testing.T) {
 		{"1m10h", 0, false},
 		{"", 0, false},
 		{"-1m", 0, false},
+		{"never", DurationNever, true},
+		{"Never", 0, false},
 	}
 
 	for _, test := range tests {
@@ -55,6 +57,7 @@ func TestPrettyDuration(t *testing.T) {
 		{timeYear, "1 year"},
 		{12 * timeMonth, "1 year"},
 		{13 * timeMonth, "1 year 1 month"},
+		{DurationNever, "unlimited"},
 	}
 
 	for _, test := range tests {
@@ -75,8 +78,27 @@ func TestParseBytesize(t *testing.T) {
 		{"1MiB", 1024 * 1024, true},
 		{"23KiB", 23 * 1024, true},
 		{"1Mi", 0, false},
-		{"0B", 0, false},
 		{"", 0, false},
+		{"0B", 0, true},
+		{"0", 0, true},
+		{"0.0", 0, true},
+		{"1", 0, false},
+		{"1.5", 0, false},
+		{"1.5MiB", 1536 * 1024, true},
+		{"10.5MiB", 10*1024*1024 + 512*1024, true},
+		{"0.5KiB", 512, true},
+		{"1.5B", 2, true},
+		{"1.0001KiB", 1024, true},
+		{"1kib", 1024, true},
+		{"1mb", 1024 * 1024, true},
+		{"2Gb", 2 * 1024 * 1024 * 1024, true},
+		{"1.MiB", 0, false},
+		{".5MiB", 0, false},
+		{"-1B", 0, false},
+		{"8191PiB", 8191 * 1024 * 1024 * 1024 * 1024 * 1024, true},
+		{"8192PiB", 0, false},
+		{"8192.5PiB", 0, false},
+		{"99999999999999999999B", 0, false},
 	}
 
 	for _, test := range tests {
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// WebhookConfig configures a Webhook.
+type WebhookConfig struct {
+	Url       string        `yaml:"url"`
+	QueueSize int           `yaml:"queue_size"`
+	Interval  time.Duration `yaml:"interval"`
+
+	// Retries of a failed request, waiting Backoff before the first retry and
+	// doubling it for each further one, up to webhookMaxBackoff.
+	Retries int           `yaml:"retries"`
+	Backoff time.Duration `yaml:"backoff"`
+
+	// Secret signs each request's body by HMAC-SHA256, if set.
+	Secret Secret `yaml:"secret"`
+}
+
+// defaultWebhookBackoff is the WebhookConfig's default Backoff, which grows up
+// to webhookMaxBackoff.
+const (
+	defaultWebhookBackoff = time.Second
+	webhookMaxBackoff     = time.Minute
+)
+
+// webhookSignatureHeader carries the HMAC-SHA256 of a request's body as
+// "sha256=<hex>", if the Webhook has a Secret.
+const webhookSignatureHeader = "X-Gosh-Signature"
+
+// webhookEvent is the JSON payload being POSTed to a Webhook's URL.
+//
+// Only metadata are included, neither the file nor the uploader's IP address.
+type webhookEvent struct {
+	Event       ItemEvent `json:"event"`
+	Timestamp   time.Time `json:"timestamp"`
+	ID          string    `json:"id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	ContentHash string    `json:"content_hash"`
+	Size        int64     `json:"size"`
+	Created     time.Time `json:"created"`
+	Expires     time.Time `json:"expires"`
+}
+
+// Webhook notifies an external HTTP endpoint about ItemEvents.
+//
+// Notifications are best-effort. They are queued in a bounded queue and being
+// sent by a background goroutine, waiting at least the configured interval
+// between two requests. A failed request is retried with an exponential
+// backoff. If the queue is full, events are dropped.
+type Webhook struct {
+	url      string
+	interval time.Duration
+	retries  int
+	backoff  time.Duration
+	secret   Secret
+	client   *http.Client
+
+	queue   chan webhookEvent
+	stop    chan struct{}
+	stopAck chan struct{}
+}
+
+// NewWebhook creates a Webhook POSTing to the URL and starts its goroutine.
+//
+// As the Webhook might be used after chroot'ing, the system's certificate pool
+// is being loaded now. For the same reason, the URL's host should be an IP
+// address as no DNS resolver configuration might be available afterwards.
+func NewWebhook(conf WebhookConfig) (*Webhook, error) {
+	if conf.Url == "" {
+		return nil, fmt.Errorf("webhook URL is empty")
+	}
+	if conf.QueueSize <= 0 {
+		return nil, fmt.Errorf("webhook queue size must be positive, not %d", conf.QueueSize)
+	}
+	if conf.Retries < 0 || conf.Backoff < 0 {
+		return nil, fmt.Errorf("webhook retries and backoff must not be negative")
+	}
+	if err := conf.Secret.validate(); err != nil {
+		return nil, fmt.Errorf("webhook %w", err)
+	}
+
+	backoff := conf.Backoff
+	if backoff == 0 {
+		backoff = defaultWebhookBackoff
+	}
+
+	_, err := x509.SystemCertPool()
+	if err != nil {
+		slog.Warn("Failed to load system certificate pool for webhook", slog.Any("error", err))
+	}
+
+	wh := &Webhook{
+		url:      conf.Url,
+		interval: conf.Interval,
+		retries:  conf.Retries,
+		backoff:  backoff,
+		secret:   conf.Secret,
+		client:   &http.Client{Timeout: 10 * time.Second},
+
+		queue:   make(chan webhookEvent, conf.QueueSize),
+		stop:    make(chan struct{}),
+		stopAck: make(chan struct{}),
+	}
+
+	go wh.worker()
+
+	return wh, nil
+}
+
+// Notify queues an ItemEvent for an Item without blocking.
+//
+// It fits the StoreOpts.DeletionHook signature.
+func (wh *Webhook) Notify(event ItemEvent, item Item) {
+	ev := webhookEvent{
+		Event:       event,
+		Timestamp:   time.Now().UTC(),
+		ID:          item.ID,
+		Filename:    item.Filename,
+		ContentType: item.ContentType,
+		ContentHash: item.ContentHash,
+		Size:        item.Size,
+		Created:     item.Created,
+		Expires:     item.Expires,
+	}
+
+	select {
+	case wh.queue <- ev:
+	default:
+		slog.Warn("Webhook queue is full, dropping event",
+			slog.String("event", string(event)), slog.String("id", item.ID))
+	}
+}
+
+// worker sends the queued events until the queue is closed.
+func (wh *Webhook) worker() {
+	defer close(wh.stopAck)
+
+	var last time.Time
+	for ev := range wh.queue {
+		if wait := wh.interval - time.Since(last); wait > 0 {
+			time.Sleep(wait)
+		}
+		last = time.Now()
+
+		if err := wh.sendRetry(ev); err != nil {
+			slog.Warn("Failed to send webhook event",
+				slog.String("event", string(ev.Event)), slog.String("id", ev.ID),
+				slog.Any("error", err))
+		}
+	}
+}
+
+// sendRetry sends a single event, retrying it after an exponential backoff.
+// Retries are aborted when the Webhook is being closed.
+func (wh *Webhook) sendRetry(ev webhookEvent) error {
+	backoff := wh.backoff
+	for retry := 0; ; retry++ {
+		err := wh.send(ev)
+		if err == nil || retry >= wh.retries {
+			return err
+		}
+
+		slog.Debug("Retrying webhook event after a failure",
+			slog.String("event", string(ev.Event)), slog.String("id", ev.ID),
+			slog.Duration("backoff", backoff), slog.Any("error", err))
+
+		select {
+		case <-time.After(backoff):
+		case <-wh.stop:
+			return err
+		}
+		backoff = min(2*backoff, webhookMaxBackoff)
+	}
+}
+
+// sign the payload by the HMAC-SHA256 of the Webhook's Secret.
+func (wh *Webhook) sign(payload []byte) string {
+	mac := hmac.New(sha256.New, wh.secret)
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// send a single event to the webhook's URL.
+func (wh *Webhook) send(ev webhookEvent) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, wh.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(wh.secret) > 0 {
+		req.Header.Set(webhookSignatureHeader, wh.sign(payload))
+	}
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with HTTP status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close the Webhook after sending all queued events, without further retries.
+func (wh *Webhook) Close() error {
+	close(wh.stop)
+	close(wh.queue)
+	<-wh.stopAck
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	events := make(chan webhookEvent, 8)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("Expected POST, got %s", r.Method)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Expected JSON, got %q", ct)
+		}
+
+		var ev webhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Error(err)
+		}
+		events <- ev
+	}))
+	defer ts.Close()
+
+	wh, err := NewWebhook(WebhookConfig{Url: ts.URL, QueueSize: 8, Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item := Item{
+		ID:          "foo",
+		Filename:    "foo.txt",
+		ContentType: "text/plain",
+		Created:     time.Now().UTC(),
+		Expires:     time.Now().Add(time.Hour).UTC(),
+	}
+	wh.Notify(ItemDeleted, item)
+	wh.Notify(ItemExpired, item)
+
+	if err := wh.Close(); err != nil {
+		t.Fatal(err)
+	}
+	close(events)
+
+	expected := []ItemEvent{ItemDeleted, ItemExpired}
+	i := 0
+	for ev := range events {
+		if i >= len(expected) {
+			t.Fatalf("Received unexpected event %v", ev)
+		}
+		if ev.Event != expected[i] {
+			t.Fatalf("Expected event %s, got %s", expected[i], ev.Event)
+		}
+		if ev.ID != item.ID || ev.Filename != item.Filename || ev.ContentType != item.ContentType {
+			t.Fatalf("Event metadata mismatches: %v", ev)
+		}
+		if !ev.Created.Equal(item.Created) || !ev.Expires.Equal(item.Expires) {
+			t.Fatalf("Event times mismatch: %v", ev)
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Fatalf("Expected %d events, got %d", len(expected), i)
+	}
+}
+
+func TestWebhookQueueFull(t *testing.T) {
+	block := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer ts.Close()
+
+	wh, err := NewWebhook(WebhookConfig{Url: ts.URL, QueueSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 16; i++ {
+			wh.Notify(ItemDeleted, Item{ID: "foo"})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Notify blocked on a full queue")
+	}
+
+	close(block)
+	if err := wh.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhookRetrySignature(t *testing.T) {
+	secret := Secret(strings.Repeat("s", secretMinLength))
+
+	var requests atomic.Int32
+	events := make(chan webhookEvent, 8)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(payload)
+		if sig := r.Header.Get(webhookSignatureHeader); sig != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			t.Errorf("Invalid signature %q", sig)
+		}
+
+		// The first two requests fail and must be retried.
+		if requests.Add(1) <= 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var ev webhookEvent
+		if err := json.Unmarshal(payload, &ev); err != nil {
+			t.Error(err)
+		}
+		events <- ev
+	}))
+	defer ts.Close()
+
+	wh, err := NewWebhook(WebhookConfig{Url: ts.URL, QueueSize: 8, Retries: 2, Backoff: time.Millisecond, Secret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wh.Notify(ItemCreated, Item{ID: "foo", Size: 42})
+
+	// Closing the Webhook would abort the retries.
+	select {
+	case ev := <-events:
+		if ev.Event != ItemCreated || ev.ID != "foo" || ev.Size != 42 {
+			t.Fatalf("Unexpected event %v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No event was received")
+	}
+
+	if err := wh.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Fatalf("Expected 3 requests, got %d", n)
+	}
+}
+
+func TestWebhookInvalidConfig(t *testing.T) {
+	for _, conf := range []WebhookConfig{
+		{QueueSize: 1},
+		{Url: "http://127.0.0.1/", QueueSize: 0},
+		{Url: "http://127.0.0.1/", QueueSize: 1, Retries: -1},
+		{Url: "http://127.0.0.1/", QueueSize: 1, Secret: Secret("short")},
+	} {
+		if _, err := NewWebhook(conf); err == nil {
+			t.Fatalf("Invalid config %v was accepted", conf)
+		}
+	}
+}
@@ -2,16 +2,29 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"log/slog"
+	"math"
+	"mime"
 	"net"
 	"net/http"
 	"net/http/fcgi"
+	"net/rpc"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	_ "embed"
@@ -20,320 +33,2429 @@ import (
 //go:embed index.html
 var defaultIndexTpl string
 
+//go:embed delete.html
+var deletionConfirmHtml string
+
+// deletionConfirmTpl asks to confirm a deletion by a GET request, which is then
+// performed by the form's POST request.
+var deletionConfirmTpl = template.Must(template.New("delete").Parse(deletionConfirmHtml))
+
+//go:embed report.html
+var reportFormHtml string
+
+// reportFormTpl is the form of an abuse report, being POSTed to the same URL.
+var reportFormTpl = template.Must(template.New("report").Parse(reportFormHtml))
+
+// parseIndexTpl parses an index template, or the defaultIndexTpl if empty.
+func parseIndexTpl(indexTplRaw string) (*template.Template, error) {
+	if indexTplRaw == "" {
+		indexTplRaw = defaultIndexTpl
+	}
+	return template.New("index").Funcs(indexTplFuncs).Parse(indexTplRaw)
+}
+
+const (
+	msgAdminUnauthorized   = "Error: Not authorized."
+	msgChecksumInvalid     = "Error: Checksum is invalid."
+	msgChecksumMismatch    = "Error: File does not match the checksum."
+	msgChecksumMissing     = "Error: Checksum is required."
+	msgContentTypeMismatch = "Error: Content-Type does not match the file."
+	msgContentTypeMissing  = "Error: Content-Type is missing."
+	msgCrossSiteRequest    = "Error: Cross-site requests are forbidden."
+	msgDeletionKeyWrong    = "Error: Deletion key is incorrect."
+	msgDeletionDisabled    = "Error: Deletion keys are disabled."
+	msgDeletionSuccess     = "OK: Item was deleted."
+	msgFieldAfterFile      = "Error: Checksum fields must precede the file."
+	msgForbiddenOrigin     = "Error: Uploads from this origin are forbidden."
+	msgFileSizeExceeds     = "Error: File size exceeds maximum."
+	msgFileFieldMissing    = "Error: Request has no file field."
+	msgGenericError        = "Error: Something went wrong."
+	msgIllegalMime         = "Error: MIME type is blacklisted."
+	msgIllegalOverride     = "Error: MIME type override is not allowed."
+	msgIncompleteFile      = "Error: File was not received completely."
+	msgLifetimeExceeds     = "Error: Lifetime exceeds maximum."
+	msgLifetimeExtended    = "Error: Lifetime can only be shortened."
+	msgListQueryInvalid    = "Error: Order or filter is invalid."
+	msgMaxDownloads        = "Error: Maximum downloads must be a positive number."
+	msgMetadataFormat      = "Error: Image cannot be parsed for stripping metadata."
+	msgMultipartInvalid    = "Error: Expected multipart/form-data."
+	msgNotExists           = "Error: Does not exist."
+	msgPaginationInvalid   = "Error: Offset or limit is invalid."
+	msgPasswordRequired    = "Error: Password is missing or wrong."
+	msgPasswordTooLong     = "Error: Password must not exceed 72 bytes."
+	msgSettingsInvalid     = "Error: Settings are invalid."
+	msgRateLimited         = "Error: Too many uploads, please try again later."
+	msgReportBusy          = "Error: Report queue is full, please try again later."
+	msgReportInvalid       = "Error: Report needs a reason and an optional valid email address."
+	msgReportRateLimited   = "Error: Too many reports, please try again later."
+	msgReportSuccess       = "OK: Report was received."
+	msgStoreBusy           = "Error: Store is busy, please try again later."
+	msgStoreFull           = "Error: Store is full, please try again later."
+	msgStoreHealthy        = "OK: Store is available."
+	msgStoreUnhealthy      = "Error: Store is not available."
+	msgTokenInvalid        = "Error: Download token is missing, invalid, or expired."
+	msgTooManyDownloads    = "Error: Too many concurrent downloads, please try again later."
+	msgTooManyFiles        = "Error: Too many files."
+	msgTooManyUploads      = "Error: Too many concurrent uploads, please try again later."
+	msgTusVersion          = "Error: Tus version is not supported."
+	msgUnauthorized        = "Error: Upload is not authorized."
+	msgUploadBusy          = "Error: Upload is busy, please try again later."
+	msgUploadContentType   = "Error: Expected application/offset+octet-stream."
+	msgUploadExceeds       = "Error: Data exceeds the Upload-Length."
+	msgUploadLength        = "Error: Upload-Length is missing or invalid."
+	msgUploadMetadata      = "Error: Upload-Metadata is invalid."
+	msgUploadOffset        = "Error: Upload-Offset is missing or does not match."
+	msgStoreStarting       = "Error: Store is starting, please try again later."
+	msgStoreTimeout        = "Error: Store did not respond in time, please try again later."
+	msgUnsupportedMethod   = "Error: Method not supported."
+)
+
+// TrailingSlashMode defines how item URLs with a trailing slash are handled.
+type TrailingSlashMode string
+
 const (
-	msgDeletionKeyWrong  = "Error: Deletion key is incorrect."
-	msgDeletionSuccess   = "OK: Item was deleted."
-	msgFileSizeExceeds   = "Error: File size exceeds maximum."
-	msgGenericError      = "Error: Something went wrong."
-	msgIllegalMime       = "Error: MIME type is blacklisted."
-	msgLifetimeExceeds   = "Error: Lifetime exceeds maximum."
-	msgNotExists         = "Error: Does not exist."
-	msgUnsupportedMethod = "Error: Method not supported."
+	// TrailingSlashReject answers item URLs with a trailing slash with a 404.
+	TrailingSlashReject TrailingSlashMode = "reject"
+	// TrailingSlashRedirect redirects to the URL without trailing slashes.
+	TrailingSlashRedirect TrailingSlashMode = "redirect"
+	// TrailingSlashStrip treats both URLs equivalently.
+	TrailingSlashStrip TrailingSlashMode = "strip"
 )
 
+// unsafeTypeOverrides are MIME types which might be interpreted as active
+// content by a browser and thus cannot be used as a type override.
+var unsafeTypeOverrides = map[string]struct{}{
+	"application/ecmascript": {},
+	"application/javascript": {},
+	"application/xhtml+xml":  {},
+	"application/xml":        {},
+	"image/svg+xml":          {},
+	"text/ecmascript":        {},
+	"text/html":              {},
+	"text/javascript":        {},
+	"text/xml":               {},
+}
+
+// ErrChecksumMismatch is returned if a served file does not match its stored
+// ContentHash.
+var ErrChecksumMismatch = errors.New("File does not match its checksum")
+
+// ErrDownloadIncomplete is returned if a served file was not sent completely,
+// e.g., as the client has closed its connection.
+var ErrDownloadIncomplete = errors.New("File was not sent completely")
+
+// defaultItemsCsp is the Content-Security-Policy for served Items, treating
+// them as an opaque origin without any resources or scripts.
+const defaultItemsCsp = "sandbox; default-src 'none'"
+
+// defaultIndexCsp is the Content-Security-Policy for the index page, allowing
+// its inline style, local resources, and the upload form.
+const defaultIndexCsp = "default-src 'none'; style-src 'self' 'unsafe-inline'; img-src 'self'; form-action 'self'; frame-ancestors 'none'; base-uri 'none'"
+
+// formPageCsp is the Content-Security-Policy for the deletion's confirmation
+// and the abuse report's page, only allowing their inline style and the form.
+const formPageCsp = "default-src 'none'; style-src 'unsafe-inline'; form-action 'self'; frame-ancestors 'none'; base-uri 'none'"
+
+// defaultCompressionTypes are the media type prefixes of compressible Items,
+// unless being configured otherwise.
+var defaultCompressionTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// compressionEncodings are the supported Content-Encodings for downloads, in
+// the order of preference.
+var compressionEncodings = []string{"gzip", "deflate"}
+
 // Server implements an http.Handler for up- and download.
 type Server struct {
-	store       *StoreRpcClient
-	maxSize     int64
-	maxLifetime time.Duration
-	contactMail string
-	mimeDrop    map[string]struct{}
-	mimeMap     map[string]string
-	urlPrefix   string
-	indexTpl    *template.Template
-	staticFiles map[string]StaticFileConfig
-}
-
-// NewServer creates a new Server with a given database directory, and
-// configuration values. The Server must be started as an http.Handler.
-func NewServer(
-	store *StoreRpcClient,
-	maxSize int64,
-	maxLifetime time.Duration,
-	contactMail string,
-	mimeDrop map[string]struct{},
-	mimeMap map[string]string,
-	urlPrefix string,
-	indexTplRaw string,
-	staticFiles map[string]StaticFileConfig,
-) (s *Server, err error) {
-	indexTpl := defaultIndexTpl
-	if indexTplRaw != "" {
-		indexTpl = indexTplRaw
-	}
-
-	t, err := template.New("index").Parse(indexTpl)
+	store          *StoreRpcClient
+	itemOpts       ItemOpts
+	contactMail    string
+	templateVars   map[string]string
+	version        string
+	mimeDrop       map[string]struct{}
+	mimeMap        map[string]string
+	typeOverrides  map[string]struct{}
+	urlPrefix      string
+	indexTpl       *template.Template
+	staticFiles    map[string]StaticFileConfig
+	verifyChecksum bool
+	stripMetadata  bool
+	trailingSlash  TrailingSlashMode
+
+	// validId rejects obviously invalid IDs before querying the store, if set.
+	validId func(string) bool
+
+	// inlineMaxSize is the largest Item being served inline, or 0 for all.
+	inlineMaxSize int64
+	// attachment serves all Items as an attachment, unless ?download=0 is set.
+	attachment bool
+	// attachmentTypes are always served as an attachment, e.g., text/html.
+	attachmentTypes map[string]struct{}
+
+	// compressionTypes are media type prefixes of Items being compressed for
+	// downloads, if accepted by the client. Compression is disabled if empty.
+	compressionTypes []string
+
+	// itemsCsp and indexCsp are the Content-Security-Policy headers.
+	itemsCsp string
+	indexCsp string
+
+	burnRetries BurnRetries
+
+	// deletionConfirm only deletes Items by a POST request, while a GET
+	// request shows a confirmation form.
+	deletionConfirm bool
+
+	maxConns      int
+	maxConnsPerIP int
+
+	// uploadSlots and downloadSlots limit concurrent transfers, if not nil.
+	uploadSlots   transferSlots
+	downloadSlots transferSlots
+
+	// tlsConfig terminates TLS for the HTTPD, if not nil.
+	tlsConfig *tls.Config
+
+	// downloadTokens are required to download Items if not nil.
+	downloadTokens *downloadTokens
+
+	// uploadLimiter limits uploads per IP address, or is nil.
+	uploadLimiter *rateLimiter
+
+	uploadAuthorizer UploadAuthorizer
+
+	// uploadOrigins is a set of allowed upload origins, or nil for all.
+	uploadOrigins        map[string]struct{}
+	uploadOriginRequired bool
+
+	// abuseReporter mails abuse reports, if not nil, being limited per IP
+	// address by the reportLimiter.
+	abuseReporter *AbuseReporter
+	reportLimiter *rateLimiter
+
+	// webhook is notified about ItemEvents, if not nil.
+	webhook *Webhook
+
+	// metricsPath serves the metrics, if not empty.
+	metricsPath string
+	metrics     *metrics
+
+	// healthPath serves the store's health, if not empty.
+	healthPath string
+
+	// accessLog logs each request, see logAccess.
+	accessLog bool
+
+	// tracing traces each request into the store, see traceSpan.
+	tracing bool
+
+	// adminPath serves the admin endpoints, if not empty, authorized by the
+	// adminAuthorizer's token.
+	adminPath       string
+	adminAuthorizer UploadAuthorizer
+
+	// adminListener serves the metrics and admin endpoints only on a separate
+	// listener, see AdminHandler.
+	adminListener bool
+
+	// tusPath serves resumable uploads by the tus protocol, if not empty.
+	// Unfinished uploads are dropped after the tusLifetime.
+	tusPath     string
+	tusLifetime time.Duration
+
+	// storeReady is set after the store has answered a Ping.
+	storeReady atomic.Bool
+}
+
+// NewServer creates a new Server for a given store client, the webserver's
+// configuration, and an optional custom index template. The Server must be
+// started as an http.Handler.
+func NewServer(store *StoreRpcClient, conf WebserverConfig, indexTplRaw string) (s *Server, err error) {
+	t, err := parseIndexTpl(indexTplRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	maxSize, err := ParseBytesize(conf.ItemConfig.MaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse max_size %q: %w", conf.ItemConfig.MaxSize, err)
+	} else if maxSize == 0 {
+		return nil, fmt.Errorf("max_size must not be zero")
+	}
+
+	trustedProxies, err := parseTrustedProxies(conf.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	var validId func(string) bool
+	if conf.RejectInvalidIds {
+		validId, err = idValidator(conf.idGenerator)
+		if err != nil {
+			return nil, fmt.Errorf("cannot reject invalid IDs: %w", err)
+		}
+	}
+
+	burnRetries := BurnRetries{
+		Attempts: conf.ItemConfig.BurnRetries.Attempts,
+		Window:   conf.ItemConfig.BurnRetries.Window,
+	}
+	if burnRetries.Attempts < 0 || burnRetries.Window < 0 {
+		return nil, fmt.Errorf("burn_retries must not be negative")
+	}
+
+	delKeyLen := conf.ItemConfig.DeletionKeys.Length
+	if delKeyLen != 0 && (delKeyLen < MinDeletionKeyLength || delKeyLen > MaxDeletionKeyLength) {
+		return nil, fmt.Errorf("deletion_keys length must be between %d and %d bytes",
+			MinDeletionKeyLength, MaxDeletionKeyLength)
+	}
+
+	if conf.MaxConcurrentUploads < 0 || conf.MaxConcurrentDownloads < 0 {
+		return nil, fmt.Errorf("max_concurrent_uploads and max_concurrent_downloads must not be negative")
+	}
+
+	var inlineMaxSize int64
+	if conf.ItemConfig.InlineMaxSize != "" {
+		inlineMaxSize, err = ParseBytesize(conf.ItemConfig.InlineMaxSize)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse inline_max_size %q: %w", conf.ItemConfig.InlineMaxSize, err)
+		}
+	}
+
+	attachmentTypes := make(map[string]struct{})
+	for _, key := range conf.ItemConfig.AttachmentTypes {
+		attachmentTypes[strings.ToLower(key)] = struct{}{}
+	}
+
+	var compressionTypes []string
+	if conf.Compression.Enabled {
+		compressionTypes = defaultCompressionTypes
+		if len(conf.Compression.Types) > 0 {
+			compressionTypes = make([]string, 0, len(conf.Compression.Types))
+			for _, prefix := range conf.Compression.Types {
+				compressionTypes = append(compressionTypes, strings.ToLower(prefix))
+			}
+		}
+	}
+
+	itemsCsp := conf.ContentSecurityPolicy.Items
+	if itemsCsp == "" {
+		itemsCsp = defaultItemsCsp
+	}
+	indexCsp := conf.ContentSecurityPolicy.Index
+	if indexCsp == "" {
+		indexCsp = defaultIndexCsp
+	}
+
+	// Without a max_lifetime, Items might be kept forever.
+	maxLifetime := conf.ItemConfig.MaxLifetime
+	if maxLifetime <= 0 {
+		maxLifetime = DurationNever
+	}
+
+	defaultLifetime := conf.ItemConfig.DefaultLifetime
+	if defaultLifetime <= 0 {
+		defaultLifetime = maxLifetime
+	} else if defaultLifetime > maxLifetime {
+		slog.Warn("Default lifetime exceeds maximum lifetime and is capped",
+			slog.Duration("default_lifetime", defaultLifetime),
+			slog.Duration("max_lifetime", maxLifetime))
+		defaultLifetime = maxLifetime
+	}
+
+	mimeDrop := make(map[string]struct{})
+	for _, key := range conf.ItemConfig.MimeDrop {
+		mimeDrop[strings.ToLower(key)] = struct{}{}
+	}
+
+	typeOverrides := make(map[string]struct{})
+	for _, override := range conf.ItemConfig.TypeOverrides {
+		override = strings.ToLower(override)
+		if _, unsafe := unsafeTypeOverrides[override]; unsafe {
+			return nil, fmt.Errorf("type override %q is unsafe", override)
+		}
+		typeOverrides[override] = struct{}{}
+	}
+
+	checksumConf := conf.ItemConfig.UploadChecksum
+	for _, algorithm := range checksumConf.Algorithms {
+		if _, ok := checksumAlgorithms[algorithm]; !ok {
+			return nil, fmt.Errorf("unsupported upload checksum algorithm %q", algorithm)
+		}
+	}
+	if checksumConf.Required && len(checksumConf.Algorithms) == 0 {
+		return nil, fmt.Errorf("upload checksums are required, but no algorithms are allowed")
+	}
+
+	contentTypeConf := conf.ItemConfig.MissingContentType
+	contentTypeMode := ContentTypeMode(contentTypeConf.Mode)
+	switch contentTypeMode {
+	case "":
+		contentTypeMode = ContentTypeReject
+	case ContentTypeReject, ContentTypeSniff:
+	case ContentTypeFallback:
+		if _, _, err := mime.ParseMediaType(contentTypeConf.Fallback); err != nil {
+			return nil, fmt.Errorf("invalid fallback Content-Type %q: %w", contentTypeConf.Fallback, err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown missing_content_type mode %q", contentTypeConf.Mode)
+	}
+
+	contentTypeMismatchMode := ContentTypeMismatchMode(conf.ItemConfig.MismatchingContentType)
+	switch contentTypeMismatchMode {
+	case "":
+		contentTypeMismatchMode = ContentTypeMismatchKeep
+	case ContentTypeMismatchKeep, ContentTypeMismatchOverwrite, ContentTypeMismatchReject:
+	default:
+		return nil, fmt.Errorf("unknown mismatching_content_type mode %q", conf.ItemConfig.MismatchingContentType)
+	}
+
+	trailingSlash := TrailingSlashMode(conf.TrailingSlash)
+	switch trailingSlash {
+	case "":
+		trailingSlash = TrailingSlashReject
+	case TrailingSlashReject, TrailingSlashRedirect, TrailingSlashStrip:
+	default:
+		return nil, fmt.Errorf("unknown trailing_slash mode %q", conf.TrailingSlash)
+	}
+
+	var downloadTokens *downloadTokens
+	if conf.DownloadTokens.Enabled {
+		lifetime := conf.DownloadTokens.Lifetime
+		if lifetime <= 0 {
+			lifetime = conf.ItemConfig.MaxLifetime
+		}
+		if lifetime <= 0 {
+			return nil, fmt.Errorf("download tokens require a lifetime without a max_lifetime")
+		}
+
+		downloadTokens, err = newDownloadTokens(conf.secret, lifetime)
+		if err != nil {
+			return nil, fmt.Errorf("cannot enable download tokens: %w", err)
+		}
+	}
+
+	var uploadLimiter *rateLimiter
+	if conf.UploadRateLimit.Uploads > 0 {
+		if conf.UploadRateLimit.Interval <= 0 {
+			return nil, fmt.Errorf("upload rate limit requires a positive interval")
+		}
+		uploadLimiter = newRateLimiter(conf.UploadRateLimit.Uploads, conf.UploadRateLimit.Interval)
+	}
+
+	uploadAuthorizer, err := NewUploadAuthorizer(conf.UploadAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	var uploadOrigins map[string]struct{}
+	if len(conf.UploadOrigins.Allowed) > 0 {
+		uploadOrigins = make(map[string]struct{})
+		for _, allowed := range conf.UploadOrigins.Allowed {
+			origin, ok := normalizeOrigin(allowed)
+			if !ok {
+				return nil, fmt.Errorf("invalid upload origin %q", allowed)
+			}
+			uploadOrigins[origin] = struct{}{}
+		}
+	} else if conf.UploadOrigins.Required {
+		return nil, fmt.Errorf("upload origins are required, but none are allowed")
+	}
+
+	if conf.MetricsPath != "" {
+		err = checkReservedPath(conf, "metrics_path", conf.MetricsPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if conf.HealthPath != "" {
+		err = checkReservedPath(conf, "health_path", conf.HealthPath)
+		if err != nil {
+			return nil, err
+		}
+		if conf.HealthPath == conf.MetricsPath {
+			return nil, fmt.Errorf("health_path %q collides with the metrics_path", conf.HealthPath)
+		}
+	}
+
+	var adminAuthorizer UploadAuthorizer
+	if conf.Admin.Path != "" {
+		err = checkReservedPath(conf, "admin path", conf.Admin.Path)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range []string{conf.MetricsPath, conf.HealthPath} {
+			if path != "" && strings.HasPrefix(path+"/", conf.Admin.Path+"/") {
+				return nil, fmt.Errorf("admin path %q collides with %q", conf.Admin.Path, path)
+			}
+		}
+
+		if strings.HasSuffix(conf.Admin.Path, "/") {
+			return nil, fmt.Errorf("admin path %q must not end with a slash", conf.Admin.Path)
+		}
+		if conf.Admin.Token == "" {
+			return nil, fmt.Errorf("admin path requires a token")
+		}
+		adminAuthorizer, err = newTokenAuthorizer(UploadAuthConfig{Token: conf.Admin.Token})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tusLifetime := conf.ResumableUploads.Lifetime
+	if conf.ResumableUploads.Path != "" {
+		err = checkReservedPath(conf, "resumable_uploads path", conf.ResumableUploads.Path)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(conf.ResumableUploads.Path, "/") {
+			return nil, fmt.Errorf("resumable_uploads path %q must not end with a slash", conf.ResumableUploads.Path)
+		}
+		for _, path := range []string{conf.MetricsPath, conf.HealthPath, conf.Admin.Path} {
+			if path != "" && (strings.HasPrefix(path+"/", conf.ResumableUploads.Path+"/") ||
+				strings.HasPrefix(conf.ResumableUploads.Path+"/", path+"/")) {
+				return nil, fmt.Errorf("resumable_uploads path %q collides with %q", conf.ResumableUploads.Path, path)
+			}
+		}
+
+		if tusLifetime <= 0 {
+			tusLifetime = defaultTusLifetime
+		}
+	}
+
+	var tlsConfig *tls.Config
+	if conf.tlsCertificate != nil {
+		tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{*conf.tlsCertificate},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+
+	s = &Server{
+		store: store,
+		itemOpts: ItemOpts{
+			MaxSize:         maxSize,
+			DefaultLifetime: defaultLifetime,
+			MaxLifetime:     maxLifetime,
+
+			ChecksumAlgorithms: checksumConf.Algorithms,
+			ChecksumRequired:   checksumConf.Required,
+
+			MaxForwardedHops: conf.ItemConfig.MaxForwardedHops,
+			TrustedProxies:   trustedProxies,
+
+			MissingContentType:  contentTypeMode,
+			FallbackContentType: contentTypeConf.Fallback,
+
+			MismatchingContentType: contentTypeMismatchMode,
+
+			DeletionKeyLength:   delKeyLen,
+			DisableDeletionKeys: conf.ItemConfig.DeletionKeys.Disabled,
+		},
+		contactMail:    conf.Contact,
+		templateVars:   conf.TemplateVars,
+		version:        conf.version,
+		mimeDrop:       mimeDrop,
+		mimeMap:        conf.ItemConfig.MimeMap,
+		typeOverrides:  typeOverrides,
+		urlPrefix:      conf.UrlPrefix,
+		indexTpl:       t,
+		staticFiles:    conf.StaticFiles,
+		verifyChecksum: conf.VerifyChecksum,
+		stripMetadata:  conf.ItemConfig.StripMetadata,
+		trailingSlash:  trailingSlash,
+
+		validId:         validId,
+		inlineMaxSize:   inlineMaxSize,
+		attachment:      conf.ItemConfig.Attachment,
+		attachmentTypes: attachmentTypes,
+
+		compressionTypes: compressionTypes,
+
+		itemsCsp:    itemsCsp,
+		indexCsp:    indexCsp,
+		burnRetries: burnRetries,
+
+		deletionConfirm: conf.ItemConfig.DeletionKeys.Confirm,
+
+		maxConns:      conf.MaxConnections,
+		maxConnsPerIP: conf.MaxConnectionsPerIP,
+
+		uploadSlots:   newTransferSlots(conf.MaxConcurrentUploads),
+		downloadSlots: newTransferSlots(conf.MaxConcurrentDownloads),
+
+		tlsConfig: tlsConfig,
+
+		downloadTokens: downloadTokens,
+		uploadLimiter:  uploadLimiter,
+
+		uploadAuthorizer: uploadAuthorizer,
+
+		uploadOrigins:        uploadOrigins,
+		uploadOriginRequired: conf.UploadOrigins.Required,
+
+		metricsPath: conf.MetricsPath,
+		metrics:     newMetrics(),
+
+		healthPath: conf.HealthPath,
+
+		accessLog: conf.AccessLog,
+		tracing:   conf.Tracing,
+
+		adminPath:       conf.Admin.Path,
+		adminAuthorizer: adminAuthorizer,
+		adminListener:   conf.AdminListen.Protocol != "",
+
+		tusPath:     conf.ResumableUploads.Path,
+		tusLifetime: tusLifetime,
+	}
+
+	if conf.Webhook.Url != "" {
+		s.webhook, err = NewWebhook(conf.Webhook)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.AbuseReports.Enabled {
+		s.abuseReporter, s.reportLimiter, err = newAbuseReporting(store, conf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if store != nil {
+		go s.waitForStore()
+	}
+
+	return
+}
+
+// checkReservedPath ensures that a configured path, e.g., the metrics_path,
+// lives outside of the item ID space and does not shadow another route.
+func checkReservedPath(conf WebserverConfig, option, path string) error {
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("%s %q must start with a slash", option, path)
+	}
+
+	first, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if first == "" || first == "del" || first == "settings" || first == "qr" || first == "report" {
+		return fmt.Errorf("%s %q collides with another route", option, path)
+	}
+	if _, ok := conf.StaticFiles[path]; ok {
+		return fmt.Errorf("%s %q collides with a static file", option, path)
+	}
+
+	validId, err := idValidator(conf.idGenerator)
+	if err != nil {
+		return fmt.Errorf("cannot check %s: %w", option, err)
+	}
+	if validId(first) {
+		return fmt.Errorf("%s %q might collide with an item ID, e.g., use \"/-/%s\"", option, path, first)
+	}
+	return nil
+}
+
+// waitForStore pings the store until it answers and marks it as ready.
+func (serv *Server) waitForStore() {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := serv.store.Ping(ctx)
+		cancel()
+
+		switch err {
+		case nil:
+			slog.Info("Store is ready")
+			serv.storeReady.Store(true)
+			return
+
+		case rpc.ErrShutdown:
+			return
+
+		default:
+			slog.Debug("Store is not ready yet", slog.Any("error", err))
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+}
+
+// checkStoreReady writes an HTTP status code 503 if the store is not ready and
+// returns false in this case.
+func (serv *Server) checkStoreReady(w http.ResponseWriter) bool {
+	if serv.storeReady.Load() {
+		return true
+	}
+
+	slog.Debug("Rejected request as the store is not ready yet")
+
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, msgStoreStarting, http.StatusServiceUnavailable)
+	return false
+}
+
+// httpStoreError responds to a failed store request. If the store has not
+// answered in time, it is a 504 Gateway Timeout. Otherwise, a generic error.
+func httpStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, msgStoreTimeout, http.StatusGatewayTimeout)
+		return
+	}
+
+	http.Error(w, msgGenericError, http.StatusBadRequest)
+}
+
+// httpMethodNotAllowed responds with a 405 Method Not Allowed, listing the
+// allowed methods in the Allow header, as required by RFC 9110.
+func httpMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	http.Error(w, msgUnsupportedMethod, http.StatusMethodNotAllowed)
+}
+
+// listener creates a net.Listener for the file descriptor, limited by the
+// configured maximum connections.
+func (serv *Server) listener(fd *os.File) (net.Listener, error) {
+	ln, err := net.FileListener(fd)
 	if err != nil {
 		return nil, err
 	}
 
-	s = &Server{
-		store:       store,
-		maxSize:     maxSize,
-		maxLifetime: maxLifetime,
-		contactMail: contactMail,
-		mimeDrop:    mimeDrop,
-		mimeMap:     mimeMap,
-		urlPrefix:   urlPrefix,
-		indexTpl:    t,
-		staticFiles: staticFiles,
+	return newLimitListener(ln, serv.maxConns, serv.maxConnsPerIP), nil
+}
+
+// ServeFcgi starts an FastCGI listener on the given file descriptor.
+func (serv *Server) ServeFcgi(fd *os.File) error {
+	ln, err := serv.listener(fd)
+	if err != nil {
+		return err
+	}
+
+	return fcgi.Serve(ln, serv)
+}
+
+// ServeHttpd starts an HTTPD listener on the given file descriptor. If TLS is
+// configured, it is terminated for each connection.
+func (serv *Server) ServeHttpd(fd *os.File) error {
+	webServer := &http.Server{Handler: serv}
+	ln, err := serv.listener(fd)
+	if err != nil {
+		return err
+	}
+
+	if serv.tlsConfig != nil {
+		ln = tls.NewListener(ln, serv.tlsConfig)
+	}
+
+	return webServer.Serve(ln)
+}
+
+// ServeAdmin starts an HTTPD listener for the AdminHandler on the given file
+// descriptor. It is served without TLS, as it should not be public.
+func (serv *Server) ServeAdmin(fd *os.File) error {
+	webServer := &http.Server{Handler: serv.AdminHandler()}
+	ln, err := serv.listener(fd)
+	if err != nil {
+		return err
+	}
+
+	return webServer.Serve(ln)
+}
+
+// Close the Server and its components.
+func (serv *Server) Close() error {
+	if serv.abuseReporter != nil {
+		_ = serv.abuseReporter.Close()
+	}
+	if serv.webhook != nil {
+		_ = serv.webhook.Close()
+	}
+	return serv.store.Close()
+}
+
+// notify the webhook about an ItemEvent, if configured, without blocking.
+func (serv *Server) notify(event ItemEvent, item Item) {
+	if serv.webhook != nil {
+		serv.webhook.Notify(event, item)
+	}
+}
+
+func (serv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serv.serve(w, r, serv.route)
+}
+
+// AdminHandler serves only the metrics and admin endpoints for the separate
+// admin listener, hidden from the public one.
+func (serv *Server) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serv.serve(w, r, serv.routeAdmin)
+	})
+}
+
+// serve a request by its route, being logged and traced if configured.
+func (serv *Server) serve(w http.ResponseWriter, r *http.Request, route http.HandlerFunc) {
+	if !serv.accessLog && !serv.tracing {
+		route(w, r)
+		return
+	}
+
+	// A request is traced as a child of the client's traceparent, if any.
+	var span *traceSpan
+	if serv.tracing {
+		span = newTraceSpan("HTTP "+r.Method, r.Header.Get("traceparent"))
+		r = r.WithContext(contextWithSpan(r.Context(), span))
+	}
+
+	start := time.Now()
+	cw := &countingResponseWriter{ResponseWriter: w}
+	route(cw, r)
+
+	span.end(nil)
+	if serv.accessLog {
+		serv.logAccess(r, cw, time.Since(start))
+	}
+}
+
+// storeContext returns the context for store requests on behalf of an HTTP
+// request, carrying its traceSpan. It is not canceled with the request, as,
+// e.g., a burned Item must be deleted even if its client has disconnected.
+func storeContext(r *http.Request) context.Context {
+	return context.WithoutCancel(r.Context())
+}
+
+// logAccess logs a served request together with its response's status code,
+// size, and latency.
+func (serv *Server) logAccess(r *http.Request, cw *countingResponseWriter, duration time.Duration) {
+	status := cw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	_, reqPath, _ := strings.Cut(r.URL.Path, serv.urlPrefix)
+	if !slog.Default().Enabled(r.Context(), slog.LevelDebug) {
+		reqPath = serv.accessLogPath(reqPath)
+	}
+
+	slog.Info("Served request",
+		slog.String("method", r.Method),
+		slog.String("path", reqPath),
+		slog.Int("status", status),
+		slog.Int64("size", cw.written),
+		slog.String("client_ip", serv.clientIP(r)),
+		slog.Float64("duration_ms", float64(duration.Microseconds())/1000))
+}
+
+// accessLogPath returns a request's path below the urlPrefix with each secret
+// part, e.g., an ID, being replaced by ":id". Only the server's own routes,
+// e.g., "/del", are kept.
+func (serv *Server) accessLogPath(reqPath string) string {
+	if _, ok := serv.staticFiles[reqPath]; ok || reqPath == "" || reqPath == "/" ||
+		reqPath == serv.metricsPath || reqPath == serv.healthPath ||
+		(serv.adminPath != "" && strings.HasPrefix(reqPath, serv.adminPath+"/")) {
+		return reqPath
+	}
+
+	var route string
+	for _, prefix := range []string{"/del", "/settings", "/qr", "/report", serv.tusPath} {
+		if prefix != "" && (reqPath == prefix || strings.HasPrefix(reqPath, prefix+"/")) {
+			route, reqPath = prefix, strings.TrimPrefix(reqPath, prefix)
+			break
+		}
+	}
+
+	parts := strings.Split(reqPath, "/")
+	for i, part := range parts {
+		if part != "" {
+			parts[i] = ":id"
+		}
+	}
+	return route + strings.Join(parts, "/")
+}
+
+// route a request to its handler.
+func (serv *Server) route(w http.ResponseWriter, r *http.Request) {
+	_, reqPath, _ := strings.Cut(r.URL.Path, serv.urlPrefix)
+	if reqPath == "" {
+		http.RedirectHandler(serv.urlPrefix+"/", http.StatusTemporaryRedirect).ServeHTTP(w, r)
+	} else if reqPath == "/" {
+		serv.handleRoot(w, r)
+	} else if strings.HasPrefix(reqPath, "/del/") {
+		serv.handleDeletion(w, r)
+	} else if strings.HasPrefix(reqPath, "/settings/") {
+		serv.handleSettings(w, r)
+	} else if strings.HasPrefix(reqPath, "/report/") {
+		serv.handleReport(w, r)
+	} else if strings.HasPrefix(reqPath, "/qr/") {
+		serv.handleQr(w, r)
+	} else if !serv.adminListener && serv.metricsPath != "" && reqPath == serv.metricsPath {
+		serv.handleMetrics(w, r)
+	} else if serv.healthPath != "" && reqPath == serv.healthPath {
+		serv.handleHealth(w, r)
+	} else if serv.tusPath != "" && (reqPath == serv.tusPath || strings.HasPrefix(reqPath, serv.tusPath+"/")) {
+		serv.handleTus(w, r, strings.TrimPrefix(reqPath, serv.tusPath))
+	} else if !serv.adminListener && serv.adminPath != "" && strings.HasPrefix(reqPath, serv.adminPath+"/") {
+		serv.handleAdmin(w, r, strings.TrimPrefix(reqPath, serv.adminPath))
+	} else if stc, ok := serv.staticFiles[reqPath]; ok {
+		serv.handleStaticFile(w, r, stc)
+	} else if filename := strings.TrimPrefix(reqPath, "/"); r.Method == http.MethodPut && !strings.Contains(filename, "/") {
+		// A raw upload, e.g., by "curl -T foo.png", is named by its path.
+		serv.handleUpload(w, r, filename)
+	} else {
+		serv.handleRequest(w, r)
+	}
+}
+
+// routeAdmin routes a request of the admin listener to its handler.
+func (serv *Server) routeAdmin(w http.ResponseWriter, r *http.Request) {
+	_, reqPath, _ := strings.Cut(r.URL.Path, serv.urlPrefix)
+	if serv.metricsPath != "" && reqPath == serv.metricsPath {
+		serv.handleMetrics(w, r)
+	} else if serv.adminPath != "" && strings.HasPrefix(reqPath, serv.adminPath+"/") {
+		serv.handleAdmin(w, r, strings.TrimPrefix(reqPath, serv.adminPath))
+	} else {
+		http.Error(w, msgNotExists, http.StatusNotFound)
+	}
+}
+
+func (serv *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		serv.handleIndex(w, r)
+
+	case http.MethodPost, http.MethodPut:
+		serv.handleUpload(w, r, "")
+
+	default:
+		slog.Debug("Called with unsupported method", slog.String("method", r.Method))
+
+		httpMethodNotAllowed(w, http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut)
+	}
+}
+
+// indexTplFuncs are available within the index template, e.g., to format the
+// raw MaxSize by "{{prettyBytes .MaxSize}}".
+var indexTplFuncs = template.FuncMap{
+	"prettyBytes":    PrettyBytesize,
+	"prettyDuration": PrettyDuration,
+}
+
+func (serv *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Expires         string
+		DefaultExpires  string
+		Size            string
+		Proto           string
+		Hostname        string
+		Prefix          string
+		EMail           string
+		DurationPattern string
+		Version         string
+		DeletionKeys    bool
+		AbuseReports    bool
+
+		// Vars are the operator's template_vars, kept apart from the fields
+		// above to never shadow them.
+		Vars map[string]string
+
+		// Raw values to be formatted within the template, e.g., by indexTplFuncs.
+		MaxSize         int64
+		MaxLifetime     time.Duration
+		DefaultLifetime time.Duration
+		// Never is the MaxLifetime resp. DefaultLifetime without an expiry.
+		Never time.Duration
+	}{
+		Expires:         PrettyDuration(serv.itemOpts.MaxLifetime),
+		DefaultExpires:  PrettyDuration(serv.itemOpts.DefaultLifetime),
+		Size:            PrettyBytesize(serv.itemOpts.MaxSize),
+		Proto:           WebProtocol(r),
+		Hostname:        r.Host,
+		Prefix:          serv.urlPrefix,
+		EMail:           serv.contactMail,
+		DurationPattern: getHtmlDurationPattern(serv.itemOpts.MaxLifetime == DurationNever),
+		Version:         serv.version,
+		DeletionKeys:    !serv.itemOpts.DisableDeletionKeys,
+		AbuseReports:    serv.abuseReporter != nil,
+		Vars:            serv.templateVars,
+
+		MaxSize:         serv.itemOpts.MaxSize,
+		MaxLifetime:     serv.itemOpts.MaxLifetime,
+		DefaultLifetime: serv.itemOpts.DefaultLifetime,
+		Never:           DurationNever,
+	}
+
+	w.Header().Set("Content-Type", "text/html;charset=UTF-8")
+	w.Header().Set("Content-Security-Policy", serv.indexCsp)
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if err := serv.indexTpl.Execute(w, data); err != nil {
+		slog.Error("Failed to execute template", slog.Any("error", err))
+	}
+}
+
+func (serv *Server) handleStaticFile(w http.ResponseWriter, r *http.Request, sfc StaticFileConfig) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		slog.Debug("Request with unsupported method", slog.String("method", r.Method))
+
+		httpMethodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+
+	w.Header().Set("Content-Type", sfc.Mime)
+	w.Header().Set("Content-Length", strconv.Itoa(len(sfc.data)))
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	staticReader := bytes.NewReader(sfc.data)
+	_, err := io.Copy(w, staticReader)
+	if err != nil {
+		slog.Error("Failed to write static file back to request", slog.Any("error", err))
+
+		http.Error(w, msgGenericError, http.StatusBadRequest)
+		return
+	}
+}
+
+// normalizeOrigin converts an Origin or Referer header's value to an origin,
+// i.e., a lowercase "scheme://host[:port]".
+func normalizeOrigin(value string) (string, bool) {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", false
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), true
+}
+
+// checkUploadOrigin verifies an upload's Origin, or Referer as a fallback,
+// against the allowed upload origins. Otherwise, a 403 error is sent.
+func (serv *Server) checkUploadOrigin(w http.ResponseWriter, r *http.Request) bool {
+	if serv.uploadOrigins == nil {
+		return true
+	}
+
+	value := r.Header.Get("Origin")
+	if value == "" {
+		value = r.Header.Get("Referer")
+	}
+
+	if value == "" {
+		if !serv.uploadOriginRequired {
+			return true
+		}
+	} else if origin, ok := normalizeOrigin(value); ok {
+		if _, allowed := serv.uploadOrigins[origin]; allowed {
+			return true
+		}
+	}
+
+	slog.Info("Rejected upload from a forbidden origin", slog.String("origin", value))
+
+	http.Error(w, msgForbiddenOrigin, http.StatusForbidden)
+	return false
+}
+
+// checkSameSite rejects cross-site requests changing Items with a 403 error.
+func (serv *Server) checkSameSite(w http.ResponseWriter, r *http.Request) bool {
+	sameSite := true
+	if fetchSite := r.Header.Get("Sec-Fetch-Site"); fetchSite != "" {
+		sameSite = fetchSite == "same-origin" || fetchSite == "none"
+	} else if value := r.Header.Get("Origin"); value != "" {
+		origin, ok := normalizeOrigin(value)
+		sameSite = ok && origin == strings.ToLower(WebProtocol(r)+"://"+r.Host)
+	}
+
+	if !sameSite {
+		slog.Info("Rejected cross-site request", slog.String("path", r.URL.Path))
+
+		http.Error(w, msgCrossSiteRequest, http.StatusForbidden)
+	}
+	return sameSite
+}
+
+// clientIP returns the request's client IP address. Behind a trusted proxy,
+// this is the forwarded client.
+func (serv *Server) clientIP(r *http.Request) string {
+	if owners, err := NewOwnerTypes(r, serv.itemOpts.TrustedProxies, serv.itemOpts.MaxForwardedHops); err == nil {
+		return clientIP(owners).String()
+	}
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return ip
+	}
+	return r.RemoteAddr
+}
+
+// checkUploadRate checks the upload rate limit for the request's IP address.
+func (serv *Server) checkUploadRate(w http.ResponseWriter, r *http.Request) bool {
+	if serv.uploadLimiter == nil {
+		return true
+	}
+
+	// Behind a trusted proxy, the limit applies to the forwarded client.
+	ip := serv.clientIP(r)
+
+	allowed, wait := serv.uploadLimiter.allow(ip)
+	if allowed {
+		return true
+	}
+
+	slog.Info("Rejected upload exceeding the rate limit", slog.String("ip", ip))
+
+	w.Header().Set("Connection", "close")
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, msgRateLimited, http.StatusTooManyRequests)
+	return false
+}
+
+// checkUploadAuth checks the request against the UploadAuthorizer.
+func (serv *Server) checkUploadAuth(w http.ResponseWriter, r *http.Request) bool {
+	err := serv.uploadAuthorizer.Authorize(r)
+	if err == nil {
+		return true
+	}
+
+	slog.Info("Rejected unauthorized upload", slog.Any("error", err))
+
+	if challenger, ok := serv.uploadAuthorizer.(uploadChallenger); ok {
+		w.Header().Set("WWW-Authenticate", challenger.Challenge())
+	}
+	w.Header().Set("Connection", "close")
+	http.Error(w, msgUnauthorized, http.StatusUnauthorized)
+	return false
+}
+
+// transferSlots limit concurrent transfers by a semaphore. A nil transferSlots
+// is unlimited.
+type transferSlots chan struct{}
+
+// newTransferSlots for up to n concurrent transfers, or unlimited for zero.
+func newTransferSlots(n int) transferSlots {
+	if n <= 0 {
+		return nil
+	}
+	return make(transferSlots, n)
+}
+
+// tryAcquire a slot without waiting. If all slots are taken, false is
+// returned. Otherwise, the slot must be released afterwards.
+func (slots transferSlots) tryAcquire() bool {
+	if slots == nil {
+		return true
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release a slot taken by tryAcquire.
+func (slots transferSlots) release() {
+	if slots != nil {
+		<-slots
+	}
+}
+
+// checkUploadSlot acquires one of the uploadSlots, which must be released by
+// the caller afterwards.
+func (serv *Server) checkUploadSlot(w http.ResponseWriter) bool {
+	if serv.uploadSlots.tryAcquire() {
+		return true
+	}
+
+	slog.Warn("Rejected upload as there are too many concurrent uploads")
+
+	w.Header().Set("Connection", "close")
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, msgTooManyUploads, http.StatusServiceUnavailable)
+	return false
+}
+
+// httpNewItemError responds to a failed creation of a new Item, e.g., by
+// NewItemFromRequest.
+func httpNewItemError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrLifetimeTooLong) {
+		slog.Info("New Item with a too long lifetime was rejected")
+
+		http.Error(w, msgLifetimeExceeds, http.StatusNotAcceptable)
+	} else if errors.Is(err, ErrFileTooBig) {
+		slog.Info("New Item with a too great file size was rejected")
+
+		http.Error(w, msgFileSizeExceeds, http.StatusRequestEntityTooLarge)
+	} else if errors.Is(err, ErrContentTypeMissing) {
+		slog.Info("New Item without a Content-Type was rejected")
+
+		http.Error(w, msgContentTypeMissing, http.StatusBadRequest)
+	} else if errors.Is(err, ErrContentTypeMismatch) {
+		slog.Info("New Item with a mismatching Content-Type was rejected")
+
+		http.Error(w, msgContentTypeMismatch, http.StatusBadRequest)
+	} else if errors.Is(err, ErrChecksumMissing) {
+		slog.Info("New Item without a required checksum was rejected")
+
+		http.Error(w, msgChecksumMissing, http.StatusBadRequest)
+	} else if errors.Is(err, ErrChecksumInvalid) {
+		slog.Info("New Item with an invalid checksum was rejected")
+
+		http.Error(w, msgChecksumInvalid, http.StatusBadRequest)
+	} else if errors.Is(err, ErrMaxDownloadsInvalid) {
+		slog.Info("New Item with invalid maximum downloads was rejected")
+
+		http.Error(w, msgMaxDownloads, http.StatusBadRequest)
+	} else if errors.Is(err, ErrPasswordTooLong) {
+		slog.Info("New Item with a too long password was rejected")
+
+		http.Error(w, msgPasswordTooLong, http.StatusBadRequest)
+	} else if errors.Is(err, ErrMultipartInvalid) {
+		slog.Info("New Item without a valid multipart/form-data body was rejected", slog.Any("error", err))
+
+		http.Error(w, msgMultipartInvalid, http.StatusBadRequest)
+	} else if errors.Is(err, ErrFileFieldMissing) {
+		slog.Info("New Item without a file field was rejected")
+
+		http.Error(w, msgFileFieldMissing, http.StatusBadRequest)
+	} else if errors.Is(err, ErrTooManyFiles) {
+		slog.Info("New Items exceeding the maximum files were rejected")
+
+		http.Error(w, msgTooManyFiles, http.StatusBadRequest)
+	} else if err != nil {
+		slog.Error("Failed to create new Item", slog.Any("error", err))
+
+		http.Error(w, msgGenericError, http.StatusBadRequest)
+	}
+}
+
+// checkMimeDrop rejects an upload of a dropped MIME type with a 400 error and
+// returns false in this case.
+func (serv *Server) checkMimeDrop(w http.ResponseWriter, contentType string) bool {
+	if _, drop := serv.mimeDrop[contentType]; !drop {
+		return true
+	}
+
+	slog.Info("Prevented upload of an illegal MIME", slog.String("mime", contentType))
+
+	http.Error(w, msgIllegalMime, http.StatusBadRequest)
+	return false
+}
+
+// httpPutError responds to a failed Put of a new Item into the store, whose
+// file is streamed and thus might fail only now.
+func httpPutError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrFileTooBig) {
+		slog.Info("New Item with a too great file size was rejected")
+
+		http.Error(w, msgFileSizeExceeds, http.StatusRequestEntityTooLarge)
+	} else if errors.Is(err, ErrFieldAfterFile) {
+		slog.Info("New Item with a checksum after its file was rejected")
+
+		http.Error(w, msgFieldAfterFile, http.StatusBadRequest)
+	} else if errors.Is(err, ErrTrailingFieldInvalid) || errors.Is(err, ErrMultipartInvalid) {
+		// Form fields succeeding the file are only parsed after streaming it.
+		httpNewItemError(w, err)
+	} else if errors.Is(err, ErrMetadataFormat) {
+		slog.Info("New Item not being parsable for stripping metadata was rejected", slog.Any("error", err))
+
+		http.Error(w, msgMetadataFormat, http.StatusBadRequest)
+	} else if errors.Is(err, ErrIncompleteFile) {
+		slog.Info("Rejected incomplete upload")
+
+		http.Error(w, msgIncompleteFile, http.StatusBadRequest)
+	} else if err == ErrUploadChecksum {
+		slog.Info("Rejected upload not matching its checksum")
+
+		http.Error(w, msgChecksumMismatch, http.StatusBadRequest)
+	} else if err == ErrStoreFull {
+		slog.Warn("Rejected upload as the store is full")
+
+		http.Error(w, msgStoreFull, http.StatusInsufficientStorage)
+	} else if err != nil {
+		slog.Error("Failed to store Item", slog.Any("error", err))
+
+		httpStoreError(w, err)
+	}
+}
+
+// itemUrls returns the fetch URL, including a download token if required, and
+// the delete URL of a newly uploaded Item. The delete URL is empty for an Item
+// without a DeletionKey.
+func (serv *Server) itemUrls(r *http.Request, item Item) (fetchUrl, deleteUrl string) {
+	baseUrl := fmt.Sprintf("%s://%s%s", WebProtocol(r), r.Host, serv.urlPrefix)
+
+	fetchUrl = fmt.Sprintf("%s/%s", baseUrl, item.ID)
+	if serv.downloadTokens != nil {
+		tokenExpires := time.Now().Add(serv.downloadTokens.lifetime)
+		if !item.NeverExpires() && item.Expires.Before(tokenExpires) {
+			tokenExpires = item.Expires
+		}
+		fetchUrl += "?token=" + serv.downloadTokens.sign(item.ID, tokenExpires)
+	}
+
+	if item.DeletionKey != "" {
+		deleteUrl = fmt.Sprintf("%s/del/%s/%s", baseUrl, item.ID, item.DeletionKey)
+	}
+	return
+}
+
+// handleUpload creates new Items, either from a multipart/form-data POST
+// request or from a PUT request's raw body, being named like the filename.
+func (serv *Server) handleUpload(w http.ResponseWriter, r *http.Request, filename string) {
+	if !serv.checkUploadOrigin(w, r) {
+		return
+	}
+	if !serv.checkUploadRate(w, r) {
+		return
+	}
+	if !serv.checkUploadAuth(w, r) {
+		return
+	}
+	if !serv.checkUploadSlot(w) {
+		return
+	}
+	defer serv.uploadSlots.release()
+
+	if !serv.checkStoreReady(w) {
+		return
+	}
+
+	var items []Item
+	var sizes []int64
+	var ok bool
+	if r.Method == http.MethodPut {
+		items, sizes, ok = serv.uploadRaw(w, r, filename)
+	} else {
+		items, sizes, ok = serv.uploadMultipart(w, r)
+	}
+	if !ok {
+		return
+	}
+
+	for i, size := range sizes {
+		serv.metrics.uploads.Add(1)
+		serv.metrics.uploadSizes.observe(size)
+
+		items[i].Size = size
+		serv.notify(ItemCreated, items[i])
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	// The output format is selected by a query parameter, being either only
+	// the fetch URL, both URLs without labels, or the verbose default. For
+	// multiple files, each Item is listed in the order of the upload.
+	query := r.URL.Query()
+	for i, item := range items {
+		fetchUrl, deleteUrl := serv.itemUrls(r, item)
+
+		switch {
+		case query.Has("onlyURL"):
+			fmt.Fprintln(w, fetchUrl)
+
+		case query.Has("urls"):
+			fmt.Fprintln(w, fetchUrl)
+			if deleteUrl != "" {
+				fmt.Fprintln(w, deleteUrl)
+			}
+
+		default:
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "Fetch:   %s\n", fetchUrl)
+			if deleteUrl != "" {
+				fmt.Fprintf(w, "Delete:  %s\n", deleteUrl)
+			}
+			fmt.Fprintln(w)
+			fmt.Fprintf(w, "Expires: %s\n", formatExpires(item))
+			fmt.Fprintf(w, "Burn:    %t\n", item.BurnAfterReading)
+		}
+	}
+}
+
+// storeUpload puts a new Item and its file into the Store, returning the Item
+// with its ID and the file's size. On error, false is returned after the error
+// was responded.
+func (serv *Server) storeUpload(w http.ResponseWriter, r *http.Request, item Item, f io.ReadCloser) (Item, int64, bool) {
+	if !serv.checkMimeDrop(w, item.ContentType) {
+		f.Close()
+		return item, 0, false
+	}
+
+	// Stripping metadata would alter the file, invalidating its checksum.
+	if serv.stripMetadata && item.UploadChecksum.Algorithm == "" {
+		f, _ = stripMetadataFile(f, item.ContentType)
+	}
+
+	// As the file is streamed into the Store, some errors only occur now.
+	counter := &countingReadCloser{ReadCloser: f}
+	itemId, err := serv.store.Put(item, counter, storeContext(r))
+	if err != nil {
+		httpPutError(w, err)
+		return item, 0, false
+	}
+
+	slog.Info("Uploaded new Item",
+		slog.String("id", itemId), slog.Any("expires", item.Expires))
+
+	item.ID = itemId
+	return item, counter.n, true
+}
+
+// uploadMultipart stores the files of a multipart/form-data upload. All files
+// are stored or, on an error, none of them.
+func (serv *Server) uploadMultipart(w http.ResponseWriter, r *http.Request) (items []Item, sizes []int64, ok bool) {
+	// Cut off oversized bodies early, regardless of their announced sizes. Each
+	// file is limited to the MaxSize on its own while being read.
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBody(serv.itemOpts.MaxSize))
+
+	uploads, err := NewItemUploads(r, serv.itemOpts)
+	if err != nil {
+		httpNewItemError(w, err)
+		return
+	}
+
+	// The Items' creation was not yet announced, neither is their deletion.
+	rollback := func() {
+		for _, item := range items {
+			if err := serv.store.Rollback(item.ID, storeContext(r)); err != nil {
+				slog.Error("Failed to roll back Item",
+					slog.String("id", item.ID), slog.Any("error", err))
+			}
+		}
+	}
+
+	for {
+		item, f, err := uploads.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			rollback()
+			if errors.Is(err, ErrFileTooBig) || errors.Is(err, ErrFieldAfterFile) ||
+				errors.Is(err, ErrTrailingFieldInvalid) || errors.Is(err, ErrIncompleteFile) {
+				httpPutError(w, err)
+			} else {
+				httpNewItemError(w, err)
+			}
+			return nil, nil, false
+		}
+
+		item, size, ok := serv.storeUpload(w, r, item, f)
+		if !ok {
+			rollback()
+			return nil, nil, false
+		}
+
+		items = append(items, item)
+		sizes = append(sizes, size)
+	}
+
+	// Form fields succeeding the first file are applied to all stored Items.
+	if settings, ok := uploads.Trailing(); ok {
+		for i := range items {
+			item, err := serv.store.UpdateSettings(items[i].ID, settings, storeContext(r))
+			if err != nil {
+				slog.Error("Failed to apply form fields succeeding the file",
+					slog.String("id", items[i].ID), slog.Any("error", err))
+
+				rollback()
+				httpStoreError(w, err)
+				return nil, nil, false
+			}
+			items[i] = item
+		}
+	}
+	return items, sizes, true
+}
+
+// uploadRaw stores the raw body of a PUT request as a single file.
+func (serv *Server) uploadRaw(w http.ResponseWriter, r *http.Request, filename string) (items []Item, sizes []int64, ok bool) {
+	// Cut off oversized bodies early, regardless of their announced sizes.
+	r.Body = http.MaxBytesReader(w, r.Body, serv.itemOpts.MaxSize+1)
+
+	item, f, err := NewItemFromRawRequest(r, filename, serv.itemOpts)
+	if err != nil {
+		httpNewItemError(w, err)
+		return
+	}
+
+	item, size, ok := serv.storeUpload(w, r, item, f)
+	if !ok {
+		return
+	}
+	return []Item{item}, []int64{size}, true
+}
+
+// downloadPassword returns the password supplied either by the key query
+// parameter or as the password of HTTP basic authentication.
+func downloadPassword(r *http.Request) string {
+	if key := r.URL.Query().Get("key"); key != "" {
+		return key
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	return ""
+}
+
+// itemETag returns a strong ETag for an Item, derived from its ID and
+// ContentHash, or an empty string for an Item without a ContentHash.
+func itemETag(item Item) string {
+	if item.ContentHash == "" {
+		return ""
+	}
+
+	hash := sha256.Sum256([]byte(item.ID + "\x00" + item.ContentHash))
+	return `"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// etagMatches checks an If-None-Match header's list of ETags against an ETag
+// by the weak comparison of RFC 9110.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		} else if etag != "" && strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// hasClientCachedRequest if the client submits a conditional GET, i.e.,
+// If-None-Match or If-Modified-Since. As required by RFC 9110, the latter is
+// ignored if an If-None-Match header is present.
+func (serv *Server) hasClientCachedRequest(r *http.Request, item Item) bool {
+	if inm := r.Header.Values("If-None-Match"); len(inm) > 0 {
+		return etagMatches(strings.Join(inm, ","), itemETag(item))
+	}
+
+	ims, imsErr := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if imsErr != nil {
+		return false
+	}
+
+	return item.Created.Before(ims) && (item.NeverExpires() || item.Expires.After(ims))
+}
+
+// formatExpires returns an Item's Expires for a human, or "never".
+func formatExpires(item Item) string {
+	if item.NeverExpires() {
+		return "never"
+	}
+	return item.Expires.String()
+}
+
+// itemDisposition decides if an Item is served "inline" or as an "attachment".
+func (serv *Server) itemDisposition(r *http.Request, item Item, mimeType string) string {
+	mediaType, _, _ := strings.Cut(mimeType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if _, ok := serv.attachmentTypes[mediaType]; ok {
+		return "attachment"
+	}
+
+	if serv.inlineMaxSize > 0 && item.Size > serv.inlineMaxSize {
+		return "attachment"
+	}
+
+	attachment := serv.attachment
+	if r.URL.Query().Has("download") {
+		// An empty value, i.e., a bare ?download, requests a download as well.
+		download := r.URL.Query().Get("download")
+		if v, err := strconv.ParseBool(download); err == nil {
+			attachment = v
+		} else if download == "" {
+			attachment = true
+		}
+	}
+
+	if attachment {
+		return "attachment"
+	}
+	return "inline"
+}
+
+// setItemHeaders sets the headers describing an Item's file for both a GET
+// and a HEAD request.
+func (serv *Server) setItemHeaders(w http.ResponseWriter, r *http.Request, item Item) {
+	mimeType := item.ContentType
+	if mimeSubst, ok := serv.mimeMap[mimeType]; ok {
+		mimeType = mimeSubst
+	}
+
+	disposition := serv.itemDisposition(r, item, mimeType)
+
+	w.Header().Set("Content-Type", mimeType)
+	if item.Filename != "" {
+		disposition = fmt.Sprintf("%s; filename=%q", disposition, item.Filename)
+	}
+	w.Header().Set("Content-Disposition", disposition)
+
+	// Items are served from the same origin. Thus, they must neither be sniffed
+	// into another type nor run scripts, e.g., an uploaded HTML page.
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", serv.itemsCsp)
+
+	// Original creation date might be seen as confidential.
+	w.Header().Set("Last-Modified", time.Now().Format(http.TimeFormat))
+	if etag := itemETag(item); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+}
+
+// handleRequestHead answers a HEAD request for an Item with its headers, its
+// Content-Length, and its ContentHash as X-Content-SHA256. As no file is being
+// sent, this neither burns an Item nor counts as one of its downloads.
+func (serv *Server) handleRequestHead(w http.ResponseWriter, r *http.Request, item Item) {
+	serv.setItemHeaders(w, r, item)
+
+	w.Header().Set("Content-Length", strconv.FormatInt(item.Size, 10))
+	if item.ContentHash != "" {
+		w.Header().Set("X-Content-SHA256", item.ContentHash)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// acceptedEncoding returns the first of the encodings being accepted by the
+// request's Accept-Encoding header, or an empty string for none.
+func acceptedEncoding(r *http.Request, encodings []string) string {
+	accepted := make(map[string]bool)
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(part, ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+
+			quality := 1.0
+			if key, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(key) == "q" {
+				var err error
+				if quality, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+					quality = 0
+				}
+			}
+			accepted[coding] = quality > 0
+		}
+	}
+
+	for _, encoding := range encodings {
+		if ok, found := accepted[encoding]; found {
+			if ok {
+				return encoding
+			}
+		} else if accepted["*"] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// downloadEncoding returns the Content-Encoding to compress an Item's download
+// with, or an empty string for none.
+func (serv *Server) downloadEncoding(w http.ResponseWriter, r *http.Request, mimeType string) string {
+	mediaType, _, _ := strings.Cut(mimeType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	compressible := false
+	for _, prefix := range serv.compressionTypes {
+		if strings.HasPrefix(mediaType, prefix) {
+			compressible = true
+			break
+		}
+	}
+	if !compressible {
+		return ""
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	if r.Header.Get("Range") != "" {
+		return ""
+	}
+	return acceptedEncoding(r, compressionEncodings)
+}
+
+// newCompressor creates an io.WriteCloser compressing into w by the encoding.
+func newCompressor(w io.Writer, encoding string) io.WriteCloser {
+	if encoding == "deflate" {
+		// HTTP's deflate encoding is actually the zlib format, RFC 9110.
+		return zlib.NewWriter(w)
+	}
+	return gzip.NewWriter(w)
+}
+
+// handleRequestServe is called from handleRequest when a valid Item should be served.
+func (serv *Server) handleRequestServe(w http.ResponseWriter, r *http.Request, item Item) error {
+	f, err := serv.store.GetFile(item.ID, storeContext(r))
+	if err != nil {
+		return fmt.Errorf("reading file failed: %w", err)
+	}
+
+	defer f.Close()
+
+	serv.setItemHeaders(w, r, item)
+
+	// Only regular files are seekable, unlike, e.g., a MemoryStore's pipe.
+	size := int64(-1)
+	if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+		size = fi.Size()
+	}
+
+	encoding := serv.downloadEncoding(w, r, w.Header().Get("Content-Type"))
+
+	// Partial downloads of an Item being deleted after its download would allow
+	// fetching it piece by piece without ever deleting it. Compressed downloads
+	// are never partial, as their ranges would refer to the compressed data.
+	ranges := size >= 0 && !item.BurnAfterReading && item.MaxDownloads == 0 && encoding == ""
+
+	// A partial download cannot be verified. Thus, only complete downloads are
+	// served verified if verifyChecksum is set.
+	verify := serv.verifyChecksum && item.ContentHash != ""
+	if ranges && (!verify || r.Header.Get("Range") != "") {
+		return serveContent(w, r, f)
+	} else if ranges {
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+
+		// The compressed representation differs from the stored file.
+		if etag := w.Header().Get("ETag"); etag != "" {
+			w.Header().Set("ETag", "W/"+etag)
+		}
+	} else if verify && size >= 0 {
+		// An explicit Content-Length allows clients to detect a truncated
+		// response due to a checksum mismatch, as described below.
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	var dst io.Writer = w
+	var compressor io.WriteCloser
+	if encoding != "" {
+		compressor = newCompressor(w, encoding)
+		dst = compressor
+	}
+
+	if !verify {
+		// An error might happen here if the peer resets the connection, e.g., if
+		// curl tries to print a non text file to stdout.
+		if _, err := io.Copy(dst, f); err != nil {
+			return fmt.Errorf("%w: %v", ErrDownloadIncomplete, err)
+		}
+	} else {
+		// As the headers were already sent, a checksum mismatch can only result
+		// in a truncated response and a log entry. A compressed response lacks
+		// its trailer in this case, also letting the client detect it.
+		err = copyVerified(dst, f, item.ContentHash)
+		if err == ErrChecksumMismatch {
+			slog.Error("Stored file does not match its checksum, it might be corrupted",
+				slog.String("id", item.ID))
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrDownloadIncomplete, err)
+		}
+	}
+
+	if compressor != nil {
+		if err := compressor.Close(); err != nil {
+			return fmt.Errorf("%w: %v", ErrDownloadIncomplete, err)
+		}
+	}
+
+	return nil
+}
+
+// countingResponseWriter records the status code and written bytes of an
+// http.ResponseWriter.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (cw *countingResponseWriter) WriteHeader(status int) {
+	cw.status = status
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *countingResponseWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	n, err := cw.ResponseWriter.Write(p)
+	cw.written += int64(n)
+	return n, err
+}
+
+// serveContent serves a seekable file by http.ServeContent, supporting Range
+// requests. Sending less than its Content-Length results in an error.
+func serveContent(w http.ResponseWriter, r *http.Request, f io.ReadSeeker) error {
+	cw := &countingResponseWriter{ResponseWriter: w}
+	http.ServeContent(cw, r, "", time.Time{}, f)
+
+	if cw.status != http.StatusOK && cw.status != http.StatusPartialContent {
+		return fmt.Errorf("%w: HTTP status code %d", ErrDownloadIncomplete, cw.status)
+	}
+
+	length, err := strconv.ParseInt(cw.Header().Get("Content-Length"), 10, 64)
+	if err != nil || cw.written < length {
+		return fmt.Errorf("%w: sent %d bytes", ErrDownloadIncomplete, cw.written)
+	}
+	return nil
+}
+
+// copyVerified copies src to dst, holding back the last chunk until its
+// SHA-256 hash was compared against the expected hex encoded hash.
+func copyVerified(dst io.Writer, src io.Reader, expectedHash string) error {
+	hasher := sha256.New()
+
+	buffs := [2][]byte{make([]byte, 32*1024), make([]byte, 32*1024)}
+	buffIdx := 0
+	var pending []byte
+
+	for {
+		n, err := src.Read(buffs[buffIdx])
+		if n > 0 {
+			if len(pending) > 0 {
+				if _, err := dst.Write(pending); err != nil {
+					return err
+				}
+			}
+
+			_, _ = hasher.Write(buffs[buffIdx][:n])
+			pending = buffs[buffIdx][:n]
+			buffIdx = 1 - buffIdx
+		}
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if hex.EncodeToString(hasher.Sum(nil)) != expectedHash {
+		return ErrChecksumMismatch
+	}
+
+	_, err := dst.Write(pending)
+	return err
+}
+
+func (serv *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
+	_, reqPath, _ := strings.Cut(r.URL.Path, serv.urlPrefix)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		slog.Debug("Request with unsupported method", slog.String("method", r.Method))
+
+		// A single path segment might also be the filename of a raw upload.
+		if strings.Contains(strings.TrimPrefix(reqPath, "/"), "/") {
+			httpMethodNotAllowed(w, http.MethodGet, http.MethodHead)
+		} else {
+			httpMethodNotAllowed(w, http.MethodGet, http.MethodHead, http.MethodPut)
+		}
+		return
+	}
+
+	if trimmedPath := strings.TrimRight(reqPath, "/"); trimmedPath != reqPath && trimmedPath != "" {
+		switch serv.trailingSlash {
+		case TrailingSlashRedirect:
+			target := url.URL{Path: serv.urlPrefix + trimmedPath, RawQuery: r.URL.RawQuery}
+			http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+			return
+
+		case TrailingSlashStrip:
+			reqPath = trimmedPath
+		}
+	}
+
+	reqId := strings.TrimPrefix(reqPath, "/")
+	reqId, reqSuffix, hasSuffix := strings.Cut(reqId, "/")
+
+	if reqId == "" || (hasSuffix && (reqSuffix == "" || strings.Contains(reqSuffix, "/"))) {
+		slog.Debug("Requested URL is malformed", slog.String("path", r.URL.Path))
+
+		http.Error(w, msgNotExists, http.StatusNotFound)
+		return
+	}
+
+	if serv.validId != nil && !serv.validId(reqId) {
+		slog.Debug("Requested an invalid ID", slog.String("path", r.URL.Path))
+
+		http.Error(w, msgNotExists, http.StatusNotFound)
+		return
+	}
+
+	if !serv.checkStoreReady(w) {
+		return
+	}
+
+	if serv.downloadTokens != nil {
+		err := serv.downloadTokens.verify(reqId, r.URL.Query().Get("token"), time.Now())
+		if err != nil {
+			slog.Debug("Requested with an unacceptable download token",
+				slog.String("id", reqId), slog.Any("error", err))
+
+			http.Error(w, msgTokenInvalid, http.StatusForbidden)
+			return
+		}
+	}
+
+	item, err := serv.store.Get(reqId, storeContext(r))
+	if err == ErrNotFound {
+		slog.Debug("Requested non-existing ID", slog.String("id", reqId))
+
+		http.Error(w, msgNotExists, http.StatusNotFound)
+		return
+	} else if err != nil {
+		slog.Warn("Failed to request", slog.String("id", reqId), slog.Any("error", err))
+
+		httpStoreError(w, err)
+		return
+	}
+
+	// The reserved "expires" suffix requests the expiry, unless it is the
+	// Item's filename. Then, the Item is served as for any other filename.
+	if reqSuffix == "expires" && item.Filename != reqSuffix {
+		serv.handleExpires(w, r, item)
+		return
+	}
+
+	// An optional type query parameter overrides the Content-Type, if allowed.
+	typeOverride := strings.ToLower(r.URL.Query().Get("type"))
+	if _, allowed := serv.typeOverrides[typeOverride]; typeOverride != "" && len(serv.typeOverrides) > 0 && !allowed {
+		slog.Debug("Requested with a disallowed type override",
+			slog.String("id", reqId), slog.String("type", typeOverride))
+
+		http.Error(w, msgIllegalOverride, http.StatusBadRequest)
+		return
+	}
+
+	// An optional suffix must match the Item's filename, e.g., "/ID/foo.pdf".
+	if hasSuffix && reqSuffix != item.Filename {
+		slog.Debug("Requested with a mismatching filename",
+			slog.String("id", reqId), slog.String("filename", reqSuffix))
+
+		http.Error(w, msgNotExists, http.StatusNotFound)
+		return
+	}
+
+	// A password protected Item must neither be served nor burned without it.
+	if !item.checkPassword(downloadPassword(r)) {
+		slog.Debug("Requested with a missing or wrong password", slog.String("id", reqId))
+
+		w.Header().Set("WWW-Authenticate", `Basic realm="gosh"`)
+		http.Error(w, msgPasswordRequired, http.StatusUnauthorized)
+		return
+	}
+
+	// A partly downloaded Item to be burned cannot be retried after its window.
+	if item.BurnAfterReading && serv.burnRetries.windowExceeded(item, time.Now()) {
+		slog.Info("Item will be burned after its retry window", slog.String("id", item.ID))
+		if serv.deleteItem(item.ID, storeContext(r)) {
+			serv.notify(ItemBurned, item)
+		}
+
+		http.Error(w, msgNotExists, http.StatusNotFound)
+		return
+	}
+
+	if _, allowed := serv.typeOverrides[typeOverride]; allowed {
+		item.ContentType = typeOverride
+	}
+
+	// A cached response is no download. Thus, it is neither reserved nor does
+	// it burn the Item.
+	if serv.hasClientCachedRequest(r, item) {
+		slog.Debug("Requested with conditional GET; HTTP Status Code 304", slog.String("id", reqId))
+
+		if etag := itemETag(item); etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		slog.Debug("Requested headers of Item", slog.String("id", reqId))
+		serv.handleRequestHead(w, r, item)
+		return
+	}
+
+	// A download is rejected before being reserved, as it would count otherwise.
+	if !serv.downloadSlots.tryAcquire() {
+		slog.Warn("Rejected download as there are too many concurrent downloads", slog.String("id", reqId))
+
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, msgTooManyDownloads, http.StatusServiceUnavailable)
+		return
+	}
+	defer serv.downloadSlots.release()
+
+	// Each download of an Item with MaxDownloads is reserved before serving it.
+	// Thus, concurrent downloads cannot exceed its MaxDownloads.
+	if item.MaxDownloads > 0 {
+		contentType := item.ContentType
+		item, err = serv.store.ReserveDownload(item.ID, storeContext(r))
+		item.ContentType = contentType // keep a type override
+		if err == ErrNotFound || err == ErrDownloadsExhausted {
+			slog.Debug("Requested Item without downloads left", slog.String("id", reqId))
+
+			http.Error(w, msgNotExists, http.StatusNotFound)
+			return
+		} else if err != nil {
+			slog.Warn("Failed to reserve download", slog.String("id", reqId), slog.Any("error", err))
+
+			httpStoreError(w, err)
+			return
+		}
+	}
+
+	// The status code tells a complete download from a Range request's part.
+	cw := &countingResponseWriter{ResponseWriter: w}
+
+	complete := true
+	err = serv.handleRequestServe(cw, r, item)
+
+	// Unlike an incomplete download, a failed one has sent nothing. Thus, its
+	// reserved download is left for another request.
+	if err != nil && !errors.Is(err, ErrDownloadIncomplete) && item.MaxDownloads > 0 {
+		if err := serv.store.ReleaseDownload(item.ID, storeContext(r)); err != nil && err != ErrNotFound {
+			slog.Error("Failed to release reserved download of Item",
+				slog.String("id", item.ID), slog.Any("error", err))
+		}
+	}
+
+	if errors.Is(err, ErrDownloadIncomplete) {
+		slog.Debug("Item was not downloaded completely",
+			slog.String("id", reqId), slog.Any("error", err))
+
+		complete = false
+	} else if errors.Is(err, ErrStoreBusy) {
+		slog.Warn("Store is too busy to serve request", slog.String("id", reqId))
+
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, msgStoreBusy, http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		slog.Warn("Failed to serve request",
+			slog.Any("error", err), slog.String("id", reqId))
+
+		httpStoreError(w, err)
+		return
 	}
-	return
-}
 
-// ServeFcgi starts an FastCGI listener on the given file descriptor.
-func (serv *Server) ServeFcgi(fd *os.File) error {
-	ln, err := net.FileListener(fd)
-	if err != nil {
-		return err
+	slog.Info("Item was requested", slog.String("id", item.ID))
+	serv.metrics.downloads.Add(1)
+	if complete {
+		serv.notify(ItemDownloaded, item)
 	}
 
-	return fcgi.Serve(ln, serv)
+	if item.BurnAfterReading {
+		serv.handleBurn(item, complete, storeContext(r))
+	} else if item.lastDownload() {
+		slog.Info("Item will be deleted after its last download", slog.String("id", item.ID))
+		if serv.deleteItem(item.ID, storeContext(r)) {
+			serv.notify(ItemDeleted, item)
+		}
+	} else if item.MaxDownloads == 0 && complete {
+		// Downloads of an Item with MaxDownloads were already reserved. Partial
+		// content is an access, but no download.
+		if cw.status == http.StatusOK {
+			if err := serv.store.RecordDownload(item.ID, storeContext(r)); err != nil {
+				slog.Warn("Failed to record download of Item",
+					slog.String("id", item.ID), slog.Any("error", err))
+			}
+		} else if item.touch(time.Now()) {
+			// The fetched Item allows skipping the Touch RPC if throttled anyway.
+			if err := serv.store.Touch(item.ID, storeContext(r)); err != nil {
+				slog.Warn("Failed to record access of Item",
+					slog.String("id", item.ID), slog.Any("error", err))
+			}
+		}
+	}
 }
 
-// ServeHttpd starts an HTTPD listener on the given file descriptor.
-func (serv *Server) ServeHttpd(fd *os.File) error {
-	webServer := &http.Server{Handler: serv}
-	ln, err := net.FileListener(fd)
-	if err != nil {
-		return err
+// handleBurn burns a BurnAfterReading Item after its download. An incomplete
+// download is recorded as a failed attempt, keeping the Item until the
+// burnRetries are exhausted.
+func (serv *Server) handleBurn(item Item, complete bool, ctx context.Context) {
+	if !complete && serv.burnRetries.Attempts > 1 {
+		attempted, err := serv.store.RecordBurnAttempt(item.ID, ctx)
+		if err == ErrNotFound {
+			// Another request has already burned this Item.
+			return
+		} else if err != nil {
+			slog.Error("Failed to record failed download attempt of Item",
+				slog.String("id", item.ID), slog.Any("error", err))
+		} else if !serv.burnRetries.exhausted(attempted, time.Now()) {
+			slog.Info("Item is kept for a retry after an incomplete download",
+				slog.String("id", item.ID), slog.Int("attempts", attempted.BurnAttempts))
+			return
+		}
 	}
 
-	return webServer.Serve(ln)
-}
-
-// Close the Server and its components.
-func (serv *Server) Close() error {
-	return serv.store.Close()
+	slog.Info("Item will be burned", slog.String("id", item.ID))
+	if serv.deleteItem(item.ID, ctx) {
+		serv.notify(ItemBurned, item)
+	}
 }
 
-func (serv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	_, reqPath, _ := strings.Cut(r.URL.Path, serv.urlPrefix)
-	if reqPath == "" {
-		http.RedirectHandler(serv.urlPrefix+"/", http.StatusTemporaryRedirect).ServeHTTP(w, r)
-	} else if reqPath == "/" {
-		serv.handleRoot(w, r)
-	} else if strings.HasPrefix(reqPath, "/del/") {
-		serv.handleDeletion(w, r)
-	} else if stc, ok := serv.staticFiles[reqPath]; ok {
-		serv.handleStaticFile(w, r, stc)
-	} else {
-		serv.handleRequest(w, r)
+// deleteItem deletes an Item after its download, e.g., a BurnAfterReading one.
+// Failures are logged and false is returned.
+func (serv *Server) deleteItem(id string, ctx context.Context) bool {
+	if err := serv.store.Delete(id, ctx); err != nil {
+		slog.Error("Failed to delete Item",
+			slog.String("id", id), slog.Any("error", err))
+		return false
 	}
+	return true
 }
 
-func (serv *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		serv.handleIndex(w, r)
-
-	case http.MethodPost:
-		serv.handleUpload(w, r)
+// handleExpires responds with an Item's remaining lifetime, either as a pretty
+// duration or, with the seconds query parameter, in seconds.
+func (serv *Server) handleExpires(w http.ResponseWriter, r *http.Request, item Item) {
+	// A password protected Item's existence and expiry must not be revealed.
+	if !item.checkPassword(downloadPassword(r)) {
+		slog.Debug("Requested expiry with a missing or wrong password", slog.String("id", item.ID))
 
-	default:
-		slog.Debug("Called with unsupported method", slog.String("method", r.Method))
-
-		http.Error(w, msgUnsupportedMethod, http.StatusMethodNotAllowed)
+		w.Header().Set("WWW-Authenticate", `Basic realm="gosh"`)
+		http.Error(w, msgPasswordRequired, http.StatusUnauthorized)
+		return
 	}
-}
 
-func (serv *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
-	data := struct {
-		Expires         string
-		Size            string
-		Proto           string
-		Hostname        string
-		Prefix          string
-		EMail           string
-		DurationPattern string
-	}{
-		Expires:         PrettyDuration(serv.maxLifetime),
-		Size:            PrettyBytesize(serv.maxSize),
-		Proto:           WebProtocol(r),
-		Hostname:        r.Host,
-		Prefix:          serv.urlPrefix,
-		EMail:           serv.contactMail,
-		DurationPattern: getHtmlDurationPattern(),
+	remaining := DurationNever
+	if !item.NeverExpires() {
+		remaining = time.Until(item.Expires).Truncate(time.Second)
+	}
+	if remaining <= 0 {
+		http.Error(w, msgNotExists, http.StatusNotFound)
+		return
 	}
 
-	w.Header().Set("Content-Type", "text/html;charset=UTF-8")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
 	w.WriteHeader(http.StatusOK)
 
-	if err := serv.indexTpl.Execute(w, data); err != nil {
-		slog.Error("Failed to execute template", slog.Any("error", err))
+	// An Item without an expiry has -1 seconds resp. an unlimited duration left.
+	if r.URL.Query().Has("seconds") && remaining == DurationNever {
+		fmt.Fprintln(w, "-1")
+	} else if r.URL.Query().Has("seconds") {
+		fmt.Fprintf(w, "%d\n", int64(remaining.Seconds()))
+	} else {
+		fmt.Fprintln(w, PrettyDuration(remaining))
 	}
 }
 
-func (serv *Server) handleStaticFile(w http.ResponseWriter, r *http.Request, sfc StaticFileConfig) {
-	if r.Method != http.MethodGet {
+// handleDeletion deletes an Item for "/del/<id>/<deletion key>", either by a
+// POST or, unless deletionConfirm is set, by a GET request. Otherwise, a GET
+// request only shows a form to confirm the deletion.
+func (serv *Server) handleDeletion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
 		slog.Debug("Request with unsupported method", slog.String("method", r.Method))
 
-		http.Error(w, msgUnsupportedMethod, http.StatusMethodNotAllowed)
+		httpMethodNotAllowed(w, http.MethodGet, http.MethodPost)
 		return
 	}
 
-	w.Header().Set("Content-Type", sfc.Mime)
-	w.WriteHeader(http.StatusOK)
+	if serv.itemOpts.DisableDeletionKeys {
+		slog.Debug("Request for a disabled deletion key", slog.String("path", r.URL.Path))
 
-	staticReader := bytes.NewReader(sfc.data)
-	_, err := io.Copy(w, staticReader)
-	if err != nil {
-		slog.Error("Failed to write static file back to request", slog.Any("error", err))
+		http.Error(w, msgDeletionDisabled, http.StatusForbidden)
+		return
+	}
 
-		http.Error(w, msgGenericError, http.StatusBadRequest)
+	if !serv.checkStoreReady(w) {
 		return
 	}
-}
 
-func (serv *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
-	item, f, err := NewItemFromRequest(r, serv.maxSize, serv.maxLifetime)
-	if err == ErrLifetimeTooLong {
-		slog.Info("New Item with a too long lifetime was rejected")
+	_, reqId, _ := strings.Cut(r.URL.Path, serv.urlPrefix)
+	reqId = strings.TrimPrefix(reqId, "/")
+	reqParts := strings.Split(reqId, "/")
 
-		http.Error(w, msgLifetimeExceeds, http.StatusNotAcceptable)
+	if len(reqParts) != 3 {
+		slog.Debug("Requested URL is malformed", slog.Any("request", reqParts))
+
+		http.Error(w, msgGenericError, http.StatusBadRequest)
 		return
-	} else if err == ErrFileTooBig {
-		slog.Info("New Item with a too great file size was rejected")
+	}
+
+	reqId, delKey := reqParts[1], reqParts[2]
+
+	item, err := serv.store.Get(reqId, storeContext(r))
+	if err == ErrNotFound {
+		slog.Debug("Requested non-existing ID", slog.String("id", reqId))
 
-		http.Error(w, msgFileSizeExceeds, http.StatusNotAcceptable)
+		http.Error(w, msgNotExists, http.StatusNotFound)
 		return
 	} else if err != nil {
-		slog.Error("Failed to create new Item", slog.Any("error", err))
+		slog.Warn("Failed to request", slog.String("id", reqId), slog.Any("error", err))
 
-		http.Error(w, msgGenericError, http.StatusBadRequest)
+		httpStoreError(w, err)
 		return
-	} else if _, drop := serv.mimeDrop[item.ContentType]; drop {
-		slog.Info("Prevented upload of an illegal MIME", slog.String("mime", item.ContentType))
+	}
+
+	if !item.checkDeletionKey(delKey) {
+		slog.Warn("Deletion was requested with invalid key", slog.String("id", reqId))
 
-		http.Error(w, msgIllegalMime, http.StatusBadRequest)
+		http.Error(w, msgDeletionKeyWrong, http.StatusForbidden)
 		return
 	}
 
-	itemId, err := serv.store.Put(item, f, context.Background())
-	if err != nil {
-		slog.Error("Failed to store Item", slog.Any("error", err))
-
-		http.Error(w, msgGenericError, http.StatusBadRequest)
+	if r.Method == http.MethodGet && serv.deletionConfirm {
+		serv.handleDeletionConfirm(w, item)
+		return
+	}
+	if r.Method == http.MethodPost && !serv.checkSameSite(w, r) {
 		return
 	}
 
-	slog.Info("Uploaded new Item",
-		slog.String("id", itemId), slog.Any("expires", item.Expires))
+	if err := serv.store.Delete(item.ID, storeContext(r)); err != nil {
+		slog.Error("Failed to delete", slog.String("id", reqId), slog.Any("error", err))
 
-	w.WriteHeader(http.StatusOK)
+		httpStoreError(w, err)
+		return
+	}
 
-	baseUrl := fmt.Sprintf("%s://%s%s", WebProtocol(r), r.Host, serv.urlPrefix)
-	onlyUrl := r.URL.Query().Has("onlyURL")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, msgDeletionSuccess)
 
-	if onlyUrl {
-		fmt.Fprintf(w, "%s/%s\n", baseUrl, itemId)
-	} else {
-		fmt.Fprintf(w, "Fetch:   %s/%s\n", baseUrl, itemId)
-		fmt.Fprintf(w, "Delete:  %s/del/%s/%s\n", baseUrl, itemId, item.DeletionKey)
-		fmt.Fprintln(w)
-		fmt.Fprintf(w, "Expires: %v\n", item.Expires)
-		fmt.Fprintf(w, "Burn:    %t\n", item.BurnAfterReading)
-	}
+	slog.Info("Item was deleted by request", slog.String("id", reqId))
+	serv.metrics.deletions.Add(1)
+	serv.notify(ItemDeleted, item)
 }
 
-// hasClientCachedRequest if the client submits a conditional GET, e.g., If-Modified-Since.
-func (serv *Server) hasClientCachedRequest(r *http.Request, item Item) bool {
-	ims, imsErr := http.ParseTime(r.Header.Get("If-Modified-Since"))
-	if imsErr != nil {
-		return false
+// handleMetrics exposes the metrics in the Prometheus text format.
+func (serv *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		slog.Debug("Request with unsupported method", slog.String("method", r.Method))
+
+		httpMethodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
 	}
 
-	return item.Created.Before(ims) && item.Expires.After(ims)
-}
+	if !serv.checkStoreReady(w) {
+		return
+	}
 
-// handleRequestServe is called from handleRequest when a valid Item should be served.
-func (serv *Server) handleRequestServe(w http.ResponseWriter, r *http.Request, item Item) error {
-	f, err := serv.store.GetFile(item.ID, context.Background())
+	stats, err := serv.store.Stats(storeContext(r))
 	if err != nil {
-		return fmt.Errorf("reading file failed: %v", err)
+		slog.Warn("Failed to request store stats", slog.Any("error", err))
+
+		httpStoreError(w, err)
+		return
 	}
 
-	defer f.Close()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	serv.metrics.write(w, stats)
+}
 
-	mimeType := item.ContentType
-	if mimeSubst, ok := serv.mimeMap[mimeType]; ok {
-		mimeType = mimeSubst
+// healthTimeout is the time in which the store must answer a health check.
+const healthTimeout = time.Second
+
+// handleHealth responds with a 200 OK if the store answers a Ping within the
+// healthTimeout and with a 503 Service Unavailable otherwise. Thus, a load
+// balancer might drain an instance whose store process has died.
+func (serv *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		slog.Debug("Request with unsupported method", slog.String("method", r.Method))
+
+		httpMethodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
 	}
 
-	w.Header().Set("Content-Type", mimeType)
-	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", item.Filename))
+	// Responses must reflect the current state and not be cached.
+	w.Header().Set("Cache-Control", "no-store")
 
-	// Original creation date might be seen as confidential.
-	w.Header().Set("Last-Modified", time.Now().Format(http.TimeFormat))
+	ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+	defer cancel()
+
+	if err := serv.store.Ping(ctx); err != nil {
+		slog.Warn("Store failed the health check", slog.Any("error", err))
 
+		http.Error(w, msgStoreUnhealthy, http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, msgStoreHealthy)
+}
 
-	// An error might happen here if the peer resets the connection, e.g., if
-	// curl tries to print a non text file to stdout.
-	_, _ = io.Copy(w, f)
+// Limits of the admin item listing, if no resp. a too great limit is requested.
+const (
+	adminListDefaultLimit = 100
+	adminListMaxLimit     = 1000
+)
 
-	return nil
+// adminItem is the JSON representation of an Item for the admin listing. It
+// omits secrets, e.g., the DeletionKey and the PasswordHash.
+type adminItem struct {
+	ID          string               `json:"id"`
+	Filename    string               `json:"filename"`
+	ContentType string               `json:"content_type"`
+	Size        int64                `json:"size"`
+	Created     time.Time            `json:"created"`
+	Expires     time.Time            `json:"expires"`
+	Downloads   int                  `json:"downloads"`
+	LastAccess  time.Time            `json:"last_access"`
+	Owners      map[OwnerType]net.IP `json:"owners"`
 }
 
-func (serv *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
+// handleAdmin serves the admin endpoints below the adminPath, requiring its
+// token as a bearer token.
+func (serv *Server) handleAdmin(w http.ResponseWriter, r *http.Request, adminPath string) {
+	if err := serv.adminAuthorizer.Authorize(r); err != nil {
+		slog.Warn("Rejected unauthorized admin request", slog.String("path", r.URL.Path))
+
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, msgAdminUnauthorized, http.StatusUnauthorized)
+		return
+	}
+
+	if adminPath != "/items" {
+		http.Error(w, msgNotExists, http.StatusNotFound)
+		return
+	}
+
 	if r.Method != http.MethodGet {
 		slog.Debug("Request with unsupported method", slog.String("method", r.Method))
 
-		http.Error(w, msgUnsupportedMethod, http.StatusMethodNotAllowed)
+		httpMethodNotAllowed(w, http.MethodGet)
 		return
 	}
 
-	_, reqId, _ := strings.Cut(r.URL.Path, serv.urlPrefix)
-	reqId = strings.TrimLeft(reqId, "/")
+	if !serv.checkStoreReady(w) {
+		return
+	}
 
-	item, err := serv.store.Get(reqId, context.Background())
-	if err == ErrNotFound {
-		slog.Debug("Requested non-existing ID", slog.String("id", reqId))
+	offset, limit := 0, adminListDefaultLimit
+	var err error
+	if v := r.URL.Query().Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+	}
+	if v := r.URL.Query().Get("limit"); err == nil && v != "" {
+		limit, err = strconv.Atoi(v)
+	}
+	if err != nil || offset < 0 || limit <= 0 {
+		http.Error(w, msgPaginationInvalid, http.StatusBadRequest)
+		return
+	}
+	limit = min(limit, adminListMaxLimit)
 
-		http.Error(w, msgNotExists, http.StatusNotFound)
+	query := ListQuery{Offset: offset, Limit: limit, Order: ListOrder(r.URL.Query().Get("order"))}
+	switch query.Order {
+	case "":
+		query.Order = ListByCreated
+	case ListByCreated, ListByDownloads, ListByLastAccess:
+	default:
+		err = fmt.Errorf("unknown order %q", query.Order)
+	}
+	if v := r.URL.Query().Get("never_downloaded"); err == nil && v != "" {
+		query.NeverDownloaded, err = strconv.ParseBool(v)
+	}
+	if err != nil {
+		http.Error(w, msgListQueryInvalid, http.StatusBadRequest)
 		return
-	} else if err != nil {
-		slog.Warn("Failed to request", slog.String("id", reqId), slog.Any("error", err))
+	}
 
-		http.Error(w, msgGenericError, http.StatusBadRequest)
+	items, err := serv.store.List(query, storeContext(r))
+	if err != nil {
+		slog.Warn("Failed to list Items", slog.Any("error", err))
+
+		httpStoreError(w, err)
 		return
 	}
 
-	if serv.hasClientCachedRequest(r, item) {
-		slog.Debug("Requested with conditional GET; HTTP Status Code 304", slog.String("id", reqId))
-		w.WriteHeader(http.StatusNotModified)
-	} else {
-		err := serv.handleRequestServe(w, r, item)
-		if err != nil {
-			slog.Warn("Failed to serve request",
-				slog.Any("error", err), slog.String("id", reqId))
+	resp := struct {
+		Offset int         `json:"offset"`
+		Limit  int         `json:"limit"`
+		Items  []adminItem `json:"items"`
+	}{
+		Offset: offset,
+		Limit:  limit,
+		Items:  make([]adminItem, 0, len(items)),
+	}
+	for _, item := range items {
+		resp.Items = append(resp.Items, adminItem{
+			ID:          item.ID,
+			Filename:    item.Filename,
+			ContentType: item.ContentType,
+			Size:        item.Size,
+			Created:     item.Created,
+			Expires:     item.Expires,
+			Downloads:   item.Downloads,
+			LastAccess:  item.LastAccess,
+			Owners:      item.Owner,
+		})
+	}
 
-			http.Error(w, msgGenericError, http.StatusBadRequest)
-			return
-		}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Warn("Failed to write admin item listing", slog.Any("error", err))
 	}
+}
 
-	slog.Info("Item was requested", slog.String("id", item.ID))
+// handleDeletionConfirm responds with a form to confirm the Item's deletion,
+// not being cached and not leaking the deletion key as a referrer.
+func (serv *Server) handleDeletionConfirm(w http.ResponseWriter, item Item) {
+	data := struct {
+		ID       string
+		Filename string
+	}{
+		ID:       item.ID,
+		Filename: item.Filename,
+	}
 
-	if item.BurnAfterReading {
-		slog.Info("Item will be burned", slog.String("id", item.ID))
-		if err := serv.store.Delete(item.ID, context.Background()); err != nil {
-			slog.Error("Failed to delete Item",
-				slog.String("id", item.ID), slog.Any("error", err))
-		}
+	w.Header().Set("Content-Type", "text/html;charset=UTF-8")
+	w.Header().Set("Content-Security-Policy", formPageCsp)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(http.StatusOK)
+
+	if err := deletionConfirmTpl.Execute(w, data); err != nil {
+		slog.Error("Failed to execute template", slog.Any("error", err))
 	}
 }
 
-func (serv *Server) handleDeletion(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+// handleSettings updates an Item's settings, i.e., burn after reading and a
+// shorter lifetime, for a POST to "/settings/<id>/<deletion key>".
+func (serv *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		slog.Debug("Request with unsupported method", slog.String("method", r.Method))
 
-		http.Error(w, msgUnsupportedMethod, http.StatusMethodNotAllowed)
+		httpMethodNotAllowed(w, http.MethodPost)
 		return
 	}
 
+	if serv.itemOpts.DisableDeletionKeys {
+		slog.Debug("Request for a disabled deletion key", slog.String("path", r.URL.Path))
+
+		http.Error(w, msgDeletionDisabled, http.StatusForbidden)
+		return
+	}
+
+	if !serv.checkSameSite(w, r) || !serv.checkStoreReady(w) {
+		return
+	}
+
+	// The settings are only a few short form fields.
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormFieldSize)
+
 	_, reqId, _ := strings.Cut(r.URL.Path, serv.urlPrefix)
-	reqId = strings.TrimLeft(reqId, "/")
+	reqId = strings.TrimPrefix(reqId, "/")
 	reqParts := strings.Split(reqId, "/")
 
 	if len(reqParts) != 3 {
@@ -345,7 +2467,42 @@ func (serv *Server) handleDeletion(w http.ResponseWriter, r *http.Request) {
 
 	reqId, delKey := reqParts[1], reqParts[2]
 
-	item, err := serv.store.Get(reqId, context.Background())
+	var settings ItemSettings
+
+	switch burn := r.FormValue(formBurnAfterReading); burn {
+	case "":
+	case "0", "1":
+		settings.SetBurnAfterReading = true
+		settings.BurnAfterReading = burn == "1"
+	default:
+		http.Error(w, msgSettingsInvalid, http.StatusBadRequest)
+		return
+	}
+
+	if lifetime := r.FormValue(formLifetime); lifetime != "" {
+		parseLt, err := ParseDuration(lifetime)
+		if err != nil {
+			http.Error(w, msgSettingsInvalid, http.StatusBadRequest)
+			return
+		} else if parseLt > serv.itemOpts.MaxLifetime {
+			http.Error(w, msgLifetimeExceeds, http.StatusBadRequest)
+			return
+		} else if parseLt == DurationNever {
+			// A lifetime might only be shortened, thus never be unlimited.
+			http.Error(w, msgLifetimeExtended, http.StatusBadRequest)
+			return
+		}
+
+		expires := time.Now().UTC().Add(parseLt)
+		settings.Expires = &expires
+	}
+
+	if !settings.SetBurnAfterReading && settings.Expires == nil {
+		http.Error(w, msgSettingsInvalid, http.StatusBadRequest)
+		return
+	}
+
+	item, err := serv.store.Get(reqId, storeContext(r))
 	if err == ErrNotFound {
 		slog.Debug("Requested non-existing ID", slog.String("id", reqId))
 
@@ -354,33 +2511,46 @@ func (serv *Server) handleDeletion(w http.ResponseWriter, r *http.Request) {
 	} else if err != nil {
 		slog.Warn("Failed to request", slog.String("id", reqId), slog.Any("error", err))
 
-		http.Error(w, msgGenericError, http.StatusBadRequest)
+		httpStoreError(w, err)
 		return
 	}
 
-	if item.DeletionKey != delKey {
-		slog.Warn("Deletion was requested with invalid key", slog.String("id", reqId))
+	if !item.checkDeletionKey(delKey) {
+		slog.Warn("Settings update was requested with invalid key", slog.String("id", reqId))
 
 		http.Error(w, msgDeletionKeyWrong, http.StatusForbidden)
 		return
 	}
 
-	if err := serv.store.Delete(item.ID, context.Background()); err != nil {
-		slog.Error("Failed to delete", slog.String("id", reqId), slog.Any("error", err))
+	item, err = serv.store.UpdateSettings(item.ID, settings, storeContext(r))
+	if err == ErrNotFound {
+		http.Error(w, msgNotExists, http.StatusNotFound)
+		return
+	} else if err == ErrLifetimeExtended {
+		http.Error(w, msgLifetimeExtended, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		slog.Error("Failed to update settings", slog.String("id", reqId), slog.Any("error", err))
 
-		http.Error(w, msgGenericError, http.StatusBadRequest)
+		httpStoreError(w, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintln(w, msgDeletionSuccess)
+	slog.Info("Item's settings were updated by request", slog.String("id", reqId))
 
-	slog.Info("Item was deleted by request", slog.String("id", reqId))
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "Expires: %s\n", formatExpires(item))
+	fmt.Fprintf(w, "Burn:    %t\n", item.BurnAfterReading)
 }
 
-// WebProtocol returns "http" or "https", based either on the X-Forwarded-Proto
-// header or FastCGI's SERVER_PORT variable.
+// WebProtocol returns "http" or "https", based either on a TLS connection
+// terminated by the Server itself, the X-Forwarded-Proto header, or FastCGI's
+// SERVER_PORT variable.
 func WebProtocol(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+
 	fcgiParams := fcgi.ProcessEnv(r)
 	if serverPort, ok := fcgiParams["SERVER_PORT"]; ok && serverPort == "443" {
 		return "https"
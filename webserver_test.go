@@ -0,0 +1,3308 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"math/rand"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/akamensky/base58"
+)
+
+func TestCopyVerified(t *testing.T) {
+	data := make([]byte, 100*1024)
+	rand.New(rand.NewSource(0)).Read(data)
+
+	corrupted := bytes.Clone(data)
+	corrupted[len(corrupted)/2] ^= 0xff
+
+	tests := []struct {
+		name  string
+		input []byte
+		hash  string
+		valid bool
+	}{
+		{"valid", data, sha256Hex(data), true},
+		{"valid-empty", []byte{}, sha256Hex([]byte{}), true},
+		{"corrupted", corrupted, sha256Hex(data), false},
+		{"corrupted-empty", []byte{}, sha256Hex(data), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := copyVerified(&out, bytes.NewReader(test.input), test.hash)
+
+			if test.valid {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(out.Bytes(), test.input) {
+					t.Fatalf("Output mismatches input")
+				}
+				return
+			}
+
+			if err != ErrChecksumMismatch {
+				t.Fatalf("Expected ErrChecksumMismatch, got %v", err)
+			}
+			if out.Len() >= len(test.input) && len(test.input) > 0 {
+				t.Fatalf("Output was not truncated, %d bytes written", out.Len())
+			}
+		})
+	}
+}
+
+func TestCopyVerifiedCorruptedBlob(t *testing.T) {
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, randomIdGenerator(4), StoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	itemDataRaw := []byte("hello world")
+	itemId, err := store.Put(Item{Expires: time.Now().Add(time.Minute).UTC()},
+		newDummyReadCloser(bytes.NewBuffer(itemDataRaw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := store.Get(itemId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(store.storageDir(), itemId), []byte("hello w0rld"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := store.GetFile(itemId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var out bytes.Buffer
+	if err := copyVerified(&out, f, item.ContentHash); err != ErrChecksumMismatch {
+		t.Fatalf("Expected ErrChecksumMismatch, got %v", err)
+	}
+	if out.Len() >= len(itemDataRaw) {
+		t.Fatalf("Output was not truncated, %d bytes written", out.Len())
+	}
+}
+
+// newTestServer creates a Server backed by the store client for testing.
+//
+// The given modify function might alter the default configuration.
+func newTestServer(t *testing.T, store *StoreRpcClient, modify func(*WebserverConfig)) *Server {
+	var conf WebserverConfig
+	conf.ItemConfig.MaxSize = "1MiB"
+	conf.ItemConfig.MaxLifetime = time.Hour
+	conf.Contact = "nobody@example.com"
+	conf.StaticFiles = map[string]StaticFileConfig{
+		"/custom.css": {Path: "custom.css", Mime: "text/css", data: []byte("body {}")},
+	}
+
+	if modify != nil {
+		modify(&conf)
+	}
+
+	server, err := NewServer(store, conf, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestServerHead(t *testing.T) {
+	server := newTestServer(t, nil, nil)
+
+	tests := []struct {
+		path        string
+		contentType string
+	}{
+		{"/", "text/html;charset=UTF-8"},
+		{"/custom.css", "text/css"},
+	}
+
+	for _, test := range tests {
+		getRec := httptest.NewRecorder()
+		server.ServeHTTP(getRec, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+		headRec := httptest.NewRecorder()
+		server.ServeHTTP(headRec, httptest.NewRequest(http.MethodHead, test.path, nil))
+
+		for _, rec := range []*httptest.ResponseRecorder{getRec, headRec} {
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s: expected status %d, got %d", test.path, http.StatusOK, rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != test.contentType {
+				t.Fatalf("%s: expected Content-Type %q, got %q", test.path, test.contentType, ct)
+			}
+		}
+
+		if getRec.Body.Len() == 0 {
+			t.Fatalf("%s: GET returned an empty body", test.path)
+		}
+		if headRec.Body.Len() != 0 {
+			t.Fatalf("%s: HEAD returned a body of %d bytes", test.path, headRec.Body.Len())
+		}
+	}
+}
+
+func TestServerStoreReadiness(t *testing.T) {
+	serverRpc, clientRpc, serverFd, clientFd := newUnixConnPairs(t)
+
+	client := NewStoreRpcClient(clientRpc, clientFd, StoreRpcClientOpts{})
+	server := newTestServer(t, client, nil)
+	defer server.Close()
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/whatever", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d before the store is ready, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d for the index, got %d", http.StatusOK, rec.Code)
+	}
+
+	storageDir, err := os.MkdirTemp("", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	store, err := NewStore(storageDir, randomIdGenerator(4), StoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpcServer := NewStoreRpcServer(store, serverRpc, serverFd, 0)
+	defer rpcServer.Close()
+
+	for i := 0; !server.storeReady.Load(); i++ {
+		if i > 50 {
+			t.Fatal("Store was not marked as ready")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/whatever", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %d after the store is ready, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestServerDefaultLifetime(t *testing.T) {
+	tests := []struct {
+		defaultLifetime time.Duration
+		maxLifetime     time.Duration
+
+		expected time.Duration
+	}{
+		{0, time.Hour, time.Hour},
+		{time.Minute, time.Hour, time.Minute},
+		{2 * time.Hour, time.Hour, time.Hour},
+	}
+
+	for _, test := range tests {
+		server := newTestServer(t, nil, func(conf *WebserverConfig) {
+			conf.ItemConfig.DefaultLifetime = test.defaultLifetime
+			conf.ItemConfig.MaxLifetime = test.maxLifetime
+		})
+
+		if server.itemOpts.DefaultLifetime != test.expected {
+			t.Fatalf("Expected default lifetime %v, got %v", test.expected, server.itemOpts.DefaultLifetime)
+		}
+	}
+}
+
+func TestServerUploadOrigin(t *testing.T) {
+	tests := []struct {
+		name     string
+		allowed  []string
+		required bool
+		headers  map[string]string
+		expected int
+	}{
+		{"disabled", nil, false, map[string]string{"Origin": "https://evil.example"}, http.StatusServiceUnavailable},
+		{"allowed-origin", []string{"https://example.org"}, false, map[string]string{"Origin": "https://example.org"}, http.StatusServiceUnavailable},
+		{"allowed-origin-case", []string{"https://Example.org"}, false, map[string]string{"Origin": "HTTPS://example.ORG"}, http.StatusServiceUnavailable},
+		{"allowed-referer", []string{"https://example.org"}, false, map[string]string{"Referer": "https://example.org/index.html"}, http.StatusServiceUnavailable},
+		{"disallowed-origin", []string{"https://example.org"}, false, map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"disallowed-port", []string{"https://example.org"}, false, map[string]string{"Origin": "https://example.org:8443"}, http.StatusForbidden},
+		{"disallowed-referer", []string{"https://example.org"}, false, map[string]string{"Referer": "https://evil.example/"}, http.StatusForbidden},
+		{"disallowed-null", []string{"https://example.org"}, false, map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{"origin-precedes-referer", []string{"https://example.org"}, false, map[string]string{"Origin": "https://evil.example", "Referer": "https://example.org/"}, http.StatusForbidden},
+		{"missing", []string{"https://example.org"}, false, nil, http.StatusServiceUnavailable},
+		{"missing-required", []string{"https://example.org"}, true, nil, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, nil, func(conf *WebserverConfig) {
+				conf.UploadOrigins.Allowed = test.allowed
+				conf.UploadOrigins.Required = test.required
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			for k, v := range test.headers {
+				req.Header.Set(k, v)
+			}
+
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, req)
+			if rec.Code != test.expected {
+				t.Fatalf("Expected status %d, got %d", test.expected, rec.Code)
+			}
+		})
+	}
+}
+
+// newTestServerMemoryStore creates a Server backed by a MemoryStore over RPC
+// and waits until the store is ready.
+func newTestServerMemoryStore(t *testing.T, modify func(*WebserverConfig)) *Server {
+	return newTestServerStorer(t, NewMemoryStore(randomIdGenerator(4), StoreOpts{}), modify)
+}
+
+// newTestServerStorer creates a Server backed by the Storer over a Store RPC.
+func newTestServerStorer(t *testing.T, store Storer, modify func(*WebserverConfig)) *Server {
+	serverRpc, clientRpc, serverFd, clientFd := newUnixConnPairs(t)
+
+	rpcServer := NewStoreRpcServer(store, serverRpc, serverFd, 0)
+	t.Cleanup(func() { _ = rpcServer.Close() })
+
+	server := newTestServer(t, NewStoreRpcClient(clientRpc, clientFd, StoreRpcClientOpts{}), modify)
+	t.Cleanup(func() { _ = server.Close() })
+
+	for i := 0; !server.storeReady.Load(); i++ {
+		if i > 50 {
+			t.Fatal("Store was not marked as ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return server
+}
+
+// newUploadRequest creates a multipart upload request for the data.
+func newUploadRequest(t *testing.T, target string, data []byte, fields map[string]string) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="file"; filename="test.txt"`)
+	header.Set("Content-Type", "text/plain")
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := part.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, target, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestServerUploadDownload(t *testing.T) {
+	server := newTestServerMemoryStore(t, nil)
+	data := []byte("hello world")
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", data, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d for upload, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+	}
+
+	fetchUrl, err := url.Parse(strings.TrimSpace(rec.Body.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fetchUrl.Path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d for download, got %d", http.StatusOK, rec.Code)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), data) {
+		t.Fatalf("Downloaded data mismatches: %q", rec.Body.Bytes())
+	}
+}
+
+func TestServerTypeOverride(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides []string
+		query     string
+		code      int
+		mime      string
+	}{
+		{"disabled", nil, "?type=application/json", http.StatusOK, "text/plain"},
+		{"none", []string{"application/json"}, "", http.StatusOK, "text/plain"},
+		{"allowed", []string{"application/json"}, "?type=application/json", http.StatusOK, "application/json"},
+		{"allowed-case", []string{"application/json"}, "?type=Application/JSON", http.StatusOK, "application/json"},
+		{"rejected", []string{"application/json"}, "?type=application/pdf", http.StatusBadRequest, ""},
+		{"rejected-html", []string{"application/json"}, "?type=text/html", http.StatusBadRequest, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+				conf.ItemConfig.TypeOverrides = test.overrides
+			})
+
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", []byte("{}"), nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected status %d for upload, got %d", http.StatusOK, rec.Code)
+			}
+
+			fetchUrl, err := url.Parse(strings.TrimSpace(rec.Body.String()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rec = httptest.NewRecorder()
+			server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fetchUrl.Path+test.query, nil))
+			if rec.Code != test.code {
+				t.Fatalf("Expected status %d, got %d", test.code, rec.Code)
+			}
+			if test.mime != "" && rec.Header().Get("Content-Type") != test.mime {
+				t.Fatalf("Expected Content-Type %q, got %q", test.mime, rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestServerTypeOverrideUnsafe(t *testing.T) {
+	for _, override := range []string{"text/html", "image/svg+xml", "TEXT/JavaScript"} {
+		var conf WebserverConfig
+		conf.ItemConfig.MaxSize = "1MiB"
+		conf.ItemConfig.TypeOverrides = []string{"text/plain", override}
+
+		if _, err := NewServer(nil, conf, ""); err == nil {
+			t.Fatalf("Unsafe type override %q was accepted", override)
+		}
+	}
+}
+
+func TestServerSecurityHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		itemsCsp string
+		indexCsp string
+	}{
+		{"default", "", ""},
+		{"custom", "default-src 'self'", "default-src *"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+				conf.ContentSecurityPolicy.Items = test.itemsCsp
+				conf.ContentSecurityPolicy.Index = test.indexCsp
+			})
+
+			itemsCsp, indexCsp := test.itemsCsp, test.indexCsp
+			if itemsCsp == "" {
+				itemsCsp = defaultItemsCsp
+			}
+			if indexCsp == "" {
+				indexCsp = defaultIndexCsp
+			}
+
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if csp := rec.Header().Get("Content-Security-Policy"); csp != indexCsp {
+				t.Fatalf("Expected index Content-Security-Policy %q, got %q", indexCsp, csp)
+			}
+
+			rec = httptest.NewRecorder()
+			server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", []byte("<script>alert(1)</script>"), nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected status %d for upload, got %d", http.StatusOK, rec.Code)
+			}
+
+			fetchUrl, err := url.Parse(strings.TrimSpace(rec.Body.String()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, method := range []string{http.MethodHead, http.MethodGet} {
+				rec = httptest.NewRecorder()
+				server.ServeHTTP(rec, httptest.NewRequest(method, fetchUrl.Path, nil))
+				if rec.Code != http.StatusOK {
+					t.Fatalf("%s: expected status %d, got %d", method, http.StatusOK, rec.Code)
+				}
+				if xcto := rec.Header().Get("X-Content-Type-Options"); xcto != "nosniff" {
+					t.Fatalf("%s: expected X-Content-Type-Options nosniff, got %q", method, xcto)
+				}
+				if csp := rec.Header().Get("Content-Security-Policy"); csp != itemsCsp {
+					t.Fatalf("%s: expected Content-Security-Policy %q, got %q", method, itemsCsp, csp)
+				}
+			}
+		})
+	}
+}
+
+func TestServerRequestPath(t *testing.T) {
+	server := newTestServerMemoryStore(t, nil)
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", []byte("hello world"), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d for upload, got %d", http.StatusOK, rec.Code)
+	}
+
+	fetchUrl, err := url.Parse(strings.TrimSpace(rec.Body.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := strings.TrimPrefix(fetchUrl.Path, "/")
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/" + id, http.StatusOK},
+		{"//" + id, http.StatusNotFound},
+		{"/" + id + "/", http.StatusNotFound},
+		{"/" + id + "/foo", http.StatusNotFound},
+		{"/" + id + "/test.txt", http.StatusOK},
+		{"/" + id + "/test.txt/", http.StatusNotFound},
+		{"/" + id + "/TEST.txt", http.StatusNotFound},
+		{"/" + id + "/foo/test.txt", http.StatusNotFound},
+		{"/foo/" + id, http.StatusNotFound},
+		{"/nope", http.StatusNotFound},
+		{"/nope/test.txt", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL.Path = test.path
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		if rec.Code != test.code {
+			t.Fatalf("%q: expected status %d, got %d", test.path, test.code, rec.Code)
+		}
+
+		if test.code == http.StatusOK {
+			if body := rec.Body.String(); body != "hello world" {
+				t.Fatalf("%q: unexpected body %q", test.path, body)
+			}
+			if cd := rec.Header().Get("Content-Disposition"); cd != `inline; filename="test.txt"` {
+				t.Fatalf("%q: unexpected Content-Disposition %q", test.path, cd)
+			}
+		}
+	}
+}
+
+func TestServerRequestFilename(t *testing.T) {
+	server := newTestServerMemoryStore(t, nil)
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", []byte("hello world"),
+		map[string]string{"filename": "my report.pdf", "burn": "1"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d for upload, got %d", http.StatusOK, rec.Code)
+	}
+
+	fetchUrl, err := url.Parse(strings.TrimSpace(rec.Body.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := strings.TrimPrefix(fetchUrl.Path, "/")
+
+	// A mismatching filename must neither serve nor burn the Item.
+	for _, path := range []string{"/" + id + "/my report.pdf", "/" + id + "/report.pdf"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL.Path = path
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("%q: expected status %d, got %d", path, http.StatusNotFound, rec.Code)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+id+"/my_report.pdf", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d for the sanitized filename, got %d", http.StatusOK, rec.Code)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); cd != `inline; filename="my_report.pdf"` {
+		t.Fatalf("Unexpected Content-Disposition %q", cd)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+id, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected burned Item, got status %d", rec.Code)
+	}
+}
+
+func TestServerExpires(t *testing.T) {
+	server := newTestServerMemoryStore(t, nil)
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", []byte("hello world"), map[string]string{"burn": "1", "time": "10m"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d for upload, got %d", http.StatusOK, rec.Code)
+	}
+
+	fetchUrl, err := url.Parse(strings.TrimSpace(rec.Body.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getExpires := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code, strings.TrimSpace(rec.Body.String())
+	}
+
+	code, pretty := getExpires(fetchUrl.Path + "/expires")
+	if code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	} else if !strings.HasPrefix(pretty, "9 minutes") {
+		t.Fatalf("Unexpected pretty countdown %q", pretty)
+	}
+
+	_, first := getExpires(fetchUrl.Path + "/expires?seconds")
+	time.Sleep(1100 * time.Millisecond)
+	_, second := getExpires(fetchUrl.Path + "/expires?seconds")
+
+	firstSecs, err := strconv.Atoi(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondSecs, err := strconv.Atoi(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if firstSecs > 600 || secondSecs >= firstSecs {
+		t.Fatalf("Countdown did not decrease: %d, %d", firstSecs, secondSecs)
+	}
+
+	// The expiry requests must not have burned the Item.
+	if code, body := getExpires(fetchUrl.Path); code != http.StatusOK || body != "hello world" {
+		t.Fatalf("Item was not served after expiry requests: %d", code)
+	}
+	if code, _ := getExpires(fetchUrl.Path + "/expires"); code != http.StatusNotFound {
+		t.Fatalf("Expected status %d for burned Item, got %d", http.StatusNotFound, code)
+	}
+	if code, _ := getExpires("/nope/expires"); code != http.StatusNotFound {
+		t.Fatalf("Expected status %d for missing Item, got %d", http.StatusNotFound, code)
+	}
+
+	// An Item named like the reserved suffix is served under its filename.
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", []byte("hello world"), map[string]string{"filename": "expires"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d for upload, got %d", http.StatusOK, rec.Code)
+	}
+	if code, body := getExpires(strings.TrimPrefix(strings.TrimSpace(rec.Body.String()), "http://example.com") + "/expires"); code != http.StatusOK || body != "hello world" {
+		t.Fatalf("Item named expires was not served: %d, %q", code, body)
+	}
+}
+
+func TestServerNeverExpires(t *testing.T) {
+	upload := func(server *Server, lifetime string) (int, string) {
+		fields := map[string]string{}
+		if lifetime != "" {
+			fields["time"] = lifetime
+		}
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", []byte("hello world"), fields))
+		return rec.Code, strings.TrimSpace(rec.Body.String())
+	}
+
+	// A finite max_lifetime rejects unlimited lifetimes.
+	server := newTestServerMemoryStore(t, nil)
+	if code, _ := upload(server, "never"); code != http.StatusNotAcceptable {
+		t.Fatalf("Expected status %d for limited server, got %d", http.StatusNotAcceptable, code)
+	}
+
+	server = newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+		conf.ItemConfig.MaxLifetime = 0
+		conf.ItemConfig.DefaultLifetime = time.Hour
+	})
+
+	tests := []struct {
+		lifetime string
+		never    bool
+	}{
+		{"", false},
+		{"2y", false},
+		{"never", true},
+	}
+
+	for _, test := range tests {
+		code, body := upload(server, test.lifetime)
+		if code != http.StatusOK {
+			t.Fatalf("%q: expected status %d for upload, got %d: %s", test.lifetime, http.StatusOK, code, body)
+		}
+
+		fetchUrl, err := url.Parse(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		item, err := server.store.Get(strings.TrimPrefix(fetchUrl.Path, "/"), context.Background())
+		if err != nil {
+			t.Fatal(err)
+		} else if item.NeverExpires() != test.never {
+			t.Fatalf("%q: expected never expiring %t, got Expires %v", test.lifetime, test.never, item.Expires)
+		}
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fetchUrl.Path+"/expires?seconds", nil))
+		if seconds := strings.TrimSpace(rec.Body.String()); test.never != (seconds == "-1") {
+			t.Fatalf("%q: unexpected remaining seconds %q", test.lifetime, seconds)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if body := rec.Body.String(); !strings.Contains(body, `|never"`) || !strings.Contains(body, `Another expiry or "never"`) {
+		t.Fatalf("Index does not offer unlimited lifetimes: %s", body)
+	}
+}
+
+func TestServerSettings(t *testing.T) {
+	server := newTestServerMemoryStore(t, nil)
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, newUploadRequest(t, "/", []byte("hello world"), map[string]string{"time": "30m"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d for upload, got %d", http.StatusOK, rec.Code)
+	}
+
+	var delPath string
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if after, ok := strings.CutPrefix(line, "Delete:"); ok {
+			delUrl, err := url.Parse(strings.TrimSpace(after))
+			if err != nil {
+				t.Fatal(err)
+			}
+			delPath = delUrl.Path
+		}
+	}
+	settingsPath := strings.Replace(delPath, "/del/", "/settings/", 1)
+	id := strings.Split(settingsPath, "/")[2]
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		form   url.Values
+		code   int
+		burn   bool
+	}{
+		{"wrong-method", http.MethodGet, settingsPath, url.Values{"burn": {"1"}}, http.StatusMethodNotAllowed, false},
+		{"wrong-key", http.MethodPost, "/settings/" + id + "/nope", url.Values{"burn": {"1"}}, http.StatusForbidden, false},
+		{"missing", http.MethodPost, "/settings/nope/nope", url.Values{"burn": {"1"}}, http.StatusNotFound, false},
+		{"malformed", http.MethodPost, "/settings/" + id, url.Values{"burn": {"1"}}, http.StatusBadRequest, false},
+		{"empty", http.MethodPost, settingsPath, url.Values{}, http.StatusBadRequest, false},
+		{"invalid-burn", http.MethodPost, settingsPath, url.Values{"burn": {"yes"}}, http.StatusBadRequest, false},
+		{"invalid-time", http.MethodPost, settingsPath, url.Values{"time": {"soon"}}, http.StatusBadRequest, false},
+		{"extend", http.MethodPost, settingsPath, url.Values{"time": {"45m"}}, http.StatusBadRequest, false},
+		{"exceed-max", http.MethodPost, settingsPath, url.Values{"time": {"2h"}}, http.StatusBadRequest, false},
+		{"never", http.MethodPost, settingsPath, url.Values{"time": {"never"}}, http.StatusBadRequest, false},
+		{"burn", http.MethodPost, settingsPath, url.Values{"burn": {"1"}}, http.StatusOK, true},
+		{"shorten", http.MethodPost, settingsPath, url.Values{"time": {"5m"}}, http.StatusOK, true},
+		{"unburn", http.MethodPost, settingsPath, url.Values{"burn": {"0"}}, http.StatusOK, false},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		if rec.Code != test.code {
+			t.Fatalf("%s: expected status %d, got %d: %s", test.name, test.code, rec.Code, rec.Body)
+		}
+
+		item, err := server.store.Get(id, context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.BurnAfterReading != test.burn {
+			t.Fatalf("%s: expected burn %t, got %t", test.name, test.burn, item.BurnAfterReading)
+		}
+		if remaining := time.Until(item.Expires); remaining > 30*time.Minute {
+			t.Fatalf("%s: lifetime was extended to %v", test.name, remaining)
+		}
+		if test.name == "shorten" && time.Until(item.Expires) > 5*time.Minute {
+			t.Fatalf("%s: lifetime was not shortened", test.name)
+		}
+	}
+
+	// Neither a cross-site request nor an oversized body changes the Item.
+	for _, test := range []struct {
+		name   string
+		header map[string]string
+		body   string
+		code   int
+	}{
+		{"cross-site", map[string]string{"Sec-Fetch-Site": "cross-site"}, "burn=1", http.StatusForbidden},
+		{"oversized", nil, "burn=1&pad=" + strings.Repeat("x", maxFormFieldSize), http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(http.MethodPost, settingsPath, strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for k, v := range test.header {
+			req.Header.Set(k, v)
+		}
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		if rec.Code != test.code {
+			t.Fatalf("%s: expected status %d, got %d", test.name, test.code, rec.Code)
+		}
+
+		if item, err := server.store.Get(id, context.Background()); err != nil {
+			t.Fatal(err)
+		} else if item.BurnAfterReading {
+			t.Fatalf("%s: Item was changed to burn after reading", test.name)
+		}
+	}
+}
+
+// slowStore delays each request to its Storer, except for Close.
+type slowStore struct {
+	Storer
+	delay time.Duration
+}
+
+func (s slowStore) Get(id string) (Item, error) {
+	time.Sleep(s.delay)
+	return s.Storer.Get(id)
+}
+
+func (s slowStore) GetFile(id string) (*os.File, error) {
+	time.Sleep(s.delay)
+	return s.Storer.GetFile(id)
+}
+
+func (s slowStore) Put(item Item, file io.ReadCloser) (string, error) {
+	time.Sleep(s.delay)
+	return s.Storer.Put(item, file)
+}
+
+func (s slowStore) UpdateSettings(id string, settings ItemSettings) (Item, error) {
+	time.Sleep(s.delay)
+	return s.Storer.UpdateSettings(id, settings)
+}
+
+func (s slowStore) Delete(id string) error {
+	time.Sleep(s.delay)
+	return s.Storer.Delete(id)
+}
+
+func TestServerStoreTimeout(t *testing.T) {
+	memStore := NewMemoryStore(randomIdGenerator(4), StoreOpts{})
+	itemId, err := memStore.Put(
+		Item{DeletionKey: "key", Expires: time.Now().Add(time.Minute).UTC()},
+		newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newTestServerStorer(t, slowStore{Storer: memStore, delay: 200 * time.Millisecond}, nil)
+	server.store.SetTimeout(50 * time.Millisecond)
+
+	settingsReq := httptest.NewRequest(http.MethodPost, "/settings/"+itemId+"/key", strings.NewReader("burn=1"))
+	settingsReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	tests := []struct {
+		name string
+		req  *http.Request
+	}{
+		{"upload", newUploadRequest(t, "/", []byte("hello world"), nil)},
+		{"request", httptest.NewRequest(http.MethodGet, "/"+itemId, nil)},
+		{"expires", httptest.NewRequest(http.MethodGet, "/"+itemId+"/expires", nil)},
+		{"deletion", httptest.NewRequest(http.MethodGet, "/del/"+itemId+"/key", nil)},
+		{"settings", settingsReq},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, test.req)
+
+		if rec.Code != http.StatusGatewayTimeout {
+			t.Fatalf("%s: expected status %d, got %d: %s", test.name, http.StatusGatewayTimeout, rec.Code, rec.Body)
+		}
+		if body := strings.TrimSpace(rec.Body.String()); body != msgStoreTimeout {
+			t.Fatalf("%s: unexpected body %q", test.name, body)
+		}
+	}
+}
+
+func TestServerMethodNotAllowed(t *testing.T) {
+	server := newTestServerMemoryStore(t, nil)
+
+	tests := []struct {
+		method string
+		path   string
+		allow  string
+	}{
+		{http.MethodDelete, "/", "GET, HEAD, POST, PUT"},
+		{http.MethodPost, "/custom.css", "GET, HEAD"},
+		{http.MethodPut, "/custom.css", "GET, HEAD"},
+		{http.MethodPost, "/abcd", "GET, HEAD, PUT"},
+		{http.MethodPut, "/abcd/efgh", "GET, HEAD"},
+		{http.MethodPut, "/del/abcd/key", "GET, POST"},
+		{http.MethodGet, "/settings/abcd/key", "POST"},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(test.method, test.path, nil))
+
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("%s %s: expected status %d, got %d", test.method, test.path, http.StatusMethodNotAllowed, rec.Code)
+		}
+		if allow := rec.Header().Get("Allow"); allow != test.allow {
+			t.Fatalf("%s %s: expected Allow %q, got %q", test.method, test.path, test.allow, allow)
+		}
+	}
+}
+
+func TestServerUploadChecksum(t *testing.T) {
+	data := []byte("hello world")
+
+	tests := []struct {
+		name     string
+		required bool
+		fields   map[string]string
+		code     int
+		body     string
+	}{
+		{"none", false, nil, http.StatusOK, ""},
+		{"match", false, map[string]string{"sha256": sha256Hex(data)}, http.StatusOK, ""},
+		{"match-uppercase", false, map[string]string{"sha256": strings.ToUpper(sha256Hex(data))}, http.StatusOK, ""},
+		{"mismatch", false, map[string]string{"sha256": sha256Hex([]byte("hello"))}, http.StatusBadRequest, msgChecksumMismatch},
+		{"invalid-hex", false, map[string]string{"sha256": "nope"}, http.StatusBadRequest, msgChecksumInvalid},
+		{"invalid-length", false, map[string]string{"sha256": "abcd"}, http.StatusBadRequest, msgChecksumInvalid},
+		{"unaccepted-algorithm", false, map[string]string{"md5": "00000000000000000000000000000000"}, http.StatusOK, ""},
+		{"required-missing", true, nil, http.StatusBadRequest, msgChecksumMissing},
+		{"required-match", true, map[string]string{"sha256": sha256Hex(data)}, http.StatusOK, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+				conf.ItemConfig.UploadChecksum.Algorithms = []string{"sha256"}
+				conf.ItemConfig.UploadChecksum.Required = test.required
+			})
+
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", data, test.fields))
+
+			if rec.Code != test.code {
+				t.Fatalf("Expected status %d, got %d: %s", test.code, rec.Code, rec.Body)
+			}
+			if test.body != "" && strings.TrimSpace(rec.Body.String()) != test.body {
+				t.Fatalf("Unexpected body %q", rec.Body)
+			}
+		})
+	}
+}
+
+func TestServerUploadChecksumConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		algorithms []string
+		required   bool
+	}{
+		{"unsupported", []string{"crc32"}, false},
+		{"required-without-algorithms", nil, true},
+	}
+
+	for _, test := range tests {
+		var conf WebserverConfig
+		conf.ItemConfig.MaxSize = "1MiB"
+		conf.ItemConfig.MaxLifetime = time.Hour
+		conf.ItemConfig.UploadChecksum.Algorithms = test.algorithms
+		conf.ItemConfig.UploadChecksum.Required = test.required
+
+		if _, err := NewServer(nil, conf, ""); err == nil {
+			t.Fatalf("%s: invalid configuration was accepted", test.name)
+		}
+	}
+}
+
+func TestServerMissingContentTypeConfig(t *testing.T) {
+	tests := []struct {
+		mode     string
+		fallback string
+		valid    bool
+	}{
+		{"", "", true},
+		{"reject", "", true},
+		{"sniff", "", true},
+		{"fallback", "application/octet-stream", true},
+		{"fallback", "", false},
+		{"guess", "", false},
+	}
+
+	for _, test := range tests {
+		var conf WebserverConfig
+		conf.ItemConfig.MaxSize = "1MiB"
+		conf.ItemConfig.MaxLifetime = time.Hour
+		conf.ItemConfig.MissingContentType.Mode = test.mode
+		conf.ItemConfig.MissingContentType.Fallback = test.fallback
+
+		server, err := NewServer(nil, conf, "")
+		if (err == nil) != test.valid {
+			t.Fatalf("%q/%q: expected valid %t, got %v", test.mode, test.fallback, test.valid, err)
+		} else if test.valid && test.mode == "" && server.itemOpts.MissingContentType != ContentTypeReject {
+			t.Fatalf("Empty mode was not defaulted to %q", ContentTypeReject)
+		}
+	}
+}
+
+func TestServerMismatchingContentType(t *testing.T) {
+	tests := []struct {
+		mode  string
+		valid bool
+		code  int
+	}{
+		{"", true, http.StatusOK},
+		{"keep", true, http.StatusOK},
+		{"overwrite", true, http.StatusBadRequest},
+		{"reject", true, http.StatusBadRequest},
+		{"guess", false, 0},
+	}
+
+	for _, test := range tests {
+		var conf WebserverConfig
+		conf.ItemConfig.MaxSize = "1MiB"
+		conf.ItemConfig.MaxLifetime = time.Hour
+		conf.ItemConfig.MismatchingContentType = test.mode
+
+		if _, err := NewServer(nil, conf, ""); (err == nil) != test.valid {
+			t.Fatalf("%q: expected valid %t, got %v", test.mode, test.valid, err)
+		} else if !test.valid {
+			continue
+		}
+
+		server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+			conf.ItemConfig.MismatchingContentType = test.mode
+			conf.ItemConfig.MimeDrop = []string{"text/html"}
+		})
+
+		// An HTML file declared as text/plain must not dodge the mime_drop,
+		// either by being overwritten or by being rejected.
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", []byte("<html><body>hi</body></html>"), nil))
+		if rec.Code != test.code {
+			t.Fatalf("%q: expected status %d, got %d: %s", test.mode, test.code, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestServerMimeDropCase(t *testing.T) {
+	server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+		conf.ItemConfig.MimeDrop = []string{"Text/Plain"}
+	})
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", []byte("hello world"), nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusBadRequest, rec.Code, rec.Body)
+	}
+}
+
+func TestServerRecordDownload(t *testing.T) {
+	server := newTestServerMemoryStore(t, nil)
+
+	ids := make(map[bool]string)
+	for _, burn := range []bool{false, true} {
+		fields := map[string]string{}
+		if burn {
+			fields["burn"] = "1"
+		}
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", []byte("hello world"), fields))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d for upload, got %d", http.StatusOK, rec.Code)
+		}
+
+		fetchUrl, err := url.Parse(strings.TrimSpace(rec.Body.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[burn] = strings.TrimPrefix(fetchUrl.Path, "/")
+	}
+
+	// Neither the expiry nor a failed request count as a download.
+	for _, path := range []string{"/" + ids[false] + "/expires", "/" + ids[false] + "/nope.txt"} {
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	if item, err := server.store.Get(ids[false], context.Background()); err != nil {
+		t.Fatal(err)
+	} else if item.Downloads != 0 || !item.LastAccess.IsZero() {
+		t.Fatalf("Item has %d downloads, last at %v", item.Downloads, item.LastAccess)
+	}
+
+	for _, id := range ids {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+id, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+	}
+
+	if item, err := server.store.Get(ids[false], context.Background()); err != nil {
+		t.Fatal(err)
+	} else if item.Downloads != 1 || time.Since(item.LastAccess) > time.Minute {
+		t.Fatalf("Download was not recorded: %d downloads, last at %v", item.Downloads, item.LastAccess)
+	}
+	if _, err := server.store.Get(ids[true], context.Background()); err != ErrNotFound {
+		t.Fatalf("Burned Item still exists: %v", err)
+	}
+}
+
+func TestServerTrailingSlash(t *testing.T) {
+	tests := []struct {
+		mode     string
+		path     string
+		code     int
+		location string
+	}{
+		{"", "/ID", http.StatusOK, ""},
+		{"", "/ID/", http.StatusNotFound, ""},
+		{"reject", "/ID/", http.StatusNotFound, ""},
+		{"reject", "/ID/test.txt/", http.StatusNotFound, ""},
+		{"redirect", "/ID", http.StatusOK, ""},
+		{"redirect", "/ID/", http.StatusMovedPermanently, "/ID"},
+		{"redirect", "/ID//", http.StatusMovedPermanently, "/ID"},
+		{"redirect", "/ID/?type=text/plain", http.StatusMovedPermanently, "/ID?type=text/plain"},
+		{"redirect", "/ID/test.txt/", http.StatusMovedPermanently, "/ID/test.txt"},
+		{"redirect", "/nope/", http.StatusMovedPermanently, "/nope"},
+		{"redirect", "//", http.StatusNotFound, ""},
+		{"strip", "/ID/", http.StatusOK, ""},
+		{"strip", "/ID//", http.StatusOK, ""},
+		{"strip", "/ID/test.txt/", http.StatusOK, ""},
+		{"strip", "/ID/expires/", http.StatusOK, ""},
+		{"strip", "/ID/nope.txt/", http.StatusNotFound, ""},
+		{"strip", "//", http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+			conf.TrailingSlash = test.mode
+		})
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", []byte("hello world"), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d for upload, got %d", http.StatusOK, rec.Code)
+		}
+
+		fetchUrl, err := url.Parse(strings.TrimSpace(rec.Body.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := strings.TrimPrefix(fetchUrl.Path, "/")
+
+		path := strings.Replace(test.path, "ID", id, 1)
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+
+		rec = httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		if rec.Code != test.code {
+			t.Fatalf("%s %q: expected status %d, got %d", test.mode, test.path, test.code, rec.Code)
+		}
+		if location := strings.Replace(test.location, "ID", id, 1); rec.Header().Get("Location") != location {
+			t.Fatalf("%s %q: expected Location %q, got %q", test.mode, test.path, location, rec.Header().Get("Location"))
+		}
+	}
+
+	var conf WebserverConfig
+	conf.ItemConfig.MaxSize = "1MiB"
+	conf.TrailingSlash = "sometimes"
+	if _, err := NewServer(nil, conf, ""); err == nil {
+		t.Fatal("Unknown trailing_slash mode was accepted")
+	}
+}
+
+// countingReader counts the bytes read from the underlying io.Reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func TestServerUploadRateLimit(t *testing.T) {
+	server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+		conf.UploadRateLimit.Uploads = 2
+		conf.UploadRateLimit.Interval = time.Hour
+	})
+
+	data := bytes.Repeat([]byte("A"), 512*1024)
+
+	tests := []struct {
+		remoteAddr string
+		code       int
+	}{
+		{"192.0.2.1:1234", http.StatusOK},
+		{"192.0.2.1:1235", http.StatusOK},
+		{"192.0.2.1:1236", http.StatusTooManyRequests},
+		{"192.0.2.2:1234", http.StatusOK},
+		{"[2001:db8::1]:1234", http.StatusOK},
+		{"192.0.2.1:1237", http.StatusTooManyRequests},
+	}
+
+	for _, test := range tests {
+		req := newUploadRequest(t, "/?onlyURL", data, nil)
+		req.RemoteAddr = test.remoteAddr
+
+		body := &countingReader{r: req.Body}
+		req.Body = io.NopCloser(body)
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		if rec.Code != test.code {
+			t.Fatalf("%s: expected status %d, got %d", test.remoteAddr, test.code, rec.Code)
+		}
+
+		if test.code != http.StatusTooManyRequests {
+			continue
+		}
+
+		if body.n != 0 {
+			t.Fatalf("%s: rejected upload's body was read, %d bytes", test.remoteAddr, body.n)
+		}
+		if conn := rec.Header().Get("Connection"); conn != "close" {
+			t.Fatalf("%s: expected Connection close, got %q", test.remoteAddr, conn)
+		}
+		if retry, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || retry <= 0 {
+			t.Fatalf("%s: invalid Retry-After %q", test.remoteAddr, rec.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestServerConcurrentTransfers(t *testing.T) {
+	server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+		conf.MaxConcurrentUploads = 1
+		conf.MaxConcurrentDownloads = 1
+	})
+
+	upload := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", []byte("hello world"), nil))
+		return rec
+	}
+	download := func(id string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+id, nil))
+		return rec
+	}
+
+	rec := upload()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	fetchUrl, err := url.Parse(strings.TrimSpace(rec.Body.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := strings.TrimPrefix(fetchUrl.Path, "/")
+
+	if rec := download(id); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	// Occupy each single slot, as if by a running transfer.
+	for _, slots := range []transferSlots{server.uploadSlots, server.downloadSlots} {
+		if !slots.tryAcquire() {
+			t.Fatal("Expected a free slot")
+		}
+	}
+
+	if rec := upload(); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	} else if body := strings.TrimSpace(rec.Body.String()); body != msgTooManyUploads {
+		t.Fatalf("Expected message %q, got %q", msgTooManyUploads, body)
+	} else if rec.Header().Get("Retry-After") == "" || rec.Header().Get("Connection") != "close" {
+		t.Fatalf("Expected Retry-After and Connection close, got %v", rec.Header())
+	}
+	if rec := download(id); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	} else if body := strings.TrimSpace(rec.Body.String()); body != msgTooManyDownloads {
+		t.Fatalf("Expected message %q, got %q", msgTooManyDownloads, body)
+	}
+
+	server.uploadSlots.release()
+	server.downloadSlots.release()
+
+	if rec := upload(); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if rec := download(id); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestServerUploadRateLimitTrustedProxies(t *testing.T) {
+	server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+		conf.UploadRateLimit.Uploads = 1
+		conf.UploadRateLimit.Interval = time.Hour
+		conf.TrustedProxies = []string{"10.0.0.1"}
+	})
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		code       int
+	}{
+		{"10.0.0.1:1234", "192.0.2.1", http.StatusOK},
+		{"10.0.0.1:1235", "192.0.2.2", http.StatusOK},
+		{"10.0.0.1:1236", "192.0.2.1", http.StatusTooManyRequests},
+		// An untrusted client cannot evade the limit by a forged header.
+		{"192.0.2.3:1234", "192.0.2.4", http.StatusOK},
+		{"192.0.2.3:1235", "192.0.2.5", http.StatusTooManyRequests},
+	}
+
+	for _, test := range tests {
+		req := newUploadRequest(t, "/?onlyURL", []byte("hello"), nil)
+		req.RemoteAddr = test.remoteAddr
+		req.Header.Set("X-Forwarded-For", test.forwarded)
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		if rec.Code != test.code {
+			t.Fatalf("%s, %s: expected status %d, got %d", test.remoteAddr, test.forwarded, test.code, rec.Code)
+		}
+	}
+}
+
+func TestServerDownloadTokens(t *testing.T) {
+	server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+		conf.DownloadTokens.Enabled = true
+		conf.DownloadTokens.Lifetime = 10 * time.Minute
+		conf.secret = Secret(strings.Repeat("a", secretMinLength))
+	})
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", []byte("hello world"), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d for upload, got %d", http.StatusOK, rec.Code)
+	}
+
+	fetchUrl, err := url.Parse(strings.TrimSpace(rec.Body.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := strings.TrimPrefix(fetchUrl.Path, "/")
+	token := fetchUrl.Query().Get("token")
+
+	if err := server.downloadTokens.verify(id, token, time.Now().Add(9*time.Minute)); err != nil {
+		t.Fatalf("Token from upload response is invalid: %v", err)
+	} else if err := server.downloadTokens.verify(id, token, time.Now().Add(11*time.Minute)); err != ErrTokenExpired {
+		t.Fatalf("Token from upload response outlives its lifetime: %v", err)
+	}
+
+	expired := server.downloadTokens.sign(id, time.Now().Add(-time.Second))
+
+	tests := []struct {
+		name string
+		path string
+		code int
+	}{
+		{"bare", "/" + id, http.StatusForbidden},
+		{"bare-expires", "/" + id + "/expires", http.StatusForbidden},
+		{"expired", "/" + id + "?token=" + expired, http.StatusForbidden},
+		{"tampered", "/" + id + "?token=" + token + "A", http.StatusForbidden},
+		{"other-id", "/nope?token=" + token, http.StatusForbidden},
+		{"expires", "/" + id + "/expires?token=" + token, http.StatusOK},
+		{"filename", "/" + id + "/test.txt?token=" + token, http.StatusOK},
+		{"valid", "/" + id + "?token=" + token, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if rec.Code != test.code {
+			t.Fatalf("%s: expected status %d, got %d", test.name, test.code, rec.Code)
+		}
+	}
+
+	var conf WebserverConfig
+	conf.ItemConfig.MaxSize = "1MiB"
+	conf.DownloadTokens.Enabled = true
+	if _, err := NewServer(nil, conf, ""); err == nil {
+		t.Fatal("Download tokens were enabled without a secret")
+	}
+}
+
+func TestServerUploadOutput(t *testing.T) {
+	server := newTestServerMemoryStore(t, nil)
+
+	tests := []struct {
+		target string
+		lines  []string
+	}{
+		{"/?onlyURL", []string{`^http://example\.com/\w+$`}},
+		{"/?urls", []string{`^http://example\.com/\w+$`, `^http://example\.com/del/\w+/\w+$`}},
+		{"/?onlyURL&urls", []string{`^http://example\.com/\w+$`}},
+		{"/", []string{`^Fetch:   http://example\.com/\w+$`, `^Delete:  http://example\.com/del/\w+/\w+$`, `^$`, `^Expires: `, `^Burn:    false$`}},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, newUploadRequest(t, test.target, []byte("hello world"), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", test.target, http.StatusOK, rec.Code)
+		}
+
+		body := rec.Body.String()
+		if !strings.HasSuffix(body, "\n") {
+			t.Fatalf("%s: output misses trailing newline: %q", test.target, body)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+		if len(lines) != len(test.lines) {
+			t.Fatalf("%s: expected %d lines, got %q", test.target, len(test.lines), body)
+		}
+		for i, line := range lines {
+			if !regexp.MustCompile(test.lines[i]).MatchString(line) {
+				t.Fatalf("%s: line %d %q does not match %q", test.target, i, line, test.lines[i])
+			}
+		}
+	}
+
+	// The deletion URL of the urls mode must work.
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, newUploadRequest(t, "/?urls", []byte("hello world"), nil))
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+
+	requests := []struct {
+		target string
+		code   int
+	}{
+		{lines[0], http.StatusOK},
+		{lines[1], http.StatusOK},
+		{lines[0], http.StatusNotFound},
+	}
+
+	for _, req := range requests {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, req.target, nil))
+		if rec.Code != req.code {
+			t.Fatalf("%s: expected status %d, got %d", req.target, req.code, rec.Code)
+		}
+	}
+}
+
+func TestServerDeletionKeys(t *testing.T) {
+	for _, length := range []int{-1, MinDeletionKeyLength - 1, MaxDeletionKeyLength + 1} {
+		var conf WebserverConfig
+		conf.ItemConfig.MaxSize = "1MiB"
+		conf.ItemConfig.DeletionKeys.Length = length
+		if _, err := NewServer(nil, conf, ""); err == nil {
+			t.Fatalf("Deletion key length %d was accepted", length)
+		}
+	}
+
+	server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+		conf.ItemConfig.DeletionKeys.Length = MinDeletionKeyLength
+	})
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, newUploadRequest(t, "/?urls", []byte("hello world"), nil))
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected fetch and delete URL, got %q", rec.Body.String())
+	}
+
+	delUrl, err := url.Parse(lines[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	delParts := strings.Split(delUrl.Path, "/")
+	delKey, err := base58.Decode(delParts[len(delParts)-1])
+	if err != nil {
+		t.Fatal(err)
+	} else if len(delKey) != MinDeletionKeyLength {
+		t.Fatalf("Expected deletion key of %d bytes, got %d", MinDeletionKeyLength, len(delKey))
+	}
+
+	server = newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+		conf.ItemConfig.DeletionKeys.Disabled = true
+	})
+
+	for _, target := range []string{"/?urls", "/"} {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, newUploadRequest(t, target, []byte("hello world"), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", target, http.StatusOK, rec.Code)
+		}
+		if body := rec.Body.String(); strings.Contains(body, "/del/") || strings.Contains(body, "Delete:") {
+			t.Fatalf("%s: output contains a deletion URL: %q", target, body)
+		}
+	}
+
+	items, err := server.store.List(ListQuery{Limit: 10}, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].DeletionKey != "" || items[1].DeletionKey != "" {
+		t.Fatalf("Expected two Items without deletion keys, got %v", items)
+	}
+
+	requests := []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/del/"+items[0].ID+"/", nil),
+		httptest.NewRequest(http.MethodPost, "/settings/"+items[0].ID+"/", strings.NewReader("burn=1")),
+	}
+	for _, req := range requests {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("%s: expected status %d, got %d", req.URL.Path, http.StatusForbidden, rec.Code)
+		}
+	}
+}
+
+func TestServerDeletionConfirm(t *testing.T) {
+	for _, confirm := range []bool{false, true} {
+		server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+			conf.ItemConfig.DeletionKeys.Confirm = confirm
+		})
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, newUploadRequest(t, "/?urls", []byte("hello world"), map[string]string{"filename": "hello.txt"}))
+		lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+		fetchUrl, deleteUrl := lines[0], lines[1]
+
+		rec = httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, deleteUrl, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("confirm=%t: expected status %d, got %d", confirm, http.StatusOK, rec.Code)
+		}
+
+		if !confirm {
+			if rec.Body.String() != msgDeletionSuccess+"\n" {
+				t.Fatalf("Expected deletion by GET, got %q", rec.Body.String())
+			}
+			continue
+		}
+
+		if csp := rec.Header().Get("Content-Security-Policy"); csp != formPageCsp {
+			t.Fatalf("Expected Content-Security-Policy %q, got %q", formPageCsp, csp)
+		}
+		if body := rec.Body.String(); !strings.Contains(body, `<form method="POST">`) || !strings.Contains(body, "hello.txt") {
+			t.Fatalf("Expected confirmation form, got %q", body)
+		}
+
+		requests := []struct {
+			name    string
+			method  string
+			headers map[string]string
+			target  string
+			code    int
+		}{
+			{"still exists", http.MethodGet, nil, fetchUrl, http.StatusOK},
+			{"cross-site", http.MethodPost, map[string]string{"Sec-Fetch-Site": "cross-site"}, deleteUrl, http.StatusForbidden},
+			{"foreign origin", http.MethodPost, map[string]string{"Origin": "http://evil.example.com"}, deleteUrl, http.StatusForbidden},
+			{"same origin", http.MethodPost, map[string]string{"Sec-Fetch-Site": "same-origin", "Origin": "http://example.com"}, deleteUrl, http.StatusOK},
+			{"deleted", http.MethodGet, nil, fetchUrl, http.StatusNotFound},
+		}
+
+		for _, req := range requests {
+			r := httptest.NewRequest(req.method, req.target, nil)
+			for k, v := range req.headers {
+				r.Header.Set(k, v)
+			}
+
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, r)
+			if rec.Code != req.code {
+				t.Fatalf("%s: expected status %d, got %d", req.name, req.code, rec.Code)
+			}
+		}
+	}
+}
+
+func TestServerWebhook(t *testing.T) {
+	events := make(chan webhookEvent, 16)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev webhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Error(err)
+		}
+		events <- ev
+	}))
+	defer ts.Close()
+
+	server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+		conf.Webhook = WebhookConfig{Url: ts.URL, QueueSize: 16}
+	})
+
+	upload := func(fields map[string]string) (fetchUrl, deleteUrl string) {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, newUploadRequest(t, "/?urls", []byte("hello world"), fields))
+		lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+		return lines[0], lines[1]
+	}
+	get := func(target string) {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", target, http.StatusOK, rec.Code)
+		}
+	}
+
+	fetchUrl, deleteUrl := upload(nil)
+	get(fetchUrl)
+	get(deleteUrl)
+
+	burnUrl, _ := upload(map[string]string{"burn": "1"})
+	get(burnUrl)
+
+	expected := []ItemEvent{ItemCreated, ItemDownloaded, ItemDeleted, ItemCreated, ItemDownloaded, ItemBurned}
+	for i, event := range expected {
+		select {
+		case ev := <-events:
+			if ev.Event != event {
+				t.Fatalf("Event %d: expected %s, got %s", i, event, ev.Event)
+			}
+			if ev.Size != int64(len("hello world")) || ev.ContentType == "" {
+				t.Fatalf("Event %d misses metadata: %v", i, ev)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Event %d was not received", i)
+		}
+	}
+}
+
+func TestServerInlineMaxSize(t *testing.T) {
+	tests := []struct {
+		inlineMaxSize string
+		size          int
+		disposition   string
+	}{
+		{"", 2048, "inline"},
+		{"1KiB", 1023, "inline"},
+		{"1KiB", 1024, "inline"},
+		{"1KiB", 1025, "attachment"},
+	}
+
+	for _, test := range tests {
+		server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+			conf.ItemConfig.InlineMaxSize = test.inlineMaxSize
+		})
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", bytes.Repeat([]byte("a"), test.size), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q, %d: expected status %d for upload, got %d", test.inlineMaxSize, test.size, http.StatusOK, rec.Code)
+		}
+
+		fetchUrl, err := url.Parse(strings.TrimSpace(rec.Body.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec = httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fetchUrl.Path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q, %d: expected status %d, got %d", test.inlineMaxSize, test.size, http.StatusOK, rec.Code)
+		}
+
+		expected := test.disposition + `; filename="test.txt"`
+		if cd := rec.Header().Get("Content-Disposition"); cd != expected {
+			t.Fatalf("%q, %d: expected Content-Disposition %q, got %q", test.inlineMaxSize, test.size, expected, cd)
+		}
+	}
+}
+
+func TestServerAttachment(t *testing.T) {
+	tests := []struct {
+		attachment      bool
+		attachmentTypes []string
+		contentType     string
+		query           string
+		disposition     string
+	}{
+		{false, nil, "text/plain", "", "inline"},
+		{false, nil, "text/plain", "?download=1", "attachment"},
+		{false, nil, "text/plain", "?download", "attachment"},
+		{false, nil, "text/plain", "?download=0", "inline"},
+		{true, nil, "text/plain", "", "attachment"},
+		{true, nil, "text/plain", "?download=0", "inline"},
+		{false, []string{"text/html"}, "text/html", "", "attachment"},
+		{false, []string{"text/html"}, "text/html; charset=utf-8", "", "attachment"},
+		{false, []string{"TEXT/HTML"}, "text/html", "?download=0", "attachment"},
+		{false, []string{"text/html"}, "text/plain", "", "inline"},
+	}
+
+	for _, test := range tests {
+		server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+			conf.ItemConfig.Attachment = test.attachment
+			conf.ItemConfig.AttachmentTypes = test.attachmentTypes
+		})
+
+		id, err := server.store.Put(Item{
+			Filename:    "test.txt",
+			ContentType: test.contentType,
+			Expires:     time.Now().Add(time.Minute),
+		}, newDummyReadCloser(bytes.NewBufferString("hello")), context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, httptest.NewRequest(method, "/"+id+test.query, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%v: %s: expected status %d, got %d", test, method, http.StatusOK, rec.Code)
+			}
+
+			expected := test.disposition + `; filename="test.txt"`
+			if cd := rec.Header().Get("Content-Disposition"); cd != expected {
+				t.Fatalf("%v: %s: expected Content-Disposition %q, got %q", test, method, expected, cd)
+			}
+		}
+	}
+}
+
+func TestServerInlineMaxSizeConfig(t *testing.T) {
+	var conf WebserverConfig
+	conf.ItemConfig.MaxSize = "1MiB"
+	conf.ItemConfig.InlineMaxSize = "lots"
+
+	if _, err := NewServer(nil, conf, ""); err == nil {
+		t.Fatal("Expected error for an invalid inline_max_size")
+	}
+}
+
+func TestServerMaxSizeConfig(t *testing.T) {
+	tests := []struct {
+		maxSize string
+		valid   bool
+	}{
+		{"1MiB", true},
+		{"1.5mib", true},
+		{"0", false},
+		{"0B", false},
+		{"lots", false},
+	}
+
+	for _, test := range tests {
+		var conf WebserverConfig
+		conf.ItemConfig.MaxSize = test.maxSize
+
+		if _, err := NewServer(nil, conf, ""); (err == nil) != test.valid {
+			t.Fatalf("%q: expected valid %t, got %v", test.maxSize, test.valid, err)
+		}
+	}
+}
+
+func TestServerUploadAuth(t *testing.T) {
+	server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+		conf.UploadAuth = UploadAuthConfig{Type: "token", Token: "s3cr3t"}
+	})
+
+	for _, token := range []string{"", "wrong", "s3cr3t"} {
+		req := newUploadRequest(t, "/?onlyURL", []byte("hello world"), nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+
+		if token == "s3cr3t" {
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%q: expected status %d, got %d", token, http.StatusOK, rec.Code)
+			}
+			continue
+		}
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("%q: expected status %d, got %d", token, http.StatusUnauthorized, rec.Code)
+		}
+		if challenge := rec.Header().Get("WWW-Authenticate"); challenge != "Bearer" {
+			t.Fatalf("%q: unexpected WWW-Authenticate %q", token, challenge)
+		}
+		if conn := rec.Header().Get("Connection"); conn != "close" {
+			t.Fatalf("%q: expected Connection close, got %q", token, conn)
+		}
+	}
+}
+
+func TestServerUploadMalformed(t *testing.T) {
+	server := newTestServerMemoryStore(t, nil)
+
+	tests := []struct {
+		name string
+		req  func() *http.Request
+		msg  string
+	}{
+		{"not multipart", func() *http.Request {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello world"))
+			req.Header.Set("Content-Type", "text/plain")
+			return req
+		}, msgMultipartInvalid},
+		{"wrong field", func() *http.Request {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			if w, err := writer.CreateFormFile("upload", "test.txt"); err != nil {
+				t.Fatal(err)
+			} else if _, err := w.Write([]byte("hello world")); err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			return req
+		}, msgFileFieldMissing},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, test.req())
+
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected status %d, got %d", test.name, http.StatusBadRequest, rec.Code)
+		}
+		if body := strings.TrimSpace(rec.Body.String()); body != test.msg {
+			t.Fatalf("%s: expected message %q, got %q", test.name, test.msg, body)
+		}
+	}
+}
+
+// newMultiUploadRequest creates a multipart upload request with a file field
+// for each of the files, preceded by the fields.
+func newMultiUploadRequest(t *testing.T, target string, files [][]byte, fields map[string]string) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, data := range files {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="test-%d.txt"`, i))
+		header.Set("Content-Type", "text/plain")
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := part.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, target, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestServerUploadMultipleFiles(t *testing.T) {
+	var hookCalls atomic.Int32
+	store, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{
+		DeletionHook: func(ItemEvent, Item) { hookCalls.Add(1) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServerStorer(t, store, func(conf *WebserverConfig) {
+		conf.ItemConfig.MaxSize = "1KiB"
+	})
+
+	files := [][]byte{[]byte("foo"), []byte("bar"), []byte("baz")}
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, newMultiUploadRequest(t, "/?urls", files, map[string]string{formBurnAfterReading: "1"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	urls := strings.Fields(rec.Body.String())
+	if len(urls) != 2*len(files) {
+		t.Fatalf("expected fetch and delete URLs for %d files, got %q", len(files), urls)
+	}
+	for i, data := range files {
+		fetchUrl, deleteUrl := urls[2*i], urls[2*i+1]
+		if !strings.Contains(deleteUrl, "/del/") {
+			t.Fatalf("expected delete URL, got %q", deleteUrl)
+		}
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fetchUrl, nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != string(data) {
+			t.Fatalf("file %d: expected %q, got %d: %q", i, data, rec.Code, rec.Body)
+		}
+	}
+
+	tooManyFiles := make([][]byte, maxUploadFiles+1)
+	for i := range tooManyFiles {
+		tooManyFiles[i] = []byte("foo")
+	}
+
+	// All Items were burned after reading, thus a failed upload must not
+	// leave any other Item behind. Its rolled back Items were never announced
+	// and must not call the deletion hook either.
+	burned := hookCalls.Load()
+	for _, test := range []struct {
+		name  string
+		files [][]byte
+		code  int
+		msg   string
+	}{
+		{"too big", [][]byte{[]byte("foo"), bytes.Repeat([]byte("a"), 1025)}, http.StatusRequestEntityTooLarge, msgFileSizeExceeds},
+		{"too many", tooManyFiles, http.StatusBadRequest, msgTooManyFiles},
+	} {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, newMultiUploadRequest(t, "/", test.files, nil))
+		if rec.Code != test.code {
+			t.Fatalf("%s: expected status %d, got %d: %s", test.name, test.code, rec.Code, rec.Body)
+		}
+		if body := strings.TrimSpace(rec.Body.String()); body != test.msg {
+			t.Fatalf("%s: expected message %q, got %q", test.name, test.msg, body)
+		}
+
+		items, err := store.FindByContentType("text/plain")
+		if err != nil {
+			t.Fatal(err)
+		} else if len(items) > 0 {
+			t.Fatalf("%s: expected no Items, got %d", test.name, len(items))
+		}
+		if calls := hookCalls.Load(); calls != burned {
+			t.Fatalf("%s: expected no deletion hook calls, got %d", test.name, calls-burned)
+		}
+	}
+}
+
+func TestServerUploadRaw(t *testing.T) {
+	store, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServerStorer(t, store, func(conf *WebserverConfig) {
+		conf.ItemConfig.MaxSize = "1KiB"
+	})
+
+	newPutRequest := func(target string, body io.Reader, header map[string]string) *http.Request {
+		req := httptest.NewRequest(http.MethodPut, target, body)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		return req
+	}
+	textPlain := map[string]string{"Content-Type": "text/plain"}
+
+	tests := []struct {
+		name     string
+		req      *http.Request
+		code     int
+		msg      string
+		filename string
+	}{
+		{"path", newPutRequest("/foo.txt?urls", strings.NewReader("hello world"), textPlain), http.StatusOK, "", "foo.txt"},
+		{"root", newPutRequest("/?urls", strings.NewReader("hello world"), textPlain), http.StatusOK, "", ""},
+		{"header", newPutRequest("/foo.txt?urls", strings.NewReader("hello world"),
+			map[string]string{"Content-Type": "text/plain", "X-Filename": "bar.txt"}), http.StatusOK, "", "bar.txt"},
+		{"parent directory", newPutRequest("/?urls", strings.NewReader("hello world"),
+			map[string]string{"Content-Type": "text/plain", "X-Filename": ".."}), http.StatusOK, "", ""},
+		{"content length too big", newPutRequest("/foo.txt", bytes.NewReader(make([]byte, 1025)), textPlain),
+			http.StatusRequestEntityTooLarge, msgFileSizeExceeds, ""},
+		{"streamed too big", newPutRequest("/foo.txt", io.MultiReader(bytes.NewReader(make([]byte, 1025))), textPlain),
+			http.StatusRequestEntityTooLarge, msgFileSizeExceeds, ""},
+		{"content type missing", newPutRequest("/foo.txt", strings.NewReader("hello world"), nil),
+			http.StatusBadRequest, msgContentTypeMissing, ""},
+		{"lifetime too long", newPutRequest("/foo.txt?time=1d", strings.NewReader("hello world"), textPlain),
+			http.StatusNotAcceptable, msgLifetimeExceeds, ""},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, test.req)
+
+		if rec.Code != test.code {
+			t.Fatalf("%s: expected status %d, got %d: %s", test.name, test.code, rec.Code, rec.Body)
+		}
+		if test.code != http.StatusOK {
+			if body := strings.TrimSpace(rec.Body.String()); body != test.msg {
+				t.Fatalf("%s: expected message %q, got %q", test.name, test.msg, body)
+			}
+			continue
+		}
+
+		fetchUrl := strings.Fields(rec.Body.String())[0]
+		rec = httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fetchUrl, nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != "hello world" {
+			t.Fatalf("%s: unexpected download %d: %q", test.name, rec.Code, rec.Body)
+		}
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+			t.Fatalf("%s: unexpected Content-Type %q", test.name, ct)
+		}
+		if cd := rec.Header().Get("Content-Disposition"); test.filename != "" && !strings.Contains(cd, fmt.Sprintf("filename=%q", test.filename)) {
+			t.Fatalf("%s: unexpected Content-Disposition %q", test.name, cd)
+		} else if test.filename == "" && strings.Contains(cd, "filename") {
+			t.Fatalf("%s: unexpected filename in Content-Disposition %q", test.name, cd)
+		}
+	}
+}
+
+func TestServerUploadStreaming(t *testing.T) {
+	store, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServerStorer(t, store, func(conf *WebserverConfig) {
+		conf.ItemConfig.MaxSize = "1KiB"
+		conf.ItemConfig.UploadChecksum.Algorithms = []string{"sha256"}
+	})
+
+	fieldsAfterFile := func(fields map[string]string) *http.Request {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", `form-data; name="file"; filename="test.txt"`)
+		header.Set("Content-Type", "text/plain")
+		if w, err := writer.CreatePart(header); err != nil {
+			t.Fatal(err)
+		} else if _, err := w.Write([]byte("hello world")); err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range fields {
+			if err := writer.WriteField(k, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return req
+	}
+
+	// A skipped file part is not limited by the MaxSize itself, but the
+	// request's body is.
+	oversizedBody := func() *http.Request {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		if w, err := writer.CreateFormFile("other", "other.txt"); err != nil {
+			t.Fatal(err)
+		} else if _, err := w.Write(bytes.Repeat([]byte("a"), 1024*maxUploadFiles+maxUploadOverhead)); err != nil {
+			t.Fatal(err)
+		}
+		if w, err := writer.CreateFormFile(formFile, "test.txt"); err != nil {
+			t.Fatal(err)
+		} else if _, err := w.Write([]byte("hello world")); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return req
+	}
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		code   int
+		msg    string
+		stored bool
+	}{
+		{"fields first", newUploadRequest(t, "/", []byte("hello world"), map[string]string{formBurnAfterReading: "1"}), http.StatusOK, "", true},
+		{"fields after file", fieldsAfterFile(map[string]string{formBurnAfterReading: "1", formLifetime: "30m"}), http.StatusOK, "", true},
+		{"invalid field after file", fieldsAfterFile(map[string]string{formBurnAfterReading: "1", formMaxDownloads: "0"}), http.StatusBadRequest, msgMaxDownloads, false},
+		{"checksum after file", fieldsAfterFile(map[string]string{formBurnAfterReading: "1", "sha256": sha256Hex([]byte("hello world"))}), http.StatusBadRequest, msgFieldAfterFile, false},
+		{"too big", newUploadRequest(t, "/", bytes.Repeat([]byte("a"), 1025), nil), http.StatusRequestEntityTooLarge, msgFileSizeExceeds, false},
+		{"oversized body", oversizedBody(), http.StatusRequestEntityTooLarge, msgFileSizeExceeds, false},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, test.req)
+
+		if rec.Code != test.code {
+			t.Fatalf("%s: expected status %d, got %d: %s", test.name, test.code, rec.Code, rec.Body)
+		}
+		if body := strings.TrimSpace(rec.Body.String()); test.msg != "" && body != test.msg {
+			t.Fatalf("%s: expected message %q, got %q", test.name, test.msg, body)
+		}
+
+		// Failed uploads must be rolled back, even if the Store has already
+		// received the truncated file.
+		items, err := store.FindByContentType("text/plain")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored := len(items) > 0; stored != test.stored {
+			t.Fatalf("%s: expected stored %t, got %t", test.name, test.stored, stored)
+		}
+		for _, item := range items {
+			if !item.BurnAfterReading || item.Size != int64(len("hello world")) {
+				t.Fatalf("%s: unexpected Item %v", test.name, item)
+			}
+			if lifetime := item.Expires.Sub(item.Created); test.name == "fields after file" && lifetime != 30*time.Minute {
+				t.Fatalf("%s: expected lifetime of 30m, got %v", test.name, lifetime)
+			}
+			if err := store.Delete(item.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestServerStoreFull(t *testing.T) {
+	store, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{MaxStoreSize: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServerStorer(t, store, nil)
+
+	for i, code := range []int{http.StatusOK, http.StatusInsufficientStorage} {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, newUploadRequest(t, "/", []byte(fmt.Sprintf("hello world %d", i)), nil))
+		if rec.Code != code {
+			t.Fatalf("Upload %d: expected status %d, got %d", i, code, rec.Code)
+		}
+		if body := strings.TrimSpace(rec.Body.String()); code != http.StatusOK && body != msgStoreFull {
+			t.Fatalf("Upload %d: expected message %q, got %q", i, msgStoreFull, body)
+		}
+	}
+}
+
+// failingResponseWriter is an http.ResponseWriter whose body writes fail, as
+// if the client has closed its connection.
+type failingResponseWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w failingResponseWriter) Write(_ []byte) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
+
+func TestServerBurnRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		attempts  int
+		failures  int
+		burned    bool
+		burnAfter bool
+	}{
+		{"disabled failed", 0, 1, true, true},
+		{"failed then succeeded", 3, 1, false, true},
+		{"twice failed then succeeded", 3, 2, false, true},
+		{"exhausted", 3, 3, true, true},
+	}
+
+	for _, test := range tests {
+		server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+			conf.ItemConfig.BurnRetries.Attempts = test.attempts
+		})
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", []byte("hello world"), map[string]string{"burn": "1"}))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d for upload, got %d", test.name, http.StatusOK, rec.Code)
+		}
+
+		fetchUrl, err := url.Parse(strings.TrimSpace(rec.Body.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		itemId := strings.TrimPrefix(fetchUrl.Path, "/")
+
+		for i := 0; i < test.failures; i++ {
+			server.ServeHTTP(failingResponseWriter{httptest.NewRecorder()}, httptest.NewRequest(http.MethodGet, fetchUrl.Path, nil))
+		}
+
+		_, err = server.store.Get(itemId, context.Background())
+		if burned := err == ErrNotFound; burned != test.burned {
+			t.Fatalf("%s: expected burned %t after failures, got %t (%v)", test.name, test.burned, burned, err)
+		}
+		if test.burned {
+			continue
+		}
+
+		rec = httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fetchUrl.Path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", test.name, http.StatusOK, rec.Code)
+		} else if body := rec.Body.String(); body != "hello world" {
+			t.Fatalf("%s: unexpected body %q", test.name, body)
+		}
+
+		_, err = server.store.Get(itemId, context.Background())
+		if burned := err == ErrNotFound; burned != test.burnAfter {
+			t.Fatalf("%s: expected burned %t after success, got %t (%v)", test.name, test.burnAfter, burned, err)
+		}
+	}
+}
+
+func TestServerBurnRetriesWindow(t *testing.T) {
+	memStore := NewMemoryStore(randomIdGenerator(4), StoreOpts{})
+	itemId, err := memStore.Put(
+		Item{BurnAfterReading: true, Expires: time.Now().Add(time.Hour).UTC()},
+		newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fake a failed attempt, being older than the window.
+	memStore.mu.Lock()
+	item := memStore.items[itemId]
+	item.recordBurnAttempt(time.Now().Add(-time.Hour))
+	memStore.items[itemId] = item
+	memStore.mu.Unlock()
+
+	server := newTestServerStorer(t, memStore, func(conf *WebserverConfig) {
+		conf.ItemConfig.BurnRetries.Attempts = 3
+		conf.ItemConfig.BurnRetries.Window = time.Minute
+	})
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+itemId, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+	if _, err := memStore.Get(itemId); err != ErrNotFound {
+		t.Fatalf("Item was not burned after its window: %v", err)
+	}
+}
+
+func TestServerIndexTemplateFuncs(t *testing.T) {
+	var conf WebserverConfig
+	conf.ItemConfig.MaxSize = "2MiB"
+	conf.ItemConfig.MaxLifetime = 24 * time.Hour
+	conf.ItemConfig.DefaultLifetime = time.Hour
+
+	const tpl = `{{.MaxSize}} {{prettyBytes .MaxSize}} ` +
+		`{{prettyDuration .MaxLifetime}} {{prettyDuration .DefaultLifetime}} {{.Size}}`
+
+	server, err := NewServer(nil, conf, tpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	expected := fmt.Sprintf("%d %s %s %s %s",
+		2<<20, PrettyBytesize(2<<20),
+		PrettyDuration(24*time.Hour), PrettyDuration(time.Hour), PrettyBytesize(2<<20))
+	if body := rec.Body.String(); body != expected {
+		t.Fatalf("Expected %q, got %q", expected, body)
+	}
+}
+
+func TestServerIndexTemplateVars(t *testing.T) {
+	var conf WebserverConfig
+	conf.ItemConfig.MaxSize = "2MiB"
+	conf.ItemConfig.MaxLifetime = 24 * time.Hour
+	conf.TemplateVars = map[string]string{
+		"onion": "example.onion",
+		"Size":  "not shadowing",
+		"tos":   "<b>",
+	}
+	conf.version = "v1.2.3"
+
+	const tpl = `{{.Version}} {{.Vars.onion}} {{.Vars.Size}} {{.Size}} {{index .Vars "tos"}}`
+
+	server, err := NewServer(nil, conf, tpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	expected := fmt.Sprintf("v1.2.3 example.onion not shadowing %s &lt;b&gt;", PrettyBytesize(2<<20))
+	if body := rec.Body.String(); body != expected {
+		t.Fatalf("Expected %q, got %q", expected, body)
+	}
+}
+
+func TestServerRejectInvalidIds(t *testing.T) {
+	// Without a store, plausible IDs result in a 503 as the store is not ready
+	// yet. Invalid IDs are rejected without considering the store.
+	server := newTestServer(t, nil, func(conf *WebserverConfig) {
+		conf.RejectInvalidIds = true
+		conf.idGenerator = IdGeneratorConfig{Type: "random", Length: 4}
+	})
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/3yQ7Yx", http.StatusServiceUnavailable},
+		{"/3yQ7Yx/test.txt", http.StatusServiceUnavailable},
+		{"/3yQ7Yx/expires", http.StatusServiceUnavailable},
+		{"/wp-login.php", http.StatusNotFound},
+		{"/.env", http.StatusNotFound},
+		{"/3yQ7YxAbCd", http.StatusNotFound},
+		{"/wp-admin/setup-config.php", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if rec.Code != test.code {
+			t.Fatalf("%s: expected status %d, got %d", test.path, test.code, rec.Code)
+		}
+	}
+
+	var conf WebserverConfig
+	conf.ItemConfig.MaxSize = "1MiB"
+	conf.RejectInvalidIds = true
+	if _, err := NewServer(nil, conf, ""); err == nil {
+		t.Fatal("Expected an error without an ID generator")
+	}
+}
+
+func TestServerDownloadPassword(t *testing.T) {
+	server := newTestServerMemoryStore(t, nil)
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, newUploadRequest(t, "/", []byte("hello world"), map[string]string{"password": strings.Repeat("a", 73)}))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d for a too long password, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", []byte("hello world"), map[string]string{
+		"password": "hunter2",
+		"burn":     "1",
+	}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d for upload, got %d", http.StatusOK, rec.Code)
+	}
+
+	fetchUrl, err := url.Parse(strings.TrimSpace(rec.Body.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The expiry of a password protected Item must not be revealed either.
+	tests := []struct {
+		name   string
+		suffix string
+		basic  string
+		code   int
+	}{
+		{"missing", "", "", http.StatusUnauthorized},
+		{"wrong key", "?key=hunter3", "", http.StatusUnauthorized},
+		{"wrong basic auth", "", "hunter3", http.StatusUnauthorized},
+		{"expires missing", "/expires", "", http.StatusUnauthorized},
+		{"expires wrong key", "/expires?key=hunter3", "", http.StatusUnauthorized},
+		{"expires key", "/expires?key=hunter2", "", http.StatusOK},
+		{"basic auth", "", "hunter2", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, fetchUrl.Path+test.suffix, nil)
+		if test.basic != "" {
+			req.SetBasicAuth("", test.basic)
+		}
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		if rec.Code != test.code {
+			t.Fatalf("%s: expected status %d, got %d", test.name, test.code, rec.Code)
+		}
+		if test.code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Fatalf("%s: expected a WWW-Authenticate header", test.name)
+		}
+		if test.code == http.StatusOK && test.suffix == "" && rec.Body.String() != "hello world" {
+			t.Fatalf("%s: unexpected body %q", test.name, rec.Body)
+		}
+	}
+
+	// Wrong passwords must not have burned the Item before the correct one.
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fetchUrl.Path+"?key=hunter2", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected burned Item, got status %d", rec.Code)
+	}
+}
+
+func TestServerMaxDownloads(t *testing.T) {
+	server := newTestServerMemoryStore(t, nil)
+
+	for _, maxDownloads := range []string{"0", "-1", "lots"} {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, newUploadRequest(t, "/", []byte("hello world"), map[string]string{"maxDownloads": maxDownloads}))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%q: expected status %d, got %d", maxDownloads, http.StatusBadRequest, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", []byte("hello world"), map[string]string{"maxDownloads": "2"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d for upload, got %d", http.StatusOK, rec.Code)
+	}
+
+	fetchUrl, err := url.Parse(strings.TrimSpace(rec.Body.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	itemId := strings.TrimPrefix(fetchUrl.Path, "/")
+
+	for i, code := range []int{http.StatusOK, http.StatusOK, http.StatusNotFound} {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fetchUrl.Path, nil))
+		if rec.Code != code {
+			t.Fatalf("Download %d: expected status %d, got %d", i, code, rec.Code)
+		}
+
+		// Neither expiry requests nor the first download delete the Item.
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, fetchUrl.Path+"/expires", nil))
+		_, err := server.store.Get(itemId, context.Background())
+		if deleted := err == ErrNotFound; deleted != (i > 0) {
+			t.Fatalf("Download %d: expected deleted %t, got %t (%v)", i, i > 0, deleted, err)
+		}
+	}
+}
+
+// brokenFileStore fails each GetFile while broken is set.
+type brokenFileStore struct {
+	Storer
+	broken *atomic.Bool
+}
+
+func (s brokenFileStore) GetFile(id string) (*os.File, error) {
+	if s.broken.Load() {
+		return nil, errors.New("broken file")
+	}
+	return s.Storer.GetFile(id)
+}
+
+func TestServerMaxDownloadsServeError(t *testing.T) {
+	store := NewMemoryStore(randomIdGenerator(4), StoreOpts{})
+	broken := &atomic.Bool{}
+	server := newTestServerStorer(t, brokenFileStore{Storer: store, broken: broken}, nil)
+
+	id, err := store.Put(
+		Item{MaxDownloads: 1, Expires: time.Now().Add(time.Hour).UTC()},
+		newDummyReadCloser(bytes.NewBufferString("hello world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed download must not use up the Item's only download.
+	broken.Store(true)
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+id, nil))
+	if rec.Code == http.StatusOK {
+		t.Fatal("Download of a broken file succeeded")
+	}
+	if item, err := store.Get(id); err != nil {
+		t.Fatal(err)
+	} else if item.Downloads != 0 {
+		t.Fatalf("Expected no downloads, got %d", item.Downloads)
+	}
+
+	broken.Store(false)
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+id, nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello world" {
+		t.Fatalf("Unexpected download %d: %q", rec.Code, rec.Body)
+	}
+	if _, err := store.Get(id); err != ErrNotFound {
+		t.Fatalf("Item still exists after its last download: %v", err)
+	}
+}
+
+func TestServerRangeRequests(t *testing.T) {
+	store, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServerStorer(t, store, func(conf *WebserverConfig) {
+		conf.VerifyChecksum = true
+	})
+
+	put := func(item Item) string {
+		item.ContentType = "text/plain"
+		item.Expires = time.Now().Add(time.Hour).UTC()
+		id, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	tests := []struct {
+		name         string
+		item         Item
+		header       map[string]string
+		code         int
+		body         string
+		contentRange string
+		deleted      bool
+		downloads    int
+	}{
+		{"full", Item{}, nil, http.StatusOK, "hello world", "", false, 1},
+		{"range", Item{}, map[string]string{"Range": "bytes=0-4"}, http.StatusPartialContent, "hello", "bytes 0-4/11", false, 0},
+		{"suffix range", Item{}, map[string]string{"Range": "bytes=-5"}, http.StatusPartialContent, "world", "bytes 6-10/11", false, 0},
+		{"unsatisfiable", Item{}, map[string]string{"Range": "bytes=100-"}, http.StatusRequestedRangeNotSatisfiable, "", "bytes */11", false, 0},
+		{"cached", Item{}, map[string]string{"If-Modified-Since": time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)}, http.StatusNotModified, "", "", false, 0},
+		{"burn ignores range", Item{BurnAfterReading: true}, map[string]string{"Range": "bytes=0-4"}, http.StatusOK, "hello world", "", true, 0},
+		{"max downloads ignores range", Item{MaxDownloads: 1}, map[string]string{"Range": "bytes=0-4"}, http.StatusOK, "hello world", "", true, 0},
+	}
+
+	for _, test := range tests {
+		id := put(test.item)
+
+		req := httptest.NewRequest(http.MethodGet, "/"+id, nil)
+		for k, v := range test.header {
+			req.Header.Set(k, v)
+		}
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+
+		if rec.Code != test.code {
+			t.Fatalf("%s: expected status %d, got %d", test.name, test.code, rec.Code)
+		}
+		if test.code == http.StatusOK || test.code == http.StatusPartialContent {
+			if body := rec.Body.String(); body != test.body {
+				t.Fatalf("%s: expected body %q, got %q", test.name, test.body, body)
+			}
+			expectedAr := "bytes"
+			if test.deleted {
+				expectedAr = ""
+			}
+			if ar := rec.Header().Get("Accept-Ranges"); ar != expectedAr {
+				t.Fatalf("%s: expected Accept-Ranges %q, got %q", test.name, expectedAr, ar)
+			}
+		}
+		if cr := rec.Header().Get("Content-Range"); cr != test.contentRange {
+			t.Fatalf("%s: expected Content-Range %q, got %q", test.name, test.contentRange, cr)
+		}
+
+		item, err := store.Get(id)
+		if deleted := err == ErrNotFound; deleted != test.deleted {
+			t.Fatalf("%s: expected deleted %t, got %t (%v)", test.name, test.deleted, deleted, err)
+		} else if deleted {
+			continue
+		}
+
+		// Only a complete download is counted, while partial content is an access.
+		accessed := test.code == http.StatusOK || test.code == http.StatusPartialContent
+		if item.Downloads != test.downloads {
+			t.Fatalf("%s: expected %d downloads, got %d", test.name, test.downloads, item.Downloads)
+		} else if item.LastAccess.IsZero() == accessed {
+			t.Fatalf("%s: expected accessed %t, got LastAccess %v", test.name, accessed, item.LastAccess)
+		}
+	}
+}
+
+func TestNewServerMetricsPath(t *testing.T) {
+	tests := []struct {
+		path  string
+		valid bool
+	}{
+		{"/-/metrics", true},
+		{"/_/metrics", true},
+		{"metrics", false},
+		{"/", false},
+		{"/del/metrics", false},
+		{"/custom.css", false},
+		{"/metric", false},
+		{"/metrics", true},
+		{"/abc/metrics", false},
+	}
+
+	for _, test := range tests {
+		var conf WebserverConfig
+		conf.ItemConfig.MaxSize = "1MiB"
+		conf.ItemConfig.MaxLifetime = time.Hour
+		conf.StaticFiles = map[string]StaticFileConfig{"/custom.css": {}}
+		conf.MetricsPath = test.path
+		conf.idGenerator = IdGeneratorConfig{Type: "random", Length: 4}
+
+		_, err := NewServer(nil, conf, "")
+		if test.valid && err != nil {
+			t.Fatalf("%s: unexpected error %v", test.path, err)
+		} else if !test.valid && err == nil {
+			t.Fatalf("%s: expected an error", test.path)
+		}
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	store := NewMemoryStore(randomIdGenerator(4), StoreOpts{})
+	server := newTestServerStorer(t, store, func(conf *WebserverConfig) {
+		conf.UrlPrefix = "/gosh"
+		conf.MetricsPath = "/-/metrics"
+		conf.idGenerator = IdGeneratorConfig{Type: "random", Length: 4}
+	})
+
+	for _, data := range []string{"hello", "hello world"} {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, newUploadRequest(t, "/gosh/", []byte(data), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Upload failed with status %d", rec.Code)
+		}
+	}
+
+	items, err := store.FindByContentType("text/plain")
+	if err != nil || len(items) != 2 {
+		t.Fatalf("Expected two Items, got %v, %v", items, err)
+	}
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/gosh/"+items[0].ID, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Download failed with status %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/gosh/del/"+items[1].ID+"/"+items[1].DeletionKey, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Deletion failed with status %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/gosh/-/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Metrics failed with status %d", rec.Code)
+	}
+
+	body := rec.Body.String()
+	for _, line := range []string{
+		"gosh_uploads_total 2",
+		"gosh_downloads_total 1",
+		"gosh_deletions_total 1",
+		"gosh_items_current 1",
+		fmt.Sprintf("gosh_store_bytes %d", items[0].Size),
+		`gosh_upload_size_bytes_bucket{le="1024"} 2`,
+		"gosh_upload_size_bytes_sum 16",
+		"gosh_upload_size_bytes_count 2",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("Metrics miss %q:\n%s", line, body)
+		}
+	}
+}
+
+func TestServerMetricsDisabled(t *testing.T) {
+	server := newTestServerMemoryStore(t, nil)
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/metrics", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestServerAdminListener(t *testing.T) {
+	server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+		conf.MetricsPath = "/-/metrics"
+		conf.Admin.Path = "/-/admin"
+		conf.Admin.Token = "secret"
+		conf.AdminListen.Protocol = "tcp"
+		conf.AdminListen.Bound = "127.0.0.1:8081"
+		conf.idGenerator = IdGeneratorConfig{Type: "random", Length: 4}
+	})
+	admin := server.AdminHandler()
+
+	tests := []struct {
+		path   string
+		public int
+		admin  int
+	}{
+		{"/-/metrics", http.StatusNotFound, http.StatusOK},
+		{"/-/admin/items", http.StatusNotFound, http.StatusOK},
+		{"/", http.StatusOK, http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		for _, listener := range []struct {
+			handler http.Handler
+			code    int
+		}{{server, test.public}, {admin, test.admin}} {
+			req := httptest.NewRequest(http.MethodGet, test.path, nil)
+			req.Header.Set("Authorization", "Bearer secret")
+
+			rec := httptest.NewRecorder()
+			listener.handler.ServeHTTP(rec, req)
+			if rec.Code != listener.code {
+				t.Fatalf("%s: expected status %d, got %d", test.path, listener.code, rec.Code)
+			}
+		}
+	}
+}
+
+func TestServerHealth(t *testing.T) {
+	serverRpc, clientRpc, serverFd, clientFd := newUnixConnPairs(t)
+	rpcServer := NewStoreRpcServer(NewMemoryStore(randomIdGenerator(4), StoreOpts{}), serverRpc, serverFd, 0)
+
+	server := newTestServer(t, NewStoreRpcClient(clientRpc, clientFd, StoreRpcClientOpts{}), func(conf *WebserverConfig) {
+		conf.HealthPath = "/-/healthz"
+		conf.idGenerator = IdGeneratorConfig{Type: "random", Length: 4}
+	})
+	t.Cleanup(func() { _ = server.Close() })
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	// A dead store process must fail the health check.
+	_ = rpcServer.Close()
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
+
+func TestNewServerHealthPathCollision(t *testing.T) {
+	var conf WebserverConfig
+	conf.ItemConfig.MaxSize = "1MiB"
+	conf.ItemConfig.MaxLifetime = time.Hour
+	conf.MetricsPath = "/-/status"
+	conf.HealthPath = "/-/status"
+	conf.idGenerator = IdGeneratorConfig{Type: "random", Length: 4}
+
+	if _, err := NewServer(nil, conf, ""); err == nil {
+		t.Fatal("Expected an error for a health_path being the metrics_path")
+	}
+}
+
+func TestServerETag(t *testing.T) {
+	store, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServerStorer(t, store, nil)
+
+	put := func(item Item) Item {
+		item.ContentType = "text/plain"
+		item.Expires = time.Now().Add(time.Hour).UTC()
+		id, err := store.Put(item, newDummyReadCloser(bytes.NewBufferString("hello world")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		item, err = store.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return item
+	}
+
+	plain := put(Item{})
+	etag := itemETag(plain)
+	if etag == "" || strings.Contains(etag, plain.ContentHash[:32]) {
+		t.Fatalf("Unexpected ETag %q", etag)
+	}
+	if other := itemETag(put(Item{})); other == etag {
+		t.Fatalf("Items with the same content share the ETag %q", etag)
+	}
+
+	burn := put(Item{BurnAfterReading: true})
+	ims := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name    string
+		item    Item
+		header  map[string]string
+		code    int
+		deleted bool
+	}{
+		{"unconditional", plain, nil, http.StatusOK, false},
+		{"matching", plain, map[string]string{"If-None-Match": etag}, http.StatusNotModified, false},
+		{"matching list", plain, map[string]string{"If-None-Match": `"foo", W/` + etag}, http.StatusNotModified, false},
+		{"wildcard", plain, map[string]string{"If-None-Match": "*"}, http.StatusNotModified, false},
+		{"mismatching precedes modified since", plain, map[string]string{"If-None-Match": `"foo"`, "If-Modified-Since": ims}, http.StatusOK, false},
+		{"range", plain, map[string]string{"If-Range": etag, "Range": "bytes=0-4"}, http.StatusPartialContent, false},
+		{"burn cached", burn, map[string]string{"If-None-Match": itemETag(burn)}, http.StatusNotModified, false},
+		{"burn modified since", burn, map[string]string{"If-Modified-Since": ims}, http.StatusNotModified, false},
+		{"burn", burn, nil, http.StatusOK, true},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/"+test.item.ID, nil)
+		for k, v := range test.header {
+			req.Header.Set(k, v)
+		}
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+
+		if rec.Code != test.code {
+			t.Fatalf("%s: expected status %d, got %d", test.name, test.code, rec.Code)
+		}
+		if got := rec.Header().Get("ETag"); got != itemETag(test.item) {
+			t.Fatalf("%s: expected ETag %q, got %q", test.name, itemETag(test.item), got)
+		}
+
+		_, err := store.Get(test.item.ID)
+		if deleted := err == ErrNotFound; deleted != test.deleted {
+			t.Fatalf("%s: expected deleted %t, got %t (%v)", test.name, test.deleted, deleted, err)
+		}
+	}
+}
+
+func TestServerHeadItem(t *testing.T) {
+	store := NewMemoryStore(randomIdGenerator(4), StoreOpts{})
+	server := newTestServerStorer(t, store, func(conf *WebserverConfig) {
+		conf.ItemConfig.TypeOverrides = []string{"application/json"}
+	})
+
+	data := []byte("hello world")
+
+	tests := []struct {
+		name        string
+		item        Item
+		query       string
+		contentType string
+	}{
+		{"plain", Item{Filename: "test.txt"}, "", "text/plain"},
+		{"burn", Item{Filename: "test.txt", BurnAfterReading: true}, "", "text/plain"},
+		{"max downloads", Item{Filename: "test.txt", MaxDownloads: 1}, "", "text/plain"},
+		{"type override", Item{Filename: "test.txt", MaxDownloads: 1}, "?type=application/json", "application/json"},
+	}
+
+	for _, test := range tests {
+		test.item.ContentType = "text/plain"
+		test.item.Expires = time.Now().Add(time.Hour).UTC()
+		id, err := store.Put(test.item, newDummyReadCloser(bytes.NewBuffer(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/"+id+test.query, nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", test.name, http.StatusOK, rec.Code)
+		}
+		if rec.Body.Len() != 0 {
+			t.Fatalf("%s: expected no body, got %q", test.name, rec.Body.String())
+		}
+		for header, value := range map[string]string{
+			"Content-Length":      "11",
+			"Content-Type":        test.contentType,
+			"Content-Disposition": `inline; filename="test.txt"`,
+			"X-Content-SHA256":    sha256Hex(data),
+		} {
+			if got := rec.Header().Get(header); got != value {
+				t.Fatalf("%s: expected %s %q, got %q", test.name, header, value, got)
+			}
+		}
+
+		// A HEAD request must neither burn nor count as a download.
+		item, err := store.Get(id)
+		if err != nil {
+			t.Fatalf("%s: Item is gone after a HEAD request: %v", test.name, err)
+		} else if item.Downloads != 0 {
+			t.Fatalf("%s: expected no downloads, got %d", test.name, item.Downloads)
+		}
+
+		// A following GET still serves the overridden type after reserving it.
+		rec = httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+id+test.query, nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != string(data) {
+			t.Fatalf("%s: GET failed with status %d and body %q", test.name, rec.Code, rec.Body.String())
+		} else if ct := rec.Header().Get("Content-Type"); ct != test.contentType {
+			t.Fatalf("%s: expected GET Content-Type %q, got %q", test.name, test.contentType, ct)
+		}
+	}
+}
+
+func TestServerAdminItems(t *testing.T) {
+	store := NewMemoryStore(randomIdGenerator(4), StoreOpts{})
+	server := newTestServerStorer(t, store, func(conf *WebserverConfig) {
+		conf.Admin.Path = "/-/admin"
+		conf.Admin.Token = "secret"
+		conf.idGenerator = IdGeneratorConfig{Type: "random", Length: 4}
+	})
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		id, err := store.Put(Item{
+			Filename:    fmt.Sprintf("%d.txt", i),
+			ContentType: "text/plain",
+			DeletionKey: "key",
+			Created:     time.Now().Add(time.Duration(i) * time.Second).UTC(),
+			Expires:     time.Now().Add(time.Hour).UTC(),
+			Owner:       map[OwnerType]net.IP{RemoteAddr: net.ParseIP("192.0.2.1")},
+		}, newDummyReadCloser(bytes.NewBufferString("hello world")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := store.RecordDownload(ids[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		if rec := request("/-/admin/items", token); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("Token %q: expected status %d, got %d", token, http.StatusUnauthorized, rec.Code)
+		}
+	}
+	if rec := request("/-/admin/nope", "secret"); rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+	for _, query := range []string{"?offset=-1", "?limit=0", "?limit=foo", "?order=size", "?never_downloaded=maybe"} {
+		if rec := request("/-/admin/items"+query, "secret"); rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected status %d, got %d", query, http.StatusBadRequest, rec.Code)
+		}
+	}
+
+	tests := []struct {
+		query string
+		ids   []string
+	}{
+		{"", ids},
+		{"?limit=2", ids[:2]},
+		{"?offset=1&limit=1", ids[1:2]},
+		{"?offset=2&limit=5", ids[2:]},
+		{"?offset=3", []string{}},
+		{"?order=downloads", []string{ids[1], ids[2], ids[0]}},
+		{"?order=last_access&limit=2", []string{ids[0], ids[2]}},
+		{"?never_downloaded=true", []string{ids[0], ids[2]}},
+	}
+
+	for _, test := range tests {
+		rec := request("/-/admin/items"+test.query, "secret")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", test.query, http.StatusOK, rec.Code)
+		}
+		if strings.Contains(rec.Body.String(), "key") {
+			t.Fatalf("%s: listing discloses the deletion key: %s", test.query, rec.Body.String())
+		}
+
+		var resp struct {
+			Items []adminItem `json:"items"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+
+		gotIds := make([]string, 0, len(resp.Items))
+		for _, item := range resp.Items {
+			gotIds = append(gotIds, item.ID)
+			if item.Size != 11 || !item.Owners[RemoteAddr].Equal(net.ParseIP("192.0.2.1")) {
+				t.Fatalf("%s: unexpected item %+v", test.query, item)
+			}
+		}
+		if !reflect.DeepEqual(gotIds, test.ids) {
+			t.Fatalf("%s: expected IDs %v, got %v", test.query, test.ids, gotIds)
+		}
+	}
+}
+
+func TestNewServerAdminPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*WebserverConfig)
+	}{
+		{"no token", func(conf *WebserverConfig) { conf.Admin.Token = "" }},
+		{"trailing slash", func(conf *WebserverConfig) { conf.Admin.Path = "/-/admin/" }},
+		{"item ID", func(conf *WebserverConfig) { conf.Admin.Path = "/admin" }},
+		{"metrics below", func(conf *WebserverConfig) { conf.MetricsPath = "/-/admin/metrics" }},
+		{"health below", func(conf *WebserverConfig) { conf.HealthPath = "/-/admin/items" }},
+	}
+
+	for _, test := range tests {
+		var conf WebserverConfig
+		conf.ItemConfig.MaxSize = "1MiB"
+		conf.ItemConfig.MaxLifetime = time.Hour
+		conf.Admin.Path = "/-/admin"
+		conf.Admin.Token = "secret"
+		conf.idGenerator = IdGeneratorConfig{Type: "random", Length: 4}
+		test.modify(&conf)
+
+		if _, err := NewServer(nil, conf, ""); err == nil {
+			t.Fatalf("%s: expected an error", test.name)
+		}
+	}
+}
+
+// newTestCertificate creates a self-signed TLS certificate for localhost.
+func newTestCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestServerHttpdTls(t *testing.T) {
+	cert := newTestCertificate(t)
+	server := newTestServer(t, nil, func(conf *WebserverConfig) {
+		conf.tlsCertificate = &cert
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = ln.Close()
+
+	go func() { _ = server.ServeHttpd(fd) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	resp, err := client.Get("https://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.TLS == nil || !strings.Contains(string(body), "https://"+ln.Addr().String()+"/") {
+		t.Fatalf("Expected index with HTTPS URLs, got: %s", body)
+	}
+
+	// Plain HTTP requests are not answered by the TLS listener.
+	if resp, err := http.Get("http://" + ln.Addr().String() + "/"); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Fatal("Expected plain HTTP request to fail")
+		}
+	}
+}
+
+func TestAcceptedEncoding(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"GZIP;q=0.5", "gzip"},
+		{"gzip;q=0, deflate", "deflate"},
+		{"gzip;q=0", ""},
+		{"*", "gzip"},
+		{"*, gzip;q=0", "deflate"},
+		{"br", ""},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.header != "" {
+			r.Header.Set("Accept-Encoding", test.header)
+		}
+		if encoding := acceptedEncoding(r, compressionEncodings); encoding != test.expected {
+			t.Fatalf("%q: expected %q, got %q", test.header, test.expected, encoding)
+		}
+	}
+}
+
+func TestServerCompression(t *testing.T) {
+	store, err := NewStore(t.TempDir(), randomIdGenerator(4), StoreOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServerStorer(t, store, func(conf *WebserverConfig) {
+		conf.Compression.Enabled = true
+		conf.VerifyChecksum = true
+	})
+
+	data := strings.Repeat("hello world\n", 1024)
+	put := func(contentType string) string {
+		id, err := store.Put(Item{ContentType: contentType, Expires: time.Now().Add(time.Hour).UTC()},
+			newDummyReadCloser(bytes.NewBufferString(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	textId, pngId := put("text/plain; charset=utf-8"), put("image/png")
+
+	tests := []struct {
+		name     string
+		id       string
+		header   map[string]string
+		code     int
+		encoding string
+		vary     bool
+	}{
+		{"plain", textId, nil, http.StatusOK, "", true},
+		{"gzip", textId, map[string]string{"Accept-Encoding": "gzip"}, http.StatusOK, "gzip", true},
+		{"deflate", textId, map[string]string{"Accept-Encoding": "deflate"}, http.StatusOK, "deflate", true},
+		{"range", textId, map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-4"}, http.StatusPartialContent, "", true},
+		{"incompressible", pngId, map[string]string{"Accept-Encoding": "gzip"}, http.StatusOK, "", false},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/"+test.id, nil)
+		for k, v := range test.header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+
+		if rec.Code != test.code {
+			t.Fatalf("%s: expected status %d, got %d", test.name, test.code, rec.Code)
+		} else if encoding := rec.Header().Get("Content-Encoding"); encoding != test.encoding {
+			t.Fatalf("%s: expected Content-Encoding %q, got %q", test.name, test.encoding, encoding)
+		} else if vary := rec.Header().Get("Vary") == "Accept-Encoding"; vary != test.vary {
+			t.Fatalf("%s: expected Vary %t, got %v", test.name, test.vary, rec.Header().Values("Vary"))
+		}
+
+		if test.code != http.StatusOK {
+			continue
+		}
+
+		var body io.Reader = rec.Body
+		switch test.encoding {
+		case "gzip":
+			body, err = gzip.NewReader(rec.Body)
+		case "deflate":
+			body, err = zlib.NewReader(rec.Body)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if test.encoding != "" {
+			if rec.Body.Len() >= len(data) {
+				t.Fatalf("%s: response of %d bytes was not compressed", test.name, rec.Body.Len())
+			} else if etag := rec.Header().Get("ETag"); !strings.HasPrefix(etag, "W/") {
+				t.Fatalf("%s: expected a weak ETag, got %q", test.name, etag)
+			}
+		}
+
+		got, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		} else if string(got) != data {
+			t.Fatalf("%s: unexpected body of %d bytes", test.name, len(got))
+		}
+	}
+}
+
+func TestServerCompressionDisabled(t *testing.T) {
+	server := newTestServerMemoryStore(t, nil)
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", []byte("hello world"), nil))
+	fetchUrl, err := url.Parse(strings.TrimSpace(rec.Body.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, fetchUrl.Path, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	if rec.Header().Get("Content-Encoding") != "" || rec.Header().Get("Vary") != "" || rec.Body.String() != "hello world" {
+		t.Fatalf("Expected an uncompressed response, got %v: %q", rec.Header(), rec.Body.String())
+	}
+}
+
+func TestServerAccessLog(t *testing.T) {
+	server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+		conf.AccessLog = true
+	})
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, newUploadRequest(t, "/?onlyURL", []byte("hello world"), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	fetchUrl, err := url.Parse(strings.TrimSpace(rec.Body.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaultLogger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	for _, level := range []slog.Level{slog.LevelInfo, slog.LevelDebug} {
+		var logs bytes.Buffer
+		slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: level})))
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fetchUrl.Path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+
+		// The full ID is only logged for debug logging.
+		expected := map[string]any{
+			"method":    http.MethodGet,
+			"path":      "/:id",
+			"status":    float64(http.StatusOK),
+			"size":      float64(len("hello world")),
+			"client_ip": "192.0.2.1",
+		}
+		if level == slog.LevelDebug {
+			expected["path"] = fetchUrl.Path
+		}
+
+		var found bool
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			var record map[string]any
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				t.Fatal(err)
+			} else if record["msg"] != "Served request" {
+				continue
+			}
+
+			found = true
+			for k, v := range expected {
+				if record[k] != v {
+					t.Fatalf("%v: expected %s %v, got %v", level, k, v, record[k])
+				}
+			}
+			if _, ok := record["duration_ms"].(float64); !ok {
+				t.Fatalf("%v: expected a duration, got %v", level, record)
+			}
+		}
+		if !found {
+			t.Fatalf("%v: no access log entry in %q", level, logs.String())
+		}
+	}
+}
+
+func TestServerAccessLogPath(t *testing.T) {
+	server := newTestServerMemoryStore(t, func(conf *WebserverConfig) {
+		conf.MetricsPath = "/-/metrics"
+		conf.idGenerator = IdGeneratorConfig{Type: "random", Length: 4}
+		conf.Admin.Path = "/-/admin"
+		conf.Admin.Token = "secret"
+		conf.ResumableUploads.Path = "/-/tus"
+	})
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/", "/"},
+		{"/-/metrics", "/-/metrics"},
+		{"/-/admin/items", "/-/admin/items"},
+		{"/abcd", "/:id"},
+		{"/abcd/foo.pdf", "/:id/:id"},
+		{"/del/abcd/key", "/del/:id/:id"},
+		{"/settings/abcd/key", "/settings/:id/:id"},
+		{"/report/abcd", "/report/:id"},
+		{"/-/tus", "/-/tus"},
+		{"/-/tus/token", "/-/tus/:id"},
+	}
+
+	for _, test := range tests {
+		if path := server.accessLogPath(test.path); path != test.expected {
+			t.Fatalf("%s: expected %q, got %q", test.path, test.expected, path)
+		}
+	}
+}